@@ -6,6 +6,11 @@ public class AdditionalDownload {
 
     private String sha256;
 
+    /**
+     * Verified in addition to the SHA256 checksum if set, for upstreams that only publish SHA512 checksums
+     */
+    private String sha512;
+
     /**
      * only applies to executable files, the name of the resulting executable
      */
@@ -23,10 +28,17 @@ public class AdditionalDownload {
      *
      * executable
      * tar
+     * zip
      * rpm
      */
     private String type;
 
+    /**
+     * Only applies to rpm type; if set the rpm is downloaded from the uri and its signature verified against this key
+     * before installing
+     */
+    private String gpgKeyUri;
+
     public String getUri() {
         return uri;
     }
@@ -45,6 +57,15 @@ public class AdditionalDownload {
         return this;
     }
 
+    public String getSha512() {
+        return sha512;
+    }
+
+    public AdditionalDownload setSha512(String sha512) {
+        this.sha512 = sha512;
+        return this;
+    }
+
     public String getFileName() {
         return fileName;
     }
@@ -81,15 +102,26 @@ public class AdditionalDownload {
         return this;
     }
 
+    public String getGpgKeyUri() {
+        return gpgKeyUri;
+    }
+
+    public AdditionalDownload setGpgKeyUri(String gpgKeyUri) {
+        this.gpgKeyUri = gpgKeyUri;
+        return this;
+    }
+
     @Override
     public String toString() {
         return "AdditionalDownload{" +
                 "uri='" + uri + '\'' +
                 ", sha256='" + sha256 + '\'' +
+                ", sha512='" + sha512 + '\'' +
                 ", fileName='" + fileName + '\'' +
                 ", binaryPath='" + binaryPath + '\'' +
                 ", packageName='" + packageName + '\'' +
                 ", type='" + type + '\'' +
+                ", gpgKeyUri='" + gpgKeyUri + '\'' +
                 '}';
     }
 }
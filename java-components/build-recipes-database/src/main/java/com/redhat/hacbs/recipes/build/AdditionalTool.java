@@ -0,0 +1,58 @@
+package com.redhat.hacbs.recipes.build;
+
+import java.util.ArrayList;
+import java.util.List;
+
+public class AdditionalTool {
+
+    /**
+     * The build tool e.g. gradle
+     */
+    private String tool;
+
+    /**
+     * The version of the tool, used if the recipe does not already have a version for it
+     */
+    private String version;
+
+    /**
+     * The arguments to invoke the tool with
+     */
+    private List<String> commandLine = new ArrayList<>();
+
+    public String getTool() {
+        return tool;
+    }
+
+    public AdditionalTool setTool(String tool) {
+        this.tool = tool;
+        return this;
+    }
+
+    public String getVersion() {
+        return version;
+    }
+
+    public AdditionalTool setVersion(String version) {
+        this.version = version;
+        return this;
+    }
+
+    public List<String> getCommandLine() {
+        return commandLine;
+    }
+
+    public AdditionalTool setCommandLine(List<String> commandLine) {
+        this.commandLine = commandLine;
+        return this;
+    }
+
+    @Override
+    public String toString() {
+        return "AdditionalTool{" +
+                "tool='" + tool + '\'' +
+                ", version='" + version + '\'' +
+                ", commandLine=" + commandLine +
+                '}';
+    }
+}
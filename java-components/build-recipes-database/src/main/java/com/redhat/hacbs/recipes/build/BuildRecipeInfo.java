@@ -1,6 +1,7 @@
 package com.redhat.hacbs.recipes.build;
 
 import java.util.ArrayList;
+import java.util.HashMap;
 import java.util.List;
 import java.util.Map;
 
@@ -52,6 +53,130 @@ public class BuildRecipeInfo {
 
     String tool;
 
+    /**
+     * The number of times the build task is retried if it fails, e.g. due to network issues while resolving dependencies.
+     * Only the build is retried as retrying the deployment could publish the artifacts twice. At most 5.
+     */
+    int retries;
+
+    /**
+     * Extra environment variables for the build, e.g. GRADLE_OPTS. The variables managed by the build service such as
+     * JAVA_HOME and MAVEN_HOME take precedence, so any of those set here are ignored.
+     */
+    Map<String, String> extraEnv = new HashMap<>();
+
+    /**
+     * If this is greater than zero only this many commits of history are fetched, rather than cloning the whole
+     * repository. Submodules are still cloned in full.
+     */
+    int cloneDepth;
+
+    /**
+     * The JAVA_HOME the preprocessor runs with, a JDK within the build request processor image. This is for projects
+     * whose build files need an older toolchain to be parsed. Defaults to the bundled system Java.
+     */
+    String preprocessorJavaHome;
+
+    /**
+     * The body of a Gradle init script passed to Gradle builds with --init-script, e.g. to redirect repositories or
+     * apply plugins. It is ignored for other build tools.
+     */
+    String gradleInitScript;
+
+    /**
+     * The platform the builder image is pulled and the build is run for, one of linux/amd64 or linux/arm64.
+     */
+    String architecture;
+
+    /**
+     * If true Maven and Gradle builds run the wrapper in the root of the repository rather than the installed build tool.
+     */
+    boolean useBuildToolWrapper;
+
+    /**
+     * The expected SHA-256 checksum of the wrapper jar. The build fails if the jar does not match.
+     */
+    String wrapperChecksum;
+
+    /**
+     * Files written to the build settings directory before the build, keyed by file name.
+     */
+    Map<String, String> buildSettingsFiles = new HashMap<>();
+
+    /**
+     * A Maven style version range, e.g. [1.0,2.0), used instead of true when enforcing the version. The version is only
+     * enforced when building a version in the range.
+     */
+    String enforceVersionRange;
+
+    /**
+     * The location of the JDK within the builder image, with {VERSION} replaced by the Java version e.g.
+     * /opt/java/jdk-{VERSION}. If not set the standard /lib/jvm layout is used.
+     */
+    String javaHomeTemplate;
+
+    /**
+     * The Java version Maven and Gradle are launched with, if it must differ from the JDK the project is compiled with,
+     * e.g. to avoid a bug in the compile JDK. If not set the build tool runs on the compile JDK.
+     */
+    String launcherJavaVersion;
+
+    /**
+     * If this is true an Ant build deploys the artifacts itself, with a deploy target in build.xml that deploys to the
+     * Maven repository in the jbs.deploy.url property, rather than the artifacts being collected from the source tree.
+     */
+    boolean antNativeDeploy;
+
+    /**
+     * The home directory of the build user. If not set the home directory of the image user is used if it is writable,
+     * otherwise a directory within the workspace.
+     */
+    String homeDirectory;
+
+    /**
+     * Contaminant groupId:artifactId[:version] coordinates that are known to be acceptable, such as shaded dependencies. Any part
+     * may use * as a wildcard.
+     */
+    List<String> allowedContaminants = new ArrayList<>();
+
+    /**
+     * If true the pre build script runs in a sub-shell so it cannot change the build environment. Variables it needs
+     * to pass to the build are written as KEY=VALUE lines to the file named by JBS_PRE_BUILD_ENV.
+     */
+    boolean isolatePreBuildScript;
+
+    /**
+     * Credentials for private submodules hosted on a different host than the top-level repository
+     */
+    List<SubmoduleCredential> submoduleCredentials = new ArrayList<>();
+
+    /**
+     * Further build tools to run once the main tool has finished, in order, e.g. for a project that is partly built
+     * with Maven and partly with Gradle. The pre-build prepares the source for every tool.
+     */
+    List<AdditionalTool> additionalTools = new ArrayList<>();
+
+    /**
+     * Fixes the order tests run in for reproducible builds. Either alphabetical or random (with a fixed seed).
+     * Defaults to the build tool default.
+     */
+    String testRunOrder;
+
+    /**
+     * Additional CPU in millicores to add to the build request and limit
+     */
+    int additionalCPU;
+
+    /**
+     * If the build targets a single module then also build the modules that depend on it (-amd)
+     */
+    boolean alsoMakeDependents;
+
+    /**
+     * If the build targets a single module then also build the modules it depends on (-am)
+     */
+    boolean alsoMake;
+
     public List<String> getAdditionalArgs() {
         return additionalArgs;
     }
@@ -196,6 +321,204 @@ public class BuildRecipeInfo {
         return this;
     }
 
+    public boolean isAlsoMake() {
+        return alsoMake;
+    }
+
+    public BuildRecipeInfo setAlsoMake(boolean alsoMake) {
+        this.alsoMake = alsoMake;
+        return this;
+    }
+
+    public boolean isAlsoMakeDependents() {
+        return alsoMakeDependents;
+    }
+
+    public BuildRecipeInfo setAlsoMakeDependents(boolean alsoMakeDependents) {
+        this.alsoMakeDependents = alsoMakeDependents;
+        return this;
+    }
+
+    public int getAdditionalCPU() {
+        return additionalCPU;
+    }
+
+    public BuildRecipeInfo setAdditionalCPU(int additionalCPU) {
+        this.additionalCPU = additionalCPU;
+        return this;
+    }
+
+    public String getTestRunOrder() {
+        return testRunOrder;
+    }
+
+    public BuildRecipeInfo setTestRunOrder(String testRunOrder) {
+        this.testRunOrder = testRunOrder;
+        return this;
+    }
+
+    public List<SubmoduleCredential> getSubmoduleCredentials() {
+        return submoduleCredentials;
+    }
+
+    public BuildRecipeInfo setSubmoduleCredentials(List<SubmoduleCredential> submoduleCredentials) {
+        this.submoduleCredentials = submoduleCredentials;
+        return this;
+    }
+
+    public boolean isIsolatePreBuildScript() {
+        return isolatePreBuildScript;
+    }
+
+    public BuildRecipeInfo setIsolatePreBuildScript(boolean isolatePreBuildScript) {
+        this.isolatePreBuildScript = isolatePreBuildScript;
+        return this;
+    }
+
+    public List<String> getAllowedContaminants() {
+        return allowedContaminants;
+    }
+
+    public BuildRecipeInfo setAllowedContaminants(List<String> allowedContaminants) {
+        this.allowedContaminants = allowedContaminants;
+        return this;
+    }
+
+    public String getHomeDirectory() {
+        return homeDirectory;
+    }
+
+    public BuildRecipeInfo setHomeDirectory(String homeDirectory) {
+        this.homeDirectory = homeDirectory;
+        return this;
+    }
+
+    public String getJavaHomeTemplate() {
+        return javaHomeTemplate;
+    }
+
+    public BuildRecipeInfo setJavaHomeTemplate(String javaHomeTemplate) {
+        this.javaHomeTemplate = javaHomeTemplate;
+        return this;
+    }
+
+    public String getLauncherJavaVersion() {
+        return launcherJavaVersion;
+    }
+
+    public BuildRecipeInfo setLauncherJavaVersion(String launcherJavaVersion) {
+        this.launcherJavaVersion = launcherJavaVersion;
+        return this;
+    }
+
+    public boolean isAntNativeDeploy() {
+        return antNativeDeploy;
+    }
+
+    public BuildRecipeInfo setAntNativeDeploy(boolean antNativeDeploy) {
+        this.antNativeDeploy = antNativeDeploy;
+        return this;
+    }
+
+    public int getRetries() {
+        return retries;
+    }
+
+    public BuildRecipeInfo setRetries(int retries) {
+        this.retries = retries;
+        return this;
+    }
+
+    public List<AdditionalTool> getAdditionalTools() {
+        return additionalTools;
+    }
+
+    public BuildRecipeInfo setAdditionalTools(List<AdditionalTool> additionalTools) {
+        this.additionalTools = additionalTools;
+        return this;
+    }
+
+    public Map<String, String> getExtraEnv() {
+        return extraEnv;
+    }
+
+    public BuildRecipeInfo setExtraEnv(Map<String, String> extraEnv) {
+        this.extraEnv = extraEnv;
+        return this;
+    }
+
+    public int getCloneDepth() {
+        return cloneDepth;
+    }
+
+    public BuildRecipeInfo setCloneDepth(int cloneDepth) {
+        this.cloneDepth = cloneDepth;
+        return this;
+    }
+
+    public String getPreprocessorJavaHome() {
+        return preprocessorJavaHome;
+    }
+
+    public BuildRecipeInfo setPreprocessorJavaHome(String preprocessorJavaHome) {
+        this.preprocessorJavaHome = preprocessorJavaHome;
+        return this;
+    }
+
+    public String getGradleInitScript() {
+        return gradleInitScript;
+    }
+
+    public BuildRecipeInfo setGradleInitScript(String gradleInitScript) {
+        this.gradleInitScript = gradleInitScript;
+        return this;
+    }
+
+    public String getArchitecture() {
+        return architecture;
+    }
+
+    public BuildRecipeInfo setArchitecture(String architecture) {
+        this.architecture = architecture;
+        return this;
+    }
+
+    public boolean isUseBuildToolWrapper() {
+        return useBuildToolWrapper;
+    }
+
+    public BuildRecipeInfo setUseBuildToolWrapper(boolean useBuildToolWrapper) {
+        this.useBuildToolWrapper = useBuildToolWrapper;
+        return this;
+    }
+
+    public String getWrapperChecksum() {
+        return wrapperChecksum;
+    }
+
+    public BuildRecipeInfo setWrapperChecksum(String wrapperChecksum) {
+        this.wrapperChecksum = wrapperChecksum;
+        return this;
+    }
+
+    public Map<String, String> getBuildSettingsFiles() {
+        return buildSettingsFiles;
+    }
+
+    public BuildRecipeInfo setBuildSettingsFiles(Map<String, String> buildSettingsFiles) {
+        this.buildSettingsFiles = buildSettingsFiles;
+        return this;
+    }
+
+    public String getEnforceVersionRange() {
+        return enforceVersionRange;
+    }
+
+    public BuildRecipeInfo setEnforceVersionRange(String enforceVersionRange) {
+        this.enforceVersionRange = enforceVersionRange;
+        return this;
+    }
+
     @Override
     public String toString() {
         return "BuildRecipeInfo{" +
@@ -213,6 +536,28 @@ public class BuildRecipeInfo {
                 ", additionalBuilds=" + additionalBuilds +
                 ", allowedDifferences=" + allowedDifferences +
                 ", disabledPlugins=" + disabledPlugins +
+                ", alsoMake=" + alsoMake +
+                ", alsoMakeDependents=" + alsoMakeDependents +
+                ", additionalCPU=" + additionalCPU +
+                ", testRunOrder=" + testRunOrder +
+                ", submoduleCredentials=" + submoduleCredentials +
+                ", additionalTools=" + additionalTools +
+                ", isolatePreBuildScript=" + isolatePreBuildScript +
+                ", allowedContaminants=" + allowedContaminants +
+                ", homeDirectory=" + homeDirectory +
+                ", javaHomeTemplate=" + javaHomeTemplate +
+                ", launcherJavaVersion='" + launcherJavaVersion + '\'' +
+                ", antNativeDeploy=" + antNativeDeploy +
+                ", retries=" + retries +
+                ", extraEnv=" + extraEnv +
+                ", cloneDepth=" + cloneDepth +
+                ", preprocessorJavaHome='" + preprocessorJavaHome + '\'' +
+                ", gradleInitScript='" + gradleInitScript + '\'' +
+                ", architecture='" + architecture + '\'' +
+                ", useBuildToolWrapper=" + useBuildToolWrapper +
+                ", wrapperChecksum='" + wrapperChecksum + '\'' +
+                ", buildSettingsFiles=" + buildSettingsFiles +
+                ", enforceVersionRange='" + enforceVersionRange + '\'' +
                 '}';
     }
 }
@@ -0,0 +1,55 @@
+package com.redhat.hacbs.recipes.build;
+
+public class SubmoduleCredential {
+
+    /**
+     * The host of the submodule repository e.g. gitlab.example.com
+     */
+    private String host;
+
+    /**
+     * The secret holding the git-credentials for the host, which must be in the allowedSubmoduleSecrets of the JBSConfig
+     */
+    private String secretName;
+
+    /**
+     * The key within the secret. Defaults to .git-credentials
+     */
+    private String secretKey;
+
+    public String getHost() {
+        return host;
+    }
+
+    public SubmoduleCredential setHost(String host) {
+        this.host = host;
+        return this;
+    }
+
+    public String getSecretName() {
+        return secretName;
+    }
+
+    public SubmoduleCredential setSecretName(String secretName) {
+        this.secretName = secretName;
+        return this;
+    }
+
+    public String getSecretKey() {
+        return secretKey;
+    }
+
+    public SubmoduleCredential setSecretKey(String secretKey) {
+        this.secretKey = secretKey;
+        return this;
+    }
+
+    @Override
+    public String toString() {
+        return "SubmoduleCredential{" +
+                "host='" + host + '\'' +
+                ", secretName='" + secretName + '\'' +
+                ", secretKey='" + secretKey + '\'' +
+                '}';
+    }
+}
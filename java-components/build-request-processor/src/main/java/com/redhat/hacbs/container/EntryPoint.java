@@ -5,6 +5,7 @@ import com.redhat.hacbs.container.analyser.dependencies.AnalyseDependencies;
 import com.redhat.hacbs.container.analyser.location.LookupScmLocationCommand;
 import com.redhat.hacbs.container.build.preprocessor.ant.AntPrepareCommand;
 import com.redhat.hacbs.container.build.preprocessor.gradle.GradlePrepareCommand;
+import com.redhat.hacbs.container.build.preprocessor.lein.LeinPrepareCommand;
 import com.redhat.hacbs.container.build.preprocessor.maven.MavenPrepareCommand;
 import com.redhat.hacbs.container.build.preprocessor.sbt.SBTPrepareCommand;
 import com.redhat.hacbs.container.deploy.BuildVerifyCommand;
@@ -13,6 +14,8 @@ import com.redhat.hacbs.container.deploy.CopyArtifactsCommand;
 import com.redhat.hacbs.container.deploy.DeployPreBuildImageCommand;
 import com.redhat.hacbs.container.deploy.DeployPreBuildSourceCommand;
 import com.redhat.hacbs.container.deploy.TagDeployCommand;
+import com.redhat.hacbs.container.verifier.NormalizeTimestampsCommand;
+import com.redhat.hacbs.container.verifier.ValidateJarsCommand;
 import com.redhat.hacbs.container.verifier.VerifyBuiltArtifactsCommand;
 
 import io.quarkus.picocli.runtime.annotations.TopCommand;
@@ -27,12 +30,15 @@ import picocli.CommandLine;
         BuildVerifyCommand.class,
         DeployPreBuildImageCommand.class,
         GradlePrepareCommand.class,
+        LeinPrepareCommand.class,
         DeployPreBuildSourceCommand.class,
         LookupBuildInfoCommand.class,
         LookupScmLocationCommand.class,
         TagDeployCommand.class,
         MavenPrepareCommand.class,
+        NormalizeTimestampsCommand.class,
         SBTPrepareCommand.class,
+        ValidateJarsCommand.class,
         VerifyBuiltArtifactsCommand.class
 })
 public class EntryPoint {
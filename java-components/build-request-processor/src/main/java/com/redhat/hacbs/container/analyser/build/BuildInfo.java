@@ -1,9 +1,13 @@
 package com.redhat.hacbs.container.analyser.build;
 
 import java.util.ArrayList;
+import java.util.HashMap;
 import java.util.List;
+import java.util.Map;
 
 import com.redhat.hacbs.recipes.build.AdditionalDownload;
+import com.redhat.hacbs.recipes.build.AdditionalTool;
+import com.redhat.hacbs.recipes.build.SubmoduleCredential;
 
 public class BuildInfo {
 
@@ -46,6 +50,48 @@ public class BuildInfo {
 
     String contextPath;
 
+    boolean alsoMake;
+
+    boolean alsoMakeDependents;
+
+    int additionalCPU;
+
+    String testRunOrder;
+
+    List<SubmoduleCredential> submoduleCredentials = new ArrayList<>();
+
+    List<AdditionalTool> additionalTools = new ArrayList<>();
+
+    boolean isolatePreBuildScript;
+
+    List<String> allowedContaminants = new ArrayList<>();
+
+    String homeDirectory;
+
+    String javaHomeTemplate;
+
+    String launcherJavaVersion;
+
+    boolean antNativeDeploy;
+
+    int retries;
+
+    Map<String, String> extraEnv = new HashMap<>();
+
+    int cloneDepth;
+
+    String preprocessorJavaHome;
+
+    String gradleInitScript;
+
+    String architecture;
+
+    boolean useBuildToolWrapper;
+
+    String wrapperChecksum;
+
+    Map<String, String> buildSettingsFiles = new HashMap<>();
+
     public String getPreBuildScript() {
         return preBuildScript;
     }
@@ -180,6 +226,195 @@ public class BuildInfo {
         this.contextPath = contextPath;
     }
 
+    public boolean isAlsoMake() {
+        return alsoMake;
+    }
+
+    public BuildInfo setAlsoMake(boolean alsoMake) {
+        this.alsoMake = alsoMake;
+        return this;
+    }
+
+    public boolean isAlsoMakeDependents() {
+        return alsoMakeDependents;
+    }
+
+    public BuildInfo setAlsoMakeDependents(boolean alsoMakeDependents) {
+        this.alsoMakeDependents = alsoMakeDependents;
+        return this;
+    }
+
+    public int getAdditionalCPU() {
+        return additionalCPU;
+    }
+
+    public BuildInfo setAdditionalCPU(int additionalCPU) {
+        this.additionalCPU = additionalCPU;
+        return this;
+    }
+
+    public String getTestRunOrder() {
+        return testRunOrder;
+    }
+
+    public BuildInfo setTestRunOrder(String testRunOrder) {
+        this.testRunOrder = testRunOrder;
+        return this;
+    }
+
+    public List<SubmoduleCredential> getSubmoduleCredentials() {
+        return submoduleCredentials;
+    }
+
+    public BuildInfo setSubmoduleCredentials(List<SubmoduleCredential> submoduleCredentials) {
+        this.submoduleCredentials = submoduleCredentials;
+        return this;
+    }
+
+    public boolean isIsolatePreBuildScript() {
+        return isolatePreBuildScript;
+    }
+
+    public BuildInfo setIsolatePreBuildScript(boolean isolatePreBuildScript) {
+        this.isolatePreBuildScript = isolatePreBuildScript;
+        return this;
+    }
+
+    public List<String> getAllowedContaminants() {
+        return allowedContaminants;
+    }
+
+    public BuildInfo setAllowedContaminants(List<String> allowedContaminants) {
+        this.allowedContaminants = allowedContaminants;
+        return this;
+    }
+
+    public String getHomeDirectory() {
+        return homeDirectory;
+    }
+
+    public BuildInfo setHomeDirectory(String homeDirectory) {
+        this.homeDirectory = homeDirectory;
+        return this;
+    }
+
+    public String getJavaHomeTemplate() {
+        return javaHomeTemplate;
+    }
+
+    public BuildInfo setJavaHomeTemplate(String javaHomeTemplate) {
+        this.javaHomeTemplate = javaHomeTemplate;
+        return this;
+    }
+
+    public String getLauncherJavaVersion() {
+        return launcherJavaVersion;
+    }
+
+    public BuildInfo setLauncherJavaVersion(String launcherJavaVersion) {
+        this.launcherJavaVersion = launcherJavaVersion;
+        return this;
+    }
+
+    public boolean isAntNativeDeploy() {
+        return antNativeDeploy;
+    }
+
+    public BuildInfo setAntNativeDeploy(boolean antNativeDeploy) {
+        this.antNativeDeploy = antNativeDeploy;
+        return this;
+    }
+
+    public int getRetries() {
+        return retries;
+    }
+
+    public BuildInfo setRetries(int retries) {
+        this.retries = retries;
+        return this;
+    }
+
+    public List<AdditionalTool> getAdditionalTools() {
+        return additionalTools;
+    }
+
+    public BuildInfo setAdditionalTools(List<AdditionalTool> additionalTools) {
+        this.additionalTools = additionalTools;
+        return this;
+    }
+
+    public Map<String, String> getExtraEnv() {
+        return extraEnv;
+    }
+
+    public BuildInfo setExtraEnv(Map<String, String> extraEnv) {
+        this.extraEnv = extraEnv;
+        return this;
+    }
+
+    public int getCloneDepth() {
+        return cloneDepth;
+    }
+
+    public BuildInfo setCloneDepth(int cloneDepth) {
+        this.cloneDepth = cloneDepth;
+        return this;
+    }
+
+    public String getPreprocessorJavaHome() {
+        return preprocessorJavaHome;
+    }
+
+    public BuildInfo setPreprocessorJavaHome(String preprocessorJavaHome) {
+        this.preprocessorJavaHome = preprocessorJavaHome;
+        return this;
+    }
+
+    public String getGradleInitScript() {
+        return gradleInitScript;
+    }
+
+    public BuildInfo setGradleInitScript(String gradleInitScript) {
+        this.gradleInitScript = gradleInitScript;
+        return this;
+    }
+
+    public String getArchitecture() {
+        return architecture;
+    }
+
+    public BuildInfo setArchitecture(String architecture) {
+        this.architecture = architecture;
+        return this;
+    }
+
+    public boolean isUseBuildToolWrapper() {
+        return useBuildToolWrapper;
+    }
+
+    public BuildInfo setUseBuildToolWrapper(boolean useBuildToolWrapper) {
+        this.useBuildToolWrapper = useBuildToolWrapper;
+        return this;
+    }
+
+    public String getWrapperChecksum() {
+        return wrapperChecksum;
+    }
+
+    public BuildInfo setWrapperChecksum(String wrapperChecksum) {
+        this.wrapperChecksum = wrapperChecksum;
+        return this;
+    }
+
+    public Map<String, String> getBuildSettingsFiles() {
+        return buildSettingsFiles;
+    }
+
+    public BuildInfo setBuildSettingsFiles(Map<String, String> buildSettingsFiles) {
+        this.buildSettingsFiles = buildSettingsFiles;
+        return this;
+    }
+
     @Override
     public String toString() {
         return "BuildInfo{" +
@@ -197,6 +432,27 @@ public class BuildInfo {
                 ", disabledPlugins=" + disabledPlugins +
                 ", digest=" + digest +
                 ", gavs=" + gavs +
+                ", alsoMake=" + alsoMake +
+                ", alsoMakeDependents=" + alsoMakeDependents +
+                ", additionalCPU=" + additionalCPU +
+                ", testRunOrder=" + testRunOrder +
+                ", submoduleCredentials=" + submoduleCredentials +
+                ", additionalTools=" + additionalTools +
+                ", isolatePreBuildScript=" + isolatePreBuildScript +
+                ", allowedContaminants=" + allowedContaminants +
+                ", homeDirectory=" + homeDirectory +
+                ", javaHomeTemplate=" + javaHomeTemplate +
+                ", launcherJavaVersion='" + launcherJavaVersion + '\'' +
+                ", antNativeDeploy=" + antNativeDeploy +
+                ", retries=" + retries +
+                ", extraEnv=" + extraEnv +
+                ", cloneDepth=" + cloneDepth +
+                ", preprocessorJavaHome='" + preprocessorJavaHome + '\'' +
+                ", gradleInitScript='" + gradleInitScript + '\'' +
+                ", architecture='" + architecture + '\'' +
+                ", useBuildToolWrapper=" + useBuildToolWrapper +
+                ", wrapperChecksum='" + wrapperChecksum + '\'' +
+                ", buildSettingsFiles=" + buildSettingsFiles +
                 '}';
     }
 }
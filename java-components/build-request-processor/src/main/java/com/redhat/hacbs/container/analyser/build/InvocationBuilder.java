@@ -133,7 +133,8 @@ public class InvocationBuilder {
     public BuildInfo build(CacheBuildInfoLocator buildInfoLocator) {
         if (buildRecipeInfo != null) {
             if (buildRecipeInfo.isEnforceVersion() && !versionCorrect) {
-                info.enforceVersion = "true";
+                var range = buildRecipeInfo.getEnforceVersionRange();
+                info.enforceVersion = range == null || range.isBlank() ? "true" : range;
             }
             if (buildRecipeInfo.getRepositories() != null && !buildRecipeInfo.getRepositories().isEmpty()) {
                 info.setRepositories(buildRecipeInfo.getRepositories());
@@ -145,6 +146,27 @@ public class InvocationBuilder {
             info.setAdditionalMemory(buildRecipeInfo.getAdditionalMemory());
             info.setAllowedDifferences(buildRecipeInfo.getAllowedDifferences());
             info.setDisabledPlugins(buildRecipeInfo.getDisabledPlugins());
+            info.setRetries(buildRecipeInfo.getRetries());
+            info.setJavaHomeTemplate(buildRecipeInfo.getJavaHomeTemplate());
+            info.setLauncherJavaVersion(buildRecipeInfo.getLauncherJavaVersion());
+            info.setAntNativeDeploy(buildRecipeInfo.isAntNativeDeploy());
+            info.setHomeDirectory(buildRecipeInfo.getHomeDirectory());
+            info.setAllowedContaminants(buildRecipeInfo.getAllowedContaminants());
+            info.setIsolatePreBuildScript(buildRecipeInfo.isIsolatePreBuildScript());
+            info.setSubmoduleCredentials(buildRecipeInfo.getSubmoduleCredentials());
+            info.setAdditionalTools(buildRecipeInfo.getAdditionalTools());
+            info.setExtraEnv(buildRecipeInfo.getExtraEnv());
+            info.setCloneDepth(buildRecipeInfo.getCloneDepth());
+            info.setPreprocessorJavaHome(buildRecipeInfo.getPreprocessorJavaHome());
+            info.setGradleInitScript(buildRecipeInfo.getGradleInitScript());
+            info.setArchitecture(buildRecipeInfo.getArchitecture());
+            info.setUseBuildToolWrapper(buildRecipeInfo.isUseBuildToolWrapper());
+            info.setWrapperChecksum(buildRecipeInfo.getWrapperChecksum());
+            info.setBuildSettingsFiles(buildRecipeInfo.getBuildSettingsFiles());
+            info.setTestRunOrder(buildRecipeInfo.getTestRunOrder());
+            info.setAdditionalCPU(buildRecipeInfo.getAdditionalCPU());
+            info.setAlsoMakeDependents(buildRecipeInfo.isAlsoMakeDependents());
+            info.setAlsoMake(buildRecipeInfo.isAlsoMake());
             if (buildRecipeInfo.getJavaVersion() != null) {
                 preferredJavaVersion = new JavaVersion(buildRecipeInfo.getJavaVersion());
             }
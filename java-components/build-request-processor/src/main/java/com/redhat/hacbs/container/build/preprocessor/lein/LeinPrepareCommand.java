@@ -0,0 +1,63 @@
+package com.redhat.hacbs.container.build.preprocessor.lein;
+
+import java.io.IOException;
+import java.nio.file.FileVisitResult;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.nio.file.SimpleFileVisitor;
+import java.nio.file.attribute.BasicFileAttributes;
+import java.util.List;
+import java.util.regex.Pattern;
+
+import com.redhat.hacbs.container.build.preprocessor.AbstractPreprocessor;
+
+import io.quarkus.logging.Log;
+import picocli.CommandLine;
+
+/**
+ * A simple preprocessor that attempts to fix problematic Leiningen project files.
+ * <p>
+ * At present it removes disabled plugins from the project plugins.
+ */
+@CommandLine.Command(name = "lein-prepare")
+public class LeinPrepareCommand extends AbstractPreprocessor {
+
+    static final String PROJECT_FILE = "project.clj";
+
+    @Override
+    public void run() {
+        if (disabledPlugins == null || disabledPlugins.isEmpty()) {
+            return;
+        }
+        try {
+            Files.walkFileTree(buildRoot, new SimpleFileVisitor<>() {
+                @Override
+                public FileVisitResult visitFile(Path file, BasicFileAttributes attrs) throws IOException {
+                    if (file.getFileName().toString().equals(PROJECT_FILE)) {
+                        String contents = Files.readString(file);
+                        String modified = removePlugins(contents, disabledPlugins);
+                        if (!modified.equals(contents)) {
+                            Log.infof("Removing disabled plugins from %s", file);
+                            Files.writeString(file, modified);
+                        }
+                    }
+                    return FileVisitResult.CONTINUE;
+                }
+            });
+        } catch (IOException e) {
+            throw new RuntimeException(e);
+        }
+    }
+
+    /**
+     * Removes dependency vectors such as {@code [lein-foo "1.0.0" :exclusions [...]]} for the given plugins. The
+     * plugin may be given with or without its group.
+     */
+    static String removePlugins(String contents, List<String> plugins) {
+        for (var plugin : plugins) {
+            var pattern = Pattern.compile("\\[\\s*" + Pattern.quote(plugin) + "\\s+\"[^\"]*\"(\\s+:[^\\[\\]]*(\\[[^\\[\\]]*\\])?)*\\s*\\]");
+            contents = pattern.matcher(contents).replaceAll("");
+        }
+        return contents;
+    }
+}
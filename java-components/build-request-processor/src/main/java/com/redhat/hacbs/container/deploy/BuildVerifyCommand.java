@@ -32,6 +32,7 @@ import com.redhat.hacbs.classfile.tracker.TrackingData;
 import com.redhat.hacbs.common.sbom.GAV;
 import com.redhat.hacbs.container.analyser.dependencies.SBomGenerator;
 import com.redhat.hacbs.container.results.ResultsUpdater;
+import com.redhat.hacbs.container.verifier.TestArtifacts;
 import com.redhat.hacbs.recipes.util.FileUtil;
 import com.redhat.hacbs.resources.model.v1alpha1.dependencybuildstatus.Contaminates;
 import com.redhat.hacbs.resources.util.HashUtil;
@@ -42,7 +43,7 @@ import picocli.CommandLine;
 @CommandLine.Command(name = "verify")
 public class BuildVerifyCommand implements Runnable {
 
-    static final Pattern CODE_ARTIFACT_PATTERN = Pattern.compile("https://([^.]*)-\\d+\\..*\\.amazonaws\\.com/maven/(.*)/");
+    static final Pattern CODE_ARTIFACT_PATTERN = Pattern.compile("https://([^.]*)-\\d+\\..*\\.amazonaws\\.com/maven/([^/]*)/?");
     private static final String DOT_JAR = ".jar";
     private static final String DOT_POM = ".pom";
     private static final String DOT = ".";
@@ -54,6 +55,13 @@ public class BuildVerifyCommand implements Runnable {
     @CommandLine.Option(names = "--allowed-sources", defaultValue = "redhat,rebuilt", split = ",")
     Set<String> allowedSources;
 
+    /**
+     * groupId:artifactId[:version] coordinates of contaminants that are known to be acceptable. Any part may use * as a
+     * wildcard.
+     */
+    @CommandLine.Option(names = "--allowed-contaminant")
+    Set<String> allowedContaminants = Set.of();
+
     @CommandLine.Option(required = true, names = "--path")
     Path deploymentPath;
 
@@ -75,6 +83,12 @@ public class BuildVerifyCommand implements Runnable {
     @CommandLine.Option(names = "--build-id")
     String buildId;
 
+    /**
+     * Removes the built test artifacts, see {@link TestArtifacts}.
+     */
+    @CommandLine.Option(names = "--exclude-test-artifacts")
+    boolean excludeTestArtifacts;
+
     public BuildVerifyCommand(BeanManager beanManager,
             ResultsUpdater resultsUpdater) {
         this.beanManager = beanManager;
@@ -101,6 +115,11 @@ public class BuildVerifyCommand implements Runnable {
                 public FileVisitResult visitFile(Path file, BasicFileAttributes attrs) throws IOException {
                     Path path = deploymentPath.relativize(file);
                     String name = path.toString();
+                    if (excludeTestArtifacts && TestArtifacts.isTestArtifact(path.getFileName().toString())) {
+                        Log.infof("Removing test artifact %s", path.getFileName());
+                        Files.delete(file);
+                        return FileVisitResult.CONTINUE;
+                    }
                     Optional<GAV> gav = getGav(name);
                     if (gav.isPresent()) {
                         var coords = gav.get().stringForm();
@@ -115,7 +134,7 @@ public class BuildVerifyCommand implements Runnable {
                         Log.errorf("%s was contaminated by %s from %s", path.getFileName(), i.gav, i.source);
                         if (ALLOWED_CONTAMINANTS.stream().noneMatch(a -> file.getFileName().toString().endsWith(a))) {
                             int index = name.lastIndexOf("/");
-                            boolean allowed = allowedSources.contains(i.source);
+                            boolean allowed = allowedSources.contains(i.source) || isAllowedContaminant(i.gav);
                             if (!allowed) {
                                 if (index != -1) {
                                     contaminatedPaths.computeIfAbsent(name.substring(0, index),
@@ -273,6 +292,26 @@ public class BuildVerifyCommand implements Runnable {
         }
     }
 
+    boolean isAllowedContaminant(String gav) {
+        String[] parts = gav.split(":");
+        if (parts.length < 2) {
+            return false;
+        }
+        String coords = parts[0] + ":" + parts[1];
+        String fullCoords = coords + ":" + (parts.length > 2 ? parts[2] : "");
+        return allowedContaminants.stream().anyMatch(a -> wildcardPattern(a)
+                .matcher(a.split(":").length > 2 ? fullCoords : coords).matches());
+    }
+
+    static Pattern wildcardPattern(String wildcard) {
+        String[] parts = wildcard.split("\\*", -1);
+        StringBuilder sb = new StringBuilder(Pattern.quote(parts[0]));
+        for (int i = 1; i < parts.length; i++) {
+            sb.append(".*").append(Pattern.quote(parts[i]));
+        }
+        return Pattern.compile(sb.toString());
+    }
+
     private Optional<GAV> getGav(String entryName) {
         if (entryName.startsWith("." + File.separator)) {
             entryName = entryName.substring(2);
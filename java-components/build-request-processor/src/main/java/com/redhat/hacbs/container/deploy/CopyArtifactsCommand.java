@@ -17,6 +17,8 @@ import org.apache.maven.model.io.xpp3.MavenXpp3Reader;
 import org.apache.maven.project.MavenProject;
 import org.codehaus.plexus.util.xml.pull.XmlPullParserException;
 
+import com.redhat.hacbs.container.verifier.TestArtifacts;
+
 import io.quarkus.logging.Log;
 import picocli.CommandLine.Command;
 import picocli.CommandLine.Option;
@@ -29,6 +31,9 @@ public class CopyArtifactsCommand implements Runnable {
     @Option(required = true, names = {"-d", "--deploy-path"})
     Path deployPath;
 
+    @Option(names = "--exclude-test-artifacts")
+    boolean excludeTestArtifacts;
+
     @Override
     public void run() {
         if (Files.isDirectory(deployPath)) {
@@ -71,7 +76,7 @@ public class CopyArtifactsCommand implements Runnable {
                         } catch (IOException | XmlPullParserException e) {
                             throw new RuntimeException(e);
                         }
-                    } else if (fileName.endsWith(".jar")) {
+                    } else if (fileName.endsWith(".jar") && !(excludeTestArtifacts && TestArtifacts.isTestArtifact(fileName))) {
                         jarFiles.add(path);
                     }
                 }
@@ -5,12 +5,15 @@ import static org.apache.commons.lang3.ObjectUtils.isNotEmpty;
 
 import java.net.URL;
 import java.nio.file.Path;
+import java.util.List;
+import java.util.Map;
 import java.util.Optional;
 import java.util.regex.Matcher;
 import java.util.regex.Pattern;
 
 import jakarta.inject.Inject;
 
+import org.eclipse.microprofile.config.ConfigProvider;
 import org.eclipse.microprofile.config.inject.ConfigProperty;
 
 import com.amazonaws.auth.DefaultAWSCredentialsProviderChain;
@@ -30,7 +33,7 @@ import picocli.CommandLine;
 @CommandLine.Command(name = "deploy")
 public class TagDeployCommand implements Runnable {
 
-    private static final Pattern CODE_ARTIFACT_PATTERN = Pattern.compile("https://([^.]*)-\\d+\\..*\\.amazonaws\\.com/maven/(.*)/");
+    private static final Pattern CODE_ARTIFACT_PATTERN = Pattern.compile("https://([^.]*)-\\d+\\..*\\.amazonaws\\.com/maven/([^/]*)/?");
 
     @CommandLine.Option(names = "--directory")
     String artifactDirectory;
@@ -45,8 +48,25 @@ public class TagDeployCommand implements Runnable {
     @ConfigProperty(name = "aws.profile")
     Optional<String> awsProfile;
 
+    // May be specified several times to deploy to more than one repository
     @CommandLine.Option(names = "--mvn-repo")
-    String mvnRepo;
+    List<String> mvnRepo = List.of();
+
+    // Per repository usernames keyed by the index of the --mvn-repo they apply to. Repositories without one use
+    // --mvn-username.
+    @CommandLine.Option(names = "--mvn-repo-username")
+    Map<Integer, String> mvnRepoUsers = Map.of();
+
+    @CommandLine.Option(names = "--consistent-snapshot-timestamp")
+    boolean consistentSnapshotTimestamp;
+
+    // Some repositories require artifacts from different groups to be deployed separately
+    @CommandLine.Option(names = "--partition-by-group-id")
+    boolean partitionByGroupId;
+
+    // Regenerates the maven-metadata.xml of each deployed artifact from the versions already in the repository
+    @CommandLine.Option(names = "--regenerate-metadata")
+    boolean regenerateMetadata;
 
     @ConfigProperty(name = "git.deploy.token")
     Optional<String> gitToken;
@@ -105,53 +125,75 @@ public class TagDeployCommand implements Runnable {
                 Log.warnf("No deployed artifacts found. Has the build been correctly configured to deploy?");
                 throw new RuntimeException("Deploy failed");
             }
-            CodeArtifactRepository codeArtifactRepository = null;
-            if (isNotEmpty(mvnRepo) && mvnPassword.isEmpty()) {
-                Log.infof("Maven repository specified as %s and no password specified", mvnRepo);
-                URL url = new URL(mvnRepo);
-                String repo = url.getHost();
-                // This is special handling for AWS CodeArtifact. It will automatically retrieve a token
-                // (which normally only last up to 12 hours). Token information will be retrieved from
-                // the AWS configuration which will utilise the configuration file and/or scan environment
-                // variables such as AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_PROFILE
-                if (repo.endsWith(".amazonaws.com")) {
-                    if (isEmpty(mvnUser)) {
-                        Log.warnf("Username for deployment is empty");
-                    }
-                    Matcher matcher = CODE_ARTIFACT_PATTERN.matcher(mvnRepo);
-                    if (matcher.matches()) {
-                        var mr = matcher.toMatchResult();
-                        int firstDash = repo.indexOf("-");
-                        String parsedRegion = AwsHostNameUtils.parseRegion(repo, null);
-                        String domain = repo.substring(0, firstDash);
-                        String domainOwner = repo.substring(firstDash + 1, repo.indexOf("."));
-                        Log.infof("Generating AWS token for domain %s, owner %s, region %s", domain, domainOwner, parsedRegion);
-
-                        Regions region = Regions.fromName(parsedRegion);
-                        var awsClient = AWSCodeArtifactClientBuilder.standard()
-                                .withCredentials(awsProfile.isEmpty() ? DefaultAWSCredentialsProviderChain.getInstance()
-                                        : new ProfileCredentialsProvider(awsProfile.get()))
-                                .withRegion(region).build();
-                        mvnPassword = Optional.of(awsClient.getAuthorizationToken(
-                                new GetAuthorizationTokenRequest().withDomain(domain).withDomainOwner(domainOwner))
-                                .getAuthorizationToken());
-                        codeArtifactRepository = new CodeArtifactRepository(awsClient, mr.group(1), mr.group(2));
-                    } else {
-                        Log.errorf("Unable to parse AWS CodeArtifact URL: %s", mvnRepo);
-                    }
+            for (int i = 0; i < mvnRepo.size(); i++) {
+                if (isNotEmpty(mvnRepo.get(i))) {
+                    deploy(deploymentPath, mvnRepo.get(i), mvnRepoUsers.getOrDefault(i, mvnUser), repositoryPassword(i));
                 }
             }
 
-            if (isNotEmpty(mvnRepo)) {
-                // Maven Repo Deployment
-                MavenRepositoryDeployer deployer = new MavenRepositoryDeployer(mvnCtx, mvnUser, mvnPassword.orElse(""), mvnRepo,
-                    deploymentPath, codeArtifactRepository);
-                deployer.deploy();
-            }
-
         } catch (Exception e) {
             Log.error("Deployment failed", e);
             throw new RuntimeException(e);
         }
     }
+
+    /**
+     * The password for the repository at the given index. This is read from MAVEN_PASSWORD_&lt;index&gt; if
+     * the repository has its own credentials, otherwise the shared MAVEN_PASSWORD is used.
+     */
+    Optional<String> repositoryPassword(int index) {
+        var password = ConfigProvider.getConfig().getOptionalValue("maven.password." + index, String.class);
+        return password.isPresent() ? password : mvnPassword;
+    }
+
+    private void deploy(Path deploymentPath, String repository, String user, Optional<String> password) throws Exception {
+        CodeArtifactRepository codeArtifactRepository = null;
+        if (password.isEmpty()) {
+            Log.infof("Maven repository specified as %s and no password specified", repository);
+            URL url = new URL(repository);
+            String repo = url.getHost();
+            // This is special handling for AWS CodeArtifact. It will automatically retrieve a token
+            // (which normally only last up to 12 hours). Token information will be retrieved from
+            // the AWS configuration which will utilise the configuration file and/or scan environment
+            // variables such as AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_PROFILE
+            if (repo.endsWith(".amazonaws.com")) {
+                if (isEmpty(user)) {
+                    Log.warnf("Username for deployment is empty");
+                }
+                Matcher matcher = CODE_ARTIFACT_PATTERN.matcher(repository);
+                if (matcher.matches()) {
+                    var mr = matcher.toMatchResult();
+                    int firstDash = repo.indexOf("-");
+                    String parsedRegion = AwsHostNameUtils.parseRegion(repo, null);
+                    String domain = repo.substring(0, firstDash);
+                    String domainOwner = repo.substring(firstDash + 1, repo.indexOf("."));
+                    Log.infof("Generating AWS token for domain %s, owner %s, region %s", domain, domainOwner, parsedRegion);
+
+                    Regions region = Regions.fromName(parsedRegion);
+                    var awsClient = AWSCodeArtifactClientBuilder.standard()
+                            .withCredentials(awsProfile.isEmpty() ? DefaultAWSCredentialsProviderChain.getInstance()
+                                    : new ProfileCredentialsProvider(awsProfile.get()))
+                            .withRegion(region).build();
+                    password = Optional.of(awsClient.getAuthorizationToken(
+                            new GetAuthorizationTokenRequest().withDomain(domain).withDomainOwner(domainOwner))
+                            .getAuthorizationToken());
+                    codeArtifactRepository = new CodeArtifactRepository(awsClient, mr.group(1), mr.group(2));
+                } else {
+                    Log.errorf("Unable to parse AWS CodeArtifact URL: %s", repository);
+                }
+            }
+        }
+
+        // Maven Repo Deployment
+        MavenRepositoryDeployer deployer = new MavenRepositoryDeployer(mvnCtx, user, password.orElse(""), repository,
+                deploymentPath, codeArtifactRepository, consistentSnapshotTimestamp, regenerateMetadata);
+        if (partitionByGroupId) {
+            for (var group : MavenRepositoryDeployer.groupIds(deploymentPath)) {
+                Log.infof("Deploying group %s to %s", group, repository);
+                deployer.deploy(group);
+            }
+        } else {
+            deployer.deploy();
+        }
+    }
 }
@@ -7,19 +7,35 @@ import java.nio.file.Files;
 import java.nio.file.Path;
 import java.nio.file.SimpleFileVisitor;
 import java.nio.file.attribute.BasicFileAttributes;
+import java.util.ArrayList;
+import java.util.Comparator;
+import java.util.Date;
 import java.util.List;
+import java.util.Map;
+import java.util.Set;
+import java.util.TreeMap;
+import java.util.TreeSet;
 import java.util.regex.Matcher;
 import java.util.regex.Pattern;
 
+import org.apache.maven.artifact.repository.metadata.Metadata;
+import org.apache.maven.artifact.repository.metadata.Versioning;
+import org.apache.maven.artifact.repository.metadata.io.xpp3.MetadataXpp3Reader;
+import org.apache.maven.artifact.repository.metadata.io.xpp3.MetadataXpp3Writer;
+import org.apache.maven.artifact.versioning.ComparableVersion;
 import org.apache.maven.repository.internal.MavenRepositorySystemUtils;
+import org.codehaus.plexus.util.xml.pull.XmlPullParserException;
 import org.eclipse.aether.DefaultRepositorySystemSession;
 import org.eclipse.aether.RepositorySystem;
 import org.eclipse.aether.artifact.Artifact;
 import org.eclipse.aether.artifact.DefaultArtifact;
 import org.eclipse.aether.deployment.DeployRequest;
 import org.eclipse.aether.deployment.DeploymentException;
+import org.eclipse.aether.metadata.DefaultMetadata;
 import org.eclipse.aether.repository.LocalRepository;
 import org.eclipse.aether.repository.RemoteRepository;
+import org.eclipse.aether.repository.RepositoryPolicy;
+import org.eclipse.aether.resolution.MetadataRequest;
 import org.eclipse.aether.util.repository.AuthenticationBuilder;
 
 import com.amazonaws.services.codeartifact.model.DeletePackageVersionsRequest;
@@ -32,6 +48,8 @@ import io.quarkus.bootstrap.resolver.maven.BootstrapMavenException;
 import io.quarkus.logging.Log;
 
 public class MavenRepositoryDeployer {
+    private static final String MAVEN_METADATA = "maven-metadata.xml";
+
     private final String username;
 
     private final String password;
@@ -46,13 +64,17 @@ public class MavenRepositoryDeployer {
 
     private final CodeArtifactRepository codeArtifactRepository;
 
+    private final boolean regenerateMetadata;
+
     public MavenRepositoryDeployer(BootstrapMavenContext mvnCtx, String username, String password, String repository,
-            Path artifacts, CodeArtifactRepository codeArtifactRepository)
+            Path artifacts, CodeArtifactRepository codeArtifactRepository, boolean consistentSnapshotTimestamp,
+            boolean regenerateMetadata)
             throws BootstrapMavenException {
         this.username = username;
         this.password = password;
         this.repository = repository;
         this.artifacts = artifacts;
+        this.regenerateMetadata = regenerateMetadata;
 
         this.system = mvnCtx.getRepositorySystem();
         this.codeArtifactRepository = codeArtifactRepository;
@@ -62,16 +84,50 @@ public class MavenRepositoryDeployer {
 
         // https://maven.apache.org/resolver/third-party-integrations.html states a local repository manager should be added.
         session.setLocalRepositoryManager(system.newLocalRepositoryManager(session, new LocalRepository(artifacts.toFile())));
+
+        if (consistentSnapshotTimestamp) {
+            // The remote snapshot metadata generator uses this as the unique version timestamp, so fixing it ensures
+            // all SNAPSHOT artifacts within this deployment share the same timestamp.
+            Date timestamp = new Date();
+            Log.infof("Using consistent SNAPSHOT timestamp %s", timestamp);
+            session.setConfigProperty("maven.startTime", timestamp);
+        }
+    }
+
+    /**
+     * Returns the groupIds of the artifacts in the given directory, sorted.
+     */
+    public static Set<String> groupIds(Path artifacts) throws IOException {
+        Set<String> groups = new TreeSet<>();
+        try (var stream = Files.walk(artifacts)) {
+            stream.filter(p -> p.toString().endsWith(".pom")).forEach(p -> {
+                Path relative = artifacts.relativize(p.getParent());
+                if (relative.getNameCount() >= 3) {
+                    groups.add(relative.getParent().getParent().toString().replace(File.separatorChar, '.'));
+                }
+            });
+        }
+        return groups;
     }
 
     public void deploy()
             throws IOException {
+        deploy(null);
+    }
+
+    /**
+     * Deploys the artifacts with the given groupId, or all artifacts if it is null.
+     */
+    public void deploy(String groupId)
+            throws IOException {
         RemoteRepository distRepo = new RemoteRepository.Builder("repo",
                 "default",
                 repository)
                 .setAuthentication(new AuthenticationBuilder().addUsername(username)
                         .addPassword(password).build())
                 .build();
+        // The versions deployed for each groupId:artifactId, for regenerating the metadata
+        Map<String, Set<String>> deployed = new TreeMap<>();
 
         Files.walkFileTree(artifacts,
                 new SimpleFileVisitor<>() {
@@ -87,6 +143,9 @@ public class MavenRepositoryDeployer {
                                 Path relative = artifacts.relativize(dir);
                                 String group = relative.getParent().getParent().toString().replace(File.separatorChar,
                                         '.');
+                                if (groupId != null && !groupId.equals(group)) {
+                                    return FileVisitResult.CONTINUE;
+                                }
                                 String artifact = relative.getParent().getFileName().toString();
                                 String version = dir.getFileName().toString();
                                 Log.info(
@@ -133,6 +192,7 @@ public class MavenRepositoryDeployer {
                                 } catch (DeploymentException e) {
                                     throw new RuntimeException(e);
                                 }
+                                deployed.computeIfAbsent(group + ":" + artifact, k -> new TreeSet<>()).add(version);
                             } else {
                                 if (files.stream().anyMatch(p -> !p.toFile().isDirectory())) {
                                     Log.warnf("For directory %s, no pom file found with files %s", dir,
@@ -145,6 +205,65 @@ public class MavenRepositoryDeployer {
                     }
 
                 });
+        if (regenerateMetadata) {
+            for (var entry : deployed.entrySet()) {
+                var ga = entry.getKey().split(":");
+                regenerateMetadata(distRepo, ga[0], ga[1], entry.getValue());
+            }
+        }
+    }
+
+    /**
+     * Merges the deployed versions into the maven-metadata.xml of the artifact in the repository and deploys it, so
+     * the version list stays correct across incremental deploys.
+     */
+    private void regenerateMetadata(RemoteRepository distRepo, String group, String artifact, Set<String> versions)
+            throws IOException {
+        // Always fetch the current metadata rather than any copy resolved earlier
+        RemoteRepository repo = new RemoteRepository.Builder(distRepo)
+                .setPolicy(new RepositoryPolicy(true, RepositoryPolicy.UPDATE_POLICY_ALWAYS,
+                        RepositoryPolicy.CHECKSUM_POLICY_WARN))
+                .build();
+        var metadata = new DefaultMetadata(group, artifact, MAVEN_METADATA,
+                org.eclipse.aether.metadata.Metadata.Nature.RELEASE_OR_SNAPSHOT);
+        var result = system.resolveMetadata(session, List.of(new MetadataRequest(metadata, repo, null))).get(0);
+        Metadata model = new Metadata();
+        if (result.isResolved() && result.getMetadata().getFile() != null) {
+            try (var in = Files.newInputStream(result.getMetadata().getFile().toPath())) {
+                model = new MetadataXpp3Reader().read(in);
+            } catch (XmlPullParserException e) {
+                Log.warnf(e, "Unable to parse the existing metadata of %s:%s, regenerating it", group, artifact);
+                model = new Metadata();
+            }
+        }
+        model.setGroupId(group);
+        model.setArtifactId(artifact);
+        Versioning versioning = model.getVersioning() == null ? new Versioning() : model.getVersioning();
+        Set<String> all = new TreeSet<>(Comparator.comparing(ComparableVersion::new));
+        all.addAll(versioning.getVersions());
+        all.addAll(versions);
+        List<String> sorted = new ArrayList<>(all);
+        versioning.setVersions(sorted);
+        versioning.setLatest(sorted.get(sorted.size() - 1));
+        sorted.stream().filter(v -> !v.endsWith("-SNAPSHOT")).reduce((a, b) -> b).ifPresent(versioning::setRelease);
+        versioning.updateTimestamp();
+        model.setVersioning(versioning);
+
+        Path file = Files.createTempFile(artifact, MAVEN_METADATA);
+        try {
+            try (var out = Files.newOutputStream(file)) {
+                new MetadataXpp3Writer().write(out, model);
+            }
+            DeployRequest deployRequest = new DeployRequest();
+            deployRequest.setRepository(distRepo);
+            deployRequest.addMetadata(metadata.setFile(file.toFile()));
+            Log.infof("Deploying regenerated metadata of %s:%s with versions %s", group, artifact, sorted);
+            system.deploy(session, deployRequest);
+        } catch (DeploymentException e) {
+            throw new RuntimeException(e);
+        } finally {
+            Files.deleteIfExists(file);
+        }
     }
 
     private void handleThrottling(Runnable task) {
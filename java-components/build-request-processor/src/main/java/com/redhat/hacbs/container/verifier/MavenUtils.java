@@ -137,7 +137,12 @@ public class MavenUtils {
 
     public static Optional<Path> downloadFile(URI uri) throws IOException {
         if (uri.getScheme().equals("file")) {
-            return Optional.of(Path.of(uri));
+            var path = Path.of(uri);
+            if (!Files.exists(path)) {
+                Log.debugf("File %s does not exist", path);
+                return Optional.empty();
+            }
+            return Optional.of(path);
         }
         try (var client = HttpClientBuilder.create().build()) {
             Log.debugf("Getting URL %s", uri);
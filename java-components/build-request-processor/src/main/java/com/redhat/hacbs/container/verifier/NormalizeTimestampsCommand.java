@@ -0,0 +1,125 @@
+package com.redhat.hacbs.container.verifier;
+
+import java.io.IOException;
+import java.io.InputStream;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.nio.file.StandardCopyOption;
+import java.security.MessageDigest;
+import java.security.NoSuchAlgorithmException;
+import java.time.LocalDateTime;
+import java.time.ZoneOffset;
+import java.util.HexFormat;
+import java.util.List;
+import java.util.Map;
+import java.util.jar.Attributes;
+import java.util.jar.JarFile;
+import java.util.jar.Manifest;
+import java.util.stream.Stream;
+import java.util.zip.ZipEntry;
+import java.util.zip.ZipFile;
+import java.util.zip.ZipOutputStream;
+
+import io.quarkus.logging.Log;
+import picocli.CommandLine;
+
+/**
+ * Normalizes the timestamps that plugins embed in the built archives despite SOURCE_DATE_EPOCH, so they do not break
+ * the reproducibility of the build. The entry times are set to the given time and the manifest attributes that record
+ * when the archive was built are removed. The checksum files of a changed archive are updated to match.
+ */
+@CommandLine.Command(name = "normalize-timestamps")
+public class NormalizeTimestampsCommand implements Runnable {
+
+    /**
+     * Manifest attributes known to hold the time of the build.
+     */
+    static final List<String> TIMESTAMP_ATTRIBUTES = List.of("Build-Time", "Build-Date", "Build-Timestamp",
+            "Bnd-LastModified");
+
+    /**
+     * The earliest time a zip entry can hold without an extended timestamp.
+     */
+    static final LocalDateTime EARLIEST_TIME = LocalDateTime.of(1980, 1, 1, 0, 0);
+
+    static final Map<String, String> CHECKSUMS = Map.of(".md5", "MD5", ".sha1", "SHA-1", ".sha256", "SHA-256", ".sha512",
+            "SHA-512");
+
+    @CommandLine.Option(required = true, names = "--path")
+    Path path;
+
+    /**
+     * The time in seconds since the epoch the entries are set to, e.g. the commit time.
+     */
+    @CommandLine.Option(names = "--timestamp")
+    long timestamp;
+
+    @Override
+    public void run() {
+        try (Stream<Path> files = Files.walk(path)) {
+            for (var archive : files.filter(NormalizeTimestampsCommand::isArchive).toList()) {
+                normalize(archive);
+                updateChecksums(archive);
+                Log.infof("Normalized the timestamps of %s", path.relativize(archive));
+            }
+        } catch (IOException e) {
+            throw new RuntimeException(e);
+        }
+    }
+
+    static boolean isArchive(Path file) {
+        String name = file.getFileName().toString();
+        return name.endsWith(".jar") || name.endsWith(".war") || name.endsWith(".ear");
+    }
+
+    LocalDateTime entryTime() {
+        var time = LocalDateTime.ofEpochSecond(timestamp, 0, ZoneOffset.UTC);
+        return time.isBefore(EARLIEST_TIME) ? EARLIEST_TIME : time;
+    }
+
+    /**
+     * Rewrites the archive with the entries in the same order, but with normalized times and manifest.
+     */
+    void normalize(Path archive) throws IOException {
+        var time = entryTime();
+        Path normalized = Files.createTempFile(archive.getParent(), archive.getFileName().toString(), ".tmp");
+        try (ZipFile in = new ZipFile(archive.toFile());
+                ZipOutputStream out = new ZipOutputStream(Files.newOutputStream(normalized))) {
+            for (var entry : in.stream().toList()) {
+                // The extra fields are dropped as they may hold further timestamps
+                ZipEntry copy = new ZipEntry(entry.getName());
+                copy.setTimeLocal(time);
+                copy.setComment(entry.getComment());
+                out.putNextEntry(copy);
+                try (InputStream data = in.getInputStream(entry)) {
+                    if (entry.getName().equals(JarFile.MANIFEST_NAME)) {
+                        Manifest manifest = new Manifest(data);
+                        TIMESTAMP_ATTRIBUTES.forEach(i -> manifest.getMainAttributes().remove(new Attributes.Name(i)));
+                        manifest.write(out);
+                    } else {
+                        data.transferTo(out);
+                    }
+                }
+                out.closeEntry();
+            }
+        }
+        Files.move(normalized, archive, StandardCopyOption.REPLACE_EXISTING);
+    }
+
+    /**
+     * Updates any checksum files of the archive, as deployed by Maven alongside it.
+     */
+    static void updateChecksums(Path archive) throws IOException {
+        for (var checksum : CHECKSUMS.entrySet()) {
+            Path file = archive.resolveSibling(archive.getFileName() + checksum.getKey());
+            if (Files.exists(file)) {
+                try {
+                    var digest = MessageDigest.getInstance(checksum.getValue()).digest(Files.readAllBytes(archive));
+                    Files.writeString(file, HexFormat.of().formatHex(digest));
+                } catch (NoSuchAlgorithmException e) {
+                    throw new RuntimeException(e);
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,27 @@
+package com.redhat.hacbs.container.verifier;
+
+import static org.apache.commons.lang3.StringUtils.endsWithAny;
+
+/**
+ * Recognises the built test jars and their sources and javadoc, along with their checksums and signatures, so they can
+ * be excluded from verification and deployment. The scope of an artifact is not known so this goes by the file name.
+ */
+public final class TestArtifacts {
+    private static final String[] CHECKSUM_EXTENSIONS = { ".md5", ".sha1", ".sha256", ".sha512", ".asc" };
+
+    private static final String[] TEST_SUFFIXES = { "-tests.jar", "-test-sources.jar", "-test-javadoc.jar" };
+
+    private TestArtifacts() {
+
+    }
+
+    public static boolean isTestArtifact(String fileName) {
+        var name = fileName;
+
+        while (endsWithAny(name, CHECKSUM_EXTENSIONS)) {
+            name = name.substring(0, name.lastIndexOf('.'));
+        }
+
+        return endsWithAny(name, TEST_SUFFIXES);
+    }
+}
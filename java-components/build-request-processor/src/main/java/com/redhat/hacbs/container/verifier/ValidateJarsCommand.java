@@ -0,0 +1,90 @@
+package com.redhat.hacbs.container.verifier;
+
+import java.io.IOException;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.util.ArrayList;
+import java.util.List;
+import java.util.jar.Attributes;
+import java.util.jar.JarEntry;
+import java.util.jar.JarFile;
+import java.util.jar.Manifest;
+import java.util.regex.Pattern;
+import java.util.stream.Stream;
+
+import io.quarkus.logging.Log;
+import picocli.CommandLine;
+
+/**
+ * Checks that every built jar has the structural properties required by the consumers of the build, e.g. that they
+ * are all multi-release jars or all contain a module descriptor.
+ */
+@CommandLine.Command(name = "validate-jars")
+public class ValidateJarsCommand implements Runnable {
+
+    static final String MODULE_INFO = "module-info.class";
+    static final Pattern MODULE_INFO_PATTERN = Pattern.compile("(META-INF/versions/\\d+/)?module-info\\.class");
+
+    @CommandLine.Option(required = true, names = "--path")
+    Path path;
+
+    @CommandLine.Option(names = "--require-multi-release")
+    boolean requireMultiRelease;
+
+    @CommandLine.Option(names = "--require-module-info")
+    boolean requireModuleInfo;
+
+    @CommandLine.Option(names = "--require-manifest-attribute")
+    List<String> requiredManifestAttributes = List.of();
+
+    @Override
+    public void run() {
+        List<String> violations = new ArrayList<>();
+        try (Stream<Path> files = Files.walk(path)) {
+            for (var jar : files.filter(ValidateJarsCommand::isValidatedJar).toList()) {
+                for (var violation : validate(jar)) {
+                    violations.add(path.relativize(jar) + ": " + violation);
+                }
+            }
+        } catch (IOException e) {
+            throw new RuntimeException(e);
+        }
+        if (!violations.isEmpty()) {
+            violations.forEach(Log::error);
+            throw new RuntimeException("Jar validation failed with " + violations.size() + " violation(s)");
+        }
+        Log.infof("All jars in %s passed validation", path);
+    }
+
+    static boolean isValidatedJar(Path file) {
+        String name = file.getFileName().toString();
+        return name.endsWith(".jar") && !name.endsWith("-sources.jar") && !name.endsWith("-javadoc.jar");
+    }
+
+    /**
+     * Returns a description of each rule the given jar violates.
+     */
+    List<String> validate(Path jar) throws IOException {
+        List<String> violations = new ArrayList<>();
+        try (JarFile jarFile = new JarFile(jar.toFile())) {
+            Manifest manifest = jarFile.getManifest();
+            Attributes attributes = manifest == null ? new Attributes() : manifest.getMainAttributes();
+            if (requireMultiRelease && !"true".equalsIgnoreCase(attributes.getValue(Attributes.Name.MULTI_RELEASE))) {
+                violations.add("not a multi-release jar");
+            }
+            if (requireModuleInfo && jarFile.stream().map(JarEntry::getName).noneMatch(ValidateJarsCommand::isModuleInfo)) {
+                violations.add("no " + MODULE_INFO + " found");
+            }
+            for (var attribute : requiredManifestAttributes) {
+                if (attributes.getValue(attribute) == null) {
+                    violations.add("manifest attribute " + attribute + " is missing");
+                }
+            }
+        }
+        return violations;
+    }
+
+    static boolean isModuleInfo(String entry) {
+        return MODULE_INFO_PATTERN.matcher(entry).matches();
+    }
+}
@@ -17,6 +17,7 @@ import java.nio.file.attribute.BasicFileAttributes;
 import java.util.ArrayList;
 import java.util.HashMap;
 import java.util.Iterator;
+import java.util.LinkedHashMap;
 import java.util.LinkedHashSet;
 import java.util.List;
 import java.util.Map;
@@ -89,12 +90,44 @@ public class VerifyBuiltArtifactsCommand implements Callable<Integer> {
     @Option(names = { "--results-file" })
     Path resultsFile;
 
+    /**
+     * The format of the verification output, either text or json. If json the differences are written to the diff file.
+     */
+    @Option(names = { "--output-format" }, defaultValue = "text")
+    String outputFormat;
+
+    /**
+     * The file the differences for each artifact are written to as JSON when the output format is json.
+     */
+    @Option(names = { "--diff-file" })
+    Path diffFile;
+
+    /**
+     * The file a summary of the differences is written to when the output format is json. Unlike the diff file this has a
+     * bounded size, so it can be used as a task result.
+     */
+    @Option(names = { "--diff-summary-file" })
+    Path diffSummaryFile;
+
     @Option(names = { "-x", "--excludes" })
     Set<String> excludes = new LinkedHashSet<>();
 
     @Option(names = { "-e", "--excludes-file" })
     Path excludesFile;
 
+    /**
+     * Skips the built test artifacts, see {@link TestArtifacts}.
+     */
+    @Option(names = { "--exclude-test-artifacts" })
+    boolean excludeTestArtifacts;
+
+    /**
+     * Further repositories the artifacts are compared against, e.g. earlier rebuilds. The differences against each are
+     * only reported and do not affect the result.
+     */
+    @Option(names = { "--reference-repository-url" })
+    List<String> referenceRepositoryUrls = new ArrayList<>();
+
     @Option(names = { "--threads" }, defaultValue = "5")
     int threads;
     @Inject
@@ -130,6 +163,8 @@ public class VerifyBuiltArtifactsCommand implements Callable<Integer> {
                             try {
                                 if (endsWithAny(fileName, "-javadoc.jar", "-tests.jar", "-sources.jar")) {
                                     Log.debugf("Skipping file %s", file);
+                                } else if (excludeTestArtifacts && TestArtifacts.isTestArtifact(fileName)) {
+                                    Log.infof("Skipping test artifact %s", file);
                                 } else {
                                     var relativeFile = options.mavenOptions.deployPath.relativize(file);
                                     var coords = pathToCoords(relativeFile);
@@ -205,6 +240,20 @@ public class VerifyBuiltArtifactsCommand implements Callable<Integer> {
                     Log.errorf(ex, "Failed to write results");
                 }
             }
+            if (diffFile != null && "json".equals(outputFormat)) {
+                try {
+                    Files.writeString(diffFile, ResultsUpdater.MAPPER.writeValueAsString(verificationResults));
+                } catch (IOException ex) {
+                    Log.errorf(ex, "Failed to write verification differences");
+                }
+            }
+            if (diffSummaryFile != null && "json".equals(outputFormat)) {
+                try {
+                    Files.writeString(diffSummaryFile, diffSummary(verificationResults));
+                } catch (IOException ex) {
+                    Log.errorf(ex, "Failed to write verification difference summary");
+                }
+            }
             if (taskRunName != null) {
                 var json = ResultsUpdater.MAPPER.writeValueAsString(verificationResults);
                 Log.infof("Writing verification results %s", json);
@@ -273,6 +322,9 @@ public class VerifyBuiltArtifactsCommand implements Callable<Integer> {
             Log.infof("Verifying %s (%s, %s)", coords, upstreamFile.toAbsolutePath(), rebuiltFile.toAbsolutePath());
             var errors = handleJar(upstreamFile, rebuiltFile, excludes);
             Log.debugf("Verification of %s %s", coords, errors.isEmpty() ? "passed" : "failed");
+            for (var referenceRepositoryUrl : referenceRepositoryUrls) {
+                compareWithReference(referenceRepositoryUrl, rebuiltFile, relativeFile, coords, excludes);
+            }
             return errors;
         } catch (OutOfMemoryError e) {
             //HUGE hack, but some things are just too large to diff in memory
@@ -284,12 +336,50 @@ public class VerifyBuiltArtifactsCommand implements Callable<Integer> {
         }
     }
 
+    /**
+     * Reports the differences between the rebuilt file and the same artifact in a reference repository. A reference that
+     * can't be compared is only logged, as it does not affect the result.
+     */
+    private void compareWithReference(String referenceRepositoryUrl, Path rebuiltFile, Path relativeFile, String coords,
+            List<String> excludes) {
+        try {
+            var referenceFile = resolveArtifact(referenceRepositoryUrl, normalize(relativeFile.toString(), true));
+            if (referenceFile.isEmpty()) {
+                Log.warnf("Reference %s does not have %s", referenceRepositoryUrl, coords);
+                return;
+            }
+            var differences = handleJar(referenceFile.get(), rebuiltFile, excludes);
+            if (differences.isEmpty()) {
+                Log.infof("Reference %s has no differences for %s", referenceRepositoryUrl, coords);
+            } else {
+                Log.warnf("Reference %s differences for %s:\n%s", referenceRepositoryUrl, coords,
+                        String.join("\n", differences));
+            }
+        } catch (IOException e) {
+            Log.errorf(e, "Failed to compare %s with reference %s", coords, referenceRepositoryUrl);
+        }
+    }
+
     Optional<Path> resolveArtifact(String relativeFile) throws IOException {
-        var url = options.mavenOptions.repositoryUrl.endsWith("/") ? options.mavenOptions.repositoryUrl + relativeFile
-                : options.mavenOptions.repositoryUrl + "/" + relativeFile;
-        if (options.mavenOptions.repositoryUrl.startsWith("file")) {
+        return resolveArtifact(options.mavenOptions.repositoryUrl, relativeFile);
+    }
+
+    Optional<Path> resolveArtifact(String repositoryUrl, String relativeFile) throws IOException {
+        var url = repositoryUrl.endsWith("/") ? repositoryUrl + relativeFile : repositoryUrl + "/" + relativeFile;
+        if (repositoryUrl.startsWith("file")) {
             return downloadFile(URI.create(url));
         }
         return downloadFile(URI.create(url + "?upstream-only=true"));
     }
+
+    /**
+     * Summarises the differences as the number of artifacts that differ and the total number of differences. The size of
+     * the summary does not depend on the number of differences.
+     */
+    static String diffSummary(Map<String, List<String>> verificationResults) throws IOException {
+        Map<String, Integer> summary = new LinkedHashMap<>();
+        summary.put("artifacts", (int) verificationResults.values().stream().filter(r -> !r.isEmpty()).count());
+        summary.put("differences", verificationResults.values().stream().mapToInt(List::size).sum());
+        return ResultsUpdater.MAPPER.writeValueAsString(summary);
+    }
 }
@@ -0,0 +1,32 @@
+package com.redhat.hacbs.container.build.preprocessor.lein;
+
+import static org.assertj.core.api.Assertions.assertThat;
+
+import java.util.List;
+
+import org.junit.jupiter.api.Test;
+
+class LeinPrepareCommandTest {
+
+    private static final String PROJECT = """
+            (defproject foo "1.0.0"
+              :plugins [[lein-cljsbuild "1.1.8" :exclusions [org.clojure/clojure]]
+                        [lein-ancient "0.6.15"]
+                        [lein-cljsbuild-extra "1.0.0"]])
+            """;
+
+    @Test
+    void testRemovePlugins() {
+        var result = LeinPrepareCommand.removePlugins(PROJECT, List.of("lein-cljsbuild"));
+        assertThat(result).doesNotContain("[lein-cljsbuild \"1.1.8\"");
+        assertThat(result).doesNotContain("org.clojure/clojure");
+        assertThat(result).contains("[lein-ancient \"0.6.15\"]");
+        // Only the exact plugin name is removed
+        assertThat(result).contains("[lein-cljsbuild-extra \"1.0.0\"]");
+    }
+
+    @Test
+    void testNoMatchingPlugins() {
+        assertThat(LeinPrepareCommand.removePlugins(PROJECT, List.of("lein-other"))).isEqualTo(PROJECT);
+    }
+}
@@ -0,0 +1,52 @@
+package com.redhat.hacbs.container.deploy;
+
+import static org.assertj.core.api.Assertions.assertThat;
+
+import java.util.Set;
+
+import org.junit.jupiter.api.Test;
+
+class AllowedContaminantTest {
+
+    private static boolean allowed(String gav, String... allowedContaminants) {
+        BuildVerifyCommand command = new BuildVerifyCommand(null, null);
+        command.allowedContaminants = Set.of(allowedContaminants);
+        return command.isAllowedContaminant(gav);
+    }
+
+    @Test
+    void testNoAllowedContaminants() {
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final")).isFalse();
+    }
+
+    @Test
+    void testExactMatch() {
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:netty-common")).isTrue();
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:netty-common:4.1.100.Final")).isTrue();
+    }
+
+    @Test
+    void testGroupWildcard() {
+        assertThat(allowed("com.google.guava:guava:33.0.0-jre", "com.google.*:guava")).isTrue();
+        assertThat(allowed("com.google.guava:guava:33.0.0-jre", "*:guava")).isTrue();
+    }
+
+    @Test
+    void testArtifactWildcard() {
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:*")).isTrue();
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:netty-*")).isTrue();
+    }
+
+    @Test
+    void testVersionWildcard() {
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:netty-common:4.1.*")).isTrue();
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:netty-common:4.2.*")).isFalse();
+    }
+
+    @Test
+    void testNoMatch() {
+        assertThat(allowed("io.netty:netty-common:4.1.100.Final", "io.netty:netty-buffer", "org.netty:*")).isFalse();
+        // The wildcard is the only special character, so dots in the group must match literally
+        assertThat(allowed("ioxnetty:netty-common:4.1.100.Final", "io.netty:*")).isFalse();
+    }
+}
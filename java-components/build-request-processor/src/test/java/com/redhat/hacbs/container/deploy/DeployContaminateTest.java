@@ -110,6 +110,11 @@ public class DeployContaminateTest {
         Assertions.assertTrue(m.matches());
         Assertions.assertEquals("demo", m.group(1));
         Assertions.assertEquals("jbs-demo", m.group(2));
+        // The operator passes repository URLs without the trailing slash
+        m = BuildVerifyCommand.CODE_ARTIFACT_PATTERN
+                .matcher("https://demo-151537584421.d.codeartifact.us-east-1.amazonaws.com/maven/jbs-demo");
+        Assertions.assertTrue(m.matches());
+        Assertions.assertEquals("jbs-demo", m.group(2));
     }
 
     private Path createDeploymentRepo()
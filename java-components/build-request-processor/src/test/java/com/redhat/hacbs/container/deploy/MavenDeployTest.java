@@ -23,6 +23,7 @@ import org.apache.maven.model.io.xpp3.MavenXpp3Writer;
 import org.junit.jupiter.api.BeforeEach;
 import org.junit.jupiter.api.Test;
 
+import com.redhat.hacbs.container.deploy.mavenrepository.MavenRepositoryDeployer;
 import com.redhat.hacbs.resources.util.HashUtil;
 
 import io.quarkus.bootstrap.resolver.maven.BootstrapMavenContext;
@@ -64,7 +65,7 @@ public class MavenDeployTest {
         TagDeployCommand deployCommand = new TagDeployCommand();
         deployCommand.mvnCtx = mvnContext;
         deployCommand.mvnPassword = Optional.empty();
-        deployCommand.mvnRepo = deployment.toAbsolutePath().toUri().toString();
+        deployCommand.mvnRepo = List.of(deployment.toAbsolutePath().toUri().toString());
         deployCommand.artifactDirectory = onDiskRepo.toString();
 
         deployCommand.run();
@@ -80,6 +81,57 @@ public class MavenDeployTest {
         assertEquals(9, files.length);
     }
 
+    @Test
+    public void testDeployToMultipleRepositories() throws IOException {
+        Path onDiskRepo = createDeploymentRepo();
+        Path releases = Files.createTempDirectory("releases");
+        Path snapshots = Files.createTempDirectory("snapshots");
+
+        TagDeployCommand deployCommand = new TagDeployCommand();
+        deployCommand.mvnCtx = mvnContext;
+        deployCommand.mvnPassword = Optional.empty();
+        deployCommand.mvnRepo = List.of(releases.toAbsolutePath().toUri().toString(),
+                snapshots.toAbsolutePath().toUri().toString());
+        deployCommand.artifactDirectory = onDiskRepo.toString();
+
+        deployCommand.run();
+        for (Path repo : List.of(releases, snapshots)) {
+            File[] files = Paths.get(repo.toString(), "com/company/foo/foo-bar/3.25.8").toFile().listFiles();
+            assertNotNull(files);
+            assertEquals(9, files.length);
+        }
+    }
+
+    @Test
+    public void testDeployPartitionedByGroupId() throws IOException {
+        Path onDiskRepo = createDeploymentRepo();
+        // Add a second group alongside com.company.foo
+        Path other = onDiskRepo.resolve("org/other/other-lib/1.0");
+        Files.createDirectories(other);
+        Files.writeString(other.resolve("other-lib-1.0.pom"), "<project/>");
+        Files.writeString(other.resolve("other-lib-1.0.pom.sha1"), HashUtil.sha1("<project/>"));
+        assertEquals(Set.of(GROUP, "org.other"), MavenRepositoryDeployer.groupIds(onDiskRepo));
+        Path deployment = Files.createTempDirectory("deployment");
+
+        TagDeployCommand deployCommand = new TagDeployCommand();
+        deployCommand.mvnCtx = mvnContext;
+        deployCommand.mvnPassword = Optional.empty();
+        deployCommand.mvnRepo = List.of(deployment.toAbsolutePath().toUri().toString());
+        deployCommand.artifactDirectory = onDiskRepo.toString();
+        deployCommand.partitionByGroupId = true;
+
+        deployCommand.run();
+        List<LogRecord> logRecords = LogCollectingTestResource.current().getRecords();
+        for (String group : List.of(GROUP, "org.other")) {
+            assertTrue(logRecords.stream().anyMatch(r -> LogCollectingTestResource.format(r)
+                    .startsWith("Deploying group " + group + " to ")));
+        }
+        assertTrue(Files.exists(deployment.resolve("org/other/other-lib/1.0/other-lib-1.0.pom")));
+        File[] files = Paths.get(deployment.toString(), "com/company/foo/foo-bar/3.25.8").toFile().listFiles();
+        assertNotNull(files);
+        assertEquals(9, files.length);
+    }
+
     private Path createDeploymentRepo()
             throws IOException {
         Path testData = Files.createTempDirectory("test-data");
@@ -0,0 +1,94 @@
+package com.redhat.hacbs.container.verifier;
+
+import static org.assertj.core.api.Assertions.assertThat;
+
+import java.io.IOException;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.security.MessageDigest;
+import java.security.NoSuchAlgorithmException;
+import java.time.LocalDateTime;
+import java.util.HexFormat;
+import java.util.jar.Attributes;
+import java.util.jar.JarFile;
+import java.util.jar.JarOutputStream;
+import java.util.jar.Manifest;
+import java.util.zip.ZipEntry;
+
+import org.junit.jupiter.api.Test;
+import org.junit.jupiter.api.io.TempDir;
+
+class NormalizeTimestampsCommandTest {
+
+    @TempDir
+    Path temp;
+
+    private Path jar(String name) throws IOException {
+        Manifest manifest = new Manifest();
+        manifest.getMainAttributes().put(Attributes.Name.MANIFEST_VERSION, "1.0");
+        manifest.getMainAttributes().putValue("Build-Time", "2024-05-01T10:15:30Z");
+        manifest.getMainAttributes().putValue("Bnd-LastModified", "1714558530000");
+        manifest.getMainAttributes().putValue("Implementation-Version", "1.0");
+        Path jar = temp.resolve(name);
+        Files.createDirectories(jar.getParent());
+        try (JarOutputStream out = new JarOutputStream(Files.newOutputStream(jar), manifest)) {
+            out.putNextEntry(new ZipEntry("Foo.class"));
+            out.write(new byte[] { 1, 2, 3 });
+            out.closeEntry();
+        }
+        return jar;
+    }
+
+    private NormalizeTimestampsCommand command(long timestamp) {
+        var command = new NormalizeTimestampsCommand();
+        command.path = temp;
+        command.timestamp = timestamp;
+        return command;
+    }
+
+    @Test
+    void testNormalize() throws IOException {
+        Path jar = jar("com/acme/foo/1.0/foo-1.0.jar");
+        command(1700000000).run();
+        try (JarFile file = new JarFile(jar.toFile())) {
+            var attributes = file.getManifest().getMainAttributes();
+            assertThat(attributes.getValue("Build-Time")).isNull();
+            assertThat(attributes.getValue("Bnd-LastModified")).isNull();
+            assertThat(attributes.getValue("Implementation-Version")).isEqualTo("1.0");
+            assertThat(file.stream().map(ZipEntry::getTimeLocal))
+                    .containsOnly(LocalDateTime.of(2023, 11, 14, 22, 13, 20));
+            assertThat(file.getInputStream(file.getEntry("Foo.class")).readAllBytes()).containsExactly(1, 2, 3);
+        }
+    }
+
+    @Test
+    void testTimeBeforeZipEpoch() throws IOException {
+        Path jar = jar("foo.jar");
+        command(0).run();
+        try (JarFile file = new JarFile(jar.toFile())) {
+            assertThat(file.stream().map(ZipEntry::getTimeLocal)).containsOnly(NormalizeTimestampsCommand.EARLIEST_TIME);
+        }
+    }
+
+    @Test
+    void testChecksumsUpdated() throws IOException, NoSuchAlgorithmException {
+        Path jar = jar("foo.jar");
+        Path sha1 = temp.resolve("foo.jar.sha1");
+        Files.writeString(sha1, "stale");
+        command(1700000000).run();
+        var digest = MessageDigest.getInstance("SHA-1").digest(Files.readAllBytes(jar));
+        assertThat(Files.readString(sha1)).isEqualTo(HexFormat.of().formatHex(digest));
+        assertThat(temp.resolve("foo.jar.md5")).doesNotExist();
+    }
+
+    @Test
+    void testReproducible() throws IOException {
+        Path first = jar("first/foo.jar");
+        command(1700000000).run();
+        byte[] normalized = Files.readAllBytes(first);
+        Files.delete(first);
+        Path second = jar("second/foo.jar");
+        command(1700000000).run();
+        assertThat(Files.readAllBytes(second)).isEqualTo(normalized);
+    }
+}
@@ -0,0 +1,20 @@
+package com.redhat.hacbs.container.verifier;
+
+import static org.assertj.core.api.Assertions.assertThat;
+
+import org.junit.jupiter.api.Test;
+
+class TestArtifactsTest {
+
+    @Test
+    void testIsTestArtifact() {
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0.jar")).isFalse();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0.pom")).isFalse();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0-sources.jar")).isFalse();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0-tests.jar")).isTrue();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0-test-sources.jar")).isTrue();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0-test-javadoc.jar")).isTrue();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0-tests.jar.sha1")).isTrue();
+        assertThat(TestArtifacts.isTestArtifact("foo-1.0-tests.jar.asc.md5")).isTrue();
+    }
+}
@@ -0,0 +1,86 @@
+package com.redhat.hacbs.container.verifier;
+
+import static org.assertj.core.api.Assertions.assertThat;
+import static org.junit.jupiter.api.Assertions.assertThrows;
+
+import java.io.IOException;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.util.List;
+import java.util.Map;
+import java.util.jar.Attributes;
+import java.util.jar.JarOutputStream;
+import java.util.jar.Manifest;
+import java.util.zip.ZipEntry;
+
+import org.junit.jupiter.api.Test;
+import org.junit.jupiter.api.io.TempDir;
+
+class ValidateJarsCommandTest {
+
+    @TempDir
+    Path temp;
+
+    private Path jar(String name, Map<String, String> attributes, String... entries) throws IOException {
+        Manifest manifest = new Manifest();
+        manifest.getMainAttributes().put(Attributes.Name.MANIFEST_VERSION, "1.0");
+        attributes.forEach((k, v) -> manifest.getMainAttributes().putValue(k, v));
+        Path jar = temp.resolve(name);
+        Files.createDirectories(jar.getParent());
+        try (JarOutputStream out = new JarOutputStream(Files.newOutputStream(jar), manifest)) {
+            for (var entry : entries) {
+                out.putNextEntry(new ZipEntry(entry));
+                out.closeEntry();
+            }
+        }
+        return jar;
+    }
+
+    @Test
+    void testNoRules() throws IOException {
+        var command = new ValidateJarsCommand();
+        assertThat(command.validate(jar("foo.jar", Map.of(), "Foo.class"))).isEmpty();
+    }
+
+    @Test
+    void testMultiRelease() throws IOException {
+        var command = new ValidateJarsCommand();
+        command.requireMultiRelease = true;
+        assertThat(command.validate(jar("foo.jar", Map.of(), "Foo.class"))).containsExactly("not a multi-release jar");
+        assertThat(command.validate(jar("bar.jar", Map.of("Multi-Release", "true"), "Foo.class"))).isEmpty();
+    }
+
+    @Test
+    void testModuleInfo() throws IOException {
+        var command = new ValidateJarsCommand();
+        command.requireModuleInfo = true;
+        assertThat(command.validate(jar("foo.jar", Map.of(), "Foo.class"))).containsExactly("no module-info.class found");
+        assertThat(command.validate(jar("bar.jar", Map.of(), "module-info.class"))).isEmpty();
+        assertThat(command.validate(jar("baz.jar", Map.of(), "META-INF/versions/9/module-info.class"))).isEmpty();
+        // A module descriptor in a nested directory does not count
+        assertThat(command.validate(jar("qux.jar", Map.of(), "foo/module-info.class"))).hasSize(1);
+    }
+
+    @Test
+    void testManifestAttributes() throws IOException {
+        var command = new ValidateJarsCommand();
+        command.requiredManifestAttributes = List.of("Automatic-Module-Name", "Implementation-Version");
+        assertThat(command.validate(jar("foo.jar", Map.of("Automatic-Module-Name", "foo"), "Foo.class")))
+                .containsExactly("manifest attribute Implementation-Version is missing");
+    }
+
+    @Test
+    void testRun() throws IOException {
+        var command = new ValidateJarsCommand();
+        command.path = temp;
+        command.requireMultiRelease = true;
+        jar("com/acme/foo/1.0/foo-1.0.jar", Map.of("Multi-Release", "true"), "Foo.class");
+        // Source and javadoc jars are not validated
+        jar("com/acme/foo/1.0/foo-1.0-sources.jar", Map.of(), "Foo.java");
+        jar("com/acme/foo/1.0/foo-1.0-javadoc.jar", Map.of(), "index.html");
+        command.run();
+
+        jar("com/acme/bar/1.0/bar-1.0.jar", Map.of(), "Bar.class");
+        assertThrows(RuntimeException.class, command::run);
+    }
+}
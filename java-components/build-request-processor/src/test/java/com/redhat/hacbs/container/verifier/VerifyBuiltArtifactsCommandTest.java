@@ -10,6 +10,10 @@ import java.io.IOException;
 import java.net.URI;
 import java.nio.file.Files;
 import java.nio.file.Path;
+import java.util.ArrayList;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
 import java.util.zip.ZipEntry;
 import java.util.zip.ZipInputStream;
 import java.util.zip.ZipOutputStream;
@@ -136,4 +140,21 @@ public class VerifyBuiltArtifactsCommandTest {
         var exitCode = new CommandLine(verifyBuiltArtifactsCommand2).execute(args);
         assertThat(exitCode).isOne();
     }
+
+    @Test
+    void testDiffSummaryIsBounded() throws Exception {
+        Map<String, List<String>> verificationResults = new HashMap<>();
+        for (int i = 0; i < 1000; i++) {
+            List<String> differences = new ArrayList<>();
+            for (int j = 0; j < 10; j++) {
+                differences.add("+:com/example/Class" + j + ".class:Class com.example.Class" + j + " was added");
+            }
+            verificationResults.put("com.example:artifact-" + i + ":1.0:jar", differences);
+        }
+        verificationResults.put("com.example:unchanged:1.0:jar", List.of());
+        var summary = VerifyBuiltArtifactsCommand.diffSummary(verificationResults);
+        assertThat(summary).isEqualTo("{\"artifacts\":1000,\"differences\":10000}");
+        // Tekton task results share a 4 KB termination message
+        assertThat(summary.length()).isLessThan(4096);
+    }
 }
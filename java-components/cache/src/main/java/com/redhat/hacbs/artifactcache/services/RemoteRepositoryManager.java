@@ -39,6 +39,8 @@ public class RemoteRepositoryManager {
     private static final String PREPEND_TAG = ".prepend-tag";
     private static final String REPOSITORY = ".repository";
     private static final String INSECURE = ".insecure";
+    private static final String USERNAME = ".username";
+    private static final String PASSWORD = ".password";
     public static final String ARTIFACT_DEPLOYMENTS = "artifact-deployments";
     private static final String HACBS = "hacbs";
     private final ConcurrentHashMap<String, List<RepositoryCache>> remoteStores = new ConcurrentHashMap<>();
@@ -180,7 +182,7 @@ public class RemoteRepositoryManager {
                 return List.of(existingSystemRepo);
             }
             Log.infof("Maven repository %s added with URI %s", repo, uri.get());
-            RepositoryClient client = MavenClient.of(repo, uri.get());
+            RepositoryClient client = mavenClient(repo, uri.get());
             return List.of(new Repository(repo, uri.get().toASCIIString(), RepositoryType.MAVEN2, client));
         } else if (optType.orElse(null) == RepositoryType.OCI_REGISTRY) {
             String registry = config.getOptionalValue(STORE + repo + REGISTRY, String.class).orElse("quay.io");
@@ -205,13 +207,28 @@ public class RemoteRepositoryManager {
         return null;
     }
 
+    /**
+     * Creates the client for a Maven repository, which authenticates with the credentials configured for the
+     * repository if it is private.
+     */
+    private MavenClient mavenClient(String repo, URI uri) {
+        var username = config.getOptionalValue(STORE + repo + USERNAME, String.class);
+        var password = config.getOptionalValue(STORE + repo + PASSWORD, String.class);
+        if (username.isPresent() && password.isPresent()) {
+            Log.infof("Maven repository %s is accessed as %s", repo, username.get());
+            //we hard code a single retry at this point
+            return new MavenClient(repo, uri, 1, username.get(), password.get());
+        }
+        return MavenClient.of(repo, uri);
+    }
+
     private List<Repository> createSystemRepository(String repo) {
         List<Repository> ret = new ArrayList<>();
         for (var info : recipeManager.getRepositoryInfo(repo)) {
             try {
                 if (info.getUri() != null && !info.getUri().isBlank()) {
                     Log.infof("System Maven repository %s added with URI %s", repo, info.getUri());
-                    RepositoryClient client = MavenClient.of(repo, new URI(info.getUri()));
+                    RepositoryClient client = mavenClient(repo, new URI(info.getUri()));
                     ret.add(new Repository(repo, info.getUri(), RepositoryType.MAVEN2, client));
                 }
                 if (info.getRepositories() != null) {
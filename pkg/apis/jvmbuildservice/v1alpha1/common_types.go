@@ -1,7 +1,14 @@
 package v1alpha1
 
+const (
+	SCMTypeGit        = "git"
+	SCMTypeSubversion = "svn"
+	SCMTypeMercurial  = "hg"
+)
+
 type SCMInfo struct {
-	SCMURL     string `json:"scmURL,omitempty"`
+	SCMURL string `json:"scmURL,omitempty"`
+	// The type of the repository, either git (the default), svn or hg. For svn the commit hash is the revision.
 	SCMType    string `json:"scmType,omitempty"`
 	Tag        string `json:"tag,omitempty"`
 	CommitHash string `json:"commitHash,omitempty"`
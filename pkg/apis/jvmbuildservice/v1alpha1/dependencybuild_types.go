@@ -21,6 +21,14 @@ type DependencyBuildSpec struct {
 	ScmInfo              SCMInfo `json:"scm,omitempty"`
 	Version              string  `json:"version,omitempty"`
 	BuildRecipeConfigMap string  `json:"buildRecipeConfigMap,omitempty"`
+	// If this is true the built artifacts are only verified, and are not pushed or deployed
+	VerifyOnly bool `json:"verifyOnly,omitempty"`
+	// The post-build image of a known-good build, e.g. quay.io/foo/artifact-deployments@sha256:..., that the built
+	// artifacts are verified against instead of the artifacts in the cache
+	ReferenceArtifactImage string `json:"referenceArtifactImage,omitempty"`
+	// Further Maven repositories, e.g. of earlier rebuilds, that the built artifacts are compared against to investigate
+	// non-determinism. The differences against each are reported but do not affect the verification result.
+	ReferenceRepositories []string `json:"referenceRepositories,omitempty"`
 }
 
 type DependencyBuildStatus struct {
@@ -44,6 +52,10 @@ type DependencyBuildStatus struct {
 	DiscoveryPipelineResults *PipelineResults `json:"discoveryPipelineResults,omitempty"`
 	DeployPipelineResults    *PipelineResults `json:"deployPipelineResults,omitempty"`
 	PreBuildImages           []PreBuildImage  `json:"builderImages,omitempty"`
+	// The strategy used to order PotentialBuildRecipes
+	RecipeSelectionStrategy string `json:"recipeSelectionStrategy,omitempty"`
+	// The recipe used by the current build attempt
+	SelectedBuildRecipe *BuildRecipe `json:"selectedBuildRecipe,omitempty"`
 }
 
 // +genclient
@@ -101,6 +113,8 @@ type BuildPipelineRunResults struct {
 	//If the resulting image was verified
 	Verified            bool   `json:"verified,omitempty"`
 	VerificationResults string `json:"verificationFailures,omitempty"`
+	// The digest of the artifact checksum manifest recorded by the build
+	ArtifactChecksums string `json:"artifactChecksums,omitempty"`
 	// The produced GAVs
 	Gavs []string `json:"gavs,omitempty"`
 	// Deprecated
@@ -154,9 +168,12 @@ type BuildRecipe struct {
 	Pipeline string `json:"pipeline,omitempty"`
 	Tool     string `json:"tool,omitempty"`
 	// The base builder image (ubi7 / ubi8)
-	Image               string               `json:"image,omitempty"`
-	ContextPath         string               `json:"contextPath,omitempty"`
-	CommandLine         []string             `json:"commandLine,omitempty"`
+	Image       string `json:"image,omitempty"`
+	ContextPath string `json:"contextPath,omitempty"`
+	// The goals and arguments the build tool is run with. If not set Maven runs clean deploy and Gradle runs build.
+	CommandLine []string `json:"commandLine,omitempty"`
+	// If set the project version is set to the version being built. This may be a Maven style version range such as
+	// [1.0,2.0), in which case the version is only enforced when building a version in the range.
 	EnforceVersion      string               `json:"enforceVersion,omitempty"`
 	ToolVersion         string               `json:"toolVersion,omitempty"`
 	ToolVersions        map[string]string    `json:"toolVersions,omitempty"`
@@ -167,8 +184,70 @@ type BuildRecipe struct {
 	DisableSubmodules   bool                 `json:"disableSubmodules,omitempty"`
 	AdditionalMemory    int                  `json:"additionalMemory,omitempty"`
 	Repositories        []string             `json:"repositories,omitempty"`
-	AllowedDifferences  []string             `json:"allowedDifferences,omitempty"`
-	DisabledPlugins     []string             `json:"disabledPlugins,omitempty"`
+	// Differences the verifier ignores. An entry may be followed by " # " and the reason it was allowed, which is
+	// logged with the verification output.
+	AllowedDifferences []string `json:"allowedDifferences,omitempty"`
+	DisabledPlugins    []string `json:"disabledPlugins,omitempty"`
+	// If the build targets a single module then also build the modules it depends on (-am)
+	AlsoMake bool `json:"alsoMake,omitempty"`
+	// If the build targets a single module then also build the modules that depend on it (-amd)
+	AlsoMakeDependents bool `json:"alsoMakeDependents,omitempty"`
+	// Additional CPU in millicores to add to the build request and limit
+	AdditionalCPU int `json:"additionalCPU,omitempty"`
+	// Fixes the order tests run in for reproducible builds. Either alphabetical or random (with a fixed seed).
+	// Defaults to the build tool default.
+	TestRunOrder string `json:"testRunOrder,omitempty"`
+	// Credentials for private submodules hosted on a different host than the top-level repository
+	SubmoduleCredentials []SubmoduleCredential `json:"submoduleCredentials,omitempty"`
+	// If true the pre build script runs in a sub-shell so it cannot change the build environment. Variables it needs
+	// to pass to the build are written as KEY=VALUE lines to the file named by JBS_PRE_BUILD_ENV.
+	IsolatePreBuildScript bool `json:"isolatePreBuildScript,omitempty"`
+	// Contaminant groupId:artifactId[:version] coordinates that are known to be acceptable, such as shaded dependencies. Any part
+	// may use * as a wildcard.
+	AllowedContaminants []string `json:"allowedContaminants,omitempty"`
+	// The home directory of the build user. If not set the home directory of the image user is used if it is writable,
+	// otherwise a directory within the workspace.
+	HomeDirectory string `json:"homeDirectory,omitempty"`
+	// The location of the JDK within the builder image, with {VERSION} replaced by the Java version e.g.
+	// /opt/java/jdk-{VERSION}. If not set the standard /lib/jvm layout is used.
+	JavaHomeTemplate string `json:"javaHomeTemplate,omitempty"`
+	// The Java version Maven and Gradle are launched with through JAVA_HOME, if it must differ from the JDK the project
+	// is compiled with, e.g. to avoid a bug in the compile JDK. The compile JDK is passed to the build as
+	// COMPILE_JAVA_HOME, and Maven forks the compiler from it. If not set both are the JavaVersion JDK.
+	LauncherJavaVersion string `json:"launcherJavaVersion,omitempty"`
+	// If this is true an Ant build deploys the artifacts itself, with a deploy target in build.xml that deploys to the
+	// Maven repository in the jbs.deploy.url property, rather than the artifacts being collected from the source tree.
+	AntNativeDeploy bool `json:"antNativeDeploy,omitempty"`
+	// The number of times the build task is retried if it fails, e.g. due to network issues while resolving dependencies.
+	// Only the build is retried as retrying the deployment could publish the artifacts twice. At most 5.
+	Retries int `json:"retries,omitempty"`
+	// Further build tools to run once the main tool has finished, in order, e.g. for a project that is partly
+	// built with Maven and partly with Gradle. The pre-build prepares the source for every tool.
+	AdditionalTools []AdditionalTool `json:"additionalTools,omitempty"`
+	// Extra environment variables for the build, e.g. GRADLE_OPTS. The variables managed by the build service such as
+	// JAVA_HOME and MAVEN_HOME take precedence, so any of those set here are ignored.
+	ExtraEnv map[string]string `json:"extraEnv,omitempty"`
+	// If this is greater than zero only this many commits of history are fetched, rather than cloning the whole
+	// repository. Submodules are still cloned in full.
+	CloneDepth int `json:"cloneDepth,omitempty"`
+	// The JAVA_HOME the preprocessor runs with, a JDK within the build request processor image. This is for projects
+	// whose build files need an older toolchain to be parsed. Defaults to the bundled system Java.
+	PreprocessorJavaHome string `json:"preprocessorJavaHome,omitempty"`
+	// The body of a Gradle init script passed to Gradle builds with --init-script, e.g. to redirect repositories or
+	// apply plugins. It is ignored for other build tools.
+	GradleInitScript string `json:"gradleInitScript,omitempty"`
+	// The platform the builder image is pulled and the build is run for, one of linux/amd64 or linux/arm64. Pre-build
+	// images are only reused by builds for the same platform. If not set the image is used as is.
+	Architecture string `json:"architecture,omitempty"`
+	// If true Maven and Gradle builds run the wrapper (mvnw / gradlew) in the root of the repository rather than the
+	// build tool installed in the builder image. The wrapper only verifies the distribution it downloads if the
+	// distributionSha256Sum is set in the wrapper properties.
+	UseBuildToolWrapper bool `json:"useBuildToolWrapper,omitempty"`
+	// The expected SHA-256 checksum of the wrapper jar. The build fails if the jar does not match.
+	WrapperChecksum string `json:"wrapperChecksum,omitempty"`
+	// Files written to the build settings directory before the build, keyed by file name, e.g. a custom
+	// gradle.properties. The build finds the directory through $(workspaces.build-settings.path).
+	BuildSettingsFiles map[string]string `json:"buildSettingsFiles,omitempty"`
 }
 type Contaminant struct {
 	GAV                   string   `json:"gav,omitempty"`
@@ -178,13 +257,34 @@ type Contaminant struct {
 	Allowed               bool     `json:"allowed,omitempty"`
 	RebuildAvailable      bool     `json:"rebuildAvailable,omitempty"`
 }
+type SubmoduleCredential struct {
+	// The host of the submodule repository e.g. gitlab.example.com
+	Host string `json:"host,omitempty"`
+	// The secret holding the git-credentials for the host, which must be in the allowedSubmoduleSecrets of the JBSConfig
+	SecretName string `json:"secretName,omitempty"`
+	// The key within the secret. Defaults to .git-credentials
+	SecretKey string `json:"secretKey,omitempty"`
+}
+type AdditionalTool struct {
+	// The build tool e.g. gradle
+	Tool string `json:"tool,omitempty"`
+	// The version of the tool, used if the recipe does not already have a version for it
+	Version string `json:"version,omitempty"`
+	// The arguments to invoke the tool with
+	CommandLine []string `json:"commandLine,omitempty"`
+}
 type AdditionalDownload struct {
-	Uri         string `json:"uri,omitempty"`
-	Sha256      string `json:"sha256,omitempty"`
+	Uri    string `json:"uri,omitempty"`
+	Sha256 string `json:"sha256,omitempty"`
+	// Verified in addition to the SHA256 checksum if set, for upstreams that only publish SHA512 checksums
+	Sha512      string `json:"sha512,omitempty"`
 	FileName    string `json:"fileName,omitempty"`
 	BinaryPath  string `json:"binaryPath,omitempty"`
 	PackageName string `json:"packageName,omitempty"`
 	FileType    string `json:"type"`
+	// Only applies to rpm type; if set the rpm is downloaded from the uri and its signature verified against this key
+	// before installing
+	GpgKeyUri string `json:"gpgKeyUri,omitempty"`
 }
 
 // A representation of the Tekton Results records for a pipeline
@@ -1,6 +1,8 @@
 package v1alpha1
 
 import (
+	"strings"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -23,7 +25,15 @@ const (
 	AWSProfile                              = "awsprofile"                       //#nosec
 	AWSRegion                               = "awsregion"                        //#nosec
 	AWSSecretName                           = "jvm-build-maven-repo-aws-secrets" //#nosec
+	GCSSecretName                           = "jvm-build-maven-repo-gcs-secrets" //#nosec
+	GCSCredentialsKey                       = "credentials.json"                 //#nosec
+	GradleBuildCacheSecretName              = "jvm-build-gradle-cache-secrets"   //#nosec
+	GradleBuildCacheUsernameKey             = "username"                         //#nosec
+	GradleBuildCachePasswordKey             = "password"                         //#nosec
+	RepositoryUsernameKey                   = "username"                         //#nosec
+	RepositoryPasswordKey                   = "password"                         //#nosec
 	CacheDeploymentName                     = "jvm-build-workspace-artifact-cache"
+	DefaultCcacheClaimName                  = "jvm-build-ccache"
 	ConfigArtifactCacheRequestMemoryDefault = "512Mi"
 	ConfigArtifactCacheRequestCPUDefault    = "1"
 	ConfigArtifactCacheLimitMemoryDefault   = "512Mi"
@@ -33,6 +43,25 @@ const (
 	ConfigArtifactCacheStorageDefault       = "10Gi"
 
 	HermeticBuildTypeRequired HermeticBuildType = "Required"
+
+	VerificationOutputFormatText = "text"
+	VerificationOutputFormatJson = "json"
+
+	PullSecretCheckWarn = "warn"
+	PullSecretCheckFail = "fail"
+
+	RecipeSelectionFirstMatch      = "first-match"
+	RecipeSelectionHighestPriority = "highest-priority"
+
+	DeployModeMaven = "maven"
+	DeployModeOCI   = "oci"
+
+	JbsDirectoryConflictOverwrite       = "overwrite"
+	JbsDirectoryConflictFail            = "fail"
+	JbsDirectoryConflictUseAlternateDir = "use-alternate-dir"
+
+	PreBuildImageTagImageId       = "image-id"
+	PreBuildImageTagContentDigest = "content-digest"
 )
 
 type JBSConfigSpec struct {
@@ -41,6 +70,11 @@ type JBSConfigSpec struct {
 	// If this is true then the build will fail if artifact verification fails
 	// otherwise deploy will happen as normal, but a field will be set on the DependencyBuild
 	RequireArtifactVerification bool `json:"requireArtifactVerification,omitempty"`
+	// The format of the artifact verification output, either text (the default) or json. If json the full list of
+	// differences is archived with the build logs and a summary is written to the VERIFICATION_DIFF result.
+	VerificationOutputFormat string `json:"verificationOutputFormat,omitempty"`
+	// Structural rules every built jar must satisfy, the build fails if any jar violates them
+	JarValidation JarValidation `json:"jarValidation,omitempty"`
 	// Deprecated
 	HermeticBuilds HermeticBuildType `json:"hermeticBuilds,omitempty"`
 
@@ -54,6 +88,15 @@ type JBSConfigSpec struct {
 	GitSourceArchive GitSourceArchive  `json:"gitSourceArchive,omitempty"`
 	CacheSettings    CacheSettings     `json:"cacheSettings,omitempty"`
 	BuildSettings    BuildSettings     `json:"buildSettings,omitempty"`
+	// Rewrites the infrastructure images (e.g. trusted artifacts and cache) to be pulled from a local mirror, for
+	// clusters without access to the original registries. The first matching mirror is used.
+	ImageMirrors []ImageMirror `json:"imageMirrors,omitempty"`
+	// Additional mirrors added to the Maven settings of the builds, e.g. to resolve some repositories through a
+	// corporate proxy. These take precedence over the cache for the repositories they mirror.
+	MavenMirrors []MavenMirror `json:"mavenMirrors,omitempty"`
+	// Additional CA certificates imported into the trust store of the builds, e.g. for internal registries and Maven
+	// repositories signed by a corporate CA. These are imported in addition to the cache service CA.
+	AdditionalCACertificates *CACertificateSource `json:"additionalCACertificates,omitempty"`
 	// Deprecated
 	RelocationPatterns []RelocationPatternElement `json:"relocationPatterns,omitempty"`
 }
@@ -65,6 +108,40 @@ type ImageRegistrySpec struct {
 	Private *bool `json:"private,omitempty"`
 
 	DontReuseExisting *bool `json:"dontReuseExisting,omitempty"`
+
+	// An optional secondary registry that build images are also pushed to and tagged in. Its secret and insecure settings
+	// are independent of those of the primary registry.
+	Mirror *ImageRegistry `json:"mirror,omitempty"`
+
+	// Additional headers passed to oras when tagging deployed images and checking the tags, for registries that
+	// require e.g. a specific Accept or Content-Type header
+	TagHeaders map[string]string `json:"tagHeaders,omitempty"`
+	// Additional options passed to oras when tagging deployed images
+	TagOptions []string `json:"tagOptions,omitempty"`
+	// The artifact type of the pre-build and post-build image archives, for registries that reject the default.
+	// Defaults to application/vnd.oci.image.config.v1+json.
+	ArtifactType string `json:"artifactType,omitempty"`
+	// The OCI image spec version of the pre-build and post-build image archives. Defaults to v1.0.
+	ImageSpec string `json:"imageSpec,omitempty"`
+	// Annotations added to the pre-build and post-build image archives for provenance tracking, e.g.
+	// org.opencontainers.image.revision. The values may contain {SCM_URL}, {SCM_COMMIT} and {BUILD_ID}, which are
+	// replaced with those of the build. Annotations whose value contains whitespace or shell special characters are
+	// not added.
+	ArchiveAnnotations map[string]string `json:"archiveAnnotations,omitempty"`
+	// Retries of the oras requests of the pipelines (restoring the pre-build source and built artifacts, creating the
+	// image archives and tagging), for flaky registries. Nothing is retried if not set.
+	OrasRetry OrasRetry `json:"orasRetry,omitempty"`
+	// How the pre-build images are tagged, either image-id (the default) which uses the name of the DependencyBuild, or
+	// content-digest which uses a digest of the source tree including the generated build files, so identical sources
+	// always map to the same tag and the images can be deduplicated
+	PreBuildImageTag string `json:"preBuildImageTag,omitempty"`
+}
+
+type OrasRetry struct {
+	// The number of times a failed oras request is retried
+	Count int `json:"count,omitempty"`
+	// The delay in seconds before the first retry, which doubles after every retry. Defaults to 5 seconds.
+	BackoffSeconds int `json:"backoffSeconds,omitempty"`
 }
 
 type JBSConfigStatus struct {
@@ -82,6 +159,19 @@ type CacheSettings struct {
 	WorkerThreads string `json:"workerThreads,omitempty"`
 	Storage       string `json:"storage,omitempty"`
 	DisableTLS    bool   `json:"disableTLS,omitempty"`
+	// The name of the cache service builds use, if the cache runs under a different service. The TLS service has the
+	// same name with a -tls suffix. Defaults to jvm-build-workspace-artifact-cache.
+	ServiceName string `json:"serviceName,omitempty"`
+	// The cluster domain of the cache service. Defaults to cluster.local.
+	ServiceDomain string `json:"serviceDomain,omitempty"`
+	// The time in seconds since the epoch the cache is pinned to for every build, e.g. a release date or a fixed time
+	// for fully reproducible rebuilds. Artifacts published after this time are not visible to the builds. Defaults to
+	// the commit time of each build.
+	PinTimestamp int64 `json:"pinTimestamp,omitempty"`
+	// The secrets the cache authenticates to private upstream repositories with, keyed by the repository name, e.g. the
+	// name of a maven-repository-<position>-<name> base location or a recipe repository. Each secret holds the
+	// username and password keys. Repositories without a secret are accessed anonymously.
+	RepositorySecrets map[string]string `json:"repositorySecrets,omitempty"`
 }
 
 type BuildSettings struct {
@@ -99,21 +189,254 @@ type BuildSettings struct {
 	TaskLimitMemory string `json:"taskLimitMemory,omitempty"`
 	// The CPU limit for all other steps of a pipeline
 	TaskLimitCPU string `json:"taskLimitCPU,omitempty"`
+	// The requested memory for the preprocessor and create-pre-build-source steps, which parse the POMs of the whole
+	// project. Those steps use the task and build memory respectively if not set.
+	PreprocessorRequestMemory string `json:"preprocessorRequestMemory,omitempty"`
+	// The timeout in hours for the build and deploy pipelines. Defaults to 6 hours if not set.
+	BuildTimeoutHours int `json:"buildTimeoutHours,omitempty"`
+	// The maximum size in megabytes of the source tree archived into the pre-build image. The build fails if the
+	// source exceeds this. Unlimited if not set.
+	MaxSourceSizeMB int `json:"maxSourceSizeMB,omitempty"`
+	// How the recipe to build is chosen when several match; one of first-match (the default) or highest-priority (the
+	// builder image priority)
+	RecipeSelectionStrategy string `json:"recipeSelectionStrategy,omitempty"`
+	// If this is true JDK 8 builds have -XX:ActiveProcessorCount set from the build CPU limit, as older JDK 8 releases
+	// may not respect cgroup CPU limits
+	ActiveProcessorCount bool `json:"activeProcessorCount,omitempty"`
+	// A shared remote build cache used by gradle builds
+	GradleBuildCache GradleBuildCache `json:"gradleBuildCache,omitempty"`
+	// A persistent ccache directory used to cache the native (e.g. JNI) compilation of builds
+	Ccache Ccache `json:"ccache,omitempty"`
+	// A complete Maven settings.xml used by the builds instead of the generated one, so it also replaces the cache
+	// mirror. It is used by every build tool that reads the Maven settings.
+	MavenSettings MavenSettingsSource `json:"mavenSettings,omitempty"`
+	// The pull policy (Always, IfNotPresent or Never) of the build request processor steps. If not set it is derived
+	// from the image tag.
+	ImagePullPolicy string `json:"imagePullPolicy,omitempty"`
+	// Checks the disk usage of the build workspace so a build that fills the disk fails with a clear message
+	DiskMonitor DiskMonitor `json:"diskMonitor,omitempty"`
+	// Where the source workspace is mounted in the build pipeline, for builder images that reserve the default
+	// /var/workdir. If set the diagnostic and konflux container files also place the project there.
+	WorkspaceMountPath string `json:"workspaceMountPath,omitempty"`
+	// If this is true the tool and JDK locations are resolved to their canonical paths when the build runs, for images
+	// where e.g. /opt/maven/<version> is a symlink
+	ResolveToolSymlinks bool `json:"resolveToolSymlinks,omitempty"`
+	// The number of allowed differences above which they are written to a file for the verification rather than
+	// passed as individual arguments. Defaults to 20.
+	ExcludesFileThreshold int `json:"excludesFileThreshold,omitempty"`
+	// If this is true the diagnostic Containerfile for reproducing a build locally is not generated
+	DisableDiagnosticContainerfile bool `json:"disableDiagnosticContainerfile,omitempty"`
+	// Checks that the image registry and mirror secrets have credentials for every image a build pulls (the builder,
+	// build request processor, trusted artifacts and cache images), for when they are all in private registries. One of
+	// warn, which only reports the missing credentials, or fail, which also fails the build. Not checked if not set.
+	PullSecretCheck string `json:"pullSecretCheck,omitempty"`
+	// If this is true the generated build script has comments marking where each section (install-package, pre-build,
+	// build tool and post-build) begins and ends, to help debugging
+	AnnotateBuildScript bool `json:"annotateBuildScript,omitempty"`
+	// The priority class of the pods of the build discovery, build and deploy pipelines, so the scheduler can throttle
+	// or preempt builds on busy clusters. The cluster default is used if not set.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// How the pre-build handles a source tree that already has a .jbs directory, which the generated Containerfile and
+	// build scripts are written into. One of overwrite (the default), where the generated files replace any of the same
+	// name, fail, which fails the build, or use-alternate-dir, which writes them into .jbs-build instead.
+	JbsDirectoryConflictPolicy string `json:"jbsDirectoryConflictPolicy,omitempty"`
+	// The seconds the build step is given to flush its logs and partial artifacts when it is terminated. The build is
+	// sent SIGTERM this long before the build timeout rather than being killed outright. Disabled if not set.
+	GracefulTerminationSeconds int `json:"gracefulTerminationSeconds,omitempty"`
+	// If this is true the output of the build tools is buffered and only shown if the build fails, so successful builds
+	// have short logs while failures keep the full detail. The full output is always kept in the build logs archive.
+	QuietBuildOutput bool `json:"quietBuildOutput,omitempty"`
+	// If this is true builds that use the build tool wrapper fail unless the recipe has the checksum of the wrapper
+	// jar and the wrapper properties have the distributionSha256Sum of the build tool distribution the wrapper
+	// downloads. Otherwise an unverified wrapper or distribution is only reported.
+	RequireWrapperChecksum bool `json:"requireWrapperChecksum,omitempty"`
+	// If this is true and the build fails the whole build workspace is pushed to the image registry, tagged with the
+	// build id and a -failed-workspace suffix, for debugging. The location is in the DEBUG_WORKSPACE result.
+	KeepFailedWorkspace bool `json:"keepFailedWorkspace,omitempty"`
+	// If this is true the built test jars (-tests.jar, -test-sources.jar and -test-javadoc.jar) are neither verified
+	// nor deployed. They are recognised by their file name as the scope of an artifact is not known.
+	ExcludeTestArtifacts bool `json:"excludeTestArtifacts,omitempty"`
+	// The secrets that the submodule credentials of a recipe may name. Recipes come from the shared build recipe
+	// repositories, so they are not trusted to read any secret in the namespace. A build whose recipe names another
+	// secret fails.
+	AllowedSubmoduleSecrets []string `json:"allowedSubmoduleSecrets,omitempty"`
+	// If this is true the build tool JVMs run with Java Flight Recorder, to diagnose slow or hanging builds. A recording
+	// of each JVM is written when it exits into the jfr directory of the build logs archive. The builder JDKs must
+	// support flight recording.
+	FlightRecording bool `json:"flightRecording,omitempty"`
+	// Restricts the network the build step can reach to the cache and a list of allowed hosts, for security auditing
+	EgressAllowlist EgressAllowlist `json:"egressAllowlist,omitempty"`
+	// The user the steps of the build discovery, build and deploy pipelines run as, e.g. a non-root user for namespaces
+	// that enforce the restricted pod security standard. Defaults to root. The builder, build request processor and trusted
+	// artifacts images must work as this user. The egress allowlist steps always run as root.
+	RunAsUser int64 `json:"runAsUser,omitempty"`
+	// If this is true the timestamps that plugins embed in the built archives are normalized before the artifacts are
+	// verified and deployed. The zip entry times are set to the commit time and the manifest attributes that record the
+	// build time, such as Build-Time and Bnd-LastModified, are removed.
+	NormalizeTimestamps bool `json:"normalizeTimestamps,omitempty"`
+}
+
+type JarValidation struct {
+	// If this is true every jar must be a multi-release jar
+	RequireMultiRelease bool `json:"requireMultiRelease,omitempty"`
+	// If this is true every jar must contain a module-info.class, either at the root or in a versioned directory
+	RequireModuleInfo bool `json:"requireModuleInfo,omitempty"`
+	// Attributes that must be present in the main section of every jar manifest, e.g. Automatic-Module-Name
+	RequiredManifestAttributes []string `json:"requiredManifestAttributes,omitempty"`
+}
+
+type DiskMonitor struct {
+	// If this is true the disk usage is checked before the build starts and periodically while it runs
+	Enabled bool `json:"enabled,omitempty"`
+	// The workspace disk usage percentage at which the build is failed. Defaults to 95.
+	ThresholdPercent int `json:"thresholdPercent,omitempty"`
+	// How often in seconds the disk usage is checked while the build runs. Defaults to 30.
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+}
+
+type EgressAllowlist struct {
+	// If this is true the build step can only connect to the cache, DNS and the allowed hosts. Other connections are
+	// rejected and logged by the node kernel with the jbs-egress-denied prefix, and the number of rejected packets is
+	// shown once the build has finished. The restriction is lifted after the build step so the results can be pushed.
+	// Only IPv4 connections are restricted.
+	Enabled bool `json:"enabled,omitempty"`
+	// The host names, IP addresses or CIDR ranges the build may connect to besides the cache. Host names are resolved
+	// when the build starts.
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	// The image that applies the firewall rules. It must contain iptables, and the build pods must be allowed the
+	// NET_ADMIN capability.
+	Image string `json:"image,omitempty"`
+}
+
+type MavenSettingsSource struct {
+	// The config map holding the settings.xml
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// The secret holding the settings.xml, for settings that include credentials. This takes precedence over
+	// ConfigMapName.
+	SecretName string `json:"secretName,omitempty"`
+	// The key of the settings.xml within the config map or secret. Defaults to settings.xml.
+	Key string `json:"key,omitempty"`
+}
+
+type CACertificateSource struct {
+	// The config map holding the PEM encoded certificates, every key is imported and may hold several certificates
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// The secret holding the PEM encoded certificates. This takes precedence over ConfigMapName.
+	SecretName string `json:"secretName,omitempty"`
+}
+
+type Ccache struct {
+	// If this is true builds compile C/C++ through ccache, which the builder images must provide
+	Enabled bool `json:"enabled,omitempty"`
+	// The persistent volume claim holding the cache. It should be ReadWriteMany so concurrent builds can share it.
+	// Defaults to jvm-build-ccache.
+	ClaimName string `json:"claimName,omitempty"`
+	// The maximum size of the cache e.g. 5G, passed as CCACHE_MAXSIZE. Defaults to the ccache default.
+	MaxSize string `json:"maxSize,omitempty"`
+}
+
+type GradleBuildCache struct {
+	// If this is true gradle builds read from and write to the remote build cache
+	Enabled bool `json:"enabled,omitempty"`
+	// The URL of the Gradle HTTP build cache. Credentials are read from the jvm-build-gradle-cache-secrets secret.
+	URL string `json:"url,omitempty"`
 }
 type ImageRegistry struct {
 	Host       string `json:"host,omitempty"` // Defaults to quay.io in ImageRegistry()
 	Port       string `json:"port,omitempty"`
 	Owner      string `json:"owner,omitempty"`
 	Repository string `json:"repository,omitempty"` // Defaults to artifact-deployments in ImageRegistry()
-	Insecure   bool   `json:"insecure,omitempty"`
+	// The repository the pre-build images are pushed to, so they don't share a repository with the deployed artifacts.
+	// Defaults to Repository.
+	PreBuildRepository string `json:"preBuildRepository,omitempty"`
+	Insecure           bool   `json:"insecure,omitempty"`
 	// Used to stop old images from tests being picked up. Its used in the tests to add a timestamp for uniqueness.
 	PrependTag string `json:"prependTag,omitempty"`
 	SecretName string `json:"secretName,omitempty"`
 }
 
+type ImageMirror struct {
+	// The registry or repository prefix to replace e.g. quay.io/redhat-appstudio
+	Source string `json:"source,omitempty"`
+	// The registry or repository prefix to pull from instead e.g. mirror.example.com:5000/redhat-appstudio
+	Mirror string `json:"mirror,omitempty"`
+}
+
+type MavenMirror struct {
+	Id  string `json:"id,omitempty"`
+	Url string `json:"url,omitempty"`
+	// The repository ids this mirrors, in the Maven mirrorOf format e.g. central,!internal
+	MirrorOf string `json:"mirrorOf,omitempty"`
+}
+
 type MavenDeployment struct {
 	Username   string `json:"username,omitempty"`
 	Repository string `json:"repository,omitempty"`
+	// Additional repositories the artifacts are deployed to, e.g. separate snapshot and release repositories
+	Repositories []MavenRepository `json:"repositories,omitempty"`
+	// If this is true then all SNAPSHOT artifacts deployed from a build share the same unique version timestamp
+	ConsistentSnapshotTimestamp bool `json:"consistentSnapshotTimestamp,omitempty"`
+	// If this is true the checksums of the built artifacts are recorded after the build and validated before deployment
+	ValidateChecksums bool `json:"validateChecksums,omitempty"`
+	// If this is true the artifacts of each groupId are deployed separately, for repositories that require it
+	PartitionByGroupId bool `json:"partitionByGroupId,omitempty"`
+	// If this is true the maven-metadata.xml of each deployed artifact is regenerated after the deploy by merging the
+	// deployed versions into the version list already in the repository, so it stays correct across incremental deploys
+	RegenerateMetadata bool `json:"regenerateMetadata,omitempty"`
+	// Bounds the retries of the registry operations of the deploy pipeline (restoring the built artifacts and tagging
+	// the image) across all of its steps. Nothing is retried if not set.
+	RetryBudget DeployRetryBudget `json:"retryBudget,omitempty"`
+	// How the artifacts are deployed, either maven (the default) to the Maven repositories or oci to push the artifacts
+	// of each GAV to an OCI registry, annotated with their Maven coordinates
+	Mode string `json:"mode,omitempty"`
+	// The repository the artifacts are pushed to in the oci mode e.g. quay.io/foo/java-artifacts. Defaults to the
+	// maven-artifacts repository of the image registry. Each GAV is tagged with the same hash as the build images.
+	OCIRepository string `json:"ociRepository,omitempty"`
+	// If this is true and the artifacts are deployed to Maven repositories the deployed image is not tagged with the
+	// GAVs in the image registry, so the deploy pipeline only deploys the artifacts. The image is always tagged in OCI
+	// mode.
+	SkipImageTagging bool `json:"skipImageTagging,omitempty"`
+	// The public key the signatures (.asc files) of the built artifacts are verified against before they are deployed.
+	// The deploy fails if any signature is invalid. Signatures are not verified if not set.
+	SigningPublicKey *SigningPublicKeySource `json:"signingPublicKey,omitempty"`
+	// Rebuilds the known dependents of the artifacts once they have been deployed
+	DownstreamBuilds DownstreamBuilds `json:"downstreamBuilds,omitempty"`
+}
+
+type DownstreamBuilds struct {
+	// If this is true a successful deploy creates an ArtifactBuild for each dependent of the deployed artifacts, or
+	// rebuilds it if it has already been built
+	Enabled bool `json:"enabled,omitempty"`
+	// Maps the groupId:artifactId of an artifact to the GAVs of the artifacts that depend on it
+	Dependents map[string][]string `json:"dependents,omitempty"`
+}
+
+type SigningPublicKeySource struct {
+	// The config map holding the ASCII armored public key
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// The secret holding the public key. This takes precedence over ConfigMapName.
+	SecretName string `json:"secretName,omitempty"`
+	// The key of the public key within the config map or secret. Defaults to public-key.asc.
+	Key string `json:"key,omitempty"`
+}
+
+type DeployRetryBudget struct {
+	// The total number of retries shared by all the steps of the deploy pipeline
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// No more retries are made once this many seconds have passed since the first retried operation started.
+	// Unlimited if not set.
+	MaxSeconds int `json:"maxSeconds,omitempty"`
+	// The delay in seconds between retries. Defaults to 10.
+	DelaySeconds int `json:"delaySeconds,omitempty"`
+}
+
+type MavenRepository struct {
+	URL string `json:"url,omitempty"`
+	// The username for this repository. Defaults to the deployment username.
+	Username string `json:"username,omitempty"`
+	// A secret holding the password for this repository under the mavenpassword key. Defaults to the password shared by
+	// all repositories.
+	SecretName string `json:"secretName,omitempty"`
 }
 
 type GitSourceArchive struct {
@@ -163,7 +486,7 @@ func (in *JBSConfig) ImageRegistry() ImageRegistry {
 		ret.Repository = "artifact-deployments"
 	}
 	if in.Status.ImageRegistry == nil {
-		return ret
+		return normalizeRepositories(ret)
 	}
 	if in.Status.ImageRegistry.Host != "" {
 		ret.Host = in.Status.ImageRegistry.Host
@@ -174,6 +497,9 @@ func (in *JBSConfig) ImageRegistry() ImageRegistry {
 	if in.Status.ImageRegistry.Repository != "" {
 		ret.Repository = in.Status.ImageRegistry.Repository
 	}
+	if in.Status.ImageRegistry.PreBuildRepository != "" {
+		ret.PreBuildRepository = in.Status.ImageRegistry.PreBuildRepository
+	}
 	if in.Status.ImageRegistry.Port != "" {
 		ret.Port = in.Status.ImageRegistry.Port
 	}
@@ -186,7 +512,63 @@ func (in *JBSConfig) ImageRegistry() ImageRegistry {
 	if in.Status.ImageRegistry.SecretName != "" {
 		ret.SecretName = in.Status.ImageRegistry.SecretName
 	}
-	return ret
+	return normalizeRepositories(ret)
+}
+
+// MirroredImage returns the image rewritten to be pulled from the first matching image mirror, or the image itself if
+// no mirror matches.
+func (in *JBSConfig) MirroredImage(image string) string {
+	for _, mirror := range in.Spec.ImageMirrors {
+		source := strings.TrimSuffix(mirror.Source, "/")
+		if source == "" || mirror.Mirror == "" || !strings.HasPrefix(image, source) {
+			continue
+		}
+		// Only match whole path components so quay.io/foo does not match quay.io/foobar
+		rest := image[len(source):]
+		if rest == "" || strings.ContainsAny(rest[:1], "/:@") {
+			return strings.TrimSuffix(mirror.Mirror, "/") + rest
+		}
+	}
+	return image
+}
+
+// MirrorImageRegistry returns the secondary registry with the same defaults as ImageRegistry, or nil if no mirror is
+// configured.
+func (in *JBSConfig) MirrorImageRegistry() *ImageRegistry {
+	if in.Spec.Registry.Mirror == nil {
+		return nil
+	}
+	ret := *in.Spec.Registry.Mirror
+	if ret.Host == "" {
+		ret.Host = "quay.io"
+	}
+	if ret.Repository == "" {
+		ret.Repository = "artifact-deployments"
+	}
+	if ret.PrependTag == "" {
+		ret.PrependTag = in.ImageRegistry().PrependTag
+	}
+	ret = normalizeRepositories(ret)
+	return &ret
+}
+
+// normalizeRepositories removes any tag or digest from the configured repositories, as the tag or digest of each image
+// is appended to them.
+func normalizeRepositories(registry ImageRegistry) ImageRegistry {
+	registry.Repository = RepositoryName(registry.Repository)
+	registry.PreBuildRepository = RepositoryName(registry.PreBuildRepository)
+	return registry
+}
+
+// RepositoryName removes any tag or digest from an image reference.
+func RepositoryName(repository string) string {
+	if i := strings.Index(repository, "@"); i != -1 {
+		repository = repository[:i]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return repository
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -11,13 +11,20 @@ const (
 type SystemConfigSpec struct {
 	Builders            map[string]BuilderImageInfo `json:"builders,omitempty"`
 	MaxAdditionalMemory int                         `json:"maxAdditionalMemory,omitempty"`
+	MaxAdditionalCPU    int                         `json:"maxAdditionalCPU,omitempty"`
 	RecipeDatabase      string                      `json:"recipeDatabase,omitempty"`
+	// If this is true the additional memory of a build is also limited so the build can be scheduled onto the node with
+	// the most allocatable memory.
+	ClampAdditionalMemoryToNodes bool `json:"clampAdditionalMemoryToNodes,omitempty"`
 }
 
 type BuilderImageInfo struct {
 	Image    string `json:"image,omitempty"`
 	Tag      string `json:"tag,omitempty"`
 	Priority int    `json:"priority,omitempty"`
+	// The CPU architecture (e.g. amd64, arm64) the image is built for. If set build pods are only scheduled onto nodes
+	// of the same architecture.
+	Architecture string `json:"architecture,omitempty"`
 }
 
 type SystemConfigStatus struct {
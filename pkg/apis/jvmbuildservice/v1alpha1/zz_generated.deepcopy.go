@@ -40,6 +40,26 @@ func (in *AdditionalDownload) DeepCopy() *AdditionalDownload {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdditionalTool) DeepCopyInto(out *AdditionalTool) {
+	*out = *in
+	if in.CommandLine != nil {
+		in, out := &in.CommandLine, &out.CommandLine
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdditionalTool.
+func (in *AdditionalTool) DeepCopy() *AdditionalTool {
+	if in == nil {
+		return nil
+	}
+	out := new(AdditionalTool)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ArtifactBuild) DeepCopyInto(out *ArtifactBuild) {
 	*out = *in
@@ -247,6 +267,37 @@ func (in *BuildRecipe) DeepCopyInto(out *BuildRecipe) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SubmoduleCredentials != nil {
+		in, out := &in.SubmoduleCredentials, &out.SubmoduleCredentials
+		*out = make([]SubmoduleCredential, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedContaminants != nil {
+		in, out := &in.AllowedContaminants, &out.AllowedContaminants
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalTools != nil {
+		in, out := &in.AdditionalTools, &out.AdditionalTools
+		*out = make([]AdditionalTool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraEnv != nil {
+		in, out := &in.ExtraEnv, &out.ExtraEnv
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.BuildSettingsFiles != nil {
+		in, out := &in.BuildSettingsFiles, &out.BuildSettingsFiles
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildRecipe.
@@ -262,6 +313,16 @@ func (in *BuildRecipe) DeepCopy() *BuildRecipe {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildSettings) DeepCopyInto(out *BuildSettings) {
 	*out = *in
+	out.GradleBuildCache = in.GradleBuildCache
+	out.Ccache = in.Ccache
+	out.MavenSettings = in.MavenSettings
+	out.DiskMonitor = in.DiskMonitor
+	if in.AllowedSubmoduleSecrets != nil {
+		in, out := &in.AllowedSubmoduleSecrets, &out.AllowedSubmoduleSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.EgressAllowlist.DeepCopyInto(&out.EgressAllowlist)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildSettings.
@@ -289,9 +350,31 @@ func (in *BuilderImageInfo) DeepCopy() *BuilderImageInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CACertificateSource) DeepCopyInto(out *CACertificateSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CACertificateSource.
+func (in *CACertificateSource) DeepCopy() *CACertificateSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CACertificateSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CacheSettings) DeepCopyInto(out *CacheSettings) {
 	*out = *in
+	if in.RepositorySecrets != nil {
+		in, out := &in.RepositorySecrets, &out.RepositorySecrets
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheSettings.
@@ -304,6 +387,21 @@ func (in *CacheSettings) DeepCopy() *CacheSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Ccache) DeepCopyInto(out *Ccache) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Ccache.
+func (in *Ccache) DeepCopy() *Ccache {
+	if in == nil {
+		return nil
+	}
+	out := new(Ccache)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Contaminant) DeepCopyInto(out *Contaminant) {
 	*out = *in
@@ -329,7 +427,7 @@ func (in *DependencyBuild) DeepCopyInto(out *DependencyBuild) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -387,6 +485,11 @@ func (in *DependencyBuildList) DeepCopyObject() runtime.Object {
 func (in *DependencyBuildSpec) DeepCopyInto(out *DependencyBuildSpec) {
 	*out = *in
 	out.ScmInfo = in.ScmInfo
+	if in.ReferenceRepositories != nil {
+		in, out := &in.ReferenceRepositories, &out.ReferenceRepositories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DependencyBuildSpec.
@@ -462,6 +565,11 @@ func (in *DependencyBuildStatus) DeepCopyInto(out *DependencyBuildStatus) {
 		*out = make([]PreBuildImage, len(*in))
 		copy(*out, *in)
 	}
+	if in.SelectedBuildRecipe != nil {
+		in, out := &in.SelectedBuildRecipe, &out.SelectedBuildRecipe
+		*out = new(BuildRecipe)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DependencyBuildStatus.
@@ -474,6 +582,87 @@ func (in *DependencyBuildStatus) DeepCopy() *DependencyBuildStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeployRetryBudget) DeepCopyInto(out *DeployRetryBudget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeployRetryBudget.
+func (in *DeployRetryBudget) DeepCopy() *DeployRetryBudget {
+	if in == nil {
+		return nil
+	}
+	out := new(DeployRetryBudget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiskMonitor) DeepCopyInto(out *DiskMonitor) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiskMonitor.
+func (in *DiskMonitor) DeepCopy() *DiskMonitor {
+	if in == nil {
+		return nil
+	}
+	out := new(DiskMonitor)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DownstreamBuilds) DeepCopyInto(out *DownstreamBuilds) {
+	*out = *in
+	if in.Dependents != nil {
+		in, out := &in.Dependents, &out.Dependents
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DownstreamBuilds.
+func (in *DownstreamBuilds) DeepCopy() *DownstreamBuilds {
+	if in == nil {
+		return nil
+	}
+	out := new(DownstreamBuilds)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressAllowlist) DeepCopyInto(out *EgressAllowlist) {
+	*out = *in
+	if in.AllowedHosts != nil {
+		in, out := &in.AllowedHosts, &out.AllowedHosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressAllowlist.
+func (in *EgressAllowlist) DeepCopy() *EgressAllowlist {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressAllowlist)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitArchive) DeepCopyInto(out *GitArchive) {
 	*out = *in
@@ -504,6 +693,36 @@ func (in *GitSourceArchive) DeepCopy() *GitSourceArchive {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradleBuildCache) DeepCopyInto(out *GradleBuildCache) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradleBuildCache.
+func (in *GradleBuildCache) DeepCopy() *GradleBuildCache {
+	if in == nil {
+		return nil
+	}
+	out := new(GradleBuildCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageMirror) DeepCopyInto(out *ImageMirror) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageMirror.
+func (in *ImageMirror) DeepCopy() *ImageMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageRegistry) DeepCopyInto(out *ImageRegistry) {
 	*out = *in
@@ -533,6 +752,31 @@ func (in *ImageRegistrySpec) DeepCopyInto(out *ImageRegistrySpec) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.Mirror != nil {
+		in, out := &in.Mirror, &out.Mirror
+		*out = new(ImageRegistry)
+		**out = **in
+	}
+	if in.TagHeaders != nil {
+		in, out := &in.TagHeaders, &out.TagHeaders
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TagOptions != nil {
+		in, out := &in.TagOptions, &out.TagOptions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ArchiveAnnotations != nil {
+		in, out := &in.ArchiveAnnotations, &out.ArchiveAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	out.OrasRetry = in.OrasRetry
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRegistrySpec.
@@ -607,6 +851,7 @@ func (in *JBSConfigList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *JBSConfigSpec) DeepCopyInto(out *JBSConfigSpec) {
 	*out = *in
+	in.JarValidation.DeepCopyInto(&out.JarValidation)
 	if in.AdditionalRecipes != nil {
 		in, out := &in.AdditionalRecipes, &out.AdditionalRecipes
 		*out = make([]string, len(*in))
@@ -625,10 +870,25 @@ func (in *JBSConfigSpec) DeepCopyInto(out *JBSConfigSpec) {
 		copy(*out, *in)
 	}
 	in.Registry.DeepCopyInto(&out.Registry)
-	out.MavenDeployment = in.MavenDeployment
+	in.MavenDeployment.DeepCopyInto(&out.MavenDeployment)
 	out.GitSourceArchive = in.GitSourceArchive
-	out.CacheSettings = in.CacheSettings
-	out.BuildSettings = in.BuildSettings
+	in.CacheSettings.DeepCopyInto(&out.CacheSettings)
+	in.BuildSettings.DeepCopyInto(&out.BuildSettings)
+	if in.ImageMirrors != nil {
+		in, out := &in.ImageMirrors, &out.ImageMirrors
+		*out = make([]ImageMirror, len(*in))
+		copy(*out, *in)
+	}
+	if in.MavenMirrors != nil {
+		in, out := &in.MavenMirrors, &out.MavenMirrors
+		*out = make([]MavenMirror, len(*in))
+		copy(*out, *in)
+	}
+	if in.AdditionalCACertificates != nil {
+		in, out := &in.AdditionalCACertificates, &out.AdditionalCACertificates
+		*out = new(CACertificateSource)
+		**out = **in
+	}
 	if in.RelocationPatterns != nil {
 		in, out := &in.RelocationPatterns, &out.RelocationPatterns
 		*out = make([]RelocationPatternElement, len(*in))
@@ -668,6 +928,26 @@ func (in *JBSConfigStatus) DeepCopy() *JBSConfigStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *JarValidation) DeepCopyInto(out *JarValidation) {
+	*out = *in
+	if in.RequiredManifestAttributes != nil {
+		in, out := &in.RequiredManifestAttributes, &out.RequiredManifestAttributes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new JarValidation.
+func (in *JarValidation) DeepCopy() *JarValidation {
+	if in == nil {
+		return nil
+	}
+	out := new(JarValidation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *JavaDependency) DeepCopyInto(out *JavaDependency) {
 	*out = *in
@@ -789,6 +1069,18 @@ func (in *JvmImageScanStatus) DeepCopy() *JvmImageScanStatus {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MavenDeployment) DeepCopyInto(out *MavenDeployment) {
 	*out = *in
+	if in.Repositories != nil {
+		in, out := &in.Repositories, &out.Repositories
+		*out = make([]MavenRepository, len(*in))
+		copy(*out, *in)
+	}
+	out.RetryBudget = in.RetryBudget
+	if in.SigningPublicKey != nil {
+		in, out := &in.SigningPublicKey, &out.SigningPublicKey
+		*out = new(SigningPublicKeySource)
+		**out = **in
+	}
+	in.DownstreamBuilds.DeepCopyInto(&out.DownstreamBuilds)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MavenDeployment.
@@ -801,6 +1093,66 @@ func (in *MavenDeployment) DeepCopy() *MavenDeployment {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MavenMirror) DeepCopyInto(out *MavenMirror) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MavenMirror.
+func (in *MavenMirror) DeepCopy() *MavenMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(MavenMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MavenRepository) DeepCopyInto(out *MavenRepository) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MavenRepository.
+func (in *MavenRepository) DeepCopy() *MavenRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(MavenRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MavenSettingsSource) DeepCopyInto(out *MavenSettingsSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MavenSettingsSource.
+func (in *MavenSettingsSource) DeepCopy() *MavenSettingsSource {
+	if in == nil {
+		return nil
+	}
+	out := new(MavenSettingsSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OrasRetry) DeepCopyInto(out *OrasRetry) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OrasRetry.
+func (in *OrasRetry) DeepCopy() *OrasRetry {
+	if in == nil {
+		return nil
+	}
+	out := new(OrasRetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Pattern) DeepCopyInto(out *Pattern) {
 	*out = *in
@@ -1002,6 +1354,36 @@ func (in *SCMInfo) DeepCopy() *SCMInfo {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SigningPublicKeySource) DeepCopyInto(out *SigningPublicKeySource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SigningPublicKeySource.
+func (in *SigningPublicKeySource) DeepCopy() *SigningPublicKeySource {
+	if in == nil {
+		return nil
+	}
+	out := new(SigningPublicKeySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubmoduleCredential) DeepCopyInto(out *SubmoduleCredential) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubmoduleCredential.
+func (in *SubmoduleCredential) DeepCopy() *SubmoduleCredential {
+	if in == nil {
+		return nil
+	}
+	out := new(SubmoduleCredential)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SystemConfig) DeepCopyInto(out *SystemConfig) {
 	*out = *in
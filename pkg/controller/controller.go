@@ -110,6 +110,7 @@ func NewManager(cfg *rest.Config, options ctrl.Options) (ctrl.Manager, error) {
 			&v1.PersistentVolumeClaim{},
 			&rbacv1.RoleBinding{},
 			&appsv1.Deployment{},
+			&v1.Node{},
 		},
 	}
 
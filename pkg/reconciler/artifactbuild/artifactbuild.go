@@ -43,6 +43,8 @@ const (
 	RebuildAnnotation           = "jvmbuildservice.io/rebuild"
 	// RebuiltAnnotation annotation that is applied after a rebuild, it will affect the dependencybuild behaviour
 	RebuiltAnnotation = "jvmbuildservice.io/rebuilt"
+	// DownstreamOfAnnotation names the dependency build whose deployment triggered the build of this artifact
+	DownstreamOfAnnotation = "jvmbuildservice.io/downstream-of"
 	// HoursToLive if this annotation is present it will be deleted after a set time to live
 	//useful when doing builds that are being deployed to maven, and you don't want to accumulate them in the cluster
 	HoursToLive = "jvmbuildservice.io/hours-to-live"
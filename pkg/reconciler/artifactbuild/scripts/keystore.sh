@@ -6,9 +6,33 @@ FILE="$JAVA_HOME/lib/security/cacerts"
 if [ ! -f "$FILE" ]; then
     FILE="$JAVA_HOME/jre/lib/security/cacerts"
 fi
+# A non-root user can't update the JDK trust store, so a copy of it is updated and used instead.
+if [ ! -w "$FILE" ]; then
+    cp "$FILE" /tmp/jbs-cacerts
+    FILE=/tmp/jbs-cacerts
+    export JAVA_TOOL_OPTIONS="${JAVA_TOOL_OPTIONS:-} -Djavax.net.ssl.trustStore=$FILE"
+fi
 
 if [ -f $(workspaces.tls.path)/service-ca.crt ]; then
     keytool -import -alias jbs-cache-certificate -keystore "$FILE" -file $(workspaces.tls.path)/service-ca.crt -storepass changeit -noprompt
 fi
 
+# Each file may hold several certificates but keytool only imports the first one of a file, so they are split first.
+# The aliases are prefixed so that they are added alongside the system CAs rather than replacing any of them.
+if [ -d $(workspaces.tls.path)/additional-ca ]; then
+    CA_DIR=$(mktemp -d)
+    for CA_FILE in $(workspaces.tls.path)/additional-ca/*; do
+        [ -f "$CA_FILE" ] || continue
+        CA_NAME=$(basename "$CA_FILE")
+        awk -v prefix="$CA_DIR/$CA_NAME-" '/-----BEGIN CERTIFICATE-----/ { n++; out = prefix n ".pem" } out != "" { print > out } /-----END CERTIFICATE-----/ { close(out); out = "" }' "$CA_FILE"
+    done
+    for CA_FILE in "$CA_DIR"/*.pem; do
+        [ -f "$CA_FILE" ] || continue
+        CA_ALIAS="jbs-additional-ca-$(basename "$CA_FILE" .pem)"
+        if ! keytool -list -alias "$CA_ALIAS" -keystore "$FILE" -storepass changeit > /dev/null 2>&1; then
+            keytool -import -alias "$CA_ALIAS" -keystore "$FILE" -file "$CA_FILE" -storepass changeit -noprompt
+        fi
+    done
+    rm -rf "$CA_DIR"
+fi
 
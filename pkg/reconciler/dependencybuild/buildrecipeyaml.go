@@ -7,6 +7,8 @@ import (
 	"github.com/go-logr/logr"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +16,11 @@ import (
 	"github.com/redhat-appstudio/jvm-build-service/pkg/apis/jvmbuildservice/v1alpha1"
 	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/artifactbuild"
 	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/jbsconfig"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/util"
 	tektonpipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/selection"
 )
 
 const (
@@ -24,17 +28,117 @@ const (
 	WorkspaceSource        = "source"
 	WorkspaceMount         = "/var/workdir"
 	WorkspaceTls           = "tls"
-
-	BuildTaskName       = "build"
-	PreBuildTaskName    = "pre-build"
-	PreBuildImageDigest = "PRE_BUILD_IMAGE_DIGEST"
-	TagTaskName         = "tag"
+	// DiagnosticProjectPath is where the project is placed within the diagnostic and konflux container files unless a
+	// workspace mount path is configured
+	DiagnosticProjectPath = "/root/project"
+	// JbsDirectory is the directory within the source the generated Containerfile and build scripts are written into,
+	// AlternateJbsDirectory is used instead if the source has its own and the conflict policy allows it
+	JbsDirectory          = ".jbs"
+	AlternateJbsDirectory = ".jbs-build"
+	// ReferenceArtifactsDirectory is where the artifacts of the reference image are restored to within the source
+	// workspace
+	ReferenceArtifactsDirectory = "reference-artifacts"
+
+	// MaxBuildRetries is the most times a recipe can have the build task retried
+	MaxBuildRetries = 5
+
+	BuildTaskName    = "build"
+	PreBuildTaskName = "pre-build"
+	// The finally task recording where the workspace of a failed build was pushed to
+	FailedWorkspaceTaskName = "failed-workspace"
+	PreBuildImageDigest     = "PRE_BUILD_IMAGE_DIGEST"
+	TagTaskName             = "tag"
+	// The name of the deploy pipeline task if the deployed image is not tagged
+	DeployTaskName = "deploy"
+	// The registry config the secondary registry credentials are written to
+	MirrorRegistryConfig = "/tmp/mirror-registry-config.json"
+	// The full list of verification differences, stored in the logs layer of the post-build image
+	VerificationDiffFile = "verification-diff.json"
+	// The file the allowed differences are written to when there are too many to pass as arguments
+	VerificationExcludesFile = "$(workspaces.source.path)/verification-excludes.txt"
+	// The number of allowed differences above which they are passed to the verification in a file
+	DefaultExcludesFileThreshold = 20
+	// The checksum manifest of the built artifacts, stored in the logs layer of the post-build image
+	ArtifactChecksumsFile = "artifact-checksums.sha256"
+	// The OCI image spec and artifact type of the pre-build and post-build image archives unless overridden
+	DefaultArchiveImageSpec    = "v1.0"
+	DefaultArchiveArtifactType = "application/vnd.oci.image.config.v1+json"
+	// Tracks the retries shared by the steps of the deploy pipeline, within the source workspace
+	DeployRetryBudgetFile = ".deploy-retry-budget"
+	// The delay between deploy retries if the retry budget does not set one
+	DefaultDeployRetryDelaySeconds = 10
+	// The delay before the first retry of a failed oras request if the retries do not set one
+	DefaultOrasRetryBackoffSeconds = 5
+	// The variable holding the configured Maven settings.xml, if one replaces the generated settings
+	MavenSettingsVariable = "JBS_MAVEN_SETTINGS"
+	// The key of the configured Maven settings.xml unless one is set
+	DefaultMavenSettingsKey = "settings.xml"
+	// The variable holding the public key the artifact signatures are verified against before deploying
+	SigningPublicKeyVariable = "ARTIFACT_SIGNING_PUBLIC_KEY"
+	// The key of the signing public key unless one is set
+	DefaultSigningPublicKeyKey = "public-key.asc"
+	// The artifact type of the artifacts pushed in the oci deploy mode
+	OCIDeployArtifactType = "application/vnd.maven.artifact"
+	// The repository of the image registry the artifacts are pushed to in the oci deploy mode unless one is configured
+	DefaultOCIDeployRepository = "maven-artifacts"
+	// Where the Google Cloud service account key is mounted when deploying to a gs:// repository
+	GCSCredentialsPath = "/var/run/secrets/gcs"
+	// Where the ccache volume is mounted in the build step
+	CcacheDirectory = "/var/cache/ccache"
+	// Where the build output is buffered when it is only shown for failed builds
+	QuietBuildOutputLog = "$(workspaces.source.path)/logs/build-output.log"
+	// The directory the flight recordings of the build tool JVMs are written to, within the logs archived with the
+	// post-build image
+	FlightRecordingDir = "$(workspaces.source.path)/logs/jfr"
+	// Records each JVM until it exits, the file name within the directory is generated so the recordings of every
+	// build tool JVM are kept
+	flightRecordingOption = "-XX:StartFlightRecording=dumponexit=true,filename=" + FlightRecordingDir + "/"
+	// The Ant property holding the repository an Ant build that deploys natively deploys to
+	AntDeployUrlProperty = "jbs.deploy.url"
+	// The iptables chain of the egress allowlist
+	egressChain = "JBS_EGRESS"
+
+	// The platforms a recipe can build for
+	PlatformLinuxAmd64 = "linux/amd64"
+	PlatformLinuxArm64 = "linux/arm64"
+
+	TestRunOrderAlphabetical = "alphabetical"
+	TestRunOrderRandom       = "random"
+	// The seed used for random test ordering so that repeated builds run tests in the same order
+	TestRunOrderRandomSeed = "42"
+	// The file an isolated pre build script writes KEY=VALUE lines to, which are then exported to the build
+	PreBuildEnvFile = "$(workspaces.source.path)/build-info/pre-build.env"
+	// The file the recipe Gradle init script is written to, which gradle-build.sh passes to Gradle with --init-script
+	GradleInitScriptFile = "$(workspaces.source.path)/jbs-init.gradle"
+
+	// DiagnosticContextPlaceholder replaces Tekton context variables (e.g. $(context.taskRun.name)) that are only
+	// resolved when running within a cluster.
+	DiagnosticContextPlaceholder = "diagnostic"
 )
 
+// AllowedDifferenceReasonSeparator separates an allowed difference pattern from the optional reason it was allowed
+const AllowedDifferenceReasonSeparator = " # "
+
+// Matches allowed differences that start with a diff marker, which the verifier escapes when passed as arguments
+var excludeDiffMarkerRegex = regexp.MustCompile(`^([+-^]):`)
+
+// plainShellValueRegex matches values that can be used in a shell script without quoting
+var plainShellValueRegex = regexp.MustCompile(`^[A-Za-z0-9_./:,@%+=-]*$`)
+
+var contextVariableRegex = regexp.MustCompile(`\$\(context\.[^)]+\)`)
+
+var arrayParamIndexRegex = regexp.MustCompile(`\$\(params\.([^)\[]+)\[(\d+)\]\)`)
+
+// Matches the numeric and qualifier parts of a version, which are split where digits and letters meet
+var versionPartRegex = regexp.MustCompile(`[0-9]+|[^0-9._-]+`)
+
+// The version qualifiers that are the same as no qualifier
+var finalVersionQualifiers = map[string]bool{"final": true, "ga": true, "release": true}
+
 //go:embed scripts/maven-build.sh
 var mavenBuild string
 
-// used for both ant and maven
+// used for ant, gradle, lein and maven
 //
 //go:embed scripts/maven-settings.sh
 var mavenSettings string
@@ -48,6 +152,9 @@ var sbtBuild string
 //go:embed scripts/ant-build.sh
 var antBuild string
 
+//go:embed scripts/lein-build.sh
+var leinBuild string
+
 //go:embed scripts/install-package.sh
 var packageTemplate string
 
@@ -61,81 +168,165 @@ var buildEntryScript string
 var buildTrustedArtifacts string
 
 func createDeployPipelineSpec(jbsConfig *v1alpha1.JBSConfig, db *v1alpha1.DependencyBuild, buildRequestProcessorImage string, gavs string) (*tektonpipeline.PipelineSpec, error) {
-	zero := int64(0)
+	user := runAsUser(jbsConfig)
 	mavenDeployArgs := pipelineDeployCommands(jbsConfig, db)
 
-	limits, err := memoryLimits(jbsConfig, 0)
-	if err != nil {
+	if err := validateDeployMode(jbsConfig); err != nil {
 		return nil, err
 	}
-	orasOptions := ""
-	if jbsConfig.Annotations != nil && jbsConfig.Annotations[jbsconfig.TestRegistry] == "true" {
-		orasOptions = "--insecure --plain-http"
+	limits, err := memoryLimits(jbsConfig, 0, 0)
+	if err != nil {
+		return nil, err
 	}
+	orasOptions := registryOrasOptions(jbsConfig)
 
 	mavenDeployArgs = append(mavenDeployArgs, gitArgs(jbsConfig, db)...)
 	secretVariables := secretVariables(jbsConfig)
-	pullPolicy := pullPolicy(buildRequestProcessorImage)
+	pullPolicy := pullPolicy(jbsConfig, buildRequestProcessorImage)
 	regUrl := registryArgsWithDefaults(jbsConfig, "")
+	tagOptions := tagOrasOptions(jbsConfig, orasOptions)
+	// The oras requests are retried within the deploy retry budget, if both are configured
+	retryFunctions := deployRetryFunction(jbsConfig) + orasRetryFunction(jbsConfig)
+	retry := deployRetry(jbsConfig) + orasRetry(jbsConfig)
+	tagScript := fmt.Sprintf(`%sGAVS=%s
+echo "Tagging for GAVs ($GAVS)"
+%soras tag %s --verbose %s@$(params.%s) ${GAVS//,/ }
+%s`, retryFunctions, gavs, retry, tagOptions, regUrl, PipelineResultImageDigest, taggedGavsScript(retry, tagHeaderOrasOptions(jbsConfig, orasOptions), regUrl))
+	if len(db.Status.BuildAttempts) > 0 {
+		// The post-build image was pushed to the mirror under the build id tag so tag that rather than the digest
+		// which is only known for the primary registry.
+		buildId := db.Status.BuildAttempts[len(db.Status.BuildAttempts)-1].BuildId
+		if mirrorUrl := mirrorRegistryArgsWithDefaults(jbsConfig, buildId); mirrorUrl != "" && buildId != "" {
+			tagScript += fmt.Sprintf(`
+echo "Tagging mirror %s for GAVs ($GAVS)"
+%s%soras tag %s --verbose %s ${GAVS//,/ }`, mirrorUrl, mirrorRegistryConfigScript(jbsConfig), retry, mirrorOrasOptions(jbsConfig, tagOptions), mirrorUrl)
+		}
+	}
+
+	restoreScript := fmt.Sprintf(`%secho "Restoring artifacts to workspace"
+export ORAS_OPTIONS="%s"
+URL=%s
+DIGEST=$(params.%s)
+echo "URL $URL DIGEST $DIGEST"
+MANIFEST=$(%[5]soras manifest fetch $ORAS_OPTIONS $URL@$DIGEST)
+if echo "$MANIFEST" | jq --exit-status '.manifests' > /dev/null; then
+  # A manifest list has no layers of its own so take them from its first image. The list itself is what is tagged.
+  ENTRY=$(echo "$MANIFEST" | jq --raw-output '.manifests[0].digest')
+  echo "DIGEST $DIGEST is a manifest list, restoring from $ENTRY"
+  MANIFEST=$(%[5]soras manifest fetch $ORAS_OPTIONS $URL@$ENTRY)
+fi
+SARCHIVE=$(echo "$MANIFEST" | jq --raw-output '.layers[0].digest')
+AARCHIVE=$(echo "$MANIFEST" | jq --raw-output '.layers[2].digest')
+%[5]suse-archive oci:$URL@$SARCHIVE=$(workspaces.source.path)/source-archive oci:$URL@$AARCHIVE=$(workspaces.source.path)/artifacts`, retryFunctions, orasOptions, regUrl, PipelineResultImageDigest, retry)
+	params := []tektonpipeline.ParamSpec{{Name: PipelineResultImageDigest, Type: tektonpipeline.ParamTypeString}}
+	if jbsConfig.Spec.MavenDeployment.ValidateChecksums {
+		// The checksum manifest is within the logs layer so restore that as well.
+		restoreScript += fmt.Sprintf(`
+LARCHIVE=$(echo "$MANIFEST" | jq --raw-output '.layers[1].digest')
+%suse-archive oci:$URL@$LARCHIVE=$(workspaces.source.path)/logs`, retry)
+		params = append(params, tektonpipeline.ParamSpec{Name: PipelineResultArtifactChecksums, Type: tektonpipeline.ParamTypeString})
+	}
+	taskParams := []tektonpipeline.Param{}
+	for _, p := range params {
+		taskParams = append(taskParams, tektonpipeline.Param{Name: p.Name, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(params." + p.Name + ")"}})
+	}
+	gcsVolumes, gcsVolumeMounts := gcsCredentialsVolume(jbsConfig)
+	deployStep := tektonpipeline.Step{
+		Name:            "maven-deployment",
+		Image:           buildRequestProcessorImage,
+		ImagePullPolicy: pullPolicy,
+		SecurityContext: &v1.SecurityContext{RunAsUser: user},
+		Env:             secretVariables,
+		ComputeResources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultRequestCPU},
+			Limits:   v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultLimitCPU},
+		},
+		Script:       artifactbuild.InstallKeystoreIntoBuildRequestProcessor(mavenDeployArgs),
+		VolumeMounts: gcsVolumeMounts,
+	}
+	if jbsConfig.Spec.MavenDeployment.Mode == v1alpha1.DeployModeOCI {
+		deployStep = tektonpipeline.Step{
+			Name:            "oci-deployment",
+			Image:           trustedArtifactsImage(jbsConfig),
+			ImagePullPolicy: v1.PullIfNotPresent,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Env:             secretVariables,
+			Script:          ociDeployScript(jbsConfig, orasOptions),
+		}
+	}
 
 	tagTask := tektonpipeline.TaskSpec{
-		Workspaces: []tektonpipeline.WorkspaceDeclaration{{Name: WorkspaceTls}, {Name: WorkspaceSource, MountPath: WorkspaceMount}},
-		Params:     []tektonpipeline.ParamSpec{{Name: PipelineResultImageDigest, Type: tektonpipeline.ParamTypeString}},
+		Workspaces: []tektonpipeline.WorkspaceDeclaration{{Name: WorkspaceTls}, {Name: WorkspaceSource, MountPath: workspaceMount(jbsConfig)}},
+		Params:     params,
+		Volumes:    gcsVolumes,
 		Steps: []tektonpipeline.Step{
 			{
 				Name:            "restore-post-build-artifacts",
-				Image:           strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1]),
+				Image:           trustedArtifactsImage(jbsConfig),
 				ImagePullPolicy: v1.PullIfNotPresent,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+				SecurityContext: &v1.SecurityContext{RunAsUser: user},
 				Env:             secretVariables,
 				// While the manifest digest is available we need the manifest of the layer within the archive hence
 				// using 'oras manifest fetch' to extract the correct layer.
-				Script: fmt.Sprintf(`echo "Restoring artifacts to workspace"
-export ORAS_OPTIONS="%s"
-URL=%s
-DIGEST=$(params.%s)
-echo "URL $URL DIGEST $DIGEST"
-SARCHIVE=$(oras manifest fetch $ORAS_OPTIONS $URL@$DIGEST | jq --raw-output '.layers[0].digest')
-AARCHIVE=$(oras manifest fetch $ORAS_OPTIONS $URL@$DIGEST | jq --raw-output '.layers[2].digest')
-use-archive oci:$URL@$SARCHIVE=$(workspaces.source.path)/source-archive oci:$URL@$AARCHIVE=$(workspaces.source.path)/artifacts`, orasOptions, regUrl, PipelineResultImageDigest),
-			},
-			{
-				Name:            "maven-deployment",
-				Image:           buildRequestProcessorImage,
-				ImagePullPolicy: pullPolicy,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
-				Env:             secretVariables,
-				ComputeResources: v1.ResourceRequirements{
-					Requests: v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultRequestCPU},
-					Limits:   v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultLimitCPU},
-				},
-				Script: artifactbuild.InstallKeystoreIntoBuildRequestProcessor(mavenDeployArgs),
-			},
-			{
-				Name:            "tag",
-				Image:           strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1]),
-				ImagePullPolicy: v1.PullIfNotPresent,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
-				Env:             secretVariables,
-				// gavs is a comma separated list so split it into spaces
-				Script: fmt.Sprintf(`GAVS=%s
-echo "Tagging for GAVs ($GAVS)"
-oras tag %s --verbose %s@$(params.%s) ${GAVS//,/ }`, gavs, orasOptions, regUrl, PipelineResultImageDigest),
+				Script: restoreScript,
 			},
+			deployStep,
 		},
 	}
+	taskName := DeployTaskName
+	if !skipImageTagging(jbsConfig) {
+		taskName = TagTaskName
+		tagTask.Steps = append(tagTask.Steps, tektonpipeline.Step{
+			Name:            "tag",
+			Image:           trustedArtifactsImage(jbsConfig),
+			ImagePullPolicy: v1.PullIfNotPresent,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Env:             secretVariables,
+			// gavs is a comma separated list so split it into spaces
+			Script: tagScript,
+		})
+		tagTask.Results = []tektonpipeline.TaskResult{{Name: PipelineResultTaggedGavs, Description: "The number of GAVs the image was tagged for"}}
+	}
+
+	if jbsConfig.Spec.MavenDeployment.ValidateChecksums {
+		// Compare the restored artifacts against the checksums recorded by the build before anything is deployed.
+		validate := tektonpipeline.Step{
+			Name:            "validate-artifact-checksums",
+			Image:           trustedArtifactsImage(jbsConfig),
+			ImagePullPolicy: v1.PullIfNotPresent,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Script:          validateChecksumsScript(),
+		}
+		tagTask.Steps = append(tagTask.Steps[:1], append([]tektonpipeline.Step{validate}, tagTask.Steps[1:]...)...)
+	}
+	if keyVariable := signingPublicKeyVariable(jbsConfig); keyVariable != nil {
+		// The signatures are verified just before the deploy so nothing is deployed unless they are all valid
+		verify := tektonpipeline.Step{
+			Name:            "verify-artifact-signatures",
+			Image:           buildRequestProcessorImage,
+			ImagePullPolicy: pullPolicy,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Env:             []v1.EnvVar{*keyVariable},
+			Script:          verifySignaturesScript(),
+		}
+		for i, step := range tagTask.Steps {
+			if step.Name == deployStep.Name {
+				tagTask.Steps = append(tagTask.Steps[:i], append([]tektonpipeline.Step{verify}, tagTask.Steps[i:]...)...)
+				break
+			}
+		}
+	}
 
 	ps := &tektonpipeline.PipelineSpec{
-		Params: []tektonpipeline.ParamSpec{{Name: PipelineResultImageDigest, Type: tektonpipeline.ParamTypeString}},
+		Params: params,
 		Tasks: []tektonpipeline.PipelineTask{
 			{
-				Name: TagTaskName,
+				Name: taskName,
 				TaskSpec: &tektonpipeline.EmbeddedTask{
 					TaskSpec: tagTask,
 				},
-				Params: []tektonpipeline.Param{
-					{Name: PipelineResultImageDigest, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(params." + PipelineResultImageDigest + ")"}},
-				},
+				Timeout: &v12.Duration{Duration: buildTimeout(jbsConfig)},
+				Params:  taskParams,
 				Workspaces: []tektonpipeline.WorkspacePipelineTaskBinding{
 					{Name: WorkspaceTls, Workspace: WorkspaceTls},
 					{Name: WorkspaceSource, Workspace: WorkspaceSource},
@@ -144,95 +335,107 @@ oras tag %s --verbose %s@$(params.%s) ${GAVS//,/ }`, gavs, orasOptions, regUrl,
 		},
 		Workspaces: []tektonpipeline.PipelineWorkspaceDeclaration{{Name: WorkspaceSource}, {Name: WorkspaceTls}},
 	}
+	for _, i := range tagTask.Results {
+		ps.Results = append(ps.Results, tektonpipeline.PipelineResult{Name: i.Name, Description: i.Description, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(tasks." + taskName + ".results." + i.Name + ")"}})
+	}
 	return ps, nil
 }
+
+// skipImageTagging returns true if the deploy pipeline only deploys the artifacts to the Maven repositories without
+// tagging the deployed image in the image registry. The image is always tagged when the artifacts are deployed to the
+// image registry.
+func skipImageTagging(jbsConfig *v1alpha1.JBSConfig) bool {
+	return jbsConfig.Spec.MavenDeployment.SkipImageTagging && len(mavenRepositories(jbsConfig)) > 0 && jbsConfig.Spec.MavenDeployment.Mode != v1alpha1.DeployModeOCI
+}
+
+// taggedGavsScript counts the GAVs whose tag resolves to the deployed image and records the count in the
+// TAGGED_GAVS result. The step fails if not all the requested GAVs were tagged, so partial deploys are caught.
+func taggedGavsScript(retry string, orasOptions string, regUrl string) string {
+	if orasOptions != "" {
+		orasOptions += " "
+	}
+	return fmt.Sprintf(`REQUESTED=0
+TAGGED=0
+for GAV in ${GAVS//,/ }; do
+  REQUESTED=$((REQUESTED + 1))
+  if [ "$(%[5]soras manifest fetch --descriptor %[1]s%[2]s:$GAV | jq --raw-output '.digest')" == "$(params.%[3]s)" ]; then
+    TAGGED=$((TAGGED + 1))
+  else
+    echo "GAV tag $GAV does not reference $(params.%[3]s)" >&2
+  fi
+done
+echo -n "$TAGGED" > $(results.%[4]s.path)
+if [ "$TAGGED" -ne "$REQUESTED" ]; then
+  echo "Only $TAGGED of $REQUESTED GAVs were tagged" >&2
+  exit 1
+fi`, orasOptions, regUrl, PipelineResultImageDigest, PipelineResultTaggedGavs, retry)
+}
 func createPipelineSpec(log logr.Logger, tool string, commitTime int64, jbsConfig *v1alpha1.JBSConfig, systemConfig *v1alpha1.SystemConfig, recipe *v1alpha1.BuildRecipe, db *v1alpha1.DependencyBuild, paramValues []tektonpipeline.Param, buildRequestProcessorImage string, buildId string, existingImages map[string]string) (*tektonpipeline.PipelineSpec, string, string, string, error) {
 
 	// Rather than tagging with hash of json build recipe, buildrequestprocessor image and db.Name as the former two
 	// could change with new image versions just use db.Name (which is a hash of scm url/tag/path so should be stable)
 	imageId := db.Name
-	zero := int64(0)
-	verifyBuiltArtifactsArgs := verifyParameters(jbsConfig, recipe)
+	user := runAsUser(jbsConfig)
+	verifyBuiltArtifactsArgs := verifyParameters(jbsConfig, db, recipe)
 	preBuildImageArgs, postBuildImageArgs, copyArtifactsArgs, deployArgs, konfluxArgs := pipelineBuildCommands(imageId, db, jbsConfig, buildId)
+	deployArgs = append(deployArgs, allowedContaminantArgs(recipe)...)
+	copyArtifactsArgs = append(copyArtifactsArgs, testArtifactArgs(jbsConfig)...)
+	deployArgs = append(deployArgs, testArtifactArgs(jbsConfig)...)
 
-	gitScript := gitScript(db, recipe)
+	scmScript := scmScript(db, recipe)
 	install := additionalPackages(recipe)
-	orasOptions := ""
-	if jbsConfig.Annotations != nil && jbsConfig.Annotations[jbsconfig.TestRegistry] == "true" {
-		orasOptions = "--insecure --plain-http"
-	}
+	orasOptions := registryOrasOptions(jbsConfig)
 
-	preprocessorArgs := []string{
-		"maven-prepare",
-		"$(workspaces." + WorkspaceSource + ".path)/source",
-	}
-	if len(recipe.DisabledPlugins) > 0 {
-		for _, i := range recipe.DisabledPlugins {
-			preprocessorArgs = append(preprocessorArgs, "-dp "+i)
-		}
-	}
-	var javaHome string
-	if recipe.JavaVersion == "7" || recipe.JavaVersion == "8" {
-		javaHome = "/lib/jvm/java-1." + recipe.JavaVersion + ".0"
-	} else {
-		javaHome = "/lib/jvm/java-" + recipe.JavaVersion
+	compileJavaHome := javaHome(recipe, recipe.JavaVersion)
+	launcherJavaHome := compileJavaHome
+	if recipe.LauncherJavaVersion != "" {
+		launcherJavaHome = javaHome(recipe, recipe.LauncherJavaVersion)
 	}
 
 	toolEnv := []v1.EnvVar{}
-	if recipe.ToolVersions["maven"] != "" {
-		toolEnv = append(toolEnv, v1.EnvVar{Name: "MAVEN_HOME", Value: "/opt/maven/" + recipe.ToolVersions["maven"]})
-	}
-	if recipe.ToolVersions["gradle"] != "" {
-		toolEnv = append(toolEnv, v1.EnvVar{Name: "GRADLE_HOME", Value: "/opt/gradle/" + recipe.ToolVersions["gradle"]})
-	}
-	if recipe.ToolVersions["ant"] != "" {
-		toolEnv = append(toolEnv, v1.EnvVar{Name: "ANT_HOME", Value: "/opt/ant/" + recipe.ToolVersions["ant"]})
-	}
-	if recipe.ToolVersions["sbt"] != "" {
-		toolEnv = append(toolEnv, v1.EnvVar{Name: "SBT_DIST", Value: "/opt/sbt/" + recipe.ToolVersions["sbt"]})
+	for _, i := range []string{"maven", "gradle", "ant", "lein", "sbt"} {
+		version := recipe.ToolVersions[i]
+		for _, additional := range recipe.AdditionalTools {
+			if version == "" && additional.Tool == i {
+				version = additional.Version
+			}
+		}
+		if version != "" {
+			toolEnv = append(toolEnv, v1.EnvVar{Name: toolHomeVariables[i], Value: "/opt/" + i + "/" + version})
+		}
 	}
 	toolEnv = append(toolEnv, v1.EnvVar{Name: PipelineParamToolVersion, Value: recipe.ToolVersion})
 	toolEnv = append(toolEnv, v1.EnvVar{Name: PipelineParamProjectVersion, Value: db.Spec.Version})
-	toolEnv = append(toolEnv, v1.EnvVar{Name: JavaHome, Value: javaHome})
-	toolEnv = append(toolEnv, v1.EnvVar{Name: PipelineParamEnforceVersion, Value: recipe.EnforceVersion})
+	toolEnv = append(toolEnv, v1.EnvVar{Name: JavaHome, Value: launcherJavaHome})
+	toolEnv = append(toolEnv, v1.EnvVar{Name: CompileJavaHome, Value: compileJavaHome})
+	toolEnv = append(toolEnv, v1.EnvVar{Name: PipelineParamEnforceVersion, Value: enforcedVersion(db, recipe)})
+	toolEnv = append(toolEnv, extraEnv(log, recipe, append(append(append(append(toolEnv, gradleBuildCacheVariables(tool, jbsConfig)...), mavenSettingsVariables(jbsConfig)...), ccacheVariables(jbsConfig)...), v1.EnvVar{Name: PipelineParamCacheUrl}))...)
 
 	additionalMemory := recipe.AdditionalMemory
 	if systemConfig.Spec.MaxAdditionalMemory > 0 && additionalMemory > systemConfig.Spec.MaxAdditionalMemory {
 		log.Info(fmt.Sprintf("additionalMemory specified %#v but system MaxAdditionalMemory is %#v and is limiting that value", additionalMemory, systemConfig.Spec.MaxAdditionalMemory))
 		additionalMemory = systemConfig.Spec.MaxAdditionalMemory
 	}
-	var buildToolSection string
-	trueBool := true
-	if tool == "maven" {
-		buildToolSection = mavenSettings + "\n" + mavenBuild
-	} else if tool == "gradle" {
-		// We always add Maven information (in InvocationBuilder) so add the relevant settings.xml
-		buildToolSection = mavenSettings + "\n" + gradleBuild
-		preprocessorArgs = []string{
-			"gradle-prepare",
-			"$(workspaces." + WorkspaceSource + ".path)/source",
-		}
-		if len(recipe.DisabledPlugins) > 0 {
-			for _, i := range recipe.DisabledPlugins {
-				preprocessorArgs = append(preprocessorArgs, "-dp "+i)
-			}
+	additionalCPU := recipe.AdditionalCPU
+	if systemConfig.Spec.MaxAdditionalCPU > 0 && additionalCPU > systemConfig.Spec.MaxAdditionalCPU {
+		log.Info(fmt.Sprintf("additionalCPU specified %#v but system MaxAdditionalCPU is %#v and is limiting that value", additionalCPU, systemConfig.Spec.MaxAdditionalCPU))
+		additionalCPU = systemConfig.Spec.MaxAdditionalCPU
+	}
+	limits, err := memoryLimits(jbsConfig, additionalMemory, additionalCPU)
+	if err != nil {
+		return nil, "", "", "", err
+	}
+	if !plainShellValueRegex.MatchString(db.Spec.ReferenceArtifactImage) {
+		return nil, "", "", "", fmt.Errorf("invalid reference artifact image %#v", db.Spec.ReferenceArtifactImage)
+	}
+	for _, i := range db.Spec.ReferenceRepositories {
+		if i == "" || !plainShellValueRegex.MatchString(i) {
+			return nil, "", "", "", fmt.Errorf("invalid reference repository %#v", i)
 		}
-	} else if tool == "sbt" {
-		buildToolSection = sbtBuild
-		preprocessorArgs[0] = "sbt-prepare"
-	} else if tool == "ant" {
-		// We always add Maven information (in InvocationBuilder) so add the relevant settings.xml
-		buildToolSection = mavenSettings + "\n" + antBuild
-		preprocessorArgs[0] = "ant-prepare"
-	} else {
-		buildToolSection = "echo unknown build tool " + tool + " && exit 1"
 	}
-	build := buildEntryScript
-	//horrible hack
-	//we need to get our TLS CA's into our trust store
-	//we just add it at the start of the build
-	build = artifactbuild.InstallKeystoreScript() + "\n" + build
-
+	if recipe.Architecture != "" && recipe.Architecture != PlatformLinuxAmd64 && recipe.Architecture != PlatformLinuxArm64 {
+		return nil, "", "", "", fmt.Errorf("unsupported architecture %#v", recipe.Architecture)
+	}
 	buildRepos := ""
 	if len(recipe.Repositories) > 0 {
 		for c, i := range recipe.Repositories {
@@ -243,101 +446,205 @@ func createPipelineSpec(log logr.Logger, tool string, commitTime int64, jbsConfi
 			}
 		}
 	}
-	build = strings.ReplaceAll(build, "{{BUILD}}", buildToolSection)
-	build = strings.ReplaceAll(build, "{{INSTALL_PACKAGE_SCRIPT}}", install)
-	build = strings.ReplaceAll(build, "{{PRE_BUILD_SCRIPT}}", recipe.PreBuildScript)
-	build = strings.ReplaceAll(build, "{{POST_BUILD_SCRIPT}}", recipe.PostBuildScript)
-	cacheUrl := "https://jvm-build-workspace-artifact-cache-tls." + jbsConfig.Namespace + ".svc.cluster.local/v2/cache/rebuild"
-	if jbsConfig.Spec.CacheSettings.DisableTLS {
-		cacheUrl = "http://jvm-build-workspace-artifact-cache." + jbsConfig.Namespace + ".svc.cluster.local/v2/cache/rebuild"
+	cacheUrl := cacheServiceUrl(jbsConfig) + "/v2/cache/rebuild"
+	cacheTime := cachePinTime(jbsConfig, commitTime)
+	goals := defaultGoals[tool]
+	pipelineParams := pipelineParamSpecs(cacheUrl+buildRepos+"/"+strconv.FormatInt(cacheTime, 10), goals)
+	if err := validateParamTypes(pipelineParams, paramValues); err != nil {
+		return nil, "", "", "", err
+	}
+	paramValues = withDefaultGoals(paramValues, goals)
+	if err := validateDeployMode(jbsConfig); err != nil {
+		return nil, "", "", "", err
+	}
+	if egress := jbsConfig.Spec.BuildSettings.EgressAllowlist; egress.Enabled && egress.Image == "" {
+		return nil, "", "", "", fmt.Errorf("the egress allowlist is enabled but has no image to apply it")
+	}
+	for name := range recipe.BuildSettingsFiles {
+		if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+			return nil, "", "", "", fmt.Errorf("invalid build settings file name %#v", name)
+		}
+	}
+	switch db.Spec.ScmInfo.SCMType {
+	case "", v1alpha1.SCMTypeGit:
+	case v1alpha1.SCMTypeSubversion, v1alpha1.SCMTypeMercurial:
+		if db.Spec.ScmInfo.Private {
+			return nil, "", "", "", fmt.Errorf("private %s repositories are not supported", db.Spec.ScmInfo.SCMType)
+		}
+	default:
+		return nil, "", "", "", fmt.Errorf("unsupported SCM type %#v", db.Spec.ScmInfo.SCMType)
+	}
+	for _, i := range recipe.SubmoduleCredentials {
+		if !slices.Contains(jbsConfig.Spec.BuildSettings.AllowedSubmoduleSecrets, i.SecretName) {
+			return nil, "", "", "", fmt.Errorf("submodule credentials secret %#v is not in the allowed submodule secrets", i.SecretName)
+		}
+	}
+	if isVersionRange(recipe.EnforceVersion) {
+		if _, err := parseVersionRange(recipe.EnforceVersion); err != nil {
+			return nil, "", "", "", err
+		}
+	}
+	if recipe.TestRunOrder != "" && recipe.TestRunOrder != TestRunOrderAlphabetical && recipe.TestRunOrder != TestRunOrderRandom {
+		log.Info(fmt.Sprintf("testRunOrder %#v is not supported, using the build tool default", recipe.TestRunOrder))
+	}
+	trueBool := true
+	buildToolSection := scriptSection(jbsConfig, "build tool "+tool, toolBuildSection(tool, jbsConfig, recipe))
+	preprocessorCommands := [][]string{preprocessorArgs(tool, recipe)}
+	// Any additional tools are run in order once the main tool has finished, each with its own arguments
+	for _, i := range recipe.AdditionalTools {
+		args := []string{}
+		for _, arg := range i.CommandLine {
+			args = append(args, shellQuote(arg))
+		}
+		buildToolSection += "\nset -- " + strings.Join(args, " ") + "\n" + scriptSection(jbsConfig, "build tool "+i.Tool, toolBuildSection(i.Tool, jbsConfig, recipe))
+		preprocessorCommands = append(preprocessorCommands, preprocessorArgs(i.Tool, recipe))
+	}
+	jvmOptions := []string{}
+	if processorCount := activeProcessorCount(jbsConfig, recipe, limits); processorCount != "" {
+		jvmOptions = append(jvmOptions, processorCount)
+	}
+	if jbsConfig.Spec.BuildSettings.FlightRecording {
+		jvmOptions = append(jvmOptions, flightRecordingOption)
+	}
+	if len(jvmOptions) > 0 {
+		// Appended in the script rather than set on the step so any JAVA_TOOL_OPTIONS from the builder image are kept
+		buildToolSection = "export JAVA_TOOL_OPTIONS=\"${JAVA_TOOL_OPTIONS:-} " + strings.Join(jvmOptions, " ") + "\"\n" + buildToolSection
 	}
+	if jbsConfig.Spec.BuildSettings.FlightRecording {
+		buildToolSection = "mkdir -p " + FlightRecordingDir + "\n" + buildToolSection
+	}
+	buildToolSection = quietBuildOutputScript(jbsConfig, buildToolSection)
+	build := buildEntryScript
+	//horrible hack
+	//we need to get our TLS CA's into our trust store
+	//we just add it at the start of the build
+	build = artifactbuild.InstallKeystoreScript() + "\n" + build
 
-	//we generate a docker file that can be used to reproduce this build
-	//this is for diagnostic purposes, if you have a failing build it can be really hard to figure out how to fix it without this
-	log.Info(fmt.Sprintf("Generating dockerfile with recipe build image %#v", recipe.Image))
-	preprocessorScript := "#!/bin/sh\n/root/software/system-java/bin/java -jar /root/software/build-request-processor/quarkus-run.jar " + doSubstitution(strings.Join(preprocessorArgs, " "), paramValues, commitTime, buildRepos) + "\n"
-	buildScript := doSubstitution(build, paramValues, commitTime, buildRepos)
-	envVars := extractEnvVar(toolEnv)
+	build = strings.ReplaceAll(build, "{{BUILD}}", buildToolSection)
+	build = strings.ReplaceAll(build, "{{INSTALL_PACKAGE_SCRIPT}}", scriptSection(jbsConfig, "install-package", install))
+	build = strings.ReplaceAll(build, "{{DISK_MONITOR}}", diskMonitorScript(jbsConfig))
+	build = strings.ReplaceAll(build, "{{HOME}}", homeScript(recipe))
+	build = strings.ReplaceAll(build, "{{PRE_BUILD_SCRIPT}}", scriptSection(jbsConfig, "pre-build", preBuildScript(recipe)))
+	build = strings.ReplaceAll(build, "{{POST_BUILD_SCRIPT}}", scriptSection(jbsConfig, "post-build", recipe.PostBuildScript))
+	log.V(1).Info("Creating build pipeline", "tool", tool, "image", recipe.Image, "cacheUrl", cacheUrl+buildRepos, "buildRequestProcessorImage", buildRequestProcessorImage, "commitTime", commitTime)
+
+	projectPath := projectPath(jbsConfig)
+	buildScript := doSubstitution(build, paramValues, cacheTime, buildRepos, projectPath)
+	envVars := extractEnvVar(toolEnv) + resolveToolSymlinksScript(jbsConfig, toolEnv)
+	contextDir := db.Spec.ScmInfo.Path
+	if recipe.ContextPath != "" {
+		contextDir = recipe.ContextPath
+	}
+	extraArgs := append(mavenAlsoMakeArgs(tool, recipe, contextDir), mavenTestOrderArgs(tool, recipe)...)
+	extraArgs = append(extraArgs, mavenCompilerArgs(tool, compileJavaHome, launcherJavaHome)...)
 	cmdArgs := extractArrayParam(PipelineParamGoals, paramValues)
-	konfluxScript := "#!/bin/sh\n" + envVars + "\nset -- \"$@\" " + cmdArgs + "\n\n" + buildScript
-
-	df := "FROM " + buildRequestProcessorImage + " AS build-request-processor" +
-		"\nFROM " + strings.ReplaceAll(buildRequestProcessorImage, "hacbs-jvm-build-request-processor", "hacbs-jvm-cache") + " AS cache" +
-		"\nFROM " + recipe.Image +
-		"\nUSER 0" +
-		"\nWORKDIR /root" +
-		"\nENV CACHE_URL=" + doSubstitution("$(params."+PipelineParamCacheUrl+")", paramValues, commitTime, buildRepos) +
-		"\nRUN mkdir -p /root/project /root/software/settings /original-content/marker && microdnf install vim curl procps-ng" +
-		// TODO: Debug only
-		"\nRUN rpm -ivh https://vault.centos.org/8.5.2111/BaseOS/x86_64/os/Packages/tree-1.7.0-15.el8.x86_64.rpm" +
-		"\nCOPY --from=build-request-processor /deployments/ /root/software/build-request-processor" +
-		// Copying JDK17 for the cache.
-		// TODO: Could we determine if we are using UBI8 and avoid this?
-		"\nCOPY --from=build-request-processor /lib/jvm/jre-17 /root/software/system-java" +
-		"\nCOPY --from=build-request-processor /etc/java/java-17-openjdk /etc/java/java-17-openjdk" +
-		"\nCOPY --from=cache /deployments/ /root/software/cache" +
-		// Use git script rather than the preBuildImages as they are OCI archives and can't be used with docker/podman.
-		"\nRUN " + doSubstitution(gitScript, paramValues, commitTime, buildRepos) +
-		"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n/root/software/system-java/bin/java -Dbuild-policy.default.store-list=rebuilt,central,jboss,redhat -Dkube.disabled=true -Dquarkus.kubernetes-client.trust-certs=true -jar /root/software/cache/quarkus-run.jar >/root/cache.log &"+
-		"\nwhile ! cat /root/cache.log | grep 'Listening on:'; do\n        echo \"Waiting for Cache to start\"\n        sleep 1\ndone \n")) + " | base64 -d >/root/start-cache.sh" +
-		"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(preprocessorScript)) + " | base64 -d >/root/preprocessor.sh" +
-		"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(buildScript)) + " | base64 -d >/root/build.sh" +
-		"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n/root/preprocessor.sh\n"+envVars+"\n/root/build.sh "+cmdArgs+"\n")) + " | base64 -d >/root/run-full-build.sh" +
-		"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(dockerfileEntryScript)) + " | base64 -d >/root/entry-script.sh" +
-		"\nRUN chmod +x /root/*.sh" +
-		"\nCMD [ \"/bin/bash\", \"/root/entry-script.sh\" ]"
-
-	kf := "FROM " + recipe.Image +
+	if len(extraArgs) > 0 {
+		cmdArgs += doSubstitution(strings.Join(extraArgs, " "), paramValues, cacheTime, buildRepos, projectPath) + " "
+	}
+	settingsFilesScript := buildSettingsFilesScript(recipe)
+	konfluxScript := "#!/bin/sh\n" + envVars + "\nset -- \"$@\" " + cmdArgs + "\n\n" + doSubstitution(settingsFilesScript, paramValues, cacheTime, buildRepos, projectPath) + buildScript
+
+	// The diagnostic Containerfile is not needed to run the build, so generating it can be disabled
+	df := ""
+	if !jbsConfig.Spec.BuildSettings.DisableDiagnosticContainerfile {
+		//we generate a docker file that can be used to reproduce this build
+		//this is for diagnostic purposes, if you have a failing build it can be really hard to figure out how to fix it without this
+		log.Info(fmt.Sprintf("Generating dockerfile with recipe build image %#v", recipe.Image))
+		// The preprocessor JDK is copied from the build request processor image, as it is in the pipeline
+		preprocessorJava := "/root/software/system-java"
+		preprocessorJavaCopy := ""
+		if recipe.PreprocessorJavaHome != "" {
+			preprocessorJava = "/root/software/preprocessor-java"
+			preprocessorJavaCopy = "\nCOPY --from=build-request-processor " + recipe.PreprocessorJavaHome + " " + preprocessorJava
+		}
+		settingsFilesCommand := ""
+		if settingsFilesScript != "" {
+			settingsFilesCommand = "\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(doSubstitution(settingsFilesScript, paramValues, cacheTime, buildRepos, projectPath))) + " | base64 -d | sh"
+		}
+		preprocessorScript := "#!/bin/sh\n"
+		for _, i := range preprocessorCommands {
+			preprocessorScript += preprocessorJava + "/bin/java -jar /root/software/build-request-processor/quarkus-run.jar " + doSubstitution(strings.Join(i, " "), paramValues, cacheTime, buildRepos, projectPath) + "\n"
+		}
+		df = "FROM " + buildRequestProcessorImage + " AS build-request-processor" +
+			"\nFROM " + strings.ReplaceAll(buildRequestProcessorImage, "hacbs-jvm-build-request-processor", "hacbs-jvm-cache") + " AS cache" +
+			"\nFROM " + platformOption(recipe) + recipe.Image +
+			"\nUSER 0" +
+			"\nWORKDIR /root" +
+			"\nENV CACHE_URL=" + doSubstitution("$(params."+PipelineParamCacheUrl+")", paramValues, cacheTime, buildRepos, projectPath) +
+			"\nRUN mkdir -p " + projectPath + " /root/software/settings /original-content/marker && microdnf install vim curl procps-ng" +
+			// TODO: Debug only
+			"\nRUN rpm -ivh https://vault.centos.org/8.5.2111/BaseOS/x86_64/os/Packages/tree-1.7.0-15.el8.x86_64.rpm" +
+			"\nCOPY --from=build-request-processor /deployments/ /root/software/build-request-processor" +
+			// Copying JDK17 for the cache.
+			// TODO: Could we determine if we are using UBI8 and avoid this?
+			"\nCOPY --from=build-request-processor /lib/jvm/jre-17 /root/software/system-java" +
+			"\nCOPY --from=build-request-processor /etc/java/java-17-openjdk /etc/java/java-17-openjdk" +
+			preprocessorJavaCopy +
+			"\nCOPY --from=cache /deployments/ /root/software/cache" +
+			// Use the scm script rather than the preBuildImages as they are OCI archives and can't be used with docker/podman.
+			"\nRUN " + doSubstitution(scmScript, paramValues, cacheTime, buildRepos, projectPath) +
+			settingsFilesCommand +
+			"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n/root/software/system-java/bin/java -Dbuild-policy.default.store-list=rebuilt,central,jboss,redhat -Dkube.disabled=true -Dquarkus.kubernetes-client.trust-certs=true -jar /root/software/cache/quarkus-run.jar >/root/cache.log &"+
+			"\nwhile ! cat /root/cache.log | grep 'Listening on:'; do\n        echo \"Waiting for Cache to start\"\n        sleep 1\ndone \n")) + " | base64 -d >/root/start-cache.sh" +
+			"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(preprocessorScript)) + " | base64 -d >/root/preprocessor.sh" +
+			"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(buildScript)) + " | base64 -d >/root/build.sh" +
+			"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n/root/preprocessor.sh\n"+envVars+"\n/root/build.sh "+cmdArgs+"\n")) + " | base64 -d >/root/run-full-build.sh" +
+			"\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(dockerfileEntryScript)) + " | base64 -d >/root/entry-script.sh" +
+			"\nRUN chmod +x /root/*.sh" +
+			"\nCMD [ \"/bin/bash\", \"/root/entry-script.sh\" ]"
+	}
+
+	kf := "FROM " + platformOption(recipe) + recipe.Image +
 		"\nUSER 0" +
 		"\nWORKDIR /root" +
-		"\nRUN mkdir -p /root/project /root/software/settings /original-content/marker && microdnf install vim curl" +
+		"\nRUN mkdir -p " + projectPath + " /root/software/settings /original-content/marker && microdnf install vim curl" +
 		"\nENV JBS_DISABLE_CACHE=true" +
-		"\nCOPY .jbs/run-build.sh /root" +
-		"\nCOPY . /root/project/source/" +
-		"\nRUN /root/run-build.sh" +
-		"\nFROM scratch" +
-		"\nCOPY --from=0 /root/project/artifacts /root/artifacts"
-
-	pullPolicy := pullPolicy(buildRequestProcessorImage)
-	limits, err := memoryLimits(jbsConfig, additionalMemory)
-	if err != nil {
-		return nil, "", "", "", err
-	}
+		"\nCOPY " + JbsDirectory + "/run-build.sh /root" +
+		"\nCOPY . " + projectPath + "/source/" +
+		"\nRUN /root/run-build.sh"
+	artifactsStage := "0"
+	if collectAntArtifacts(tool, recipe) {
+		// The artifacts are collected from the source tree by the build request processor as in the build pipeline
+		kf += "\nFROM " + buildRequestProcessorImage +
+			"\nUSER 0" +
+			"\nCOPY --from=0 " + projectPath + " " + projectPath +
+			"\nRUN /opt/jboss/container/java/run/run-java.sh " + doSubstitution(strings.Join(copyArtifactsArgs, " "), paramValues, cacheTime, buildRepos, projectPath)
+		artifactsStage = "1"
+	}
+	kf += "\nFROM scratch" +
+		"\nCOPY --from=" + artifactsStage + " " + projectPath + "/artifacts /root/artifacts"
+
+	pullPolicy := pullPolicy(jbsConfig, buildRequestProcessorImage)
 
 	createBuildScript := createBuildScript(build)
-	pipelineParams := []tektonpipeline.ParamSpec{
-		{Name: PipelineBuildId, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamScmUrl, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamScmTag, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamScmHash, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamChainsGitUrl, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamChainsGitCommit, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamGoals, Type: tektonpipeline.ParamTypeArray},
-		{Name: PipelineParamJavaVersion, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamToolVersion, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamPath, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamEnforceVersion, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamProjectVersion, Type: tektonpipeline.ParamTypeString},
-		{Name: PipelineParamCacheUrl, Type: tektonpipeline.ParamTypeString, Default: &tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: cacheUrl + buildRepos + "/" + strconv.FormatInt(commitTime, 10)}},
-	}
 	secretVariables := secretVariables(jbsConfig)
 
-	preBuildImage := existingImages[recipe.Image+"-"+recipe.Tool]
+	preBuildImage := existingImages[recipe.Image+"-"+preBuildTools(recipe)]
 	preBuildImageRequired := preBuildImage == ""
 	if preBuildImageRequired {
 		preBuildImage = "$(tasks." + PreBuildTaskName + ".results." + PreBuildImageDigest + ")"
 	}
 
-	var buildTaskScript string
-	if tool == "ant" {
-		buildTaskScript = artifactbuild.InstallKeystoreIntoBuildRequestProcessor(verifyBuiltArtifactsArgs, copyArtifactsArgs, deployArgs)
-	} else {
-		buildTaskScript = artifactbuild.InstallKeystoreIntoBuildRequestProcessor(verifyBuiltArtifactsArgs, deployArgs)
+	buildTaskCommands := [][]string{verifyBuiltArtifactsArgs}
+	if collectAntArtifacts(tool, recipe) {
+		buildTaskCommands = append(buildTaskCommands, copyArtifactsArgs)
+		// The collected artifacts can only be normalized once they have been copied
+		if normalizeArgs := normalizeTimestampsArgs(jbsConfig, commitTime); len(normalizeArgs) > 0 {
+			buildTaskCommands = append(buildTaskCommands, normalizeArgs)
+		}
+	} else if normalizeArgs := normalizeTimestampsArgs(jbsConfig, commitTime); len(normalizeArgs) > 0 {
+		buildTaskCommands = append([][]string{normalizeArgs}, buildTaskCommands...)
 	}
+	if validateJarsArgs := jarValidationArgs(jbsConfig); len(validateJarsArgs) > 0 {
+		buildTaskCommands = append(buildTaskCommands, validateJarsArgs)
+	}
+	buildTaskScript := allowedDifferenceReasonsScript(recipe) + verificationExcludesScript(jbsConfig, recipe) + artifactbuild.InstallKeystoreIntoBuildRequestProcessor(append(buildTaskCommands, deployArgs)...)
 
+	ccacheVolumes, ccacheVolumeMounts := ccacheVolume(jbsConfig)
 	buildTask := tektonpipeline.TaskSpec{
-		Workspaces: []tektonpipeline.WorkspaceDeclaration{{Name: WorkspaceBuildSettings}, {Name: WorkspaceSource, MountPath: WorkspaceMount}, {Name: WorkspaceTls}},
+		Workspaces: []tektonpipeline.WorkspaceDeclaration{{Name: WorkspaceBuildSettings}, {Name: WorkspaceSource, MountPath: workspaceMount(jbsConfig)}, {Name: WorkspaceTls}},
 		Params:     append(pipelineParams, tektonpipeline.ParamSpec{Name: PreBuildImageDigest, Type: tektonpipeline.ParamTypeString}),
+		Volumes:    ccacheVolumes,
 		Results: []tektonpipeline.TaskResult{
 			{Name: PipelineResultContaminants},
 			{Name: PipelineResultDeployedResources},
@@ -345,41 +652,43 @@ func createPipelineSpec(log logr.Logger, tool string, commitTime int64, jbsConfi
 			{Name: PipelineResultImageDigest},
 			{Name: PipelineResultPassedVerification},
 			{Name: PipelineResultVerificationResult},
+			{Name: PipelineResultToolVersions},
 			// TODO: ### DeployPreBuildSource and Deploy push to git. Currently the former is used for GitArchive results.
 			//			{Name: PipelineResultGitArchive},
 		},
 		Steps: []tektonpipeline.Step{
 			{
 				Name:            "restore-pre-build-source",
-				Image:           strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1]),
+				Image:           trustedArtifactsImage(jbsConfig),
 				ImagePullPolicy: v1.PullIfNotPresent,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+				SecurityContext: &v1.SecurityContext{RunAsUser: user},
 				Env:             secretVariables,
-				Script: fmt.Sprintf(`echo "Restoring source to workspace : $(workspaces.source.path)"
+				Script: fmt.Sprintf(`%secho "Restoring source to workspace : $(workspaces.source.path)"
 export ORAS_OPTIONS="%s"
-use-archive $(params.%s)=$(workspaces.source.path)/source
-mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, orasOptions, PreBuildImageDigest),
+%suse-archive $(params.%s)=$(workspaces.source.path)/source
+%smv "$JBS_DIR/build.sh" $(workspaces.source.path)`, orasRetryFunction(jbsConfig), orasOptions, orasRetry(jbsConfig), PreBuildImageDigest, jbsDirectoryScript(jbsConfig)),
 			},
 			{
-				Timeout:         &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout},
+				Timeout:         &v12.Duration{Duration: buildTimeout(jbsConfig)},
 				Name:            BuildTaskName,
 				Image:           recipe.Image,
 				ImagePullPolicy: pullPolicy,
 				WorkingDir:      "$(workspaces." + WorkspaceSource + ".path)/source",
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
-				Env:             append(toolEnv, v1.EnvVar{Name: PipelineParamCacheUrl, Value: "$(params." + PipelineParamCacheUrl + ")"}),
+				SecurityContext: &v1.SecurityContext{RunAsUser: user},
+				Env:             append(append(append(append(toolEnv, gradleBuildCacheVariables(tool, jbsConfig)...), mavenSettingsVariables(jbsConfig)...), ccacheVariables(jbsConfig)...), v1.EnvVar{Name: PipelineParamCacheUrl, Value: "$(params." + PipelineParamCacheUrl + ")"}),
+				VolumeMounts:    ccacheVolumeMounts,
 				ComputeResources: v1.ResourceRequirements{
 					Requests: v1.ResourceList{"memory": limits.buildRequestMemory, "cpu": limits.buildRequestCPU},
 					Limits:   v1.ResourceList{"memory": limits.buildRequestMemory, "cpu": limits.buildLimitCPU},
 				},
-				Args:   []string{"$(params.GOALS[*])"},
-				Script: "$(workspaces." + WorkspaceSource + ".path)/build.sh \"$@\"",
+				Args:   append([]string{"$(params.GOALS[*])"}, extraArgs...),
+				Script: gracefulTerminationShebang(jbsConfig) + resolveToolSymlinksScript(jbsConfig, toolEnv) + toolVersionsScript(toolEnv) + gracefulTerminationScript(jbsConfig, "$(workspaces."+WorkspaceSource+".path)/build.sh \"$@\""),
 			},
 			{
 				Name:            "verify-and-check-for-contaminates",
 				Image:           buildRequestProcessorImage,
 				ImagePullPolicy: pullPolicy,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+				SecurityContext: &v1.SecurityContext{RunAsUser: user},
 				Env:             secretVariables,
 				ComputeResources: v1.ResourceRequirements{
 					Requests: v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultRequestCPU},
@@ -389,9 +698,9 @@ mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, or
 			},
 			{
 				Name:            "create-post-build-image",
-				Image:           strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1]),
+				Image:           trustedArtifactsImage(jbsConfig),
 				ImagePullPolicy: v1.PullIfNotPresent,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+				SecurityContext: &v1.SecurityContext{RunAsUser: user},
 				Env:             secretVariables,
 				ComputeResources: v1.ResourceRequirements{
 					Requests: v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultRequestCPU},
@@ -402,6 +711,105 @@ mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, or
 		},
 	}
 
+	if jbsConfig.Spec.VerificationOutputFormat == v1alpha1.VerificationOutputFormatJson {
+		buildTask.Results = append(buildTask.Results, tektonpipeline.TaskResult{Name: PipelineResultVerificationDiff})
+	}
+	if jbsConfig.Spec.MavenDeployment.ValidateChecksums {
+		buildTask.Results = append(buildTask.Results, tektonpipeline.TaskResult{Name: PipelineResultArtifactChecksums})
+	}
+	if db.Spec.ReferenceArtifactImage != "" {
+		// The reference artifacts are restored once the build has finished, ready for the verification
+		restore := tektonpipeline.Step{
+			Name:            "restore-reference-artifacts",
+			Image:           trustedArtifactsImage(jbsConfig),
+			ImagePullPolicy: v1.PullIfNotPresent,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Env:             secretVariables,
+			Script:          referenceArtifactsScript(orasOptions, db.Spec.ReferenceArtifactImage),
+		}
+		for i, step := range buildTask.Steps {
+			if step.Name == BuildTaskName {
+				buildTask.Steps = append(buildTask.Steps[:i+1], append([]tektonpipeline.Step{restore}, buildTask.Steps[i+1:]...)...)
+				break
+			}
+		}
+	}
+	if settingsFilesScript != "" {
+		// The build settings workspace is not shared between tasks so the files are written within the build task
+		write := tektonpipeline.Step{
+			Name:            "write-build-settings",
+			Image:           recipe.Image,
+			ImagePullPolicy: pullPolicy,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Script:          settingsFilesScript,
+		}
+		for i, step := range buildTask.Steps {
+			if step.Name == BuildTaskName {
+				buildTask.Steps = append(buildTask.Steps[:i], append([]tektonpipeline.Step{write}, buildTask.Steps[i:]...)...)
+				break
+			}
+		}
+	}
+	failedWorkspace := failedWorkspaceUrl(jbsConfig, buildId)
+	if jbsConfig.Spec.BuildSettings.KeepFailedWorkspace {
+		// A finally task can't push the workspace as it is not shared between tasks, so the build step carries on to a
+		// step that pushes it if the build failed and then fails the task
+		push := tektonpipeline.Step{
+			Name:            "push-failed-workspace",
+			Image:           trustedArtifactsImage(jbsConfig),
+			ImagePullPolicy: v1.PullIfNotPresent,
+			SecurityContext: &v1.SecurityContext{RunAsUser: user},
+			Env:             secretVariables,
+			Script:          pushFailedWorkspaceScript(orasOptions, failedWorkspace),
+		}
+		for i, step := range buildTask.Steps {
+			if step.Name == BuildTaskName {
+				buildTask.Steps[i].OnError = tektonpipeline.Continue
+				buildTask.Steps = append(buildTask.Steps[:i+1], append([]tektonpipeline.Step{push}, buildTask.Steps[i+1:]...)...)
+				break
+			}
+		}
+	}
+	if jbsConfig.Spec.BuildSettings.EgressAllowlist.Enabled {
+		// The steps share the pod network so the build step is restricted by the steps either side of it, which have
+		// the capability to change the firewall rules the build step lacks. They run as root whatever the build user.
+		root := int64(0)
+		egressStep := func(name string, script string) tektonpipeline.Step {
+			return tektonpipeline.Step{
+				Name:            name,
+				Image:           jbsConfig.Spec.BuildSettings.EgressAllowlist.Image,
+				ImagePullPolicy: v1.PullIfNotPresent,
+				SecurityContext: &v1.SecurityContext{RunAsUser: &root, Capabilities: &v1.Capabilities{Add: []v1.Capability{"NET_ADMIN"}}},
+				Script:          script,
+			}
+		}
+		for i, step := range buildTask.Steps {
+			if step.Name == BuildTaskName {
+				restrict := egressStep("restrict-egress", restrictEgressScript(jbsConfig))
+				lift := egressStep("lift-egress-restriction", liftEgressRestrictionScript())
+				buildTask.Steps = append(buildTask.Steps[:i], append([]tektonpipeline.Step{restrict, step, lift}, buildTask.Steps[i+1:]...)...)
+				break
+			}
+		}
+	}
+	if db.Spec.VerifyOnly {
+		// Nothing is deployed so the post-build image and its results are not needed
+		steps := []tektonpipeline.Step{}
+		for _, i := range buildTask.Steps {
+			if i.Name != "create-post-build-image" {
+				steps = append(steps, i)
+			}
+		}
+		buildTask.Steps = steps
+		results := []tektonpipeline.TaskResult{}
+		for _, i := range buildTask.Results {
+			if i.Name != PipelineResultImage && i.Name != PipelineResultImageDigest && i.Name != PipelineResultArtifactChecksums {
+				results = append(results, i)
+			}
+		}
+		buildTask.Results = results
+	}
+
 	runAfter := []string{}
 	if preBuildImageRequired {
 		runAfter = []string{PreBuildTaskName}
@@ -411,10 +819,11 @@ mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, or
 			{
 				Name:     BuildTaskName,
 				RunAfter: runAfter,
+				Retries:  buildRetries(recipe),
 				TaskSpec: &tektonpipeline.EmbeddedTask{
 					TaskSpec: buildTask,
 				},
-				Timeout: &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout},
+				Timeout: &v12.Duration{Duration: buildTimeout(jbsConfig)},
 				Params:  []tektonpipeline.Param{{Name: PreBuildImageDigest, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: preBuildImage}}},
 				Workspaces: []tektonpipeline.WorkspacePipelineTaskBinding{
 					{Name: WorkspaceBuildSettings, Workspace: WorkspaceBuildSettings},
@@ -428,7 +837,7 @@ mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, or
 
 	if preBuildImageRequired {
 		buildSetup := tektonpipeline.TaskSpec{
-			Workspaces: []tektonpipeline.WorkspaceDeclaration{{Name: WorkspaceBuildSettings}, {Name: WorkspaceSource, MountPath: WorkspaceMount}, {Name: WorkspaceTls}},
+			Workspaces: []tektonpipeline.WorkspaceDeclaration{{Name: WorkspaceBuildSettings}, {Name: WorkspaceSource, MountPath: workspaceMount(jbsConfig)}, {Name: WorkspaceTls}},
 			Params:     pipelineParams,
 			Results: []tektonpipeline.TaskResult{
 				{Name: PreBuildImageDigest, Type: tektonpipeline.ResultsTypeString},
@@ -438,48 +847,48 @@ mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, or
 				{
 					Name:            "git-clone-and-settings",
 					Image:           recipe.Image,
-					SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+					SecurityContext: &v1.SecurityContext{RunAsUser: user},
 					ComputeResources: v1.ResourceRequirements{
 						Requests: v1.ResourceList{"memory": limits.defaultRequestMemory, "cpu": limits.defaultRequestCPU},
 						Limits:   v1.ResourceList{"memory": limits.defaultRequestMemory, "cpu": limits.defaultLimitCPU},
 					},
-					Script: gitScript + "\n" + createBuildScript,
-					Env: []v1.EnvVar{
+					Script: scmScript + "\n" + createBuildScript,
+					Env: append([]v1.EnvVar{
 						{Name: PipelineParamCacheUrl, Value: "$(params." + PipelineParamCacheUrl + ")"},
 						{Name: "GIT_TOKEN", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.GitSecretName}, Key: v1alpha1.GitSecretTokenKey, Optional: &trueBool}}},
-					},
+					}, submoduleCredentialVariables(recipe)...),
 				},
 				{
 					Name:            "preprocessor",
 					Image:           buildRequestProcessorImage,
 					ImagePullPolicy: pullPolicy,
-					SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
-					Env: []v1.EnvVar{
+					SecurityContext: &v1.SecurityContext{RunAsUser: user},
+					Env: append([]v1.EnvVar{
 						{Name: PipelineParamCacheUrl, Value: "$(params." + PipelineParamCacheUrl + ")"},
-					},
+					}, preprocessorJavaHomeVariables(recipe)...),
 					ComputeResources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{"memory": limits.defaultRequestMemory, "cpu": limits.defaultRequestCPU},
-						Limits:   v1.ResourceList{"memory": limits.defaultRequestMemory, "cpu": limits.defaultLimitCPU},
+						Requests: v1.ResourceList{"memory": limits.preprocessorRequestMemory, "cpu": limits.defaultRequestCPU},
+						Limits:   v1.ResourceList{"memory": limits.preprocessorRequestMemory, "cpu": limits.defaultLimitCPU},
 					},
-					Script: artifactbuild.InstallKeystoreIntoBuildRequestProcessor(preprocessorArgs),
+					Script: artifactbuild.InstallKeystoreIntoBuildRequestProcessor(preprocessorCommands...),
 				},
 				{
 					Name:            "create-pre-build-source",
 					Image:           buildRequestProcessorImage,
 					ImagePullPolicy: pullPolicy,
-					SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+					SecurityContext: &v1.SecurityContext{RunAsUser: user},
 					Env:             secretVariables,
 					ComputeResources: v1.ResourceRequirements{
-						Requests: v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultRequestCPU},
-						Limits:   v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultLimitCPU},
+						Requests: v1.ResourceList{"memory": limits.preBuildSourceRequestMemory, "cpu": limits.defaultRequestCPU},
+						Limits:   v1.ResourceList{"memory": limits.preBuildSourceRequestMemory, "cpu": limits.defaultLimitCPU},
 					},
-					Script: createKonfluxScripts(kf, konfluxScript) + "\n" + artifactbuild.InstallKeystoreIntoBuildRequestProcessor(konfluxArgs),
+					Script: createKonfluxScripts(jbsConfig, kf, konfluxScript) + "\n" + artifactbuild.InstallKeystoreIntoBuildRequestProcessor(konfluxArgs),
 				},
 				{
 					Name:            "create-pre-build-image",
-					Image:           strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1]),
+					Image:           trustedArtifactsImage(jbsConfig),
 					ImagePullPolicy: v1.PullIfNotPresent,
-					SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+					SecurityContext: &v1.SecurityContext{RunAsUser: user},
 					Env:             secretVariables,
 					ComputeResources: v1.ResourceRequirements{
 						Requests: v1.ResourceList{"memory": limits.defaultBuildRequestMemory, "cpu": limits.defaultRequestCPU},
@@ -507,6 +916,18 @@ mv $(workspaces.source.path)/source/.jbs/build.sh $(workspaces.source.path)`, or
 		}
 	}
 
+	if jbsConfig.Spec.BuildSettings.KeepFailedWorkspace {
+		// The results of a failed task are not available, so the location is recorded by a finally task
+		ps.Finally = append(ps.Finally, failedWorkspaceTask(jbsConfig, orasOptions, failedWorkspace))
+		ps.Results = append(ps.Results, tektonpipeline.PipelineResult{Name: PipelineResultDebugWorkspace, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(finally." + FailedWorkspaceTaskName + ".results." + PipelineResultDebugWorkspace + ")"}})
+	}
+	if recipe.Architecture != "" {
+		// The tasks that build from the builder image are told the platform, e.g. for a buildah --platform
+		for i := range ps.Tasks {
+			ps.Tasks[i].Params = append(ps.Tasks[i].Params, tektonpipeline.Param{Name: PipelineParamPlatform, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: recipe.Architecture}})
+			ps.Tasks[i].TaskSpec.Params = append(ps.Tasks[i].TaskSpec.Params, tektonpipeline.ParamSpec{Name: PipelineParamPlatform, Type: tektonpipeline.ParamTypeString})
+		}
+	}
 	for _, i := range buildTask.Results {
 		ps.Results = append(ps.Results, tektonpipeline.PipelineResult{Name: i.Name, Description: i.Description, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(tasks." + BuildTaskName + ".results." + i.Name + ")"}})
 	}
@@ -541,12 +962,26 @@ func secretVariables(jbsConfig *v1alpha1.JBSConfig) []v1.EnvVar {
 			{Name: "REGISTRY_TOKEN", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: jbsConfig.ImageRegistry().SecretName}, Key: v1alpha1.ImageSecretTokenKey, Optional: &trueBool}}},
 		}
 	}
-	if jbsConfig.Spec.MavenDeployment.Repository != "" {
+	if mirror := jbsConfig.MirrorImageRegistry(); mirror != nil && mirror.SecretName != "" {
+		secretVariables = append(secretVariables, v1.EnvVar{Name: "MIRROR_REGISTRY_TOKEN", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: mirror.SecretName}, Key: v1alpha1.ImageSecretTokenKey, Optional: &trueBool}}})
+	}
+	repositories := mavenRepositories(jbsConfig)
+	if len(repositories) > 0 {
 		secretVariables = append(secretVariables, v1.EnvVar{Name: "MAVEN_PASSWORD", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.MavenSecretName}, Key: v1alpha1.MavenSecretKey, Optional: &trueBool}}})
+		// Repositories with their own credentials are matched to them by index in TagDeployCommand
+		for i, repository := range repositories {
+			if repository.SecretName != "" {
+				secretVariables = append(secretVariables, v1.EnvVar{Name: fmt.Sprintf("MAVEN_PASSWORD_%d", i), ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: repository.SecretName}, Key: v1alpha1.MavenSecretKey, Optional: &trueBool}}})
+			}
+		}
 
 		secretVariables = append(secretVariables, v1.EnvVar{Name: "AWS_ACCESS_KEY_ID", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.AWSSecretName}, Key: v1alpha1.AWSAccessID, Optional: &trueBool}}})
 		secretVariables = append(secretVariables, v1.EnvVar{Name: "AWS_SECRET_ACCESS_KEY", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.AWSSecretName}, Key: v1alpha1.AWSSecretKey, Optional: &trueBool}}})
 		secretVariables = append(secretVariables, v1.EnvVar{Name: "AWS_PROFILE", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.AWSSecretName}, Key: v1alpha1.AWSProfile, Optional: &trueBool}}})
+		if gcsDeployment(jbsConfig) {
+			// The key itself is mounted into the deployment step by gcsCredentialsVolume
+			secretVariables = append(secretVariables, v1.EnvVar{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: GCSCredentialsPath + "/" + v1alpha1.GCSCredentialsKey})
+		}
 	}
 	if jbsConfig.Spec.GitSourceArchive.Identity != "" {
 		secretVariables = append(secretVariables, v1.EnvVar{Name: "GIT_DEPLOY_TOKEN", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.GitRepoSecretName}, Key: v1alpha1.GitRepoSecretKey, Optional: &trueBool}}})
@@ -554,6 +989,99 @@ func secretVariables(jbsConfig *v1alpha1.JBSConfig) []v1.EnvVar {
 	return secretVariables
 }
 
+// gradleBuildCacheSettings returns a script fragment that adds an init script configuring the remote HTTP build cache.
+// gradle-build.sh copies the .hacbs-init directory into the Gradle init.d directory.
+func gradleBuildCacheSettings(jbsConfig *v1alpha1.JBSConfig) string {
+	cache := jbsConfig.Spec.BuildSettings.GradleBuildCache
+	if !cache.Enabled || cache.URL == "" {
+		return ""
+	}
+	return fmt.Sprintf(`mkdir -p .hacbs-init
+cat > .hacbs-init/remote-build-cache.gradle << 'EOF'
+gradle.settingsEvaluated { settings ->
+    settings.buildCache {
+        remote(HttpBuildCache) {
+            url = '%s'
+            allowInsecureProtocol = url.scheme == 'http'
+            push = true
+            if (System.getenv('GRADLE_BUILD_CACHE_USERNAME')) {
+                credentials {
+                    username = System.getenv('GRADLE_BUILD_CACHE_USERNAME')
+                    password = System.getenv('GRADLE_BUILD_CACHE_PASSWORD')
+                }
+            }
+        }
+    }
+}
+EOF
+`, cache.URL)
+}
+
+func gradleBuildCacheVariables(tool string, jbsConfig *v1alpha1.JBSConfig) []v1.EnvVar {
+	cache := jbsConfig.Spec.BuildSettings.GradleBuildCache
+	if tool != "gradle" || !cache.Enabled || cache.URL == "" {
+		return nil
+	}
+	trueBool := true
+	return []v1.EnvVar{
+		{Name: "GRADLE_BUILD_CACHE_USERNAME", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.GradleBuildCacheSecretName}, Key: v1alpha1.GradleBuildCacheUsernameKey, Optional: &trueBool}}},
+		{Name: "GRADLE_BUILD_CACHE_PASSWORD", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.GradleBuildCacheSecretName}, Key: v1alpha1.GradleBuildCachePasswordKey, Optional: &trueBool}}},
+	}
+}
+
+// ccacheVolume returns the persistent volume holding the ccache directory and its mount in the build step.
+func ccacheVolume(jbsConfig *v1alpha1.JBSConfig) ([]v1.Volume, []v1.VolumeMount) {
+	ccache := jbsConfig.Spec.BuildSettings.Ccache
+	if !ccache.Enabled {
+		return nil, nil
+	}
+	claimName := ccache.ClaimName
+	if claimName == "" {
+		claimName = v1alpha1.DefaultCcacheClaimName
+	}
+	return []v1.Volume{{Name: "ccache", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}}}},
+		[]v1.VolumeMount{{Name: "ccache", MountPath: CcacheDirectory}}
+}
+
+// ccacheVariables returns the variables that make native compilation go through ccache, both for builds that use CC
+// and CXX (e.g. make or the maven native plugins) and for CMake builds, which use the compiler launcher instead.
+func ccacheVariables(jbsConfig *v1alpha1.JBSConfig) []v1.EnvVar {
+	ccache := jbsConfig.Spec.BuildSettings.Ccache
+	if !ccache.Enabled {
+		return nil
+	}
+	vars := []v1.EnvVar{
+		{Name: "CCACHE_DIR", Value: CcacheDirectory},
+		// Paths within the source are hashed relative to it so the cache is shared between builds of different tags
+		{Name: "CCACHE_BASEDIR", Value: "$(workspaces." + WorkspaceSource + ".path)/source"},
+		{Name: "CC", Value: "ccache gcc"},
+		{Name: "CXX", Value: "ccache g++"},
+		{Name: "CMAKE_C_COMPILER_LAUNCHER", Value: "ccache"},
+		{Name: "CMAKE_CXX_COMPILER_LAUNCHER", Value: "ccache"},
+	}
+	if ccache.MaxSize != "" {
+		vars = append(vars, v1.EnvVar{Name: "CCACHE_MAXSIZE", Value: ccache.MaxSize})
+	}
+	return vars
+}
+
+// buildSettingsFilesScript writes the build settings files of the recipe in name order, so the generated script is
+// stable.
+func buildSettingsFilesScript(recipe *v1alpha1.BuildRecipe) string {
+	names := make([]string, 0, len(recipe.BuildSettingsFiles))
+	for name := range recipe.BuildSettingsFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := ""
+	for _, name := range names {
+		ret += "tee $(workspaces." + WorkspaceBuildSettings + ".path)/" + name + " <<'RHTAPEOF'\n"
+		ret += strings.TrimSuffix(recipe.BuildSettingsFiles[name], "\n")
+		ret += "\nRHTAPEOF\n"
+	}
+	return ret
+}
+
 func createBuildScript(build string) string {
 	ret := "tee $(workspaces." + WorkspaceSource + ".path)/build.sh <<'RHTAPEOF'\n"
 	ret += build
@@ -562,19 +1090,113 @@ func createBuildScript(build string) string {
 	return ret
 }
 
-func createKonfluxScripts(containerfile string, konfluxScript string) string {
-	ret := "mkdir -p $(workspaces." + WorkspaceSource + ".path)/source/.jbs\n"
-	ret += "tee $(workspaces." + WorkspaceSource + ".path)/source/.jbs/Containerfile <<'RHTAPEOF'\n"
+func createKonfluxScripts(jbsConfig *v1alpha1.JBSConfig, containerfile string, konfluxScript string) string {
+	source := "$(workspaces." + WorkspaceSource + ".path)/source"
+	ret := "JBS_DIR=" + source + "/" + JbsDirectory + "\n"
+	switch jbsConfig.Spec.BuildSettings.JbsDirectoryConflictPolicy {
+	case v1alpha1.JbsDirectoryConflictFail:
+		ret += "if [ -e \"$JBS_DIR\" ]; then\n"
+		ret += "    echo \"The source already has a " + JbsDirectory + " directory\" >&2\n"
+		ret += "    exit 1\n"
+		ret += "fi\n"
+	case v1alpha1.JbsDirectoryConflictUseAlternateDir:
+		// The later steps find the generated files by the alternate directory existing, so it can't be in the source
+		ret += "if [ -e " + source + "/" + AlternateJbsDirectory + " ]; then\n"
+		ret += "    echo \"The source already has a " + AlternateJbsDirectory + " directory\" >&2\n"
+		ret += "    exit 1\n"
+		ret += "fi\n"
+		ret += "if [ -e \"$JBS_DIR\" ]; then\n"
+		ret += "    echo \"The source already has a " + JbsDirectory + " directory, using " + AlternateJbsDirectory + "\"\n"
+		ret += "    JBS_DIR=" + source + "/" + AlternateJbsDirectory + "\n"
+		ret += "fi\n"
+	}
+	ret += "mkdir -p \"$JBS_DIR\"\n"
+	ret += "tee \"$JBS_DIR/Containerfile\" <<'RHTAPEOF'\n"
 	ret += containerfile
 	ret += "\nRHTAPEOF\n"
-	ret += "tee $(workspaces." + WorkspaceSource + ".path)/source/.jbs/run-build.sh <<'RHTAPEOF'\n"
+	if jbsConfig.Spec.BuildSettings.JbsDirectoryConflictPolicy == v1alpha1.JbsDirectoryConflictUseAlternateDir {
+		ret += "sed -i \"s|^COPY " + JbsDirectory + "/|COPY $(basename \"$JBS_DIR\")/|\" \"$JBS_DIR/Containerfile\"\n"
+	}
+	ret += "tee \"$JBS_DIR/run-build.sh\" <<'RHTAPEOF'\n"
 	ret += konfluxScript
 	ret += "\nRHTAPEOF\n"
-	ret += "chmod +x $(workspaces." + WorkspaceSource + ".path)/source/.jbs/run-build.sh\n"
+	ret += "chmod +x \"$JBS_DIR/run-build.sh\"\n"
+	return ret
+}
+
+// jbsDirectoryScript sets JBS_DIR to the directory within the source the pre-build wrote the generated files into.
+// With the use-alternate-dir conflict policy this is the alternate directory if it exists, as the source can't have
+// one of its own.
+func jbsDirectoryScript(jbsConfig *v1alpha1.JBSConfig) string {
+	source := "$(workspaces." + WorkspaceSource + ".path)/source"
+	ret := "JBS_DIR=" + source + "/" + JbsDirectory + "\n"
+	if jbsConfig.Spec.BuildSettings.JbsDirectoryConflictPolicy == v1alpha1.JbsDirectoryConflictUseAlternateDir {
+		ret += "if [ -d " + source + "/" + AlternateJbsDirectory + " ]; then\n"
+		ret += "    JBS_DIR=" + source + "/" + AlternateJbsDirectory + "\n"
+		ret += "fi\n"
+	}
 	return ret
 }
 
-func pullPolicy(buildRequestProcessorImage string) v1.PullPolicy {
+// cacheServiceUrl returns the URL of the cache service within the cluster, which is the TLS service unless TLS is
+// disabled.
+func cacheServiceUrl(jbsConfig *v1alpha1.JBSConfig) string {
+	serviceName := v1alpha1.CacheDeploymentName
+	if jbsConfig.Spec.CacheSettings.ServiceName != "" {
+		serviceName = jbsConfig.Spec.CacheSettings.ServiceName
+	}
+	domain := "cluster.local"
+	if jbsConfig.Spec.CacheSettings.ServiceDomain != "" {
+		domain = jbsConfig.Spec.CacheSettings.ServiceDomain
+	}
+	if jbsConfig.Spec.CacheSettings.DisableTLS {
+		return "http://" + serviceName + "." + jbsConfig.Namespace + ".svc." + domain
+	}
+	return "https://" + serviceName + "-tls." + jbsConfig.Namespace + ".svc." + domain
+}
+
+// cachePinTime returns the time the cache is pinned to, which is the commit time unless a time is configured.
+func cachePinTime(jbsConfig *v1alpha1.JBSConfig, commitTime int64) int64 {
+	if jbsConfig.Spec.CacheSettings.PinTimestamp != 0 {
+		return jbsConfig.Spec.CacheSettings.PinTimestamp
+	}
+	return commitTime
+}
+
+// workspaceMount returns where the source workspace is mounted in the build and deploy pipelines.
+func workspaceMount(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Spec.BuildSettings.WorkspaceMountPath != "" {
+		return jbsConfig.Spec.BuildSettings.WorkspaceMountPath
+	}
+	return WorkspaceMount
+}
+
+// projectPath returns where the project is placed within the diagnostic and konflux container files. This is the
+// configured workspace mount path if there is one, so the generated files match the pipeline.
+func projectPath(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Spec.BuildSettings.WorkspaceMountPath != "" {
+		return jbsConfig.Spec.BuildSettings.WorkspaceMountPath
+	}
+	return DiagnosticProjectPath
+}
+
+// configuredPullPolicy returns the pull policy configured in the JBSConfig, if any. Unknown values are treated as
+// IfNotPresent.
+func configuredPullPolicy(jbsConfig *v1alpha1.JBSConfig) (v1.PullPolicy, bool) {
+	switch policy := v1.PullPolicy(jbsConfig.Spec.BuildSettings.ImagePullPolicy); policy {
+	case "":
+		return "", false
+	case v1.PullAlways, v1.PullIfNotPresent, v1.PullNever:
+		return policy, true
+	default:
+		return v1.PullIfNotPresent, true
+	}
+}
+
+func pullPolicy(jbsConfig *v1alpha1.JBSConfig, buildRequestProcessorImage string) v1.PullPolicy {
+	if policy, ok := configuredPullPolicy(jbsConfig); ok {
+		return policy
+	}
 	pullPolicy := v1.PullIfNotPresent
 	if strings.HasPrefix(buildRequestProcessorImage, "quay.io/minikube") {
 		pullPolicy = v1.PullNever
@@ -586,9 +1208,10 @@ func pullPolicy(buildRequestProcessorImage string) v1.PullPolicy {
 
 type memLimits struct {
 	defaultRequestMemory, defaultBuildRequestMemory, defaultRequestCPU, defaultLimitCPU, buildRequestCPU, buildLimitCPU, buildRequestMemory resource.Quantity
+	preprocessorRequestMemory, preBuildSourceRequestMemory                                                                                  resource.Quantity
 }
 
-func memoryLimits(jbsConfig *v1alpha1.JBSConfig, additionalMemory int) (*memLimits, error) {
+func memoryLimits(jbsConfig *v1alpha1.JBSConfig, additionalMemory int, additionalCPU int) (*memLimits, error) {
 	limits := memLimits{}
 	var err error
 	limits.defaultRequestMemory, err = resource.ParseQuantity(settingOrDefault(jbsConfig.Spec.BuildSettings.TaskRequestMemory, "512Mi"))
@@ -622,13 +1245,42 @@ func memoryLimits(jbsConfig *v1alpha1.JBSConfig, additionalMemory int) (*memLimi
 		limits.buildRequestMemory.Add(additional)
 		limits.defaultRequestMemory.Add(additional)
 	}
+	if additionalCPU > 0 {
+		additional := resource.MustParse(fmt.Sprintf("%dm", additionalCPU))
+		limits.buildRequestCPU.Add(additional)
+		limits.buildLimitCPU.Add(additional)
+	}
+	limits.preprocessorRequestMemory = limits.defaultRequestMemory
+	limits.preBuildSourceRequestMemory = limits.defaultBuildRequestMemory
+	if jbsConfig.Spec.BuildSettings.PreprocessorRequestMemory != "" {
+		limits.preprocessorRequestMemory, err = resource.ParseQuantity(jbsConfig.Spec.BuildSettings.PreprocessorRequestMemory)
+		if err != nil {
+			return nil, err
+		}
+		limits.preBuildSourceRequestMemory = limits.preprocessorRequestMemory
+	}
 	return &limits, nil
 }
 
+// activeProcessorCount returns the JVM option that fixes the processor count to the build CPU limit. Older JDK 8
+// releases do not reliably detect cgroup CPU limits and size thread pools from the host CPU count instead. JDK 7 does
+// not support the option at all.
+func activeProcessorCount(jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe, limits *memLimits) string {
+	if !jbsConfig.Spec.BuildSettings.ActiveProcessorCount || recipe.JavaVersion != "8" {
+		return ""
+	}
+	processors := (limits.buildLimitCPU.MilliValue() + 999) / 1000
+	if processors < 1 {
+		processors = 1
+	}
+	// The option was only added in 8u191, so older JDK 8 builder images must ignore it rather than fail to start
+	return fmt.Sprintf("-XX:+IgnoreUnrecognizedVMOptions -XX:ActiveProcessorCount=%d", processors)
+}
+
 func additionalPackages(recipe *v1alpha1.BuildRecipe) string {
 	install := ""
 	for count, i := range recipe.AdditionalDownloads {
-		if i.FileType == "tar" {
+		if i.FileType == "tar" || i.FileType == "zip" {
 			if i.BinaryPath == "" {
 				install = "echo 'Binary path not specified for package " + i.Uri + "'; exit 1"
 			}
@@ -641,12 +1293,27 @@ func additionalPackages(recipe *v1alpha1.BuildRecipe) string {
 			if i.PackageName == "" {
 				install = "echo 'Package name not specified for rpm type'; exit 1"
 			}
+			if i.GpgKeyUri != "" && i.Uri == "" {
+				install = "echo 'Uri not specified for signed rpm package " + i.PackageName + "'; exit 1"
+			}
 		} else {
 			//unknown
 			//we still run the pipeline so there is logs
 			install = "echo 'Unknown file type " + i.FileType + " for package " + i.Uri + "'; exit 1"
 			break
 		}
+		// Downloaded rpms are verified by their signature, everything else must have a checksum
+		if i.FileType != "rpm" && i.Sha256 == "" && i.Sha512 == "" {
+			name := i.FileName
+			if name == "" {
+				name = i.PackageName
+			}
+			if name == "" {
+				name = i.Uri
+			}
+			// The packages before it are kept so their logs show, but nothing is downloaded unverified
+			return install + "echo " + shellQuote("Checksum not specified for package "+name) + "; exit 1\n"
+		}
 		template := packageTemplate
 		fileName := i.FileName
 		if fileName == "" {
@@ -655,49 +1322,215 @@ func additionalPackages(recipe *v1alpha1.BuildRecipe) string {
 		template = strings.ReplaceAll(template, "{URI}", i.Uri)
 		template = strings.ReplaceAll(template, "{FILENAME}", fileName)
 		template = strings.ReplaceAll(template, "{SHA256}", i.Sha256)
+		template = strings.ReplaceAll(template, "{SHA512}", i.Sha512)
 		template = strings.ReplaceAll(template, "{TYPE}", i.FileType)
 		template = strings.ReplaceAll(template, "{BINARY_PATH}", i.BinaryPath)
 		template = strings.ReplaceAll(template, "{PACKAGE_NAME}", i.PackageName)
+		template = strings.ReplaceAll(template, "{GPG_KEY_URI}", i.GpgKeyUri)
 		install = install + template
 	}
 	return install
 }
 
+// scmScript checks out the source at the commit being built. Subversion and Mercurial repositories are checked out with
+// their own clients, which the builder image must have, and everything else with git. Submodules only apply to git.
+func scmScript(db *v1alpha1.DependencyBuild, recipe *v1alpha1.BuildRecipe) string {
+	source := "$(workspaces." + WorkspaceSource + ".path)/source"
+	switch db.Spec.ScmInfo.SCMType {
+	case v1alpha1.SCMTypeSubversion:
+		return "echo \"Checking out $(params." + PipelineParamScmUrl + ") at revision $(params." + PipelineParamScmHash + ")\" && " +
+			"svn checkout --non-interactive --revision $(params." + PipelineParamScmHash + ") $(params." + PipelineParamScmUrl + ") " + source + " && cd " + source
+	case v1alpha1.SCMTypeMercurial:
+		return "echo \"Cloning $(params." + PipelineParamScmUrl + ") and updating to $(params." + PipelineParamScmHash + ")\" && " +
+			"hg clone --noupdate $(params." + PipelineParamScmUrl + ") " + source + " && cd " + source + " && hg update --clean --rev $(params." + PipelineParamScmHash + ")"
+	}
+	return gitScript(db, recipe)
+}
+
 func gitScript(db *v1alpha1.DependencyBuild, recipe *v1alpha1.BuildRecipe) string {
 	gitArgs := "echo \"Cloning $(params." + PipelineParamScmUrl + ") and resetting to $(params." + PipelineParamScmHash + ")\" && "
-	if db.Spec.ScmInfo.Private {
+	if len(recipe.SubmoduleCredentials) > 0 {
+		// Each submodule host gets its own credential helper entry so a public top-level repository can still have
+		// private submodules.
+		if db.Spec.ScmInfo.Private {
+			gitArgs = gitArgs + "echo \"$GIT_TOKEN\" > $HOME/.git-credentials && "
+		} else {
+			gitArgs = gitArgs + "echo -n > $HOME/.git-credentials && "
+		}
+		gitConfig := ""
+		if db.Spec.ScmInfo.Private {
+			gitConfig = "[credential]\n        helper=store\n"
+		}
+		for i, c := range recipe.SubmoduleCredentials {
+			gitArgs = gitArgs + "echo \"$" + submoduleTokenVariable(i) + "\" >> $HOME/.git-credentials && "
+			gitConfig = gitConfig + "[credential \"https://" + c.Host + "\"]\n        helper=store\n"
+		}
+		gitArgs = gitArgs + "chmod 400 $HOME/.git-credentials && "
+		gitArgs = gitArgs + "echo '" + gitConfig + "' > $HOME/.gitconfig && "
+	} else if db.Spec.ScmInfo.Private {
 		gitArgs = gitArgs + "echo \"$GIT_TOKEN\" > $HOME/.git-credentials && chmod 400 $HOME/.git-credentials && "
 		gitArgs = gitArgs + "echo '[credential]\n        helper=store\n' > $HOME/.gitconfig && "
 	}
-	gitArgs = gitArgs + "git clone $(params." + PipelineParamScmUrl + ") $(workspaces." + WorkspaceSource + ".path)/source && cd $(workspaces." + WorkspaceSource + ".path)/source && git reset --hard $(params." + PipelineParamScmHash + ")"
+	source := "$(workspaces." + WorkspaceSource + ".path)/source"
+	if recipe.CloneDepth > 0 {
+		// Only the commit being built is fetched, which the server needs to allow. The reset then checks it out.
+		gitArgs = gitArgs + "git init " + source + " && cd " + source + " && git remote add origin $(params." + PipelineParamScmUrl + ") && git fetch --depth " + strconv.Itoa(recipe.CloneDepth) + " origin $(params." + PipelineParamScmHash + ") && git reset --hard $(params." + PipelineParamScmHash + ")"
+	} else {
+		gitArgs = gitArgs + "git clone $(params." + PipelineParamScmUrl + ") " + source + " && cd " + source + " && git reset --hard $(params." + PipelineParamScmHash + ")"
+	}
 
 	if !recipe.DisableSubmodules {
 		gitArgs = gitArgs + " && git submodule init && git submodule update --recursive"
 	}
+	if db.Spec.ScmInfo.Private || len(recipe.SubmoduleCredentials) > 0 {
+		// The credentials are only needed to clone so remove them before anything is archived or added to an image
+		gitArgs = gitArgs + " && rm -f $HOME/.git-credentials $HOME/.gitconfig"
+	}
 	return gitArgs
 }
 
-func pipelineBuildCommands(imageId string, db *v1alpha1.DependencyBuild, jbsConfig *v1alpha1.JBSConfig, buildId string) (string, string, []string, []string, []string) {
+func submoduleTokenVariable(index int) string {
+	return "GIT_SUBMODULE_TOKEN_" + strconv.Itoa(index)
+}
 
-	orasOptions := ""
-	if jbsConfig.Annotations != nil && jbsConfig.Annotations[jbsconfig.TestRegistry] == "true" {
-		orasOptions = "--insecure --plain-http"
+// submoduleCredentialVariables returns the git-credentials for each submodule host, read from the secrets declared in
+// the recipe.
+func submoduleCredentialVariables(recipe *v1alpha1.BuildRecipe) []v1.EnvVar {
+	trueBool := true
+	ret := []v1.EnvVar{}
+	for i, c := range recipe.SubmoduleCredentials {
+		key := c.SecretKey
+		if key == "" {
+			key = v1alpha1.GitSecretTokenKey
+		}
+		ret = append(ret, v1.EnvVar{Name: submoduleTokenVariable(i), ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: c.SecretName}, Key: key, Optional: &trueBool}}})
 	}
+	return ret
+}
 
-	preBuildImageTag := imageId + "-pre-build-image"
-	// The build-trusted-artifacts container doesn't handle REGISTRY_TOKEN but the actual .docker/config.json. Was using
-	// AUTHFILE to override but now switched to adding the image secret to the pipeline.
-	// Setting ORAS_OPTIONS to ensure the archive is compatible with jib (for OCIRepositoryClient).
-	preBuildImageArgs := fmt.Sprintf(`echo "Creating pre-build-image archive"
-export ORAS_OPTIONS="%s --image-spec=v1.0 --artifact-type application/vnd.oci.image.config.v1+json"
-cp $(workspaces.source.path)/build.sh $(workspaces.source.path)/source/.jbs
-create-archive --store %s $(results.%s.path)=$(workspaces.source.path)/source
-`, orasOptions, registryArgsWithDefaults(jbsConfig, preBuildImageTag), PreBuildImageDigest)
+// mavenAlsoMakeArgs returns the reactor arguments to build a single module along with its upstream (-am) and/or
+// downstream (-amd) modules. By default, the whole reactor is built.
+func mavenAlsoMakeArgs(tool string, recipe *v1alpha1.BuildRecipe, contextDir string) []string {
+	if tool != "maven" || contextDir == "" || (!recipe.AlsoMake && !recipe.AlsoMakeDependents) {
+		return nil
+	}
+	// The module list is relative to the reactor root rather than the context directory the build runs within.
+	args := []string{"-f", "$(workspaces." + WorkspaceSource + ".path)/source/pom.xml", "-pl", contextDir}
+	if recipe.AlsoMake {
+		args = append(args, "-am")
+	}
+	if recipe.AlsoMakeDependents {
+		args = append(args, "-amd")
+	}
+	return args
+}
 
-	copyArtifactsArgs := []string{
-		"copy-artifacts",
-		"--source-path=$(workspaces.source.path)/source",
-		"--deploy-path=$(workspaces.source.path)/artifacts",
+// mavenTestOrderArgs returns the surefire and failsafe properties that fix the order tests are run in.
+func mavenTestOrderArgs(tool string, recipe *v1alpha1.BuildRecipe) []string {
+	if tool != "maven" {
+		return nil
+	}
+	switch recipe.TestRunOrder {
+	case TestRunOrderAlphabetical:
+		return []string{"-Dsurefire.runOrder=alphabetical", "-Dfailsafe.runOrder=alphabetical"}
+	case TestRunOrderRandom:
+		return []string{"-Dsurefire.runOrder=random", "-Dsurefire.runOrder.random.seed=" + TestRunOrderRandomSeed,
+			"-Dfailsafe.runOrder=random", "-Dfailsafe.runOrder.random.seed=" + TestRunOrderRandomSeed}
+	}
+	return nil
+}
+
+// mavenCompilerArgs returns the compiler plugin properties that fork javac from the compile JDK when Maven is launched
+// with a different JDK.
+func mavenCompilerArgs(tool string, compileJavaHome string, launcherJavaHome string) []string {
+	if tool != "maven" || compileJavaHome == launcherJavaHome {
+		return nil
+	}
+	return []string{"-Dmaven.compiler.fork=true", "-Dmaven.compiler.executable=" + compileJavaHome + "/bin/javac"}
+}
+
+// preBuildScript returns the recipe pre build script. By default it runs inline in the build shell. If the recipe
+// isolates it then it runs in a sub-shell, and only the KEY=VALUE lines it writes to $JBS_PRE_BUILD_ENV are exported
+// to the build.
+func preBuildScript(recipe *v1alpha1.BuildRecipe) string {
+	if !recipe.IsolatePreBuildScript || recipe.PreBuildScript == "" {
+		return recipe.PreBuildScript
+	}
+	return `export JBS_PRE_BUILD_ENV=` + PreBuildEnvFile + `
+: > "$JBS_PRE_BUILD_ENV"
+(
+` + recipe.PreBuildScript + `
+)
+set -a
+. "$JBS_PRE_BUILD_ENV"
+set +a
+`
+}
+
+// gradleTestOrderSettings returns a script fragment that adds an init script configuring the JUnit 5 class and method
+// orderers for all test tasks. gradle-build.sh copies the .hacbs-init directory into the Gradle init.d directory.
+func gradleTestOrderSettings(recipe *v1alpha1.BuildRecipe) string {
+	var properties string
+	switch recipe.TestRunOrder {
+	case TestRunOrderAlphabetical:
+		properties = `            systemProperty 'junit.jupiter.testclass.order.default', 'org.junit.jupiter.api.ClassOrderer$ClassName'
+            systemProperty 'junit.jupiter.testmethod.order.default', 'org.junit.jupiter.api.MethodOrderer$MethodName'
+`
+	case TestRunOrderRandom:
+		properties = `            systemProperty 'junit.jupiter.testclass.order.default', 'org.junit.jupiter.api.ClassOrderer$Random'
+            systemProperty 'junit.jupiter.testmethod.order.default', 'org.junit.jupiter.api.MethodOrderer$Random'
+            systemProperty 'junit.jupiter.execution.order.random.seed', '` + TestRunOrderRandomSeed + `'
+`
+	default:
+		return ""
+	}
+	return `mkdir -p .hacbs-init
+cat > .hacbs-init/test-order.gradle << 'EOF'
+allprojects {
+    tasks.withType(Test).configureEach {
+` + properties + `    }
+}
+EOF
+`
+}
+
+// gradleInitScript returns a script fragment that writes the recipe Gradle init script, which gradle-build.sh then
+// passes to Gradle.
+func gradleInitScript(recipe *v1alpha1.BuildRecipe) string {
+	if recipe.GradleInitScript == "" {
+		return ""
+	}
+	return "cat > " + GradleInitScriptFile + " << 'JBSINITEOF'\n" + strings.TrimSuffix(recipe.GradleInitScript, "\n") + "\nJBSINITEOF\n"
+}
+
+func pipelineBuildCommands(imageId string, db *v1alpha1.DependencyBuild, jbsConfig *v1alpha1.JBSConfig, buildId string) (string, string, []string, []string, []string) {
+
+	orasOptions := registryOrasOptions(jbsConfig)
+	retry := orasRetry(jbsConfig)
+
+	preBuildImageTag := imageId + "-pre-build-image"
+	if jbsConfig.Spec.Registry.PreBuildImageTag == v1alpha1.PreBuildImageTagContentDigest {
+		preBuildImageTag = "${SOURCE_DIGEST}-pre-build-image"
+	}
+	// The build-trusted-artifacts container doesn't handle REGISTRY_TOKEN but the actual .docker/config.json. Was using
+	// AUTHFILE to override but now switched to adding the image secret to the pipeline.
+	// Setting ORAS_OPTIONS to ensure the archive is compatible with jib (for OCIRepositoryClient).
+	preBuildImageArgs := fmt.Sprintf(`%secho "Creating pre-build-image archive"
+export ORAS_OPTIONS="%s %s%s"
+%scp $(workspaces.source.path)/build.sh "$JBS_DIR"
+%s%s%screate-archive --store %s $(results.%s.path)=$(workspaces.source.path)/source
+`, orasRetryFunction(jbsConfig), orasOptions, archiveOrasOptions(jbsConfig), archiveAnnotationOptions(jbsConfig, db, buildId), jbsDirectoryScript(jbsConfig), sourceSizeCheck(jbsConfig), sourceDigestScript(jbsConfig), retry, preBuildRegistryArgsWithDefaults(jbsConfig, preBuildImageTag), PreBuildImageDigest)
+	if mirror := jbsConfig.MirrorImageRegistry(); mirror != nil {
+		mirrorUrl := imageRegistryArgs(preBuildImageRegistry(*mirror), preBuildImageTag)
+		preBuildImageArgs += fmt.Sprintf(`echo "Mirroring pre-build-image archive to %s"
+%sORAS_OPTIONS="%s" %screate-archive --store %s /tmp/mirror-pre-build-image-digest=$(workspaces.source.path)/source
+`, mirrorUrl, mirrorRegistryConfigScript(jbsConfig), mirrorOrasOptions(jbsConfig, "$ORAS_OPTIONS"), retry, mirrorUrl)
+	}
+
+	copyArtifactsArgs := []string{
+		"copy-artifacts",
+		"--source-path=$(workspaces.source.path)/source",
+		"--deploy-path=$(workspaces.source.path)/artifacts",
 	}
 	deployArgs := []string{
 		"verify",
@@ -712,14 +1545,19 @@ create-archive --store %s $(results.%s.path)=$(workspaces.source.path)/source
 
 	regUrl := registryArgsWithDefaults(jbsConfig, buildId)
 	// Note as per RebuiltDownloadCommand and OCIRepositoryClient the layers are in a predefined order (namely source, logs, artifacts).
-	postBuildImageArgs := fmt.Sprintf(`echo "Creating post-build-image archive"
-export ORAS_OPTIONS="%s --image-spec=v1.0 --artifact-type application/vnd.oci.image.config.v1+json --no-tty --format=json"
-IMGURL=%s
-create-archive --store $IMGURL /tmp/source=$(workspaces.source.path)/source-archive /tmp/logs=$(workspaces.source.path)/logs /tmp/artifacts=$(workspaces.source.path)/artifacts | tee /tmp/oras-create.json
+	postBuildImageArgs := fmt.Sprintf(`%secho "Creating post-build-image archive"
+export ORAS_OPTIONS="%s %s%s --no-tty --format=json"
+%sIMGURL=%s
+%screate-archive --store $IMGURL /tmp/source=$(workspaces.source.path)/source-archive /tmp/logs=$(workspaces.source.path)/logs /tmp/artifacts=$(workspaces.source.path)/artifacts | tee /tmp/oras-create.json
 IMGDIGEST=$(cat /tmp/oras-create.json | grep -Ev '(Prepared artifact|Artifacts created)' | jq -r '.digest')
 echo -n "$IMGURL" >> $(results.%s.path)
 echo -n "$IMGDIGEST" >> $(results.%s.path)
-echo "IMAGE_URL set to $IMGURL and IMAGE_DIGEST set to $IMGDIGEST"`, orasOptions, regUrl, PipelineResultImage, PipelineResultImageDigest)
+echo "IMAGE_URL set to $IMGURL and IMAGE_DIGEST set to $IMGDIGEST"`, orasRetryFunction(jbsConfig), orasOptions, archiveOrasOptions(jbsConfig), archiveAnnotationOptions(jbsConfig, db, buildId), recordChecksumsScript(jbsConfig), regUrl, retry, PipelineResultImage, PipelineResultImageDigest)
+	if mirrorUrl := mirrorRegistryArgsWithDefaults(jbsConfig, buildId); mirrorUrl != "" {
+		postBuildImageArgs += fmt.Sprintf(`
+echo "Mirroring post-build-image archive to %s"
+%sORAS_OPTIONS="%s" %screate-archive --store %s /tmp/mirror-source=$(workspaces.source.path)/source-archive /tmp/mirror-logs=$(workspaces.source.path)/logs /tmp/mirror-artifacts=$(workspaces.source.path)/artifacts`, mirrorUrl, mirrorRegistryConfigScript(jbsConfig), mirrorOrasOptions(jbsConfig, "$ORAS_OPTIONS"), retry, mirrorUrl)
+	}
 
 	konfluxArgs := []string{
 		"deploy-pre-build-source",
@@ -734,10 +1572,413 @@ echo "IMAGE_URL set to $IMGURL and IMAGE_DIGEST set to $IMGDIGEST"`, orasOptions
 	return preBuildImageArgs, postBuildImageArgs, copyArtifactsArgs, deployArgs, konfluxArgs
 }
 
+// failedWorkspaceUrl returns where the workspace of a failed build is pushed to if failed workspaces are kept
+func failedWorkspaceUrl(jbsConfig *v1alpha1.JBSConfig, buildId string) string {
+	return registryArgsWithDefaults(jbsConfig, buildId+"-failed-workspace")
+}
+
+// runAsUser returns the user the pipeline steps run as, root unless the build settings set another.
+func runAsUser(jbsConfig *v1alpha1.JBSConfig) *int64 {
+	user := jbsConfig.Spec.BuildSettings.RunAsUser
+	return &user
+}
+
+// restrictEgressScript only allows the pod to connect to the cache, DNS and the allowed hosts, rejecting and logging
+// everything else.
+func restrictEgressScript(jbsConfig *v1alpha1.JBSConfig) string {
+	hosts := []string{"\"$CACHE_HOST\""}
+	for _, i := range jbsConfig.Spec.BuildSettings.EgressAllowlist.AllowedHosts {
+		hosts = append(hosts, shellQuote(i))
+	}
+	return fmt.Sprintf(`set -e
+CACHE_HOST=$(echo "$(params.%s)" | sed -e 's|^[a-z]*://||' -e 's|[:/].*$||')
+iptables -N %[2]s
+iptables -A %[2]s -o lo -j ACCEPT
+iptables -A %[2]s -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+iptables -A %[2]s -p udp --dport 53 -j ACCEPT
+iptables -A %[2]s -p tcp --dport 53 -j ACCEPT
+for host in %[3]s; do
+    echo "Allowing egress to $host"
+    iptables -A %[2]s -d "$host" -j ACCEPT
+done
+iptables -A %[2]s -j LOG --log-prefix "jbs-egress-denied: "
+iptables -A %[2]s -j REJECT
+iptables -A OUTPUT -j %[2]s`, PipelineParamCacheUrl, egressChain, strings.Join(hosts, " "))
+}
+
+// liftEgressRestrictionScript shows the packets the egress allowlist matched, including those it rejected, and then
+// removes it.
+func liftEgressRestrictionScript() string {
+	return fmt.Sprintf(`set -e
+echo "The egress allowlist matched, the REJECT rule counts the rejected packets:"
+iptables -L %[1]s -v -n -x
+iptables -D OUTPUT -j %[1]s
+iptables -F %[1]s
+iptables -X %[1]s`, egressChain)
+}
+
+// pushFailedWorkspaceScript pushes the whole workspace to the registry if the build step failed and then fails with
+// the exit code of the build step, so the rest of the build task does not run.
+func pushFailedWorkspaceScript(orasOptions string, url string) string {
+	return fmt.Sprintf(`BUILD_EXIT_CODE=$(cat $(steps.step-%[1]s.exitCode.path))
+if [ "$BUILD_EXIT_CODE" = "0" ]; then
+    exit 0
+fi
+echo "Build failed with exit code $BUILD_EXIT_CODE, pushing the workspace to %[3]s"
+export ORAS_OPTIONS="%[2]s"
+create-archive --store %[3]s /tmp/failed-workspace-digest=$(workspaces.source.path) || echo "Failed to push the workspace" >&2
+exit $BUILD_EXIT_CODE`, BuildTaskName, orasOptions, url)
+}
+
+// failedWorkspaceTask returns the finally task that records the location of the workspace pushed by a failed build.
+// Nothing is recorded if the build failed before the workspace could be pushed.
+func failedWorkspaceTask(jbsConfig *v1alpha1.JBSConfig, orasOptions string, url string) tektonpipeline.PipelineTask {
+	user := runAsUser(jbsConfig)
+	return tektonpipeline.PipelineTask{
+		Name: FailedWorkspaceTaskName,
+		When: tektonpipeline.WhenExpressions{{Input: "$(tasks." + BuildTaskName + ".status)", Operator: selection.In, Values: []string{"Failed"}}},
+		TaskSpec: &tektonpipeline.EmbeddedTask{
+			TaskSpec: tektonpipeline.TaskSpec{
+				Results: []tektonpipeline.TaskResult{{Name: PipelineResultDebugWorkspace}},
+				Steps: []tektonpipeline.Step{
+					{
+						Name:            "record-failed-workspace",
+						Image:           trustedArtifactsImage(jbsConfig),
+						ImagePullPolicy: v1.PullIfNotPresent,
+						SecurityContext: &v1.SecurityContext{RunAsUser: user},
+						Env:             secretVariables(jbsConfig),
+						Script: fmt.Sprintf(`if oras manifest fetch --descriptor %[1]s %[2]s > /dev/null; then
+    echo -n "%[2]s" > $(results.%[3]s.path)
+fi`, orasOptions, url, PipelineResultDebugWorkspace),
+					},
+				},
+			},
+		},
+	}
+}
+
+// sourceSizeCheck fails the pre-build image creation if the source tree is larger than the configured limit, as
+// very large archives can exceed registry limits.
+func sourceSizeCheck(jbsConfig *v1alpha1.JBSConfig) string {
+	limit := jbsConfig.Spec.BuildSettings.MaxSourceSizeMB
+	if limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(`SOURCE_SIZE=$(du -sm $(workspaces.source.path)/source | cut -f1)
+if [ "$SOURCE_SIZE" -gt %d ]; then
+    echo "Source tree size ${SOURCE_SIZE}MB exceeds the maximum of %dMB for the pre-build image" >&2
+    exit 1
+fi
+`, limit, limit)
+}
+
+// sourceDigestScript sets SOURCE_DIGEST to a digest of the paths and content of the files of the source tree, which the
+// pre-build image is tagged with if configured. The generated build files are already in the tree so the same source
+// built with a different recipe gets a different tag. The git metadata is excluded as e.g. the index is not stable
+// between clones.
+func sourceDigestScript(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Spec.Registry.PreBuildImageTag != v1alpha1.PreBuildImageTagContentDigest {
+		return ""
+	}
+	return `SOURCE_DIGEST=$(cd $(workspaces.source.path)/source && find . -path ./.git -prune -o -type f -print0 | LC_ALL=C sort -z | xargs -0 --no-run-if-empty sha256sum | sha256sum | cut -d ' ' -f 1)
+echo "Source content digest is $SOURCE_DIGEST"
+`
+}
+
+// homeScript sets HOME for the build. As the user and home directory of recipe images vary, unless the recipe
+// configures it the home directory of the image user is used, falling back to the workspace if it cannot be written to.
+func homeScript(recipe *v1alpha1.BuildRecipe) string {
+	if recipe.HomeDirectory != "" {
+		return fmt.Sprintf(`export HOME=%s
+mkdir -p "$HOME"
+echo "HOME:$HOME"`, shellQuote(recipe.HomeDirectory))
+	}
+	return `JBS_HOME=$(getent passwd "$(id -u)" | cut -d: -f6 || true)
+if [ -z "$JBS_HOME" ] || ! mkdir -p "$JBS_HOME" 2>/dev/null || [ ! -w "$JBS_HOME" ]; then
+    echo "Home directory '$JBS_HOME' of user $(id -u) is not writable, using the workspace"
+    JBS_HOME=$(workspaces.source.path)/home
+    mkdir -p "$JBS_HOME"
+fi
+export HOME="$JBS_HOME"
+echo "HOME:$HOME"`
+}
+
+// diskMonitorScript checks the workspace disk usage before the build and then in the background while it runs. If the
+// usage crosses the threshold the processes started by the build are stopped so it fails with a clear message rather
+// than whatever error the build tool reports when it cannot write.
+func diskMonitorScript(jbsConfig *v1alpha1.JBSConfig) string {
+	monitor := jbsConfig.Spec.BuildSettings.DiskMonitor
+	if !monitor.Enabled {
+		return ""
+	}
+	threshold := monitor.ThresholdPercent
+	if threshold <= 0 || threshold > 100 {
+		threshold = 95
+	}
+	interval := monitor.IntervalSeconds
+	if interval <= 0 {
+		interval = 30
+	}
+	return fmt.Sprintf(`disk_usage() {
+    df --output=pcent $(workspaces.source.path) | tail -1 | tr -dc '0-9'
+}
+if [ "$(disk_usage)" -ge %d ]; then
+    echo "Out of disk: the workspace is $(disk_usage)%% full before the build started (threshold %d%%)" >&2
+    exit 1
+fi
+BUILD_PID=$$
+(
+    while sleep %d; do
+        if [ "$(disk_usage)" -ge %d ]; then
+            echo "Out of disk: the workspace is $(disk_usage)%% full (threshold %d%%), stopping the build" >&2
+            # This also stops the monitor itself as it is a child of the build
+            pkill -TERM -P $BUILD_PID
+        fi
+    done
+) &
+DISK_MONITOR_PID=$!
+trap 'kill $DISK_MONITOR_PID 2>/dev/null || true' EXIT
+`, threshold, threshold, interval, threshold, threshold)
+}
+
+// quietBuildOutputScript runs the build tools with their output buffered in the logs workspace, which is archived with
+// the post-build image, and only shows it if the build fails. The section is run in a subshell so a failing command
+// still ends it and its exit code is kept.
+func quietBuildOutputScript(jbsConfig *v1alpha1.JBSConfig, section string) string {
+	if !jbsConfig.Spec.BuildSettings.QuietBuildOutput {
+		return section
+	}
+	return fmt.Sprintf(`echo "The build output is only shown if the build fails"
+set +e
+(
+set -e
+%s
+) > %[2]s 2>&1
+BUILD_STATUS=$?
+set -e
+if [ $BUILD_STATUS -ne 0 ]; then
+    echo "Build failed with exit code $BUILD_STATUS, the full build output follows" >&2
+    cat %[2]s >&2
+    exit $BUILD_STATUS
+fi
+echo "Build succeeded, the full build output is in %[2]s"`, section, QuietBuildOutputLog)
+}
+
+// deployRetryFunction defines the deploy_retry shell function, which retries a command while the retry budget of the
+// deploy pipeline allows. The retries made so far and when the first retried command started are kept in the source
+// workspace so the budget is shared by all the steps. The messages go to stderr as the output of the command may be
+// captured.
+func deployRetryFunction(jbsConfig *v1alpha1.JBSConfig) string {
+	budget := jbsConfig.Spec.MavenDeployment.RetryBudget
+	if budget.MaxRetries <= 0 {
+		return ""
+	}
+	delay := budget.DelaySeconds
+	if delay <= 0 {
+		delay = DefaultDeployRetryDelaySeconds
+	}
+	return fmt.Sprintf(`deploy_retry() {
+    BUDGET_FILE=$(workspaces.source.path)/%[1]s
+    if [ ! -f "$BUDGET_FILE" ]; then
+        echo "0 $(date +%%s)" > "$BUDGET_FILE"
+    fi
+    while ! "$@"; do
+        read RETRIES STARTED < "$BUDGET_FILE"
+        if [ "$RETRIES" -ge %[2]d ]; then
+            echo "Deploy retry budget of %[2]d retries exhausted" >&2
+            return 1
+        fi
+        if [ %[3]d -gt 0 ] && [ $(( $(date +%%s) - STARTED )) -ge %[3]d ]; then
+            echo "Deploy retry budget of %[3]d seconds exhausted" >&2
+            return 1
+        fi
+        RETRIES=$((RETRIES + 1))
+        echo "$RETRIES $STARTED" > "$BUDGET_FILE"
+        echo "Retrying $1 ($RETRIES of %[2]d retries)" >&2
+        sleep %[4]d
+    done
+}
+`, DeployRetryBudgetFile, budget.MaxRetries, budget.MaxSeconds, delay)
+}
+
+// deployRetry returns the prefix that runs a deploy command with deploy_retry, if a retry budget is configured.
+func deployRetry(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Spec.MavenDeployment.RetryBudget.MaxRetries <= 0 {
+		return ""
+	}
+	return "deploy_retry "
+}
+
+// orasRetryFunction defines the oras_retry shell function, which retries a failed oras request the configured number
+// of times, doubling the delay after every retry. The oras CLI can't retry requests itself. The messages go to stderr
+// as the output of the request may be captured.
+func orasRetryFunction(jbsConfig *v1alpha1.JBSConfig) string {
+	retry := jbsConfig.Spec.Registry.OrasRetry
+	if retry.Count <= 0 {
+		return ""
+	}
+	backoff := retry.BackoffSeconds
+	if backoff <= 0 {
+		backoff = DefaultOrasRetryBackoffSeconds
+	}
+	return fmt.Sprintf(`oras_retry() {
+    local attempt=0 delay=%[2]d
+    while ! "$@"; do
+        if [ "$attempt" -ge %[1]d ]; then
+            echo "Giving up on $1 after %[1]d retries" >&2
+            return 1
+        fi
+        attempt=$((attempt + 1))
+        echo "Retrying $1 in ${delay}s ($attempt of %[1]d retries)" >&2
+        sleep "$delay"
+        delay=$((delay * 2))
+    done
+}
+`, retry.Count, backoff)
+}
+
+// orasRetry returns the prefix that runs an oras request with oras_retry, if retries are configured.
+func orasRetry(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Spec.Registry.OrasRetry.Count <= 0 {
+		return ""
+	}
+	return "oras_retry "
+}
+
+// registryOrasOptions returns the options passed to every oras request of the pipelines, i.e. the insecure options
+// for the test registry.
+func registryOrasOptions(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Annotations != nil && jbsConfig.Annotations[jbsconfig.TestRegistry] == "true" {
+		return "--insecure --plain-http"
+	}
+	return ""
+}
+
+// archiveOrasOptions returns the image spec and artifact type of the pre-build and post-build image archives. The
+// defaults ensure the archives are compatible with jib (for OCIRepositoryClient).
+func archiveOrasOptions(jbsConfig *v1alpha1.JBSConfig) string {
+	imageSpec := jbsConfig.Spec.Registry.ImageSpec
+	if imageSpec == "" {
+		imageSpec = DefaultArchiveImageSpec
+	}
+	artifactType := jbsConfig.Spec.Registry.ArtifactType
+	if artifactType == "" {
+		artifactType = DefaultArchiveArtifactType
+	}
+	return "--image-spec=" + imageSpec + " --artifact-type " + artifactType
+}
+
+// archiveAnnotationOptions returns the oras options adding the configured annotations to the pre-build and post-build
+// image archives, with the placeholders replaced by the build's values. The options are passed through ORAS_OPTIONS
+// which is split on whitespace, so values that would need quoting can't be passed and are skipped.
+func archiveAnnotationOptions(jbsConfig *v1alpha1.JBSConfig, db *v1alpha1.DependencyBuild, buildId string) string {
+	annotations := jbsConfig.Spec.Registry.ArchiveAnnotations
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	replacer := strings.NewReplacer("{SCM_URL}", db.Spec.ScmInfo.SCMURL, "{SCM_COMMIT}", db.Spec.ScmInfo.CommitHash, "{BUILD_ID}", buildId)
+	ret := ""
+	for _, k := range keys {
+		annotation := k + "=" + replacer.Replace(annotations[k])
+		if k == "" || !plainShellValueRegex.MatchString(annotation) {
+			continue
+		}
+		ret += " --annotation " + annotation
+	}
+	return ret
+}
+
+// tagOrasOptions appends the configured headers and options for the oras tag command.
+func tagOrasOptions(jbsConfig *v1alpha1.JBSConfig, orasOptions string) string {
+	options := []string{}
+	if headerOptions := tagHeaderOrasOptions(jbsConfig, orasOptions); headerOptions != "" {
+		options = append(options, headerOptions)
+	}
+	return strings.Join(append(options, jbsConfig.Spec.Registry.TagOptions...), " ")
+}
+
+// tagHeaderOrasOptions appends the configured headers for the oras tag command, which are also needed to fetch the
+// tagged manifests. Headers are sorted so the generated script is stable between reconciles.
+func tagHeaderOrasOptions(jbsConfig *v1alpha1.JBSConfig, orasOptions string) string {
+	options := []string{}
+	if orasOptions != "" {
+		options = append(options, orasOptions)
+	}
+	headers := make([]string, 0, len(jbsConfig.Spec.Registry.TagHeaders))
+	for k := range jbsConfig.Spec.Registry.TagHeaders {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	for _, k := range headers {
+		options = append(options, "--header "+shellQuote(k+": "+jbsConfig.Spec.Registry.TagHeaders[k]))
+	}
+	return strings.Join(options, " ")
+}
+
+// shellQuote quotes a value so it is passed to a command as a single argument, whatever characters it contains.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
 // This effectively duplicates the defaults from DeployPreBuildImageCommand.java
 func registryArgsWithDefaults(jbsConfig *v1alpha1.JBSConfig, preBuildImageTag string) string {
+	return imageRegistryArgs(jbsConfig.ImageRegistry(), preBuildImageTag)
+}
+
+// preBuildRegistryArgsWithDefaults is the equivalent of registryArgsWithDefaults for the pre-build images, which use
+// the pre-build repository if one is configured.
+func preBuildRegistryArgsWithDefaults(jbsConfig *v1alpha1.JBSConfig, preBuildImageTag string) string {
+	return imageRegistryArgs(preBuildImageRegistry(jbsConfig.ImageRegistry()), preBuildImageTag)
+}
+
+// preBuildImageRegistry returns the registry with the pre-build repository in place of the deployment repository.
+func preBuildImageRegistry(imageRegistry v1alpha1.ImageRegistry) v1alpha1.ImageRegistry {
+	if imageRegistry.PreBuildRepository != "" {
+		imageRegistry.Repository = imageRegistry.PreBuildRepository
+	}
+	return imageRegistry
+}
+
+// mirrorRegistryArgsWithDefaults is the equivalent of registryArgsWithDefaults for the secondary registry. It returns
+// an empty string if no mirror is configured.
+func mirrorRegistryArgsWithDefaults(jbsConfig *v1alpha1.JBSConfig, preBuildImageTag string) string {
+	mirror := jbsConfig.MirrorImageRegistry()
+	if mirror == nil {
+		return ""
+	}
+	return imageRegistryArgs(*mirror, preBuildImageTag)
+}
+
+// mirrorOrasOptions adds the options needed to push to the secondary registry to orasOptions. The mirror can be
+// insecure and have its own credentials independently of the primary registry.
+func mirrorOrasOptions(jbsConfig *v1alpha1.JBSConfig, orasOptions string) string {
+	mirror := jbsConfig.MirrorImageRegistry()
+	if mirror == nil {
+		return orasOptions
+	}
+	if mirror.Insecure && !strings.Contains(orasOptions, "--insecure") {
+		orasOptions = orasOptions + " --insecure --plain-http"
+	}
+	if mirror.SecretName != "" {
+		orasOptions = orasOptions + " --registry-config " + MirrorRegistryConfig
+	}
+	return strings.TrimSpace(orasOptions)
+}
+
+// mirrorRegistryConfigScript writes the credentials for the secondary registry to their own registry config so they
+// don't replace those of the primary registry.
+func mirrorRegistryConfigScript(jbsConfig *v1alpha1.JBSConfig) string {
+	mirror := jbsConfig.MirrorImageRegistry()
+	if mirror == nil || mirror.SecretName == "" {
+		return ""
+	}
+	return `echo "$MIRROR_REGISTRY_TOKEN" > ` + MirrorRegistryConfig + "\n"
+}
+
+func imageRegistryArgs(imageRegistry v1alpha1.ImageRegistry, preBuildImageTag string) string {
 
-	imageRegistry := jbsConfig.ImageRegistry()
 	var registryArgs strings.Builder
 	if imageRegistry.Host != "" {
 		registryArgs.WriteString(imageRegistry.Host)
@@ -769,6 +2010,151 @@ func registryArgsWithDefaults(jbsConfig *v1alpha1.JBSConfig, preBuildImageTag st
 	return registryArgs.String()
 }
 
+// recordChecksumsScript records the checksums of the built artifacts in the logs directory so they are archived in the
+// post-build image alongside the artifacts. The digest of the checksum manifest is also emitted as a task result before
+// the image is pushed so the deployment does not have to trust the copy within the image.
+func recordChecksumsScript(jbsConfig *v1alpha1.JBSConfig) string {
+	if !jbsConfig.Spec.MavenDeployment.ValidateChecksums {
+		return ""
+	}
+	return fmt.Sprintf(`echo "Recording artifact checksums"
+(cd $(workspaces.source.path)/artifacts && find . -type f -exec sha256sum {} + > $(workspaces.source.path)/logs/%s)
+sha256sum $(workspaces.source.path)/logs/%s | cut -d ' ' -f 1 | tr -d '\n' > $(results.%s.path)
+`, ArtifactChecksumsFile, ArtifactChecksumsFile, PipelineResultArtifactChecksums)
+}
+
+// validateChecksumsScript checks the checksum manifest restored from the post-build image against the digest the build
+// recorded before the image was pushed, and then checks the restored artifacts against the manifest.
+func validateChecksumsScript() string {
+	return fmt.Sprintf(`echo "Validating artifact checksums"
+MANIFEST=$(workspaces.source.path)/logs/%s
+EXPECTED=$(params.%s)
+if [ -z "$EXPECTED" ] || [ ! -f $MANIFEST ]; then
+    echo "No artifact checksums were recorded by the build"
+    exit 1
+fi
+if ! echo "$EXPECTED  $MANIFEST" | sha256sum --check --quiet -; then
+    echo "Artifact checksum manifest does not match the one recorded by the build"
+    exit 1
+fi
+cd $(workspaces.source.path)/artifacts
+if ! sha256sum --check --quiet $MANIFEST; then
+    echo "Artifact checksums do not match those recorded by the build"
+    exit 1
+fi`, ArtifactChecksumsFile, PipelineResultArtifactChecksums)
+}
+
+// signingPublicKeyVariable returns the variable holding the public key the artifact signatures are verified against, or
+// nil if signatures are not verified.
+func signingPublicKeyVariable(jbsConfig *v1alpha1.JBSConfig) *v1.EnvVar {
+	source := jbsConfig.Spec.MavenDeployment.SigningPublicKey
+	if source == nil {
+		return nil
+	}
+	key := settingOrDefault(source.Key, DefaultSigningPublicKeyKey)
+	if source.SecretName != "" {
+		return &v1.EnvVar{Name: SigningPublicKeyVariable, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: source.SecretName}, Key: key}}}
+	}
+	if source.ConfigMapName != "" {
+		return &v1.EnvVar{Name: SigningPublicKeyVariable, ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: source.ConfigMapName}, Key: key}}}
+	}
+	return nil
+}
+
+// verifySignaturesScript verifies every signature within the restored artifacts against the configured public key,
+// which is imported into a keyring of its own. It fails if any signature is invalid or its artifact is missing.
+func verifySignaturesScript() string {
+	return fmt.Sprintf(`echo "Verifying artifact signatures"
+export GNUPGHOME=$(mktemp -d)
+if ! echo "$%[1]s" | gpg --batch --import; then
+    echo "Failed to import the signing public key" >&2
+    exit 1
+fi
+VERIFIED=0
+FAILED=0
+for SIGNATURE in $(find $(workspaces.source.path)/artifacts -type f -name '*.asc'); do
+    if gpg --batch --verify "$SIGNATURE" "${SIGNATURE%%.asc}"; then
+        VERIFIED=$((VERIFIED + 1))
+    else
+        echo "Invalid signature $SIGNATURE" >&2
+        FAILED=$((FAILED + 1))
+    fi
+done
+echo "Verified $VERIFIED artifact signatures"
+if [ $FAILED -ne 0 ]; then
+    echo "$FAILED artifact signatures are invalid" >&2
+    exit 1
+fi`, SigningPublicKeyVariable)
+}
+
+// trustedArtifactsImage returns the trusted artifacts image, from a mirror if one is configured.
+func trustedArtifactsImage(jbsConfig *v1alpha1.JBSConfig) string {
+	return jbsConfig.MirroredImage(strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1]))
+}
+
+// gcsDeployment returns true if any of the repositories to deploy to is a Google Cloud Storage bucket.
+func gcsDeployment(jbsConfig *v1alpha1.JBSConfig) bool {
+	for _, repository := range mavenRepositories(jbsConfig) {
+		if strings.HasPrefix(repository.URL, "gs://") {
+			return true
+		}
+	}
+	return false
+}
+
+// gcsCredentialsVolume returns the volume holding the Google Cloud service account key and its mount for the
+// deployment step, or nil if no repository is in Google Cloud Storage.
+func gcsCredentialsVolume(jbsConfig *v1alpha1.JBSConfig) ([]v1.Volume, []v1.VolumeMount) {
+	if !gcsDeployment(jbsConfig) {
+		return nil, nil
+	}
+	trueBool := true
+	return []v1.Volume{{Name: "gcs-credentials", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: v1alpha1.GCSSecretName, Optional: &trueBool}}}},
+		[]v1.VolumeMount{{Name: "gcs-credentials", MountPath: GCSCredentialsPath, ReadOnly: true}}
+}
+
+// ociDeployScript pushes the artifacts of each GAV as a single OCI artifact, with a layer per file, rather than
+// deploying them to a Maven repository. The GAVs are found from their poms within the Maven repository layout.
+func ociDeployScript(jbsConfig *v1alpha1.JBSConfig, orasOptions string) string {
+	repository := jbsConfig.Spec.MavenDeployment.OCIRepository
+	if repository == "" {
+		imageRegistry := jbsConfig.ImageRegistry()
+		imageRegistry.Repository = DefaultOCIDeployRepository
+		repository = imageRegistryArgs(imageRegistry, "")
+	}
+	tagPrefix := ""
+	if prependTag := jbsConfig.ImageRegistry().PrependTag; prependTag != "" {
+		tagPrefix = prependTag + "_"
+	}
+	return fmt.Sprintf(`%[1]secho "Pushing artifacts to %[2]s"
+export ORAS_OPTIONS="%[3]s"
+cd $(workspaces.source.path)/artifacts
+find . -name '*.pom' | sort | while read -r POM; do
+    DIR=$(dirname "$POM")
+    VERSION=$(basename "$DIR")
+    ARTIFACT=$(basename "$(dirname "$DIR")")
+    GROUP=$(dirname "$(dirname "$DIR")" | sed -e 's|^\./||' -e 's|/|.|g')
+    # Same as the tags of the build images so the GAV can be found the same way
+    TAG=%[4]s$(echo -n "$GROUP:$ARTIFACT:$VERSION" | sha256sum | cut -d ' ' -f 1)
+    echo "Pushing $GROUP:$ARTIFACT:$VERSION to %[2]s:$TAG"
+    (cd "$DIR" && %[5]soras push $ORAS_OPTIONS --artifact-type %[6]s --annotation "org.apache.maven.groupId=$GROUP" --annotation "org.apache.maven.artifactId=$ARTIFACT" --annotation "org.apache.maven.version=$VERSION" %[2]s:$TAG $(find . -maxdepth 1 -type f | sed 's|^\./||' | sort)) || exit 1
+done`, deployRetryFunction(jbsConfig), repository, orasOptions, tagPrefix, deployRetry(jbsConfig), OCIDeployArtifactType)
+}
+
+// mavenRepositories returns the repositories to deploy to, the primary repository first.
+func mavenRepositories(jbsConfig *v1alpha1.JBSConfig) []v1alpha1.MavenRepository {
+	repositories := []v1alpha1.MavenRepository{}
+	if jbsConfig.Spec.MavenDeployment.Repository != "" {
+		repositories = append(repositories, v1alpha1.MavenRepository{URL: jbsConfig.Spec.MavenDeployment.Repository})
+	}
+	for _, repository := range jbsConfig.Spec.MavenDeployment.Repositories {
+		if repository.URL != "" {
+			repositories = append(repositories, repository)
+		}
+	}
+	return repositories
+}
+
 func pipelineDeployCommands(jbsConfig *v1alpha1.JBSConfig, db *v1alpha1.DependencyBuild) []string {
 
 	imageId := db.Name
@@ -783,12 +2169,24 @@ func pipelineDeployCommands(jbsConfig *v1alpha1.JBSConfig, db *v1alpha1.Dependen
 	}
 
 	mavenArgs := make([]string, 0)
-	if jbsConfig.Spec.MavenDeployment.Repository != "" {
-		mavenArgs = append(mavenArgs, "--mvn-repo="+jbsConfig.Spec.MavenDeployment.Repository)
+	for i, repository := range mavenRepositories(jbsConfig) {
+		mavenArgs = append(mavenArgs, "--mvn-repo="+util.MavenRepositoryURL(repository.URL))
+		if repository.Username != "" {
+			mavenArgs = append(mavenArgs, fmt.Sprintf("--mvn-repo-username=%d=%s", i, repository.Username))
+		}
 	}
 	if jbsConfig.Spec.MavenDeployment.Username != "" {
 		mavenArgs = append(mavenArgs, "--mvn-username="+jbsConfig.Spec.MavenDeployment.Username)
 	}
+	if jbsConfig.Spec.MavenDeployment.ConsistentSnapshotTimestamp {
+		mavenArgs = append(mavenArgs, "--consistent-snapshot-timestamp")
+	}
+	if jbsConfig.Spec.MavenDeployment.PartitionByGroupId {
+		mavenArgs = append(mavenArgs, "--partition-by-group-id")
+	}
+	if jbsConfig.Spec.MavenDeployment.RegenerateMetadata {
+		mavenArgs = append(mavenArgs, "--regenerate-metadata")
+	}
 	deployArgs = append(deployArgs, mavenArgs...)
 
 	return deployArgs
@@ -834,36 +2232,614 @@ func prependTagToImage(imageId string, prependTag string) string {
 	return imageId
 }
 
-func verifyParameters(jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe) []string {
+func verifyParameters(jbsConfig *v1alpha1.JBSConfig, db *v1alpha1.DependencyBuild, recipe *v1alpha1.BuildRecipe) []string {
+	repositoryUrl := "$(params.CACHE_URL)"
+	if db.Spec.ReferenceArtifactImage != "" {
+		repositoryUrl = "file://$(workspaces.source.path)/" + ReferenceArtifactsDirectory
+	}
 	verifyBuiltArtifactsArgs := []string{
 		"verify-built-artifacts",
-		"--repository-url=$(params.CACHE_URL)",
+		"--repository-url=" + repositoryUrl,
 		"--deploy-path=$(workspaces.source.path)/artifacts",
 		"--task-run-name=$(context.taskRun.name)",
 		"--results-file=$(results." + PipelineResultPassedVerification + ".path)",
 	}
+	for _, i := range db.Spec.ReferenceRepositories {
+		verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, "--reference-repository-url="+i)
+	}
 
 	if !jbsConfig.Spec.RequireArtifactVerification {
 		verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, "--report-only")
 	}
 
-	if len(recipe.AllowedDifferences) > 0 {
+	verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, testArtifactArgs(jbsConfig)...)
+	if useExcludesFile(jbsConfig, recipe) {
+		verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, "--excludes-file="+VerificationExcludesFile)
+	} else {
 		for _, i := range recipe.AllowedDifferences {
-			verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, "--excludes="+i)
+			pattern, _ := splitAllowedDifference(i)
+			verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, "--excludes="+pattern)
 		}
 	}
+	if jbsConfig.Spec.VerificationOutputFormat == v1alpha1.VerificationOutputFormatJson {
+		// The full diff is unbounded so it is archived with the logs, only the summary fits in a task result
+		verifyBuiltArtifactsArgs = append(verifyBuiltArtifactsArgs, "--output-format=json",
+			"--diff-file=$(workspaces.source.path)/logs/"+VerificationDiffFile,
+			"--diff-summary-file=$(results."+PipelineResultVerificationDiff+".path)")
+	}
 	return verifyBuiltArtifactsArgs
 }
 
+// referenceArtifactsScript restores the artifacts layer of the reference post-build image, which is the third layer as
+// per the post-build image layout. A manifest list is resolved to its first image as in the deploy pipeline.
+func referenceArtifactsScript(orasOptions string, reference string) string {
+	return fmt.Sprintf(`echo "Restoring reference artifacts from %[2]s"
+export ORAS_OPTIONS="%[1]s"
+MANIFEST=$(oras manifest fetch $ORAS_OPTIONS %[2]s)
+if echo "$MANIFEST" | jq --exit-status '.manifests' > /dev/null; then
+  ENTRY=$(echo "$MANIFEST" | jq --raw-output '.manifests[0].digest')
+  MANIFEST=$(oras manifest fetch $ORAS_OPTIONS %[3]s@$ENTRY)
+fi
+AARCHIVE=$(echo "$MANIFEST" | jq --raw-output '.layers[2].digest')
+use-archive oci:%[3]s@$AARCHIVE=$(workspaces.source.path)/%[4]s`, orasOptions, reference, v1alpha1.RepositoryName(reference), ReferenceArtifactsDirectory)
+}
+
+// useExcludesFile returns true if the allowed differences are numerous enough to be written to a file rather than
+// bloating the verification command.
+func useExcludesFile(jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe) bool {
+	threshold := jbsConfig.Spec.BuildSettings.ExcludesFileThreshold
+	if threshold <= 0 {
+		threshold = DefaultExcludesFileThreshold
+	}
+	return len(recipe.AllowedDifferences) > threshold
+}
+
+// verificationExcludesScript writes the allowed differences to VerificationExcludesFile if they are too numerous to
+// be passed as arguments. Lines in the file are used as is, so the diff marker escaping the verifier applies to
+// arguments is done here instead.
+func verificationExcludesScript(jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe) string {
+	if !useExcludesFile(jbsConfig, recipe) {
+		return ""
+	}
+	ret := "cat > " + VerificationExcludesFile + " <<'RHTAPEOF'\n"
+	for _, i := range recipe.AllowedDifferences {
+		pattern, _ := splitAllowedDifference(i)
+		ret += excludeDiffMarkerRegex.ReplaceAllString(pattern, `^\$1:`) + "\n"
+	}
+	return ret + "RHTAPEOF\n"
+}
+
+// splitAllowedDifference splits an allowed difference into the pattern passed to the verifier and the reason it was
+// allowed, which is empty for plain entries. Patterns are regular expressions that may contain ':' so the reason uses
+// its own separator.
+func splitAllowedDifference(allowed string) (string, string) {
+	pattern, reason, _ := strings.Cut(allowed, AllowedDifferenceReasonSeparator)
+	return strings.TrimSpace(pattern), strings.TrimSpace(reason)
+}
+
+// allowedDifferenceReasonsScript logs the reason each allowed difference was allowed so it is recorded with the
+// verification output for audit.
+func allowedDifferenceReasonsScript(recipe *v1alpha1.BuildRecipe) string {
+	ret := ""
+	for _, i := range recipe.AllowedDifferences {
+		if pattern, reason := splitAllowedDifference(i); reason != "" {
+			ret += "echo " + shellQuote("Allowed difference "+pattern+": "+reason) + "\n"
+		}
+	}
+	return ret
+}
+
+// scriptSection marks where a section of the generated build script came from if annotating the script is enabled.
+// Empty sections are left out.
+func scriptSection(jbsConfig *v1alpha1.JBSConfig, name string, section string) string {
+	if !jbsConfig.Spec.BuildSettings.AnnotateBuildScript || strings.TrimSpace(section) == "" {
+		return section
+	}
+	return "# ---- begin " + name + " ----\n" + strings.TrimSuffix(section, "\n") + "\n# ---- end " + name + " ----\n"
+}
+
+// toolHomeVariables are the environment variables holding the location of each build tool
+var toolHomeVariables = map[string]string{"maven": "MAVEN_HOME", "gradle": "GRADLE_HOME", "ant": "ANT_HOME", "lein": "LEIN_HOME", "sbt": "SBT_DIST"}
+
+// toolBuildSection returns the part of the build script that runs the given build tool with the current arguments.
+func toolBuildSection(tool string, jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe) string {
+	switch tool {
+	case "maven":
+		return mavenSettingsScript(jbsConfig) + "\n" + buildToolWrapperScript(tool, jbsConfig, recipe) + mavenBuild
+	case "gradle":
+		// We always add Maven information (in InvocationBuilder) so add the relevant settings.xml
+		return mavenSettingsScript(jbsConfig) + "\n" + gradleBuildCacheSettings(jbsConfig) + gradleTestOrderSettings(recipe) + gradleInitScript(recipe) + buildToolWrapperScript(tool, jbsConfig, recipe) + gradleBuild
+	case "sbt":
+		return sbtBuild
+	case "ant":
+		// We always add Maven information (in InvocationBuilder) so add the relevant settings.xml
+		return mavenSettingsScript(jbsConfig) + "\n" + antDeployScript(recipe) + antBuild
+	case "lein":
+		// We always add Maven information (in InvocationBuilder) so add the relevant settings.xml
+		return mavenSettingsScript(jbsConfig) + "\n" + leinBuild
+	}
+	return "echo unknown build tool " + tool + " && exit 1"
+}
+
+// collectAntArtifacts returns true if the artifacts of an Ant build are collected from the source tree after the build,
+// as Ant only deploys if the recipe says build.xml has a deploy target.
+func collectAntArtifacts(tool string, recipe *v1alpha1.BuildRecipe) bool {
+	return tool == "ant" && !recipe.AntNativeDeploy
+}
+
+// antDeployScript passes the repository the artifacts are deployed to on to an Ant build that deploys them itself.
+func antDeployScript(recipe *v1alpha1.BuildRecipe) string {
+	if !recipe.AntNativeDeploy {
+		return ""
+	}
+	return "export ANT_ARGS=\"${ANT_ARGS:-} -D" + AntDeployUrlProperty + "=file:$(workspaces.source.path)/artifacts\"\n"
+}
+
+// defaultGoals are the goals the build tools are run with if the recipe has none
+var defaultGoals = map[string][]string{
+	"maven":  {"clean", "deploy"},
+	"gradle": {"build"},
+}
+
+// buildToolWrappers are the build tools that can be run through a wrapper, with the command the wrapper replaces, the
+// wrapper script, the jar the wrapper script runs and the properties with the distribution the jar downloads
+var buildToolWrappers = map[string]struct{ command, script, jar, properties string }{
+	"maven":  {"mvn", "mvnw", ".mvn/wrapper/maven-wrapper.jar", ".mvn/wrapper/maven-wrapper.properties"},
+	"gradle": {"gradle", "gradlew", "gradle/wrapper/gradle-wrapper.jar", "gradle/wrapper/gradle-wrapper.properties"},
+}
+
+// buildToolWrapperScript returns a script fragment that verifies the checksum of the wrapper jar and then runs the
+// wrapper in place of the installed build tool, if the recipe uses the build tool wrapper. The wrapper verifies the
+// distribution it downloads itself, but only if the wrapper properties have its checksum, so this is checked too.
+func buildToolWrapperScript(tool string, jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe) string {
+	wrapper, ok := buildToolWrappers[tool]
+	if !ok || !recipe.UseBuildToolWrapper {
+		return ""
+	}
+	source := "$(workspaces." + WorkspaceSource + ".path)/source/"
+	ret := `WRAPPER_JAR="` + source + wrapper.jar + `"
+if [ ! -f "$WRAPPER_JAR" ]; then
+    echo "Build tool wrapper jar $WRAPPER_JAR not found" >&2
+    exit 1
+fi
+`
+	if recipe.WrapperChecksum != "" {
+		ret += `EXPECTED_WRAPPER_CHECKSUM=` + shellQuote(strings.ToLower(recipe.WrapperChecksum)) + `
+WRAPPER_CHECKSUM=$(sha256sum "$WRAPPER_JAR" | cut -d ' ' -f 1)
+if [ "$WRAPPER_CHECKSUM" != "$EXPECTED_WRAPPER_CHECKSUM" ]; then
+    echo "Build tool wrapper jar $WRAPPER_JAR has checksum $WRAPPER_CHECKSUM but $EXPECTED_WRAPPER_CHECKSUM was expected" >&2
+    exit 1
+fi
+`
+	} else if jbsConfig.Spec.BuildSettings.RequireWrapperChecksum {
+		ret += `echo "No checksum is configured for build tool wrapper jar $WRAPPER_JAR" >&2
+exit 1
+`
+	} else {
+		ret += `echo "WARNING: build tool wrapper jar $WRAPPER_JAR is not verified as no checksum is configured"
+`
+	}
+	ret += `WRAPPER_PROPERTIES="` + source + wrapper.properties + `"
+if ! grep -q '^distributionSha256Sum=' "$WRAPPER_PROPERTIES" 2>/dev/null; then
+`
+	if jbsConfig.Spec.BuildSettings.RequireWrapperChecksum {
+		ret += `    echo "No distributionSha256Sum is set in $WRAPPER_PROPERTIES so the build tool distribution can't be verified" >&2
+    exit 1
+`
+	} else {
+		ret += `    echo "WARNING: the build tool distribution is not verified as no distributionSha256Sum is set in $WRAPPER_PROPERTIES"
+`
+	}
+	return ret + `fi
+` + wrapper.command + `() {
+    sh "` + source + wrapper.script + `" "$@"
+}
+`
+}
+
+// mavenSettingsScript returns the script writing the Maven settings.xml with any additional mirrors. These are listed
+// before the cache as Maven uses the first mirror matching a repository.
+func mavenSettingsScript(jbsConfig *v1alpha1.JBSConfig) string {
+	// The values are within both XML and an unquoted heredoc
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `\`, `\\`, "$", `\$`, "`", "\\`")
+	mirrors := ""
+	for _, i := range jbsConfig.Spec.MavenMirrors {
+		mirrors += `        <mirror>
+          <id>` + escape.Replace(i.Id) + `</id>
+          <url>` + escape.Replace(i.Url) + `</url>
+          <mirrorOf>` + escape.Replace(i.MirrorOf) + `</mirrorOf>
+        </mirror>
+`
+	}
+	settings := strings.ReplaceAll(mavenSettings, "{{MAVEN_MIRRORS}}\n", mirrors)
+	if len(mavenSettingsVariables(jbsConfig)) == 0 {
+		return settings
+	}
+	// The configured settings are only available within the cluster, so e.g. the diagnostic build still uses the
+	// generated settings
+	return `if [ -n "${` + MavenSettingsVariable + `:-}" ]; then
+    echo "Using the configured Maven settings"
+    printf '%s\n' "$` + MavenSettingsVariable + `" >"$(workspaces.build-settings.path)"/settings.xml
+else
+` + settings + `
+fi
+`
+}
+
+// mavenSettingsVariables returns the variable holding the configured Maven settings.xml, read from either a secret or
+// a config map.
+func mavenSettingsVariables(jbsConfig *v1alpha1.JBSConfig) []v1.EnvVar {
+	source := jbsConfig.Spec.BuildSettings.MavenSettings
+	key := settingOrDefault(source.Key, DefaultMavenSettingsKey)
+	if source.SecretName != "" {
+		return []v1.EnvVar{{Name: MavenSettingsVariable, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: source.SecretName}, Key: key}}}}
+	}
+	if source.ConfigMapName != "" {
+		return []v1.EnvVar{{Name: MavenSettingsVariable, ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: source.ConfigMapName}, Key: key}}}}
+	}
+	return nil
+}
+
+// preprocessorArgs returns the build request processor command that prepares the source for the given build tool.
+func preprocessorArgs(tool string, recipe *v1alpha1.BuildRecipe) []string {
+	command := "maven-prepare"
+	switch tool {
+	case "gradle", "sbt", "ant", "lein":
+		command = tool + "-prepare"
+	}
+	args := []string{
+		command,
+		"$(workspaces." + WorkspaceSource + ".path)/source",
+	}
+	for _, i := range recipe.DisabledPlugins {
+		args = append(args, "-dp "+i)
+	}
+	return args
+}
+
+// preBuildTools identifies the tools and platform the pre-build image was prepared for, as a pre-build image can only
+// be reused by a recipe with the same tools on the same platform.
+func preBuildTools(recipe *v1alpha1.BuildRecipe) string {
+	tools := recipe.Tool
+	for _, i := range recipe.AdditionalTools {
+		tools += "+" + i.Tool
+	}
+	if recipe.Architecture != "" {
+		tools += "@" + recipe.Architecture
+	}
+	return tools
+}
+
+// platformOption returns the FROM option selecting the platform of the builder image in the generated Containerfiles.
+func platformOption(recipe *v1alpha1.BuildRecipe) string {
+	if recipe.Architecture == "" {
+		return ""
+	}
+	return "--platform=" + recipe.Architecture + " "
+}
+
+// isToolLocation returns true if the environment variable is the location of a tool or the JDK.
+func isToolLocation(env v1.EnvVar) bool {
+	return strings.HasSuffix(env.Name, "_HOME") || env.Name == "SBT_DIST"
+}
+
+// toolVersionsScript records the tool locations, which include their versions, and the tool version the build step
+// runs with in the TOOL_VERSIONS result.
+func toolVersionsScript(toolEnv []v1.EnvVar) string {
+	versions := []string{}
+	for _, i := range toolEnv {
+		if isToolLocation(i) || i.Name == PipelineParamToolVersion {
+			versions = append(versions, i.Name+"=$"+i.Name)
+		}
+	}
+	return "echo -n \"" + strings.Join(versions, " ") + "\" > $(results." + PipelineResultToolVersions + ".path)\n"
+}
+
+// preprocessorJavaHomeVariables overrides the JAVA_HOME of the preprocessor step if the recipe needs a different JDK
+// from the one bundled with the build request processor. The keystore script also installs the CAs into this JDK.
+func preprocessorJavaHomeVariables(recipe *v1alpha1.BuildRecipe) []v1.EnvVar {
+	if recipe.PreprocessorJavaHome == "" {
+		return nil
+	}
+	return []v1.EnvVar{{Name: "JAVA_HOME", Value: recipe.PreprocessorJavaHome}}
+}
+
+// gracefulTerminationScript runs the build command so that it can be terminated gracefully if this is configured. The
+// build runs in its own process group which is sent SIGTERM when the step is, or the grace period before the build
+// timeout, and is killed if it hasn't finished by the end of the grace period. The logs and a list of the partial
+// artifacts are then flushed to the workspace before the step fails. The build timeout is the timeout of the whole
+// pipeline so it is counted from the start time of the pipeline run, or the start of the step if that is not known.
+func gracefulTerminationScript(jbsConfig *v1alpha1.JBSConfig, command string) string {
+	grace := jbsConfig.Spec.BuildSettings.GracefulTerminationSeconds
+	if grace <= 0 {
+		return command
+	}
+	source := "$(workspaces." + WorkspaceSource + ".path)"
+	ret := `set -m
+JBS_BUILD_PID=""
+JBS_WATCHDOG_PID=""
+jbs_terminate() {
+    trap - TERM
+    echo "Build terminating, waiting up to ` + strconv.Itoa(grace) + ` seconds for it to stop"
+    kill -TERM -- "-$JBS_BUILD_PID" 2>/dev/null || true
+    for _ in $(seq ` + strconv.Itoa(grace) + `); do
+        kill -0 "$JBS_BUILD_PID" 2>/dev/null || break
+        sleep 1
+    done
+    kill -KILL -- "-$JBS_BUILD_PID" 2>/dev/null || true
+    if [ -n "$JBS_WATCHDOG_PID" ]; then
+        kill -- "-$JBS_WATCHDOG_PID" 2>/dev/null || true
+    fi
+    mkdir -p ` + source + `/logs
+    find ` + source + `/artifacts -type f > ` + source + `/logs/partial-artifacts.txt 2>/dev/null || true
+    sync
+    exit 143
+}
+trap jbs_terminate TERM
+`
+	if watchdog := int(buildTimeout(jbsConfig).Seconds()) - grace; watchdog > 0 {
+		ret += `JBS_NOW=$(date +%s)
+JBS_START_TIME="$(params.` + PipelineParamStartTime + `)"
+JBS_WATCHDOG=$((${JBS_START_TIME:-$JBS_NOW} + ` + strconv.Itoa(watchdog) + ` - JBS_NOW))
+if [ "$JBS_WATCHDOG" -lt 0 ]; then
+    JBS_WATCHDOG=0
+fi
+(sleep "$JBS_WATCHDOG" && kill -TERM $$) &
+JBS_WATCHDOG_PID=$!
+disown "$JBS_WATCHDOG_PID"
+`
+	}
+	ret += command + ` &
+JBS_BUILD_PID=$!
+JBS_BUILD_STATUS=0
+wait "$JBS_BUILD_PID" || JBS_BUILD_STATUS=$?
+if [ -n "$JBS_WATCHDOG_PID" ]; then
+    kill -- "-$JBS_WATCHDOG_PID" 2>/dev/null || true
+fi
+exit $JBS_BUILD_STATUS`
+	return ret
+}
+
+// gracefulTerminationShebang runs the build step with bash if the build is terminated gracefully, as the wrapper from
+// gracefulTerminationScript relies on bash builtins such as disown. Tekton would otherwise run the step with the sh of
+// the builder image, which may be dash or busybox. The set -e that Tekton adds by default is kept.
+func gracefulTerminationShebang(jbsConfig *v1alpha1.JBSConfig) string {
+	if jbsConfig.Spec.BuildSettings.GracefulTerminationSeconds <= 0 {
+		return ""
+	}
+	return "#!/bin/bash\nset -e\n"
+}
+
+// resolveToolSymlinksScript replaces the tool locations with their canonical paths if this is enabled, as some tools
+// are confused when their home is a symlink. The locations are only known to be symlinks within the image so this has
+// to happen when the build runs.
+func resolveToolSymlinksScript(jbsConfig *v1alpha1.JBSConfig, toolEnv []v1.EnvVar) string {
+	if !jbsConfig.Spec.BuildSettings.ResolveToolSymlinks {
+		return ""
+	}
+	ret := ""
+	for _, i := range toolEnv {
+		if isToolLocation(i) {
+			ret += "export " + i.Name + "=\"$(readlink -f \"$" + i.Name + "\")\"\n"
+		}
+	}
+	return ret
+}
+
+// buildRetries returns the number of times the build task is retried, limited to MaxBuildRetries.
+func buildRetries(recipe *v1alpha1.BuildRecipe) int {
+	if recipe.Retries > MaxBuildRetries {
+		return MaxBuildRetries
+	}
+	if recipe.Retries < 0 {
+		return 0
+	}
+	return recipe.Retries
+}
+
+// javaHome returns the location of the JDK version within the builder image, either from the recipe template or the
+// standard /lib/jvm layout.
+func javaHome(recipe *v1alpha1.BuildRecipe, version string) string {
+	if recipe.JavaHomeTemplate != "" {
+		return strings.ReplaceAll(recipe.JavaHomeTemplate, "{VERSION}", version)
+	}
+	if version == "7" || version == "8" {
+		return "/lib/jvm/java-1." + version + ".0"
+	}
+	return "/lib/jvm/java-" + version
+}
+
+// jarValidationArgs returns the command that checks the built jars against the configured structural rules, or nil if
+// no rules are configured.
+func jarValidationArgs(jbsConfig *v1alpha1.JBSConfig) []string {
+	rules := []string{}
+	if jbsConfig.Spec.JarValidation.RequireMultiRelease {
+		rules = append(rules, "--require-multi-release")
+	}
+	if jbsConfig.Spec.JarValidation.RequireModuleInfo {
+		rules = append(rules, "--require-module-info")
+	}
+	for _, i := range jbsConfig.Spec.JarValidation.RequiredManifestAttributes {
+		rules = append(rules, "--require-manifest-attribute="+i)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return append([]string{"validate-jars", "--path=$(workspaces.source.path)/artifacts"}, rules...)
+}
+
+// normalizeTimestampsArgs returns the command that normalizes the timestamps in the built archives to the commit time,
+// or nil if they are left as built.
+func normalizeTimestampsArgs(jbsConfig *v1alpha1.JBSConfig, commitTime int64) []string {
+	if !jbsConfig.Spec.BuildSettings.NormalizeTimestamps {
+		return nil
+	}
+	return []string{"normalize-timestamps", "--path=$(workspaces.source.path)/artifacts", "--timestamp=" + strconv.FormatInt(commitTime, 10)}
+}
+
+// testArtifactArgs returns the option that excludes the built test jars from the verified, copied and deployed
+// artifacts, if they are excluded.
+func testArtifactArgs(jbsConfig *v1alpha1.JBSConfig) []string {
+	if !jbsConfig.Spec.BuildSettings.ExcludeTestArtifacts {
+		return nil
+	}
+	return []string{"--exclude-test-artifacts"}
+}
+
+// isVersionRange returns true if the enforced version is a Maven style version range rather than just enabling
+// version enforcement.
+func isVersionRange(version string) bool {
+	return strings.HasPrefix(version, "[") || strings.HasPrefix(version, "(")
+}
+
+// versionRange is a single restriction of a Maven style version range. An empty bound is unbounded.
+type versionRange struct {
+	lower          string
+	lowerInclusive bool
+	upper          string
+	upperInclusive bool
+}
+
+// parseVersionRange parses a Maven style version range such as [1.0,2.0), (,1.0],[1.2,) or [1.5]. Multiple
+// restrictions match if any of them match.
+func parseVersionRange(spec string) ([]versionRange, error) {
+	var ret []versionRange
+	remaining := strings.TrimSpace(spec)
+	for remaining != "" {
+		end := strings.IndexAny(remaining, "])")
+		if (remaining[0] != '[' && remaining[0] != '(') || end == -1 {
+			return nil, fmt.Errorf("invalid version range %#v", spec)
+		}
+		bounds := strings.Split(remaining[1:end], ",")
+		restriction := versionRange{lower: strings.TrimSpace(bounds[0]), lowerInclusive: remaining[0] == '[', upperInclusive: remaining[end] == ']'}
+		switch len(bounds) {
+		case 1:
+			// [1.5] only matches 1.5
+			if restriction.lower == "" || !restriction.lowerInclusive || !restriction.upperInclusive {
+				return nil, fmt.Errorf("invalid version range %#v", spec)
+			}
+			restriction.upper = restriction.lower
+		case 2:
+			restriction.upper = strings.TrimSpace(bounds[1])
+		default:
+			return nil, fmt.Errorf("invalid version range %#v", spec)
+		}
+		ret = append(ret, restriction)
+		remaining = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(remaining[end+1:]), ","))
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("invalid version range %#v", spec)
+	}
+	return ret, nil
+}
+
+// contains returns true if the version is within the restriction.
+func (v versionRange) contains(version string) bool {
+	if v.lower != "" {
+		c := compareVersions(version, v.lower)
+		if c < 0 || (c == 0 && !v.lowerInclusive) {
+			return false
+		}
+	}
+	if v.upper != "" {
+		c := compareVersions(version, v.upper)
+		if c > 0 || (c == 0 && !v.upperInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVersions compares two versions in a similar way to Maven. Numeric parts are compared numerically, qualifiers
+// are compared alphabetically and sort before numbers, so 1.0-beta1 comes before 1.0 and 1.0.1. Digits following a
+// qualifier are a separate part so 1.0-alpha10 comes after 1.0-alpha9, and the final, ga and release qualifiers are
+// the same as a missing part so 1.0.0.Final is the same as 1.0.
+func compareVersions(a string, b string) int {
+	ap := versionPartRegex.FindAllString(strings.ToLower(a), -1)
+	bp := versionPartRegex.FindAllString(strings.ToLower(b), -1)
+	for i := 0; i < len(ap) || i < len(bp); i++ {
+		// Missing parts are treated as 0, so 1.0 is the same as 1.0.0
+		as, bs := "0", "0"
+		if i < len(ap) && !finalVersionQualifiers[ap[i]] {
+			as = ap[i]
+		}
+		if i < len(bp) && !finalVersionQualifiers[bp[i]] {
+			bs = bp[i]
+		}
+		an, aErr := strconv.ParseUint(as, 10, 64)
+		bn, bErr := strconv.ParseUint(bs, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return 1
+		case bErr == nil:
+			return -1
+		default:
+			if c := strings.Compare(as, bs); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// enforcedVersion returns the value of the ENFORCE_VERSION parameter. If the recipe enforces the version for a version
+// range then it is empty, so the version is not enforced, unless the version being built is in the range.
+func enforcedVersion(db *v1alpha1.DependencyBuild, recipe *v1alpha1.BuildRecipe) string {
+	if !isVersionRange(recipe.EnforceVersion) {
+		return recipe.EnforceVersion
+	}
+	restrictions, err := parseVersionRange(recipe.EnforceVersion)
+	if err != nil {
+		return ""
+	}
+	for _, i := range restrictions {
+		if i.contains(db.Spec.Version) {
+			return recipe.EnforceVersion
+		}
+	}
+	return ""
+}
+
+// allowedContaminantArgs returns the groupId:artifactId[:version] coordinates (which may contain * wildcards) that the
+// verify command should not treat as contaminants.
+func allowedContaminantArgs(recipe *v1alpha1.BuildRecipe) []string {
+	ret := []string{}
+	for _, i := range recipe.AllowedContaminants {
+		ret = append(ret, "--allowed-contaminant="+i)
+	}
+	return ret
+}
+
+// goalVariables are the variables recipes may reference in their goals, e.g. '-Pversion=$(PROJECT_VERSION)'. In the
+// container Kubernetes expands them from the environment of the build step.
+var goalVariables = []string{PipelineParamProjectVersion, PipelineParamToolVersion}
+
 func extractArrayParam(key string, paramValues []tektonpipeline.Param) string {
 	// Within the recipe parameters its possible variables are used as '-Pversion=$(PROJECT_VERSION)'.
-	// However, this only works in the container and not within the diagnostic container files.
+	// The version variables are substituted with the values the container would see, any others are turned into
+	// shell variables.
+	replacements := []string{}
+	for _, i := range paramValues {
+		for _, variable := range goalVariables {
+			if i.Name == variable && i.Value.Type == tektonpipeline.ParamTypeString {
+				replacements = append(replacements, "$("+variable+")", i.Value.StringVal)
+			}
+		}
+	}
+	replacer := strings.NewReplacer(replacements...)
 	re := regexp.MustCompile("[(]|[)]")
 	result := ""
 	for _, i := range paramValues {
 		if i.Name == key {
 			for _, j := range i.Value.ArrayVal {
-				result += re.ReplaceAllString(j, "") + " "
+				result += re.ReplaceAllString(replacer.Replace(j), "") + " "
 			}
 		}
 	}
@@ -873,24 +2849,136 @@ func extractArrayParam(key string, paramValues []tektonpipeline.Param) string {
 func extractEnvVar(envVar []v1.EnvVar) string {
 	result := ""
 	for _, i := range envVar {
-		result += "export " + i.Name + "=" + i.Value + "\n"
+		value := i.Value
+		if !plainShellValueRegex.MatchString(value) {
+			value = shellQuote(value)
+		}
+		result += "export " + i.Name + "=" + value + "\n"
 	}
 	return result
 }
 
-func doSubstitution(script string, paramValues []tektonpipeline.Param, commitTime int64, buildRepos string) string {
+// extraEnv returns the extra environment variables of the recipe sorted by name. Variables that are already managed
+// are left out as the managed value takes precedence.
+func extraEnv(log logr.Logger, recipe *v1alpha1.BuildRecipe, managed []v1.EnvVar) []v1.EnvVar {
+	managedNames := map[string]bool{}
+	for _, i := range managed {
+		managedNames[i.Name] = true
+	}
+	names := []string{}
+	for name := range recipe.ExtraEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := []v1.EnvVar{}
+	for _, name := range names {
+		if managedNames[name] {
+			log.Info(fmt.Sprintf("extraEnv %s is managed by the build service and is ignored", name))
+			continue
+		}
+		ret = append(ret, v1.EnvVar{Name: name, Value: recipe.ExtraEnv[name]})
+	}
+	return ret
+}
+
+// validateDeployMode checks the deploy mode is known, as any other mode (e.g. OCI rather than oci) would otherwise
+// silently deploy to the Maven repositories.
+func validateDeployMode(jbsConfig *v1alpha1.JBSConfig) error {
+	switch jbsConfig.Spec.MavenDeployment.Mode {
+	case "", v1alpha1.DeployModeMaven, v1alpha1.DeployModeOCI:
+		return nil
+	}
+	return fmt.Errorf("unsupported deploy mode %#v", jbsConfig.Spec.MavenDeployment.Mode)
+}
+
+// pipelineParamSpecs are the parameters of the build pipeline that are substituted into the generated scripts
+func pipelineParamSpecs(cacheUrl string, goals []string) []tektonpipeline.ParamSpec {
+	if goals == nil {
+		goals = []string{}
+	}
+	return []tektonpipeline.ParamSpec{
+		{Name: PipelineBuildId, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamScmUrl, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamScmTag, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamScmHash, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamChainsGitUrl, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamChainsGitCommit, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamGoals, Type: tektonpipeline.ParamTypeArray, Default: &tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: goals}},
+		{Name: PipelineParamJavaVersion, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamToolVersion, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamPath, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamEnforceVersion, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamProjectVersion, Type: tektonpipeline.ParamTypeString},
+		{Name: PipelineParamCacheUrl, Type: tektonpipeline.ParamTypeString, Default: &tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: cacheUrl}},
+		{Name: PipelineParamStartTime, Type: tektonpipeline.ParamTypeString, Default: &tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString}},
+	}
+}
+
+// withDefaultGoals adds the default goals if the parameters have no goals. Tekton uses the default of the parameter
+// itself, this is for the scripts the parameters are substituted into.
+func withDefaultGoals(paramValues []tektonpipeline.Param, goals []string) []tektonpipeline.Param {
+	ret := []tektonpipeline.Param{}
+	for _, i := range paramValues {
+		if i.Name != PipelineParamGoals {
+			ret = append(ret, i)
+		} else if len(i.Value.ArrayVal) > 0 {
+			goals = i.Value.ArrayVal
+		}
+	}
+	return append(ret, tektonpipeline.Param{Name: PipelineParamGoals, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: goals}})
+}
+
+// validateParamTypes checks the parameters have the type of their declaration, as a parameter of the wrong type would
+// otherwise not be substituted (e.g. CACHE_URL would silently fall back to the default).
+func validateParamTypes(paramSpecs []tektonpipeline.ParamSpec, paramValues []tektonpipeline.Param) error {
+	for _, i := range paramValues {
+		for _, spec := range paramSpecs {
+			if spec.Name == i.Name && i.Value.Type != spec.Type {
+				return fmt.Errorf("parameter %s has type %#v but %#v was expected", i.Name, i.Value.Type, spec.Type)
+			}
+		}
+	}
+	return nil
+}
+
+func doSubstitution(script string, paramValues []tektonpipeline.Param, commitTime int64, buildRepos string, projectPath string) string {
 	for _, i := range paramValues {
 		if i.Value.Type == tektonpipeline.ParamTypeString {
 			script = strings.ReplaceAll(script, "$(params."+i.Name+")", i.Value.StringVal)
 		}
 	}
+	// Indexed array references are expanded as Tekton does. Out of range indices are left as is.
+	script = arrayParamIndexRegex.ReplaceAllStringFunc(script, func(match string) string {
+		groups := arrayParamIndexRegex.FindStringSubmatch(match)
+		index, err := strconv.Atoi(groups[2])
+		if err != nil {
+			return match
+		}
+		for _, i := range paramValues {
+			if i.Name == groups[1] && i.Value.Type == tektonpipeline.ParamTypeArray && index < len(i.Value.ArrayVal) {
+				return i.Value.ArrayVal[index]
+			}
+		}
+		return match
+	})
 	script = strings.ReplaceAll(script, "$(params.CACHE_URL)", "http://localhost:8080/v2/cache/rebuild"+buildRepos+"/"+strconv.FormatInt(commitTime, 10)+"/")
 	script = strings.ReplaceAll(script, "$(workspaces.build-settings.path)", "/root/software/settings")
-	script = strings.ReplaceAll(script, "$(workspaces.source.path)", "/root/project")
-	script = strings.ReplaceAll(script, "$(workspaces.tls.path)", "/root/project/tls/service-ca.crt")
+	script = strings.ReplaceAll(script, "$(workspaces.source.path)", projectPath)
+	script = strings.ReplaceAll(script, "$(workspaces.tls.path)", projectPath+"/tls/service-ca.crt")
+	// Tekton runtime context variables do not resolve in the diagnostic scripts so use a placeholder instead.
+	script = contextVariableRegex.ReplaceAllString(script, DiagnosticContextPlaceholder)
 	return script
 }
 
+// buildTimeout returns the configured timeout for the build and deploy pipelines. Zero or negative values fall back to
+// the default rather than producing a Tekton timeout of 0 (i.e. no timeout).
+func buildTimeout(jbsConfig *v1alpha1.JBSConfig) time.Duration {
+	if jbsConfig.Spec.BuildSettings.BuildTimeoutHours > 0 {
+		return time.Hour * time.Duration(jbsConfig.Spec.BuildSettings.BuildTimeoutHours)
+	}
+	return time.Hour * v1alpha1.DefaultTimeout
+}
+
 func settingOrDefault(setting, def string) string {
 	if len(strings.TrimSpace(setting)) == 0 {
 		return def
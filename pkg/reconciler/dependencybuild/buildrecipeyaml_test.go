@@ -1,9 +1,20 @@
 package dependencybuild
 
 import (
+	"encoding/base64"
+	"github.com/go-logr/logr"
 	. "github.com/onsi/gomega"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/apis/jvmbuildservice/v1alpha1"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/jbsconfig"
 	tektonpipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	v1 "k8s.io/api/core/v1"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestImageRegistryArrayToString(t *testing.T) {
@@ -36,4 +47,2798 @@ func TestExtractArrayParam(t *testing.T) {
 	}
 	result := extractArrayParam(PipelineParamGoals, paramValues)
 	g.Expect(result).Should(Equal("Foo -Pversion=$PROJECT_VERSION "))
+
+	// The version variables are substituted with the values the build step environment has
+	goals = append(goals, "-Dmaven.version=$(TOOL_VERSION)", "-Dhome=$(HOME)")
+	paramValues = []tektonpipeline.Param{
+		{Name: PipelineParamGoals, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: goals}},
+		{Name: PipelineParamProjectVersion, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: "1.2.3"}},
+		{Name: PipelineParamToolVersion, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: "3.8.8"}},
+	}
+	result = extractArrayParam(PipelineParamGoals, paramValues)
+	g.Expect(result).Should(Equal("Foo -Pversion=1.2.3 -Dmaven.version=3.8.8 -Dhome=$HOME "))
+
+	// The diagnostic script matches the goals the build step runs
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersion: "3.8.8", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}, CommandLine: goals}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	db.Spec.Version = "1.2.3"
+	ps, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, db, buildPipelineParams(logr.Discard(), db, &v1alpha1.BuildAttempt{Recipe: recipe}), "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(konfluxScript).Should(ContainSubstring("set -- \"$@\" Foo -Pversion=1.2.3 -Dmaven.version=3.8.8 -Dhome=$HOME "))
+	buildTask := ps.Tasks[len(ps.Tasks)-1].TaskSpec
+	g.Expect(buildTask.Steps).Should(ContainElement(And(HaveField("Name", BuildTaskName), HaveField("Env", ContainElements(v1.EnvVar{Name: PipelineParamProjectVersion, Value: "1.2.3"}, v1.EnvVar{Name: PipelineParamToolVersion, Value: "3.8.8"})))))
+}
+
+func TestDoSubstitutionContextVariables(t *testing.T) {
+	g := NewGomegaWithT(t)
+	args := strings.Join(verifyParameters(&v1alpha1.JBSConfig{}, &v1alpha1.DependencyBuild{}, &v1alpha1.BuildRecipe{}), " ")
+	g.Expect(args).Should(ContainSubstring("$(context.taskRun.name)"))
+	result := doSubstitution(args, []tektonpipeline.Param{}, 0, "", DiagnosticProjectPath)
+	g.Expect(result).ShouldNot(ContainSubstring("$(context."))
+	g.Expect(result).Should(ContainSubstring("--task-run-name=" + DiagnosticContextPlaceholder))
+	g.Expect(result).Should(ContainSubstring("--deploy-path=/root/project/artifacts"))
+
+	result = doSubstitution("$(context.pipelineRun.name) $(context.taskRun.uid) $(params.GOALS)", []tektonpipeline.Param{}, 0, "", DiagnosticProjectPath)
+	g.Expect(result).Should(Equal(DiagnosticContextPlaceholder + " " + DiagnosticContextPlaceholder + " $(params.GOALS)"))
+}
+
+func TestDeployConsistentSnapshotTimestamp(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig := &v1alpha1.JBSConfig{Spec: v1alpha1.JBSConfigSpec{MavenDeployment: v1alpha1.MavenDeployment{Repository: "https://repo.example.com"}}}
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).ShouldNot(ContainElement("--consistent-snapshot-timestamp"))
+	jbsConfig.Spec.MavenDeployment.ConsistentSnapshotTimestamp = true
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).Should(ContainElement("--consistent-snapshot-timestamp"))
+}
+
+func TestDeployPartitionByGroupId(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig := &v1alpha1.JBSConfig{Spec: v1alpha1.JBSConfigSpec{MavenDeployment: v1alpha1.MavenDeployment{Repository: "https://repo.example.com"}}}
+	// A single deploy is the default
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).ShouldNot(ContainElement("--partition-by-group-id"))
+	jbsConfig.Spec.MavenDeployment.PartitionByGroupId = true
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).Should(ContainElement("--partition-by-group-id"))
+}
+
+func TestDeployRegenerateMetadata(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig := &v1alpha1.JBSConfig{Spec: v1alpha1.JBSConfigSpec{MavenDeployment: v1alpha1.MavenDeployment{Repository: "https://repo.example.com"}}}
+	// The metadata generated by the deploy is used as is by default
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).ShouldNot(ContainElement("--regenerate-metadata"))
+	jbsConfig.Spec.MavenDeployment.RegenerateMetadata = true
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).Should(ContainElement("--regenerate-metadata"))
+}
+
+func TestDeployMavenRepositoryPathPrefix(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.Repository = "https://nexus.example.com/nexus/repository/releases/"
+	jbsConfig.Spec.MavenDeployment.Repositories = []v1alpha1.MavenRepository{
+		{URL: "https://artifactory.example.com//artifactory/libs-snapshot-local"},
+		{URL: "http://repo.example.com:8081/maven2"},
+	}
+	// The path prefixes are kept, only the repeated and trailing slashes are removed
+	g.Expect(pipelineDeployCommands(jbsConfig, db)).Should(ContainElements(
+		"--mvn-repo=https://nexus.example.com/nexus/repository/releases",
+		"--mvn-repo=https://artifactory.example.com/artifactory/libs-snapshot-local",
+		"--mvn-repo=http://repo.example.com:8081/maven2"))
+}
+
+func TestDeployMavenRepositories(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig := &v1alpha1.JBSConfig{}
+	repoArgs := func() []string {
+		ret := []string{}
+		for _, arg := range pipelineDeployCommands(jbsConfig, db) {
+			if strings.HasPrefix(arg, "--mvn-") {
+				ret = append(ret, arg)
+			}
+		}
+		return ret
+	}
+	envNames := func() []string {
+		ret := []string{}
+		for _, env := range secretVariables(jbsConfig) {
+			ret = append(ret, env.Name)
+		}
+		return ret
+	}
+
+	// No repositories
+	g.Expect(repoArgs()).Should(BeEmpty())
+	g.Expect(envNames()).ShouldNot(ContainElement("MAVEN_PASSWORD"))
+
+	// A single repository is unchanged
+	jbsConfig.Spec.MavenDeployment.Repository = "https://repo.example.com/releases"
+	jbsConfig.Spec.MavenDeployment.Username = "deployer"
+	g.Expect(repoArgs()).Should(Equal([]string{"--mvn-repo=https://repo.example.com/releases", "--mvn-username=deployer"}))
+	g.Expect(envNames()).Should(ContainElement("MAVEN_PASSWORD"))
+
+	// Many repositories share the password unless they have their own
+	jbsConfig.Spec.MavenDeployment.Repositories = []v1alpha1.MavenRepository{
+		{URL: "https://repo.example.com/snapshots"},
+		{URL: ""},
+		{URL: "https://other.example.com/maven", Username: "other", SecretName: "other-maven-secret"},
+	}
+	g.Expect(repoArgs()).Should(Equal([]string{
+		"--mvn-repo=https://repo.example.com/releases",
+		"--mvn-repo=https://repo.example.com/snapshots",
+		"--mvn-repo=https://other.example.com/maven",
+		"--mvn-repo-username=2=other",
+		"--mvn-username=deployer",
+	}))
+	env := secretVariables(jbsConfig)
+	g.Expect(envNames()).Should(ContainElements("MAVEN_PASSWORD", "MAVEN_PASSWORD_2"))
+	g.Expect(envNames()).ShouldNot(ContainElements("MAVEN_PASSWORD_0", "MAVEN_PASSWORD_1"))
+	for _, e := range env {
+		if e.Name == "MAVEN_PASSWORD_2" {
+			g.Expect(e.ValueFrom.SecretKeyRef.Name).Should(Equal("other-maven-secret"))
+			g.Expect(e.ValueFrom.SecretKeyRef.Key).Should(Equal(v1alpha1.MavenSecretKey))
+		}
+	}
+
+	// Additional repositories may be used without a primary one
+	jbsConfig.Spec.MavenDeployment.Repository = ""
+	jbsConfig.Spec.MavenDeployment.Repositories = []v1alpha1.MavenRepository{{URL: "https://repo.example.com/snapshots"}}
+	g.Expect(repoArgs()).Should(Equal([]string{"--mvn-repo=https://repo.example.com/snapshots", "--mvn-username=deployer"}))
+	g.Expect(envNames()).Should(ContainElement("MAVEN_PASSWORD"))
+}
+
+func TestDeployGCSCredentials(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.Repository = "s3://bucket/maven"
+	credentials := v1.EnvVar{Name: "GOOGLE_APPLICATION_CREDENTIALS", Value: GCSCredentialsPath + "/" + v1alpha1.GCSCredentialsKey}
+	deployStep := func() (*tektonpipeline.TaskSpec, *tektonpipeline.Step) {
+		ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		task := &ps.Tasks[0].TaskSpec.TaskSpec
+		for i := range task.Steps {
+			if task.Steps[i].Name == "maven-deployment" {
+				return task, &task.Steps[i]
+			}
+		}
+		return task, nil
+	}
+
+	// AWS handling is unchanged and no GCS credentials are added for other repositories
+	g.Expect(secretVariables(jbsConfig)).Should(ContainElement(HaveField("Name", "AWS_ACCESS_KEY_ID")))
+	g.Expect(secretVariables(jbsConfig)).ShouldNot(ContainElement(credentials))
+	task, step := deployStep()
+	g.Expect(task.Volumes).Should(BeEmpty())
+	g.Expect(step.VolumeMounts).Should(BeEmpty())
+
+	jbsConfig.Spec.MavenDeployment.Repositories = []v1alpha1.MavenRepository{{URL: "gs://bucket/maven"}}
+	g.Expect(secretVariables(jbsConfig)).Should(ContainElement(credentials))
+	g.Expect(secretVariables(jbsConfig)).Should(ContainElement(HaveField("Name", "AWS_ACCESS_KEY_ID")))
+	task, step = deployStep()
+	g.Expect(task.Volumes).Should(HaveLen(1))
+	g.Expect(task.Volumes[0].Secret.SecretName).Should(Equal(v1alpha1.GCSSecretName))
+	g.Expect(step.VolumeMounts).Should(Equal([]v1.VolumeMount{{Name: task.Volumes[0].Name, MountPath: GCSCredentialsPath, ReadOnly: true}}))
+	g.Expect(step.Env).Should(ContainElement(credentials))
+}
+
+func TestInfrastructureImageMirrors(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	original := strings.TrimSpace(strings.Split(buildTrustedArtifacts, "FROM")[1])
+	g.Expect(original).Should(HavePrefix("quay.io/redhat-appstudio/build-trusted-artifacts:"))
+	g.Expect(trustedArtifactsImage(jbsConfig)).Should(Equal(original))
+
+	jbsConfig.Spec.ImageMirrors = []v1alpha1.ImageMirror{
+		// Only whole path components match
+		{Source: "quay.io/redhat", Mirror: "wrong.example.com"},
+		{Source: "quay.io/redhat-appstudio/", Mirror: "mirror.example.com:5000/jbs/"},
+		{Source: "quay.io", Mirror: "other.example.com"},
+	}
+	mirrored := trustedArtifactsImage(jbsConfig)
+	g.Expect(mirrored).Should(Equal("mirror.example.com:5000/jbs" + strings.TrimPrefix(original, "quay.io/redhat-appstudio")))
+	g.Expect(jbsConfig.MirroredImage("quay.io/foo/bar@sha256:1234")).Should(Equal("other.example.com/foo/bar@sha256:1234"))
+	g.Expect(jbsConfig.MirroredImage("registry.example.com/foo/bar:1.0")).Should(Equal("registry.example.com/foo/bar:1.0"))
+
+	// All trusted artifacts steps use the mirror
+	db := &v1alpha1.DependencyBuild{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	deploy, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	count := 0
+	for _, task := range append(ps.Tasks, deploy.Tasks...) {
+		for _, step := range task.TaskSpec.Steps {
+			g.Expect(step.Image).ShouldNot(Equal(original))
+			if step.Image == mirrored {
+				count++
+			}
+		}
+	}
+	g.Expect(count).Should(BeNumerically(">", 0))
+}
+
+func TestMavenAlsoMakeArgs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{}
+	g.Expect(mavenAlsoMakeArgs("maven", recipe, "core")).Should(BeEmpty())
+
+	recipe.AlsoMake = true
+	g.Expect(mavenAlsoMakeArgs("maven", recipe, "")).Should(BeEmpty())
+	g.Expect(mavenAlsoMakeArgs("gradle", recipe, "core")).Should(BeEmpty())
+	g.Expect(mavenAlsoMakeArgs("maven", recipe, "core")).Should(Equal([]string{"-f", "$(workspaces.source.path)/source/pom.xml", "-pl", "core", "-am"}))
+
+	recipe.AlsoMakeDependents = true
+	g.Expect(mavenAlsoMakeArgs("maven", recipe, "core")).Should(Equal([]string{"-f", "$(workspaces.source.path)/source/pom.xml", "-pl", "core", "-am", "-amd"}))
+
+	recipe.AlsoMake = false
+	g.Expect(mavenAlsoMakeArgs("maven", recipe, "core")).Should(Equal([]string{"-f", "$(workspaces.source.path)/source/pom.xml", "-pl", "core", "-amd"}))
+}
+
+func TestAdditionalCPULimitedToSystemMax(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	systemConfig := &v1alpha1.SystemConfig{Spec: v1alpha1.SystemConfigSpec{MaxAdditionalCPU: 1000}}
+	recipe := newTestRecipe()
+	recipe.AdditionalCPU = 5000
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, systemConfig, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).Should(BeNil())
+	build := stepNamed(ps.Tasks[len(ps.Tasks)-1], BuildTaskName)
+	g.Expect(build).ShouldNot(BeNil())
+	g.Expect(build.ComputeResources.Requests.Cpu().String()).Should(Equal("1300m"))
+	g.Expect(build.ComputeResources.Limits.Cpu().String()).Should(Equal("3"))
+}
+
+func TestBuildTimeout(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	g.Expect(buildTimeout(jbsConfig)).Should(Equal(time.Hour * v1alpha1.DefaultTimeout))
+	jbsConfig.Spec.BuildSettings.BuildTimeoutHours = -1
+	g.Expect(buildTimeout(jbsConfig)).Should(Equal(time.Hour * v1alpha1.DefaultTimeout))
+	jbsConfig.Spec.BuildSettings.BuildTimeoutHours = 12
+	g.Expect(buildTimeout(jbsConfig)).Should(Equal(time.Hour * 12))
+}
+
+func TestSourceSizeCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db := &v1alpha1.DependencyBuild{}
+	g.Expect(sourceSizeCheck(jbsConfig)).Should(BeEmpty())
+	preBuildImageArgs, _, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).ShouldNot(ContainSubstring("SOURCE_SIZE"))
+
+	jbsConfig.Spec.BuildSettings.MaxSourceSizeMB = 2048
+	check := sourceSizeCheck(jbsConfig)
+	g.Expect(check).Should(ContainSubstring("du -sm $(workspaces.source.path)/source"))
+	g.Expect(check).Should(ContainSubstring("-gt 2048"))
+	g.Expect(check).Should(ContainSubstring("exit 1"))
+	preBuildImageArgs, _, _, _, _ = pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring(check + "create-archive"))
+}
+
+func TestQuietBuildOutput(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	buildScript := func() string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range ps.Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				if strings.Contains(step.Script, "/build.sh <<'RHTAPEOF'") {
+					return step.Script
+				}
+			}
+		}
+		return ""
+	}
+	g.Expect(quietBuildOutputScript(jbsConfig, "mvn install")).Should(Equal("mvn install"))
+	g.Expect(buildScript()).ShouldNot(ContainSubstring(QuietBuildOutputLog))
+
+	jbsConfig.Spec.BuildSettings.QuietBuildOutput = true
+	script := quietBuildOutputScript(jbsConfig, "mvn install")
+	g.Expect(script).Should(ContainSubstring("set +e\n(\nset -e\nmvn install\n) > " + QuietBuildOutputLog + " 2>&1\nBUILD_STATUS=$?\nset -e\n"))
+	// The buffered output is only shown on failure, with the exit code of the build kept
+	g.Expect(script).Should(ContainSubstring("if [ $BUILD_STATUS -ne 0 ]; then\n"))
+	g.Expect(script).Should(ContainSubstring("    cat " + QuietBuildOutputLog + " >&2\n    exit $BUILD_STATUS\nfi\n"))
+	g.Expect(script).Should(HaveSuffix("echo \"Build succeeded, the full build output is in " + QuietBuildOutputLog + "\""))
+
+	// The whole build tool section is wrapped, including any additional tools
+	recipe.AdditionalTools = []v1alpha1.AdditionalTool{{Tool: "ant", CommandLine: []string{"dist"}}}
+	build := buildScript()
+	g.Expect(strings.Count(build, QuietBuildOutputLog+" 2>&1")).Should(Equal(1))
+	start := strings.Index(build, "(\nset -e\n")
+	end := strings.Index(build, ") > "+QuietBuildOutputLog)
+	g.Expect(start).Should(BeNumerically(">", 0))
+	g.Expect(build[start:end]).Should(ContainSubstring("mvn -V -B -e"))
+	g.Expect(build[start:end]).Should(ContainSubstring("\nset -- 'dist'\n"))
+}
+
+func TestDiskMonitor(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	buildScript := func() string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range ps.Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				if strings.Contains(step.Script, "/build.sh <<'RHTAPEOF'") {
+					return step.Script
+				}
+			}
+		}
+		return ""
+	}
+	g.Expect(diskMonitorScript(jbsConfig)).Should(BeEmpty())
+	g.Expect(buildScript()).ShouldNot(ContainSubstring("disk_usage"))
+	g.Expect(buildScript()).ShouldNot(ContainSubstring("{{DISK_MONITOR}}"))
+
+	jbsConfig.Spec.BuildSettings.DiskMonitor.Enabled = true
+	script := diskMonitorScript(jbsConfig)
+	g.Expect(script).Should(ContainSubstring("df --output=pcent $(workspaces.source.path)"))
+	g.Expect(script).Should(ContainSubstring(`if [ "$(disk_usage)" -ge 95 ]; then`))
+	g.Expect(script).Should(ContainSubstring("full before the build started (threshold 95%)"))
+	g.Expect(script).Should(ContainSubstring("while sleep 30; do"))
+	g.Expect(script).Should(ContainSubstring("Out of disk: the workspace is $(disk_usage)% full (threshold 95%), stopping the build"))
+	g.Expect(script).Should(ContainSubstring("pkill -TERM -P $BUILD_PID"))
+	g.Expect(script).Should(ContainSubstring("trap 'kill $DISK_MONITOR_PID 2>/dev/null || true' EXIT"))
+	// The check runs before any packages are installed or the build starts
+	build := buildScript()
+	g.Expect(build).Should(ContainSubstring(script))
+	g.Expect(strings.Index(build, "BUILD_PID=$$")).Should(BeNumerically("<", strings.Index(build, "mvn")))
+
+	jbsConfig.Spec.BuildSettings.DiskMonitor.ThresholdPercent = 80
+	jbsConfig.Spec.BuildSettings.DiskMonitor.IntervalSeconds = 5
+	script = diskMonitorScript(jbsConfig)
+	g.Expect(script).Should(ContainSubstring(`if [ "$(disk_usage)" -ge 80 ]; then`))
+	g.Expect(script).Should(ContainSubstring("while sleep 5; do"))
+	g.Expect(script).ShouldNot(ContainSubstring("95"))
+
+	// Out of range thresholds use the default
+	jbsConfig.Spec.BuildSettings.DiskMonitor.ThresholdPercent = 150
+	g.Expect(diskMonitorScript(jbsConfig)).Should(ContainSubstring("-ge 95 ]"))
+}
+
+func TestBuildHome(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	buildScript := func() string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range ps.Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				if strings.Contains(step.Script, "/build.sh <<'RHTAPEOF'") {
+					return step.Script
+				}
+			}
+		}
+		return ""
+	}
+
+	// By default the home directory of the image user is detected, with the workspace as a fallback
+	script := homeScript(recipe)
+	g.Expect(script).Should(ContainSubstring(`getent passwd "$(id -u)" | cut -d: -f6`))
+	g.Expect(script).Should(ContainSubstring(`[ ! -w "$JBS_HOME" ]`))
+	g.Expect(script).Should(ContainSubstring("JBS_HOME=$(workspaces.source.path)/home"))
+	g.Expect(script).Should(HaveSuffix(`export HOME="$JBS_HOME"` + "\n" + `echo "HOME:$HOME"`))
+	build := buildScript()
+	g.Expect(build).Should(ContainSubstring(script))
+	g.Expect(build).ShouldNot(ContainSubstring("export HOME=/root"))
+	g.Expect(build).ShouldNot(ContainSubstring("{{HOME}}"))
+	// HOME is set before the build tool settings are written to it
+	g.Expect(strings.Index(build, `export HOME="$JBS_HOME"`)).Should(BeNumerically("<", strings.Index(build, "settings.xml")))
+
+	// An explicitly configured home directory is used as is
+	recipe.HomeDirectory = "/home/builder"
+	script = homeScript(recipe)
+	g.Expect(script).Should(HavePrefix(`export HOME='/home/builder'`))
+	g.Expect(script).ShouldNot(ContainSubstring("getent"))
+	g.Expect(buildScript()).Should(ContainSubstring(script))
+	// It is quoted so it can't be used to run commands
+	recipe.HomeDirectory = `/home/$(id)"`
+	g.Expect(homeScript(recipe)).Should(HavePrefix(`export HOME='/home/$(id)"'`))
+}
+
+func TestImagePullPolicy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	// The tag based behaviour is kept if no policy is configured
+	g.Expect(pullPolicy(jbsConfig, "quay.io/foo/processor:dev")).Should(Equal(v1.PullAlways))
+	g.Expect(pullPolicy(jbsConfig, "quay.io/foo/processor:1.0")).Should(Equal(v1.PullIfNotPresent))
+	g.Expect(pullPolicy(jbsConfig, "quay.io/minikube/processor:1.0")).Should(Equal(v1.PullNever))
+
+	jbsConfig.Spec.BuildSettings.ImagePullPolicy = "IfNotPresent"
+	g.Expect(pullPolicy(jbsConfig, "quay.io/foo/processor:dev")).Should(Equal(v1.PullIfNotPresent))
+	jbsConfig.Spec.BuildSettings.ImagePullPolicy = "Always"
+	g.Expect(pullPolicy(jbsConfig, "quay.io/foo/processor:1.0")).Should(Equal(v1.PullAlways))
+	jbsConfig.Spec.BuildSettings.ImagePullPolicy = "Never"
+	g.Expect(pullPolicy(jbsConfig, "quay.io/foo/processor:dev")).Should(Equal(v1.PullNever))
+	jbsConfig.Spec.BuildSettings.ImagePullPolicy = "Sometimes"
+	g.Expect(pullPolicy(jbsConfig, "quay.io/foo/processor:dev")).Should(Equal(v1.PullIfNotPresent))
+
+	// All steps using the build request processor image use the configured policy
+	jbsConfig.Spec.BuildSettings.ImagePullPolicy = "Always"
+	db := &v1alpha1.DependencyBuild{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	deploy, err := createDeployPipelineSpec(jbsConfig, db, "quay.io/foo/processor:1.0", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	count := 0
+	for _, task := range append(ps.Tasks, deploy.Tasks...) {
+		for _, step := range task.TaskSpec.Steps {
+			if step.Image == "quay.io/foo/processor:1.0" {
+				g.Expect(step.ImagePullPolicy).Should(Equal(v1.PullAlways))
+				count++
+			}
+		}
+	}
+	g.Expect(count).Should(BeNumerically(">", 0))
+}
+
+func TestMirrorRegistryArgs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "owner"
+	db := &v1alpha1.DependencyBuild{}
+	g.Expect(mirrorRegistryArgsWithDefaults(jbsConfig, "tag")).Should(BeEmpty())
+	preBuildImageArgs, postBuildImageArgs, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).ShouldNot(ContainSubstring("Mirroring"))
+	g.Expect(postBuildImageArgs).ShouldNot(ContainSubstring("Mirroring"))
+
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Host: "mirror.io", Port: "5000", Owner: "mirror-owner"}
+	g.Expect(registryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("quay.io/owner/artifact-deployments:tag"))
+	g.Expect(mirrorRegistryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("mirror.io:5000/mirror-owner/artifact-deployments:tag"))
+	preBuildImageArgs, postBuildImageArgs, _, _, _ = pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store mirror.io:5000/mirror-owner/artifact-deployments:image-id-pre-build-image "))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("create-archive --store mirror.io:5000/mirror-owner/artifact-deployments:build-id "))
+
+	jbsConfig.Spec.Registry.PrependTag = "prefix"
+	longTag := strings.Repeat("a", 200)
+	primary := registryArgsWithDefaults(jbsConfig, longTag)
+	mirror := mirrorRegistryArgsWithDefaults(jbsConfig, longTag)
+	g.Expect(primary[strings.LastIndex(primary, ":")+1:]).Should(HaveLen(128))
+	g.Expect(mirror[strings.LastIndex(mirror, ":")+1:]).Should(HaveLen(128))
+	g.Expect(mirror).Should(HavePrefix("mirror.io:5000/mirror-owner/artifact-deployments:prefix_"))
+}
+
+func TestPreBuildRepository(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "owner"
+	db := &v1alpha1.DependencyBuild{}
+	// Without a pre-build repository all the images share the deployment repository
+	g.Expect(preBuildRegistryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("quay.io/owner/artifact-deployments:tag"))
+
+	jbsConfig.Spec.Registry.PreBuildRepository = "pre-build-images"
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Host: "mirror.io", Owner: "mirror-owner", PreBuildRepository: "mirror-pre-build"}
+	preBuildImageArgs, postBuildImageArgs, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store quay.io/owner/pre-build-images:image-id-pre-build-image "))
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store mirror.io/mirror-owner/mirror-pre-build:image-id-pre-build-image "))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("IMGURL=quay.io/owner/artifact-deployments:build-id\n"))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("create-archive --store mirror.io/mirror-owner/artifact-deployments:build-id "))
+
+	jbsConfig.Spec.Registry.PrependTag = "prefix"
+	preBuild := preBuildRegistryArgsWithDefaults(jbsConfig, strings.Repeat("a", 200))
+	g.Expect(preBuild).Should(HavePrefix("quay.io/owner/pre-build-images:prefix_"))
+	g.Expect(preBuild[strings.LastIndex(preBuild, ":")+1:]).Should(HaveLen(128))
+}
+
+func TestPreBuildImageContentDigestTag(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "owner"
+	db := &v1alpha1.DependencyBuild{}
+	g.Expect(sourceDigestScript(jbsConfig)).Should(BeEmpty())
+	preBuildImageArgs, _, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store quay.io/owner/artifact-deployments:image-id-pre-build-image "))
+	g.Expect(preBuildImageArgs).ShouldNot(ContainSubstring("SOURCE_DIGEST"))
+
+	jbsConfig.Spec.Registry.PreBuildImageTag = v1alpha1.PreBuildImageTagImageId
+	preBuildImageArgs, _, _, _, _ = pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store quay.io/owner/artifact-deployments:image-id-pre-build-image "))
+
+	jbsConfig.Spec.Registry.PreBuildImageTag = v1alpha1.PreBuildImageTagContentDigest
+	script := sourceDigestScript(jbsConfig)
+	g.Expect(script).Should(HavePrefix("SOURCE_DIGEST=$(cd $(workspaces.source.path)/source && find . -path ./.git -prune -o -type f -print0 | LC_ALL=C sort -z | "))
+	g.Expect(script).Should(ContainSubstring("| sha256sum | cut -d ' ' -f 1)\n"))
+	jbsConfig.Spec.Registry.PrependTag = "prefix"
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Host: "mirror.io", Owner: "mirror-owner"}
+	preBuildImageArgs, _, _, _, _ = pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).ShouldNot(ContainSubstring("image-id-pre-build-image"))
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store quay.io/owner/artifact-deployments:prefix_${SOURCE_DIGEST}-pre-build-image "))
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("create-archive --store mirror.io/mirror-owner/artifact-deployments:prefix_${SOURCE_DIGEST}-pre-build-image "))
+	// The digest is taken once the generated build script has been copied into the source
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("cp $(workspaces.source.path)/build.sh \"$JBS_DIR\"\n" + script + "create-archive "))
+}
+
+func TestMirrorRegistryCredentials(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "owner"
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Host: "mirror.io", Owner: "mirror-owner"}
+	db := &v1alpha1.DependencyBuild{}
+	g.Expect(mirrorOrasOptions(jbsConfig, "$ORAS_OPTIONS")).Should(Equal("$ORAS_OPTIONS"))
+	g.Expect(mirrorRegistryConfigScript(jbsConfig)).Should(BeEmpty())
+	g.Expect(secretVariables(jbsConfig)).ShouldNot(ContainElement(HaveField("Name", "MIRROR_REGISTRY_TOKEN")))
+
+	jbsConfig.Spec.Registry.Mirror.Insecure = true
+	jbsConfig.Spec.Registry.Mirror.SecretName = "mirror-secret"
+	g.Expect(mirrorOrasOptions(jbsConfig, "$ORAS_OPTIONS")).Should(Equal("$ORAS_OPTIONS --insecure --plain-http --registry-config " + MirrorRegistryConfig))
+	// The primary registry options are left alone
+	g.Expect(registryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("quay.io/owner/artifact-deployments:tag"))
+	preBuildImageArgs, postBuildImageArgs, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("echo \"$MIRROR_REGISTRY_TOKEN\" > " + MirrorRegistryConfig + "\nORAS_OPTIONS=\"$ORAS_OPTIONS --insecure --plain-http --registry-config " + MirrorRegistryConfig + "\" create-archive --store mirror.io/mirror-owner/artifact-deployments:image-id-pre-build-image "))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("ORAS_OPTIONS=\"$ORAS_OPTIONS --insecure --plain-http --registry-config " + MirrorRegistryConfig + "\" create-archive --store mirror.io/mirror-owner/artifact-deployments:build-id "))
+	g.Expect(strings.Count(preBuildImageArgs, "--registry-config")).Should(Equal(1))
+
+	vars := secretVariables(jbsConfig)
+	g.Expect(vars).Should(ContainElement(HaveField("Name", "MIRROR_REGISTRY_TOKEN")))
+	for _, v := range vars {
+		if v.Name == "MIRROR_REGISTRY_TOKEN" {
+			g.Expect(v.ValueFrom.SecretKeyRef.Name).Should(Equal("mirror-secret"))
+			g.Expect(v.ValueFrom.SecretKeyRef.Key).Should(Equal(v1alpha1.ImageSecretTokenKey))
+		}
+	}
+
+	db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{BuildId: "build-id"}}
+	ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	tag := ps.Tasks[0].TaskSpec.Steps[len(ps.Tasks[0].TaskSpec.Steps)-1]
+	g.Expect(tag.Script).Should(ContainSubstring("echo \"$MIRROR_REGISTRY_TOKEN\" > " + MirrorRegistryConfig + "\noras tag --insecure --plain-http --registry-config " + MirrorRegistryConfig + " --verbose mirror.io/mirror-owner/artifact-deployments:build-id "))
+}
+
+func TestGradleBuildCacheSettings(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	g.Expect(gradleBuildCacheSettings(jbsConfig)).Should(BeEmpty())
+	g.Expect(gradleBuildCacheVariables("gradle", jbsConfig)).Should(BeEmpty())
+
+	// The URL alone does not enable the cache
+	jbsConfig.Spec.BuildSettings.GradleBuildCache.URL = "https://gradle-cache.example.com/cache/"
+	g.Expect(gradleBuildCacheSettings(jbsConfig)).Should(BeEmpty())
+
+	jbsConfig.Spec.BuildSettings.GradleBuildCache.Enabled = true
+	settings := gradleBuildCacheSettings(jbsConfig)
+	g.Expect(settings).Should(ContainSubstring("cat > .hacbs-init/remote-build-cache.gradle"))
+	g.Expect(settings).Should(ContainSubstring("remote(HttpBuildCache)"))
+	g.Expect(settings).Should(ContainSubstring("url = 'https://gradle-cache.example.com/cache/'"))
+	g.Expect(settings).Should(ContainSubstring("push = true"))
+
+	g.Expect(gradleBuildCacheVariables("maven", jbsConfig)).Should(BeEmpty())
+	vars := gradleBuildCacheVariables("gradle", jbsConfig)
+	g.Expect(vars).Should(HaveLen(2))
+	for _, v := range vars {
+		g.Expect(v.ValueFrom.SecretKeyRef.Name).Should(Equal(v1alpha1.GradleBuildCacheSecretName))
+	}
+}
+
+func TestCcache(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	buildStep := func() (*tektonpipeline.TaskSpec, *tektonpipeline.Step) {
+		recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+		db := &v1alpha1.DependencyBuild{}
+		db.Name = "test"
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range ps.Tasks {
+			for i, step := range task.TaskSpec.Steps {
+				if step.Name == BuildTaskName {
+					return &task.TaskSpec.TaskSpec, &task.TaskSpec.Steps[i]
+				}
+			}
+		}
+		return nil, nil
+	}
+	g.Expect(ccacheVariables(jbsConfig)).Should(BeEmpty())
+	volumes, mounts := ccacheVolume(jbsConfig)
+	g.Expect(volumes).Should(BeEmpty())
+	g.Expect(mounts).Should(BeEmpty())
+	task, step := buildStep()
+	g.Expect(task.Volumes).Should(BeEmpty())
+	g.Expect(step.VolumeMounts).Should(BeEmpty())
+
+	jbsConfig.Spec.BuildSettings.Ccache.Enabled = true
+	vars := ccacheVariables(jbsConfig)
+	g.Expect(vars).Should(ContainElements(
+		v1.EnvVar{Name: "CCACHE_DIR", Value: CcacheDirectory},
+		v1.EnvVar{Name: "CC", Value: "ccache gcc"},
+		v1.EnvVar{Name: "CXX", Value: "ccache g++"},
+		v1.EnvVar{Name: "CMAKE_C_COMPILER_LAUNCHER", Value: "ccache"},
+		v1.EnvVar{Name: "CMAKE_CXX_COMPILER_LAUNCHER", Value: "ccache"}))
+	g.Expect(vars).ShouldNot(ContainElement(HaveField("Name", "CCACHE_MAXSIZE")))
+	task, step = buildStep()
+	g.Expect(task.Volumes).Should(Equal([]v1.Volume{{Name: "ccache", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: v1alpha1.DefaultCcacheClaimName}}}}))
+	g.Expect(step.VolumeMounts).Should(Equal([]v1.VolumeMount{{Name: "ccache", MountPath: CcacheDirectory}}))
+	g.Expect(step.Env).Should(ContainElements(vars))
+
+	jbsConfig.Spec.BuildSettings.Ccache.ClaimName = "native-cache"
+	jbsConfig.Spec.BuildSettings.Ccache.MaxSize = "5G"
+	g.Expect(ccacheVariables(jbsConfig)).Should(ContainElement(v1.EnvVar{Name: "CCACHE_MAXSIZE", Value: "5G"}))
+	volumes, _ = ccacheVolume(jbsConfig)
+	g.Expect(volumes[0].PersistentVolumeClaim.ClaimName).Should(Equal("native-cache"))
+}
+
+func TestGradleInitScript(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{}
+	g.Expect(gradleInitScript(recipe)).Should(BeEmpty())
+
+	recipe.GradleInitScript = "allprojects {\n    repositories {\n        mavenLocal()\n    }\n}\n"
+	g.Expect(gradleInitScript(recipe)).Should(Equal("cat > " + GradleInitScriptFile + " << 'JBSINITEOF'\nallprojects {\n    repositories {\n        mavenLocal()\n    }\n}\nJBSINITEOF\n"))
+	// The init script is written before Gradle runs, and only for Gradle builds
+	section := toolBuildSection("gradle", jbsConfig, recipe)
+	g.Expect(section).Should(ContainSubstring(gradleInitScript(recipe)))
+	g.Expect(strings.Index(section, gradleInitScript(recipe))).Should(BeNumerically("<", strings.Index(section, "--init-script")))
+	for _, tool := range []string{"maven", "ant", "sbt", "lein"} {
+		g.Expect(toolBuildSection(tool, jbsConfig, recipe)).ShouldNot(ContainSubstring("JBSINITEOF"))
+	}
+
+	for _, tool := range []string{"gradle", "maven"} {
+		recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: tool, JavaVersion: "17", ToolVersions: map[string]string{tool: "8.4", "jdk": "17"}, GradleInitScript: recipe.GradleInitScript}
+		db := &v1alpha1.DependencyBuild{}
+		db.Name = "test"
+		_, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), tool, 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		if tool == "gradle" {
+			g.Expect(konfluxScript).Should(ContainSubstring("mavenLocal()\n    }\n}\nJBSINITEOF\n"))
+		} else {
+			g.Expect(konfluxScript).ShouldNot(ContainSubstring("JBSINITEOF"))
+		}
+	}
+}
+
+func TestDoSubstitutionArrayParams(t *testing.T) {
+	g := NewGomegaWithT(t)
+	paramValues := []tektonpipeline.Param{
+		{Name: PipelineParamGoals, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: []string{"install", "-DskipTests"}}},
+		{Name: PipelineParamProjectVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: "1.0"}},
+	}
+	result := doSubstitution("mvn $(params.GOALS[0]) $(params.GOALS[1]) -Dversion=$(params.PROJECT_VERSION)", paramValues, 0, "", DiagnosticProjectPath)
+	g.Expect(result).Should(Equal("mvn install -DskipTests -Dversion=1.0"))
+
+	result = doSubstitution("mvn $(params.GOALS[2]) $(params.MISSING[0]) $(params.PROJECT_VERSION[0])", paramValues, 0, "", DiagnosticProjectPath)
+	g.Expect(result).Should(Equal("mvn $(params.GOALS[2]) $(params.MISSING[0]) $(params.PROJECT_VERSION[0])"))
+}
+
+func TestTagOrasOptions(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	g.Expect(tagOrasOptions(jbsConfig, "")).Should(BeEmpty())
+	g.Expect(tagOrasOptions(jbsConfig, "--insecure --plain-http")).Should(Equal("--insecure --plain-http"))
+
+	jbsConfig.Spec.Registry.TagHeaders = map[string]string{
+		"Content-Type": "application/vnd.oci.image.manifest.v1+json",
+		"Accept":       "application/vnd.oci.image.manifest.v1+json",
+	}
+	jbsConfig.Spec.Registry.TagOptions = []string{"--concurrency=1"}
+	g.Expect(tagOrasOptions(jbsConfig, "--insecure --plain-http")).Should(Equal("--insecure --plain-http " +
+		"--header 'Accept: application/vnd.oci.image.manifest.v1+json' " +
+		"--header 'Content-Type: application/vnd.oci.image.manifest.v1+json' --concurrency=1"))
+
+	db := &v1alpha1.DependencyBuild{}
+	ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	tag := ps.Tasks[0].TaskSpec.Steps[2]
+	g.Expect(tag.Name).Should(Equal("tag"))
+	g.Expect(tag.Script).Should(ContainSubstring("oras tag --header 'Accept: application/vnd.oci.image.manifest.v1+json'"))
+	// The tags are checked with the same headers and retries, but the options are only for the oras tag command
+	jbsConfig.Spec.Registry.OrasRetry = v1alpha1.OrasRetry{Count: 3}
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(stepNamed(ps.Tasks[0], "tag").Script).Should(ContainSubstring("\"$(oras_retry oras manifest fetch --descriptor " +
+		"--header 'Accept: application/vnd.oci.image.manifest.v1+json' " +
+		"--header 'Content-Type: application/vnd.oci.image.manifest.v1+json' quay.io/"))
+	g.Expect(tagOrasOptions(jbsConfig, "")).Should(HaveSuffix("json' --concurrency=1"))
+	jbsConfig.Spec.Registry.TagHeaders = nil
+	g.Expect(tagOrasOptions(jbsConfig, "")).Should(Equal("--concurrency=1"))
+
+	// Quotes in header values must not end the quoted argument
+	jbsConfig.Spec.Registry.TagHeaders = map[string]string{"X-Note": "it's'; echo injected; echo '"}
+	jbsConfig.Spec.Registry.TagOptions = nil
+	g.Expect(tagOrasOptions(jbsConfig, "")).Should(Equal(`--header 'X-Note: it'\''s'\''; echo injected; echo '\'''`))
+}
+
+func TestTaggedGavsResult(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "owner"
+	db := &v1alpha1.DependencyBuild{}
+	ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2,gav3")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	tagTask := ps.Tasks[0]
+	g.Expect(tagTask.Name).Should(Equal(TagTaskName))
+	g.Expect(tagTask.TaskSpec.Results).Should(ContainElement(HaveField("Name", PipelineResultTaggedGavs)))
+	g.Expect(ps.Results).Should(ContainElement(tektonpipeline.PipelineResult{
+		Name:        PipelineResultTaggedGavs,
+		Description: "The number of GAVs the image was tagged for",
+		Value:       tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(tasks." + TagTaskName + ".results." + PipelineResultTaggedGavs + ")"},
+	}))
+
+	tag := tagTask.TaskSpec.Steps[len(tagTask.TaskSpec.Steps)-1]
+	g.Expect(tag.Name).Should(Equal("tag"))
+	g.Expect(tag.Script).Should(ContainSubstring("GAVS=gav1,gav2,gav3\n"))
+	// Every requested GAV is checked against the digest and the step fails if any are missing
+	g.Expect(tag.Script).Should(ContainSubstring("for GAV in ${GAVS//,/ }; do\n  REQUESTED=$((REQUESTED + 1))\n"))
+	g.Expect(tag.Script).Should(ContainSubstring("oras manifest fetch --descriptor quay.io/owner/artifact-deployments:$GAV | jq --raw-output '.digest')\" == \"$(params." + PipelineResultImageDigest + ")\""))
+	g.Expect(tag.Script).Should(ContainSubstring("echo -n \"$TAGGED\" > $(results." + PipelineResultTaggedGavs + ".path)\n"))
+	g.Expect(tag.Script).Should(HaveSuffix("if [ \"$TAGGED\" -ne \"$REQUESTED\" ]; then\n  echo \"Only $TAGGED of $REQUESTED GAVs were tagged\" >&2\n  exit 1\nfi"))
+	// The count is checked before the mirror is tagged
+	db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{BuildId: "build-id"}}
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Host: "mirror.io", Owner: "mirror-owner"}
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2,gav3")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	tag = ps.Tasks[0].TaskSpec.Steps[len(ps.Tasks[0].TaskSpec.Steps)-1]
+	g.Expect(strings.Index(tag.Script, "exit 1")).Should(BeNumerically("<", strings.Index(tag.Script, "Tagging mirror")))
+}
+
+func TestVerifyArtifactSignatures(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db := &v1alpha1.DependencyBuild{}
+	stepNames := func(ps *tektonpipeline.PipelineSpec) []string {
+		names := []string{}
+		for _, step := range ps.Tasks[0].TaskSpec.Steps {
+			names = append(names, step.Name)
+		}
+		return names
+	}
+	g.Expect(signingPublicKeyVariable(jbsConfig)).Should(BeNil())
+	ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(stepNames(ps)).ShouldNot(ContainElement("verify-artifact-signatures"))
+
+	jbsConfig.Spec.MavenDeployment.SigningPublicKey = &v1alpha1.SigningPublicKeySource{ConfigMapName: "signing-key"}
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(stepNames(ps)).Should(Equal([]string{"restore-post-build-artifacts", "verify-artifact-signatures", "maven-deployment", "tag"}))
+	verify := ps.Tasks[0].TaskSpec.Steps[1]
+	g.Expect(verify.Image).Should(Equal("image"))
+	g.Expect(verify.Env).Should(HaveLen(1))
+	g.Expect(verify.Env[0].Name).Should(Equal(SigningPublicKeyVariable))
+	g.Expect(verify.Env[0].ValueFrom.ConfigMapKeyRef.Name).Should(Equal("signing-key"))
+	g.Expect(verify.Env[0].ValueFrom.ConfigMapKeyRef.Key).Should(Equal(DefaultSigningPublicKeyKey))
+	g.Expect(verify.Script).Should(ContainSubstring("echo \"$" + SigningPublicKeyVariable + "\" | gpg --batch --import"))
+	g.Expect(verify.Script).Should(ContainSubstring("for SIGNATURE in $(find $(workspaces.source.path)/artifacts -type f -name '*.asc'); do\n"))
+	g.Expect(verify.Script).Should(ContainSubstring("gpg --batch --verify \"$SIGNATURE\" \"${SIGNATURE%.asc}\""))
+	g.Expect(verify.Script).Should(HaveSuffix("if [ $FAILED -ne 0 ]; then\n    echo \"$FAILED artifact signatures are invalid\" >&2\n    exit 1\nfi"))
+
+	// The secret takes precedence, and the signatures are verified after the checksums and before the deploy
+	jbsConfig.Spec.MavenDeployment.SigningPublicKey = &v1alpha1.SigningPublicKeySource{ConfigMapName: "signing-key", SecretName: "signing-secret", Key: "key.asc"}
+	jbsConfig.Spec.MavenDeployment.ValidateChecksums = true
+	jbsConfig.Spec.MavenDeployment.Repository = "https://repo.example.com/releases"
+	jbsConfig.Spec.MavenDeployment.SkipImageTagging = true
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(stepNames(ps)).Should(Equal([]string{"restore-post-build-artifacts", "validate-artifact-checksums", "verify-artifact-signatures", "maven-deployment"}))
+	verify = ps.Tasks[0].TaskSpec.Steps[2]
+	g.Expect(verify.Env[0].ValueFrom.ConfigMapKeyRef).Should(BeNil())
+	g.Expect(verify.Env[0].ValueFrom.SecretKeyRef.Name).Should(Equal("signing-secret"))
+	g.Expect(verify.Env[0].ValueFrom.SecretKeyRef.Key).Should(Equal("key.asc"))
+}
+
+func TestSkipImageTagging(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.SkipImageTagging = true
+	db := &v1alpha1.DependencyBuild{}
+	// Without a Maven repository the image is still tagged
+	ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks).Should(HaveLen(1))
+	g.Expect(ps.Tasks[0].Name).Should(Equal(TagTaskName))
+
+	jbsConfig.Spec.MavenDeployment.Repository = "https://repo.example.com/releases"
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks).Should(HaveLen(1))
+	g.Expect(ps.Tasks[0].Name).Should(Equal(DeployTaskName))
+	g.Expect(ps.Tasks).ShouldNot(ContainElement(HaveField("Name", TagTaskName)))
+	steps := []string{}
+	for _, step := range ps.Tasks[0].TaskSpec.Steps {
+		steps = append(steps, step.Name)
+	}
+	g.Expect(steps).Should(Equal([]string{"restore-post-build-artifacts", "maven-deployment"}))
+	g.Expect(ps.Tasks[0].TaskSpec.Results).Should(BeEmpty())
+	g.Expect(ps.Results).Should(BeEmpty())
+
+	// The repositories list is also a Maven repository
+	jbsConfig.Spec.MavenDeployment.Repository = ""
+	jbsConfig.Spec.MavenDeployment.Repositories = []v1alpha1.MavenRepository{{URL: "https://repo.example.com/releases"}}
+	g.Expect(skipImageTagging(jbsConfig)).Should(BeTrue())
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks[0].Name).Should(Equal(DeployTaskName))
+
+	// The image is always tagged when the artifacts are deployed to the image registry
+	jbsConfig.Spec.MavenDeployment.Mode = v1alpha1.DeployModeOCI
+	g.Expect(skipImageTagging(jbsConfig)).Should(BeFalse())
+	ps, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks[0].Name).Should(Equal(TagTaskName))
+	g.Expect(stepNamed(ps.Tasks[0], "tag")).ShouldNot(BeNil())
+
+	// Unknown modes are rejected rather than deploying to the Maven repositories
+	jbsConfig.Spec.MavenDeployment.Mode = "OCI"
+	_, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).Should(MatchError("unsupported deploy mode \"OCI\""))
+	_, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, newTestRecipe(), db, nil, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("unsupported deploy mode \"OCI\""))
+}
+
+func TestOrasRetry(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Annotations = map[string]string{jbsconfig.TestRegistry: "true"}
+	g.Expect(orasRetryFunction(jbsConfig)).Should(BeEmpty())
+	g.Expect(orasRetry(jbsConfig)).Should(BeEmpty())
+
+	jbsConfig.Spec.Registry.OrasRetry = v1alpha1.OrasRetry{Count: 3}
+	g.Expect(orasRetryFunction(jbsConfig)).Should(ContainSubstring("    local attempt=0 delay=5\n"))
+	jbsConfig.Spec.Registry.OrasRetry.BackoffSeconds = 2
+	function := orasRetryFunction(jbsConfig)
+	g.Expect(function).Should(HavePrefix("oras_retry() {\n    local attempt=0 delay=2\n    while ! \"$@\"; do\n        if [ \"$attempt\" -ge 3 ]; then\n"))
+	g.Expect(function).Should(ContainSubstring("        sleep \"$delay\"\n        delay=$((delay * 2))\n"))
+	// The oras CLI has no retry options
+	g.Expect(registryOrasOptions(jbsConfig)).Should(Equal("--insecure --plain-http"))
+
+	// All the oras invoking steps of both pipelines retry their requests
+	db := &v1alpha1.DependencyBuild{}
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Owner: "mirror"}
+	ps := buildPipeline(g, jbsConfig, newTestRecipe(), db)
+	deploy, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	scripts := map[string]string{}
+	for _, task := range append(ps.Tasks, deploy.Tasks...) {
+		for _, step := range task.TaskSpec.Steps {
+			scripts[step.Name] = step.Script
+		}
+	}
+	for _, step := range []string{"create-pre-build-image", "restore-pre-build-source", "create-post-build-image", "restore-post-build-artifacts", "tag"} {
+		g.Expect(scripts[step]).Should(HavePrefix(function), step)
+		g.Expect(scripts[step]).ShouldNot(ContainSubstring("--retry"), step)
+	}
+	g.Expect(strings.Count(scripts["create-pre-build-image"], "oras_retry create-archive ")).Should(Equal(2))
+	g.Expect(scripts["restore-pre-build-source"]).Should(ContainSubstring("\noras_retry use-archive $(params." + PreBuildImageDigest + ")"))
+	g.Expect(strings.Count(scripts["create-post-build-image"], "oras_retry create-archive ")).Should(Equal(2))
+	g.Expect(scripts["restore-post-build-artifacts"]).Should(ContainSubstring("MANIFEST=$(oras_retry oras manifest fetch $ORAS_OPTIONS $URL@$DIGEST)"))
+	g.Expect(scripts["restore-post-build-artifacts"]).Should(ContainSubstring("\noras_retry use-archive oci:$URL@$SARCHIVE"))
+	g.Expect(scripts["tag"]).Should(ContainSubstring("\noras_retry oras tag --insecure --plain-http --verbose "))
+
+	// Within the deploy retry budget if there is one
+	jbsConfig.Spec.MavenDeployment.RetryBudget.MaxRetries = 3
+	deploy, err = createDeployPipelineSpec(jbsConfig, db, "image", "gav1,gav2")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	tag := stepNamed(deploy.Tasks[0], "tag").Script
+	g.Expect(tag).Should(HavePrefix(deployRetryFunction(jbsConfig) + function))
+	g.Expect(tag).Should(ContainSubstring("\ndeploy_retry oras_retry oras tag "))
+}
+
+func TestTestRunOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{}
+	g.Expect(mavenTestOrderArgs("maven", recipe)).Should(BeEmpty())
+	g.Expect(gradleTestOrderSettings(recipe)).Should(BeEmpty())
+
+	recipe.TestRunOrder = TestRunOrderAlphabetical
+	g.Expect(mavenTestOrderArgs("maven", recipe)).Should(Equal([]string{"-Dsurefire.runOrder=alphabetical", "-Dfailsafe.runOrder=alphabetical"}))
+	g.Expect(mavenTestOrderArgs("gradle", recipe)).Should(BeEmpty())
+	settings := gradleTestOrderSettings(recipe)
+	g.Expect(settings).Should(ContainSubstring("cat > .hacbs-init/test-order.gradle"))
+	g.Expect(settings).Should(ContainSubstring("'org.junit.jupiter.api.ClassOrderer$ClassName'"))
+	g.Expect(settings).Should(ContainSubstring("'org.junit.jupiter.api.MethodOrderer$MethodName'"))
+
+	recipe.TestRunOrder = TestRunOrderRandom
+	g.Expect(mavenTestOrderArgs("maven", recipe)).Should(ContainElement("-Dsurefire.runOrder.random.seed=" + TestRunOrderRandomSeed))
+	g.Expect(gradleTestOrderSettings(recipe)).Should(ContainSubstring("'junit.jupiter.execution.order.random.seed', '" + TestRunOrderRandomSeed + "'"))
+
+	recipe.TestRunOrder = "hourly"
+	g.Expect(mavenTestOrderArgs("maven", recipe)).Should(BeEmpty())
+	g.Expect(gradleTestOrderSettings(recipe)).Should(BeEmpty())
+}
+
+func TestGitScriptSubmoduleCredentials(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	recipe := &v1alpha1.BuildRecipe{}
+	g.Expect(gitScript(db, recipe)).ShouldNot(ContainSubstring(".git-credentials"))
+	g.Expect(submoduleCredentialVariables(recipe)).Should(BeEmpty())
+
+	recipe.SubmoduleCredentials = []v1alpha1.SubmoduleCredential{
+		{Host: "gitlab.example.com", SecretName: "gitlab-secret"},
+		{Host: "git.example.org", SecretName: "other-secret", SecretKey: "token"},
+	}
+	// Public top-level repository with private submodules
+	script := gitScript(db, recipe)
+	g.Expect(script).ShouldNot(ContainSubstring("$GIT_TOKEN"))
+	g.Expect(script).Should(ContainSubstring("echo -n > $HOME/.git-credentials && "))
+	g.Expect(script).Should(ContainSubstring("echo \"$GIT_SUBMODULE_TOKEN_0\" >> $HOME/.git-credentials && echo \"$GIT_SUBMODULE_TOKEN_1\" >> $HOME/.git-credentials && chmod 400 $HOME/.git-credentials"))
+	g.Expect(script).Should(ContainSubstring("echo '[credential \"https://gitlab.example.com\"]\n        helper=store\n[credential \"https://git.example.org\"]\n        helper=store\n' > $HOME/.gitconfig"))
+	g.Expect(strings.Index(script, "chmod 400")).Should(BeNumerically("<", strings.Index(script, "git clone")))
+
+	db.Spec.ScmInfo.Private = true
+	script = gitScript(db, recipe)
+	g.Expect(script).Should(ContainSubstring("echo \"$GIT_TOKEN\" > $HOME/.git-credentials && echo \"$GIT_SUBMODULE_TOKEN_0\""))
+	g.Expect(script).Should(ContainSubstring("echo '[credential]\n        helper=store\n[credential \"https://gitlab.example.com\"]"))
+
+	vars := submoduleCredentialVariables(recipe)
+	g.Expect(vars).Should(HaveLen(2))
+	g.Expect(vars[0].Name).Should(Equal("GIT_SUBMODULE_TOKEN_0"))
+	g.Expect(vars[0].ValueFrom.SecretKeyRef.Name).Should(Equal("gitlab-secret"))
+	g.Expect(vars[0].ValueFrom.SecretKeyRef.Key).Should(Equal(v1alpha1.GitSecretTokenKey))
+	g.Expect(vars[1].ValueFrom.SecretKeyRef.Key).Should(Equal("token"))
+}
+
+func TestMavenMirrors(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{}
+	script := toolBuildSection("maven", jbsConfig, recipe)
+	g.Expect(script).ShouldNot(ContainSubstring("{{MAVEN_MIRRORS}}"))
+	g.Expect(strings.Count(script, "<mirror>")).Should(Equal(1))
+
+	jbsConfig.Spec.MavenMirrors = []v1alpha1.MavenMirror{
+		{Id: "corporate", Url: "https://nexus.example.com/repository/maven-public/", MirrorOf: "central"},
+		{Id: "internal", Url: "https://artifactory.example.com/maven?a=1&b=$2", MirrorOf: "internal,!snapshots"},
+	}
+	corporate := `        <mirror>
+          <id>corporate</id>
+          <url>https://nexus.example.com/repository/maven-public/</url>
+          <mirrorOf>central</mirrorOf>
+        </mirror>
+`
+	internal := `        <mirror>
+          <id>internal</id>
+          <url>https://artifactory.example.com/maven?a=1&amp;b=\$2</url>
+          <mirrorOf>internal,!snapshots</mirrorOf>
+        </mirror>
+`
+	for _, tool := range []string{"maven", "gradle", "ant", "lein"} {
+		script = toolBuildSection(tool, jbsConfig, recipe)
+		// The mirrors are added whether or not the cache is used, ahead of the cache mirror so they take precedence
+		g.Expect(strings.Count(script, "      <mirrors>\n"+corporate+internal)).Should(Equal(2), tool)
+		g.Expect(script).Should(ContainSubstring(internal + "        <mirror>\n          <id>mirror.default</id>"))
+	}
+}
+
+func TestMavenSettingsReplacement(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	g.Expect(mavenSettingsVariables(jbsConfig)).Should(BeEmpty())
+	g.Expect(toolBuildSection("maven", jbsConfig, recipe)).ShouldNot(ContainSubstring(MavenSettingsVariable))
+
+	jbsConfig.Spec.BuildSettings.MavenSettings.ConfigMapName = "maven-settings"
+	g.Expect(mavenSettingsVariables(jbsConfig)).Should(Equal([]v1.EnvVar{{Name: MavenSettingsVariable, ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "maven-settings"}, Key: DefaultMavenSettingsKey}}}}))
+	jbsConfig.Spec.BuildSettings.MavenSettings.SecretName = "maven-settings-secret"
+	jbsConfig.Spec.BuildSettings.MavenSettings.Key = "custom.xml"
+	g.Expect(mavenSettingsVariables(jbsConfig)).Should(Equal([]v1.EnvVar{{Name: MavenSettingsVariable, ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "maven-settings-secret"}, Key: "custom.xml"}}}}))
+
+	// The configured settings replace the generated ones for every tool using them
+	for _, tool := range []string{"maven", "gradle", "ant", "lein"} {
+		script := toolBuildSection(tool, jbsConfig, recipe)
+		g.Expect(script).Should(HavePrefix("if [ -n \"${JBS_MAVEN_SETTINGS:-}\" ]; then\n    echo \"Using the configured Maven settings\"\n    printf '%s\\n' \"$JBS_MAVEN_SETTINGS\" >\"$(workspaces.build-settings.path)\"/settings.xml\nelse\n"), tool)
+		g.Expect(script).Should(ContainSubstring("</settings>\nEOF\n\nfi\n"), tool)
+	}
+	g.Expect(toolBuildSection("sbt", jbsConfig, recipe)).ShouldNot(ContainSubstring(MavenSettingsVariable))
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	var build *tektonpipeline.Step
+	for _, task := range ps.Tasks {
+		for i, step := range task.TaskSpec.Steps {
+			if step.Name == BuildTaskName {
+				build = &task.TaskSpec.Steps[i]
+			}
+		}
+	}
+	g.Expect(build).ShouldNot(BeNil())
+	g.Expect(build.Env).Should(ContainElement(HaveField("Name", MavenSettingsVariable)))
+	// The settings are not available outside the cluster
+	g.Expect(konfluxScript).ShouldNot(ContainSubstring("export " + MavenSettingsVariable))
+}
+
+func TestGitScriptCloneDepth(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	recipe := &v1alpha1.BuildRecipe{}
+	script := gitScript(db, recipe)
+	g.Expect(script).Should(ContainSubstring("git clone $(params.URL) $(workspaces.source.path)/source && cd $(workspaces.source.path)/source && git reset --hard $(params.HASH)"))
+	g.Expect(script).ShouldNot(ContainSubstring("--depth"))
+
+	recipe.CloneDepth = 1
+	script = gitScript(db, recipe)
+	g.Expect(script).ShouldNot(ContainSubstring("git clone"))
+	g.Expect(script).Should(ContainSubstring("git init $(workspaces.source.path)/source && cd $(workspaces.source.path)/source && git remote add origin $(params.URL) && git fetch --depth 1 origin $(params.HASH) && git reset --hard $(params.HASH)"))
+	// Submodules are still updated once the commit is checked out
+	g.Expect(script).Should(HaveSuffix("git reset --hard $(params.HASH) && git submodule init && git submodule update --recursive"))
+	recipe.DisableSubmodules = true
+	g.Expect(gitScript(db, recipe)).Should(HaveSuffix("git reset --hard $(params.HASH)"))
+}
+
+func TestDefaultGoals(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	goals := func(tool string, commandLine ...string) ([]string, string) {
+		recipe := newTestRecipe()
+		recipe.Tool = tool
+		recipe.CommandLine = commandLine
+		ps, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), tool, 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, db, buildPipelineParams(logr.Discard(), db, &v1alpha1.BuildAttempt{Recipe: recipe}), "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, i := range ps.Params {
+			if i.Name == PipelineParamGoals {
+				return i.Default.ArrayVal, konfluxScript
+			}
+		}
+		return nil, konfluxScript
+	}
+	defaults, konfluxScript := goals("maven")
+	g.Expect(defaults).Should(Equal([]string{"clean", "deploy"}))
+	g.Expect(konfluxScript).Should(ContainSubstring("\nset -- \"$@\" clean deploy \n"))
+	defaults, konfluxScript = goals("gradle")
+	g.Expect(defaults).Should(Equal([]string{"build"}))
+	g.Expect(konfluxScript).Should(ContainSubstring("\nset -- \"$@\" build \n"))
+	// Tools without default goals run their own default
+	defaults, _ = goals("ant")
+	g.Expect(defaults).Should(BeEmpty())
+
+	// Explicit goals are used rather than the default
+	defaults, konfluxScript = goals("maven", "install", "-DskipTests")
+	g.Expect(defaults).Should(Equal([]string{"clean", "deploy"}))
+	g.Expect(konfluxScript).Should(ContainSubstring("\nset -- \"$@\" install -DskipTests \n"))
+	g.Expect(konfluxScript).ShouldNot(ContainSubstring("clean deploy"))
+	g.Expect(buildPipelineParams(logr.Discard(), db, &v1alpha1.BuildAttempt{Recipe: &v1alpha1.BuildRecipe{}})).ShouldNot(ContainElement(HaveField("Name", PipelineParamGoals)))
+}
+
+func TestScmScript(t *testing.T) {
+	g := NewGomegaWithT(t)
+	db := &v1alpha1.DependencyBuild{}
+	recipe := &v1alpha1.BuildRecipe{SubmoduleCredentials: []v1alpha1.SubmoduleCredential{{Host: "gitlab.example.com", SecretName: "gitlab-secret"}}}
+	// Git is the default
+	g.Expect(scmScript(db, recipe)).Should(Equal(gitScript(db, recipe)))
+	db.Spec.ScmInfo.SCMType = v1alpha1.SCMTypeGit
+	g.Expect(scmScript(db, recipe)).Should(Equal(gitScript(db, recipe)))
+
+	// Submodules only apply to git
+	db.Spec.ScmInfo.SCMType = v1alpha1.SCMTypeSubversion
+	g.Expect(scmScript(db, recipe)).Should(Equal("echo \"Checking out $(params.URL) at revision $(params.HASH)\" && svn checkout --non-interactive --revision $(params.HASH) $(params.URL) $(workspaces.source.path)/source && cd $(workspaces.source.path)/source"))
+	db.Spec.ScmInfo.SCMType = v1alpha1.SCMTypeMercurial
+	g.Expect(scmScript(db, recipe)).Should(Equal("echo \"Cloning $(params.URL) and updating to $(params.HASH)\" && hg clone --noupdate $(params.URL) $(workspaces.source.path)/source && cd $(workspaces.source.path)/source && hg update --clean --rev $(params.HASH)"))
+
+	db.Name = "test"
+	ps := buildPipeline(g, &v1alpha1.JBSConfig{}, newTestRecipe(), db)
+	g.Expect(stepNamed(ps.Tasks[0], "git-clone-and-settings").Script).Should(HavePrefix(scmScript(db, recipe) + "\n"))
+
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db.Spec.ScmInfo.Private = true
+	_, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, newTestRecipe(), db, nil, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("private hg repositories are not supported"))
+	db.Spec.ScmInfo.Private = false
+	db.Spec.ScmInfo.SCMType = "cvs"
+	_, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, newTestRecipe(), db, nil, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("unsupported SCM type \"cvs\""))
+}
+
+func TestGitCredentialsCleanup(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cleanup := "rm -f $HOME/.git-credentials $HOME/.gitconfig"
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17"}
+	// Nothing is written for public repositories
+	g.Expect(gitScript(db, recipe)).ShouldNot(ContainSubstring(cleanup))
+
+	db.Spec.ScmInfo.Private = true
+	script := gitScript(db, recipe)
+	g.Expect(script).Should(HaveSuffix(" && " + cleanup))
+	g.Expect(strings.Index(script, cleanup)).Should(BeNumerically(">", strings.Index(script, "git submodule update")))
+	db.Spec.ScmInfo.Private = false
+	recipe.SubmoduleCredentials = []v1alpha1.SubmoduleCredential{{Host: "gitlab.example.com", SecretName: "gitlab-secret"}}
+	g.Expect(gitScript(db, recipe)).Should(HaveSuffix(" && " + cleanup))
+
+	// Recipes can only read the secrets the JBSConfig allows
+	jbsConfig := &v1alpha1.JBSConfig{}
+	_, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("submodule credentials secret \"gitlab-secret\" is not in the allowed submodule secrets"))
+	jbsConfig.Spec.BuildSettings.AllowedSubmoduleSecrets = []string{"gitlab-secret"}
+
+	// The credentials are removed before the pre-build image is archived
+	ps, df, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	clone, archive := -1, -1
+	for _, task := range ps.Tasks {
+		if task.Name != PreBuildTaskName {
+			continue
+		}
+		for i, step := range task.TaskSpec.Steps {
+			if strings.Contains(step.Script, cleanup) {
+				clone = i
+			}
+			if strings.Contains(step.Script, "create-archive") {
+				archive = i
+			}
+		}
+	}
+	g.Expect(clone).Should(BeNumerically(">=", 0))
+	g.Expect(archive).Should(BeNumerically(">", clone))
+	// The diagnostic image removes them in the same layer they are written
+	for _, instruction := range strings.Split(df, "\nRUN ") {
+		if strings.Contains(instruction, ".git-credentials") {
+			g.Expect(instruction).Should(ContainSubstring(cleanup))
+		}
+	}
+}
+
+func TestAdditionalPackagesRpmSignature(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{AdditionalDownloads: []v1alpha1.AdditionalDownload{{FileType: "rpm", PackageName: "glibc-devel"}}}
+	install := additionalPackages(recipe)
+	g.Expect(install).Should(ContainSubstring("if [ -n \"\" ]; then"))
+	g.Expect(install).Should(ContainSubstring("PACKAGE=\"glibc-devel\""))
+
+	recipe.AdditionalDownloads[0].GpgKeyUri = "https://example.com/RPM-GPG-KEY"
+	install = additionalPackages(recipe)
+	g.Expect(install).Should(HavePrefix("echo 'Uri not specified for signed rpm package glibc-devel'; exit 1"))
+
+	recipe.AdditionalDownloads[0].Uri = "https://example.com/glibc-devel.rpm"
+	install = additionalPackages(recipe)
+	g.Expect(install).ShouldNot(ContainSubstring("Uri not specified"))
+	g.Expect(install).Should(ContainSubstring("rpm --import https://example.com/RPM-GPG-KEY"))
+	g.Expect(install).Should(ContainSubstring("rpm -Kv $(workspaces.source.path)/packages/package-0.rpm > $(workspaces.source.path)/packages/package-0.checksig"))
+	// An unsigned package only has digest lines, so a valid signature line must be present
+	g.Expect(install).Should(ContainSubstring("|| ! grep -q -E \"Signature, key ID [0-9a-f]+: OK\" $(workspaces.source.path)/packages/package-0.checksig; then"))
+	g.Expect(install).Should(ContainSubstring("echo \"GPG signature verification failed for package glibc-devel\"\n            exit 1"))
+	g.Expect(install).Should(ContainSubstring("if [ -n \"\" ] && ! echo"))
+
+	recipe.AdditionalDownloads[0].Sha256 = "abc123"
+	install = additionalPackages(recipe)
+	g.Expect(install).Should(ContainSubstring("if [ -n \"abc123\" ] && ! echo \"abc123 $(workspaces.source.path)/packages/package-0.rpm\" | sha256sum --check -; then"))
+	g.Expect(strings.Index(install, "sha256sum")).Should(BeNumerically("<", strings.Index(install, "rpm -Kv")))
+}
+
+func TestActiveProcessorCount(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{JavaVersion: "8"}
+	limits, err := memoryLimits(jbsConfig, 0, 0)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(activeProcessorCount(jbsConfig, recipe, limits)).Should(BeEmpty())
+
+	jbsConfig.Spec.BuildSettings.ActiveProcessorCount = true
+	g.Expect(activeProcessorCount(jbsConfig, recipe, limits)).Should(Equal("-XX:+IgnoreUnrecognizedVMOptions -XX:ActiveProcessorCount=2"))
+	// Partial CPUs are rounded up
+	limits, err = memoryLimits(jbsConfig, 0, 500)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(activeProcessorCount(jbsConfig, recipe, limits)).Should(Equal("-XX:+IgnoreUnrecognizedVMOptions -XX:ActiveProcessorCount=3"))
+	jbsConfig.Spec.BuildSettings.BuildLimitCPU = "100m"
+	limits, err = memoryLimits(jbsConfig, 0, 0)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(activeProcessorCount(jbsConfig, recipe, limits)).Should(Equal("-XX:+IgnoreUnrecognizedVMOptions -XX:ActiveProcessorCount=1"))
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	recipe.Image = "quay.io/foo/builder:latest"
+	recipe.Tool = "maven"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	script := ps.Tasks[0].TaskSpec.Steps[0].Script
+	for _, task := range ps.Tasks {
+		for _, step := range task.TaskSpec.Steps {
+			// JAVA_TOOL_OPTIONS from the builder image must not be replaced
+			g.Expect(step.Env).ShouldNot(ContainElement(HaveField("Name", "JAVA_TOOL_OPTIONS")))
+			if strings.Contains(step.Script, "ActiveProcessorCount") {
+				script = step.Script
+			}
+		}
+	}
+	g.Expect(script).Should(ContainSubstring("export JAVA_TOOL_OPTIONS=\"${JAVA_TOOL_OPTIONS:-} -XX:+IgnoreUnrecognizedVMOptions -XX:ActiveProcessorCount=1\"\n"))
+
+	recipe.JavaVersion = "7"
+	g.Expect(activeProcessorCount(jbsConfig, recipe, limits)).Should(BeEmpty())
+	recipe.JavaVersion = "17"
+	g.Expect(activeProcessorCount(jbsConfig, recipe, limits)).Should(BeEmpty())
+}
+
+func TestEgressAllowlist(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := newTestRecipe()
+	stepNames := func() []string {
+		ps := buildPipeline(g, jbsConfig, recipe, &v1alpha1.DependencyBuild{})
+		names := []string{}
+		for _, i := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+			names = append(names, i.Name)
+		}
+		return names
+	}
+	g.Expect(stepNames()).ShouldNot(ContainElement("restrict-egress"))
+	g.Expect(stepNames()).ShouldNot(ContainElement("lift-egress-restriction"))
+
+	jbsConfig.Spec.BuildSettings.EgressAllowlist = v1alpha1.EgressAllowlist{Enabled: true, AllowedHosts: []string{"repo.example.com", "10.0.0.0/8"}}
+	_, _, _, _, err := createPipelineSpec(logr.Discard(), recipe.Tool, 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("the egress allowlist is enabled but has no image to apply it"))
+
+	jbsConfig.Spec.BuildSettings.EgressAllowlist.Image = "quay.io/foo/iptables:1.0"
+	ps := buildPipeline(g, jbsConfig, recipe, &v1alpha1.DependencyBuild{})
+	task := ps.Tasks[len(ps.Tasks)-1]
+	g.Expect(stepNames()).Should(ContainElements("restrict-egress", BuildTaskName, "lift-egress-restriction"))
+	names := stepNames()
+	for i, name := range names {
+		if name == BuildTaskName {
+			g.Expect(names[i-1]).Should(Equal("restrict-egress"))
+			g.Expect(names[i+1]).Should(Equal("lift-egress-restriction"))
+		}
+	}
+	restrict := stepNamed(task, "restrict-egress")
+	g.Expect(restrict.Image).Should(Equal("quay.io/foo/iptables:1.0"))
+	g.Expect(restrict.SecurityContext.Capabilities.Add).Should(Equal([]v1.Capability{"NET_ADMIN"}))
+	g.Expect(restrict.Script).Should(ContainSubstring("CACHE_HOST=$(echo \"$(params.CACHE_URL)\" | sed -e 's|^[a-z]*://||' -e 's|[:/].*$||')\n"))
+	g.Expect(restrict.Script).Should(ContainSubstring("for host in \"$CACHE_HOST\" 'repo.example.com' '10.0.0.0/8'; do\n"))
+	g.Expect(restrict.Script).Should(ContainSubstring("iptables -A JBS_EGRESS -j LOG --log-prefix \"jbs-egress-denied: \"\niptables -A JBS_EGRESS -j REJECT\niptables -A OUTPUT -j JBS_EGRESS"))
+	g.Expect(stepNamed(task, "lift-egress-restriction").Script).Should(ContainSubstring("iptables -L JBS_EGRESS -v -n -x\niptables -D OUTPUT -j JBS_EGRESS\n"))
+	// The build step itself can't change the rules
+	g.Expect(stepNamed(task, BuildTaskName).SecurityContext.Capabilities).Should(BeNil())
+
+	// A failed workspace is pushed once the restriction has been lifted
+	jbsConfig.Spec.BuildSettings.KeepFailedWorkspace = true
+	names = stepNames()
+	for i, name := range names {
+		if name == BuildTaskName {
+			g.Expect(names[i+1 : i+3]).Should(Equal([]string{"lift-egress-restriction", "push-failed-workspace"}))
+		}
+	}
+}
+
+func TestRunAsUser(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.BuildSettings.KeepFailedWorkspace = true
+	jbsConfig.Spec.MavenDeployment.ValidateChecksums = true
+	jbsConfig.Spec.BuildSettings.EgressAllowlist = v1alpha1.EgressAllowlist{Enabled: true, Image: "quay.io/foo/iptables:1.0"}
+	recipe := newTestRecipe()
+	users := func() map[string]int64 {
+		ret := map[string]int64{}
+		ps := buildPipeline(g, jbsConfig, recipe, &v1alpha1.DependencyBuild{})
+		deploy, err := createDeployPipelineSpec(jbsConfig, &v1alpha1.DependencyBuild{}, "quay.io/foo/processor:1.0", "gav")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range append(append(ps.Tasks, ps.Finally...), deploy.Tasks...) {
+			for _, step := range task.TaskSpec.Steps {
+				g.Expect(step.SecurityContext).ShouldNot(BeNil(), step.Name)
+				g.Expect(step.SecurityContext.RunAsUser).ShouldNot(BeNil(), step.Name)
+				ret[task.Name+"/"+step.Name] = *step.SecurityContext.RunAsUser
+			}
+		}
+		return ret
+	}
+	g.Expect(users()).Should(HaveKeyWithValue(PreBuildTaskName+"/preprocessor", int64(0)))
+	g.Expect(users()).Should(HaveKeyWithValue(FailedWorkspaceTaskName+"/record-failed-workspace", int64(0)))
+	for _, user := range users() {
+		g.Expect(user).Should(Equal(int64(0)))
+	}
+
+	jbsConfig.Spec.BuildSettings.RunAsUser = 1001
+	for step, user := range users() {
+		if strings.HasSuffix(step, "/restrict-egress") || strings.HasSuffix(step, "/lift-egress-restriction") {
+			// Changing the firewall rules needs root
+			g.Expect(user).Should(Equal(int64(0)), step)
+		} else {
+			g.Expect(user).Should(Equal(int64(1001)), step)
+		}
+	}
+	g.Expect(users()).Should(HaveKeyWithValue(BuildTaskName+"/"+BuildTaskName, int64(1001)))
+	g.Expect(users()).Should(HaveKeyWithValue(BuildTaskName+"/restrict-egress", int64(0)))
+}
+
+func TestFlightRecording(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := newTestRecipe()
+	// The build tool section is in the build script written by the pre-build, so all the scripts are checked
+	scripts := func() string {
+		ret := ""
+		for _, task := range buildPipeline(g, jbsConfig, recipe, &v1alpha1.DependencyBuild{}).Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				g.Expect(step.Env).ShouldNot(ContainElement(HaveField("Name", "JAVA_TOOL_OPTIONS")))
+				ret += step.Script
+			}
+		}
+		return ret
+	}
+	g.Expect(scripts()).ShouldNot(ContainSubstring("StartFlightRecording"))
+	g.Expect(scripts()).ShouldNot(ContainSubstring(FlightRecordingDir))
+
+	jbsConfig.Spec.BuildSettings.FlightRecording = true
+	g.Expect(scripts()).Should(ContainSubstring("mkdir -p $(workspaces.source.path)/logs/jfr\nexport JAVA_TOOL_OPTIONS=\"${JAVA_TOOL_OPTIONS:-} -XX:StartFlightRecording=dumponexit=true,filename=$(workspaces.source.path)/logs/jfr/\"\n"))
+
+	// The recording is added to the processor count rather than replacing it
+	recipe.JavaVersion = "8"
+	jbsConfig.Spec.BuildSettings.ActiveProcessorCount = true
+	g.Expect(scripts()).Should(ContainSubstring("export JAVA_TOOL_OPTIONS=\"${JAVA_TOOL_OPTIONS:-} -XX:+IgnoreUnrecognizedVMOptions -XX:ActiveProcessorCount=2 -XX:StartFlightRecording=dumponexit=true,filename=$(workspaces.source.path)/logs/jfr/\"\n"))
+}
+
+func TestAdditionalPackagesZip(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{AdditionalDownloads: []v1alpha1.AdditionalDownload{{FileType: "zip", Uri: "https://example.com/toolchain.zip", Sha256: "abc123"}}}
+	g.Expect(additionalPackages(recipe)).Should(HavePrefix("echo 'Binary path not specified for package https://example.com/toolchain.zip'; exit 1"))
+
+	recipe.AdditionalDownloads[0].BinaryPath = "toolchain/bin"
+	install := additionalPackages(recipe)
+	g.Expect(install).ShouldNot(ContainSubstring("not specified"))
+	g.Expect(install).Should(ContainSubstring("wget --no-verbose --output-document=$(workspaces.source.path)/packages/package-0 https://example.com/toolchain.zip"))
+	g.Expect(install).Should(ContainSubstring("if [ -n \"abc123\" ] && ! echo \"abc123 $(workspaces.source.path)/packages/package-0\" | sha256sum --check -; then"))
+	g.Expect(install).Should(ContainSubstring("unzip -q $(workspaces.source.path)/packages/package-0 -d $(workspaces.source.path)/packages/package-0-extracted"))
+	g.Expect(install).Should(ContainSubstring("export PATH=\"$(workspaces.source.path)/packages/package-0-extracted/toolchain/bin:${PATH}\""))
+	g.Expect(strings.Index(install, "wget")).Should(BeNumerically("<", strings.Index(install, "unzip")))
+	g.Expect(strings.Index(install, "sha256sum")).Should(BeNumerically("<", strings.Index(install, "unzip")))
+
+	recipe.AdditionalDownloads[0].FileType = "7z"
+	g.Expect(additionalPackages(recipe)).Should(Equal("echo 'Unknown file type 7z for package https://example.com/toolchain.zip'; exit 1"))
+}
+
+func TestAdditionalPackagesChecksums(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{AdditionalDownloads: []v1alpha1.AdditionalDownload{{FileType: "executable", FileName: "tool", Uri: "https://example.com/tool"}}}
+	g.Expect(additionalPackages(recipe)).Should(HavePrefix("echo 'Checksum not specified for package tool'; exit 1"))
+	// The URI is only used if there is no name
+	tar := &v1alpha1.BuildRecipe{AdditionalDownloads: []v1alpha1.AdditionalDownload{{FileType: "tar", BinaryPath: "bin", Uri: "https://example.com/tool.tar.gz"}}}
+	g.Expect(additionalPackages(tar)).Should(Equal("echo 'Checksum not specified for package https://example.com/tool.tar.gz'; exit 1\n"))
+	// The script fails with the message, and no download of the package follows
+	out, err := exec.Command("sh", "-c", additionalPackages(recipe)).CombinedOutput()
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.(*exec.ExitError).ExitCode()).Should(Equal(1))
+	g.Expect(string(out)).Should(Equal("Checksum not specified for package tool\n"))
+	// The packages before it are kept
+	verified := v1alpha1.AdditionalDownload{FileType: "executable", FileName: "other", Uri: "https://example.com/other", Sha256: "abc123"}
+	recipe.AdditionalDownloads = []v1alpha1.AdditionalDownload{verified, recipe.AdditionalDownloads[0]}
+	install := additionalPackages(recipe)
+	g.Expect(install).Should(ContainSubstring("https://example.com/other"))
+	g.Expect(install).Should(HaveSuffix("echo 'Checksum not specified for package tool'; exit 1\n"))
+	recipe.AdditionalDownloads = recipe.AdditionalDownloads[1:]
+
+	recipe.AdditionalDownloads[0].Sha256 = "abc123"
+	install = additionalPackages(recipe)
+	g.Expect(install).ShouldNot(ContainSubstring("not specified"))
+	g.Expect(install).Should(ContainSubstring("if [ -n \"abc123\" ] && ! echo \"abc123 $(workspaces.source.path)/packages/tool\" | sha256sum --check -; then"))
+	g.Expect(install).Should(ContainSubstring("if [ -n \"\" ] && ! echo \" $(workspaces.source.path)/packages/tool\" | sha512sum --check -; then"))
+
+	recipe.AdditionalDownloads[0].Sha256 = ""
+	recipe.AdditionalDownloads[0].Sha512 = "def456"
+	install = additionalPackages(recipe)
+	g.Expect(install).ShouldNot(ContainSubstring("not specified"))
+	g.Expect(install).Should(ContainSubstring("if [ -n \"def456\" ] && ! echo \"def456 $(workspaces.source.path)/packages/tool\" | sha512sum --check -; then"))
+	g.Expect(install).Should(ContainSubstring("echo \"SHA512 verification failed for package https://example.com/tool\""))
+
+	// Both are verified if set
+	recipe.AdditionalDownloads[0].Sha256 = "abc123"
+	install = additionalPackages(recipe)
+	g.Expect(install).Should(ContainSubstring("echo \"abc123 $(workspaces.source.path)/packages/tool\" | sha256sum --check -"))
+	g.Expect(install).Should(ContainSubstring("echo \"def456 $(workspaces.source.path)/packages/tool\" | sha512sum --check -"))
+
+	// A signed rpm does not require a checksum but verifies one if set
+	recipe = &v1alpha1.BuildRecipe{AdditionalDownloads: []v1alpha1.AdditionalDownload{{FileType: "rpm", PackageName: "glibc-devel", Uri: "https://example.com/glibc-devel.rpm", GpgKeyUri: "https://example.com/RPM-GPG-KEY", Sha512: "def456"}}}
+	install = additionalPackages(recipe)
+	g.Expect(install).ShouldNot(ContainSubstring("not specified"))
+	g.Expect(install).Should(ContainSubstring("if [ -n \"def456\" ] && ! echo \"def456 $(workspaces.source.path)/packages/package-0.rpm\" | sha512sum --check -; then"))
+	g.Expect(install).Should(ContainSubstring("echo \"SHA512 verification failed for package glibc-devel\""))
+}
+
+func TestVerificationOutputFormat(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{}
+	args := verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe)
+	g.Expect(args).Should(ContainElement("--report-only"))
+	g.Expect(strings.Join(args, " ")).ShouldNot(ContainSubstring("--output-format"))
+
+	jbsConfig.Spec.VerificationOutputFormat = v1alpha1.VerificationOutputFormatText
+	g.Expect(strings.Join(verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe), " ")).ShouldNot(ContainSubstring("--diff-file"))
+
+	jbsConfig.Spec.VerificationOutputFormat = v1alpha1.VerificationOutputFormatJson
+	args = verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe)
+	g.Expect(args).Should(ContainElement("--report-only"))
+	g.Expect(args).Should(ContainElement("--output-format=json"))
+	g.Expect(args).Should(ContainElement("--diff-file=$(workspaces.source.path)/logs/" + VerificationDiffFile))
+	g.Expect(args).Should(ContainElement("--diff-summary-file=$(results." + PipelineResultVerificationDiff + ".path)"))
+	// The unbounded diff must never be written to a task result
+	g.Expect(strings.Join(args, " ")).ShouldNot(ContainSubstring("--diff-file=$(results."))
+
+	jbsConfig.Spec.RequireArtifactVerification = true
+	args = verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe)
+	g.Expect(args).ShouldNot(ContainElement("--report-only"))
+	g.Expect(args).Should(ContainElement("--output-format=json"))
+
+	recipe = &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17"}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).Should(BeNil())
+	g.Expect(ps.Results).Should(ContainElement(HaveField("Name", PipelineResultVerificationDiff)))
+}
+
+func TestDeployChecksumValidation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db := &v1alpha1.DependencyBuild{}
+	stepNames := func() []string {
+		ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		ret := []string{}
+		for _, s := range ps.Tasks[0].TaskSpec.Steps {
+			ret = append(ret, s.Name)
+		}
+		return ret
+	}
+	g.Expect(stepNames()).Should(Equal([]string{"restore-post-build-artifacts", "maven-deployment", "tag"}))
+	_, postBuildImageArgs, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(postBuildImageArgs).ShouldNot(ContainSubstring(ArtifactChecksumsFile))
+
+	jbsConfig.Spec.MavenDeployment.ValidateChecksums = true
+	g.Expect(stepNames()).Should(Equal([]string{"restore-post-build-artifacts", "validate-artifact-checksums", "maven-deployment", "tag"}))
+	_, postBuildImageArgs, _, _, _ = pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("sha256sum {} + > $(workspaces.source.path)/logs/" + ArtifactChecksumsFile))
+	g.Expect(strings.Index(postBuildImageArgs, ArtifactChecksumsFile)).Should(BeNumerically("<", strings.Index(postBuildImageArgs, "create-archive")))
+
+	// The digest of the manifest is a build result so it is known before the image is pushed
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("> $(results." + PipelineResultArtifactChecksums + ".path)"))
+	g.Expect(strings.Index(postBuildImageArgs, PipelineResultArtifactChecksums)).Should(BeNumerically("<", strings.Index(postBuildImageArgs, "create-archive")))
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, &v1alpha1.BuildRecipe{}, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Results).Should(ContainElement(HaveField("Name", PipelineResultArtifactChecksums)))
+
+	deploy, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(deploy.Params).Should(ContainElement(HaveField("Name", PipelineResultArtifactChecksums)))
+	g.Expect(deploy.Tasks[0].Params).Should(ContainElement(HaveField("Name", PipelineResultArtifactChecksums)))
+	// The logs layer is restored once, alongside the artifacts
+	restore := deploy.Tasks[0].TaskSpec.Steps[0].Script
+	g.Expect(restore).Should(ContainSubstring("jq --raw-output '.layers[1].digest'"))
+	g.Expect(restore).Should(ContainSubstring("=$(workspaces.source.path)/logs"))
+
+	script := deploy.Tasks[0].TaskSpec.Steps[1].Script
+	g.Expect(script).ShouldNot(ContainSubstring("use-archive"))
+	g.Expect(script).Should(ContainSubstring("EXPECTED=$(params." + PipelineResultArtifactChecksums + ")"))
+	g.Expect(script).Should(ContainSubstring(`echo "$EXPECTED  $MANIFEST" | sha256sum --check --quiet -`))
+	g.Expect(script).Should(ContainSubstring("sha256sum --check --quiet $MANIFEST"))
+	g.Expect(strings.Index(script, "$EXPECTED  $MANIFEST")).Should(BeNumerically("<", strings.Index(script, "--check --quiet $MANIFEST")))
+}
+
+func TestPreBuildScriptIsolation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{PreBuildScript: "export FOO=bar\ncd subdir"}
+	g.Expect(preBuildScript(recipe)).Should(Equal(recipe.PreBuildScript))
+
+	recipe.IsolatePreBuildScript = true
+	script := preBuildScript(recipe)
+	g.Expect(script).Should(ContainSubstring("export JBS_PRE_BUILD_ENV=" + PreBuildEnvFile + "\n"))
+	g.Expect(script).Should(ContainSubstring("(\nexport FOO=bar\ncd subdir\n)\n"))
+	g.Expect(script).Should(HaveSuffix("set -a\n. \"$JBS_PRE_BUILD_ENV\"\nset +a\n"))
+	g.Expect(strings.Index(script, ": > \"$JBS_PRE_BUILD_ENV\"")).Should(BeNumerically("<", strings.Index(script, "(\n")))
+
+	recipe.PreBuildScript = ""
+	g.Expect(preBuildScript(recipe)).Should(BeEmpty())
+}
+
+func TestAllowedContaminantArgs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{}
+	g.Expect(allowedContaminantArgs(recipe)).Should(BeEmpty())
+
+	recipe.AllowedContaminants = []string{"io.netty:*", "com.google.*:guava"}
+	g.Expect(allowedContaminantArgs(recipe)).Should(Equal([]string{"--allowed-contaminant=io.netty:*", "--allowed-contaminant=com.google.*:guava"}))
+}
+
+func TestLeinBuild(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "lein", JavaVersion: "17", ToolVersions: map[string]string{"lein": "2.11.2", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	paramValues := []tektonpipeline.Param{{Name: PipelineParamGoals, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: []string{"deploy", "jbs"}}}}
+	ps, df, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "lein", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, paramValues, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	var preprocessor, build, buildScript *tektonpipeline.Step
+	for _, task := range ps.Tasks {
+		for i, step := range task.TaskSpec.Steps {
+			switch {
+			case step.Name == "preprocessor":
+				preprocessor = &task.TaskSpec.Steps[i]
+			case step.Name == BuildTaskName:
+				build = &task.TaskSpec.Steps[i]
+			case strings.Contains(step.Script, "/build.sh <<'RHTAPEOF'"):
+				buildScript = &task.TaskSpec.Steps[i]
+			}
+		}
+	}
+	g.Expect(preprocessor).ShouldNot(BeNil())
+	g.Expect(preprocessor.Script).Should(ContainSubstring("lein-prepare"))
+	g.Expect(build).ShouldNot(BeNil())
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: "LEIN_HOME", Value: "/opt/lein/2.11.2"}))
+
+	// The profile is written to a per-build home rather than the tool install directory
+	g.Expect(buildScript).ShouldNot(BeNil())
+	g.Expect(buildScript.Script).Should(ContainSubstring("export LEIN_HOME=\"$(workspaces.source.path)/lein-home\""))
+	g.Expect(buildScript.Script).Should(ContainSubstring("{:user {:mirrors {#\".+\" {:url \"$(params.CACHE_URL)\"}}"))
+
+	// The diagnostic Dockerfile and Konflux build script have the Tekton references substituted
+	g.Expect(df).Should(ContainSubstring("FROM quay.io/foo/builder:latest"))
+	g.Expect(konfluxScript).Should(ContainSubstring("export LEIN_HOME=/opt/lein/2.11.2\n"))
+	g.Expect(konfluxScript).Should(ContainSubstring("export LEIN_HOME=\"/root/project/lein-home\""))
+	g.Expect(konfluxScript).Should(ContainSubstring("{:url \"http://localhost:8080/v2/cache/rebuild/0/\"}"))
+	g.Expect(konfluxScript).Should(ContainSubstring("[\"jbs\" {:url \"file:/root/project/artifacts\" :sign-releases false}]"))
+	g.Expect(konfluxScript).Should(ContainSubstring("set -- \"$@\" deploy jbs"))
+	g.Expect(konfluxScript).ShouldNot(ContainSubstring("$(workspaces."))
+	g.Expect(konfluxScript).ShouldNot(ContainSubstring("$(params.CACHE_URL)"))
+}
+
+func TestVerifyOnlyBuild(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	buildSteps := func(db *v1alpha1.DependencyBuild) ([]string, []string) {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		steps := []string{}
+		for _, step := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+			steps = append(steps, step.Name)
+		}
+		results := []string{}
+		for _, result := range ps.Results {
+			results = append(results, result.Name)
+		}
+		return steps, results
+	}
+
+	allSteps, results := buildSteps(&v1alpha1.DependencyBuild{})
+	g.Expect(allSteps).Should(ContainElement("create-post-build-image"))
+	g.Expect(results).Should(ContainElements(PipelineResultImage, PipelineResultImageDigest))
+
+	steps, results := buildSteps(&v1alpha1.DependencyBuild{Spec: v1alpha1.DependencyBuildSpec{VerifyOnly: true}})
+	g.Expect(steps).Should(ContainElement("verify-and-check-for-contaminates"))
+	g.Expect(steps).ShouldNot(ContainElement("create-post-build-image"))
+	// Only the post-build image step is removed, wherever it is
+	g.Expect(steps).Should(HaveLen(len(allSteps) - 1))
+	g.Expect(results).Should(ContainElement(PipelineResultPassedVerification))
+	g.Expect(results).ShouldNot(ContainElement(PipelineResultImage))
+	g.Expect(results).ShouldNot(ContainElement(PipelineResultImageDigest))
+}
+
+func TestJarValidationArgs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	g.Expect(jarValidationArgs(jbsConfig)).Should(BeNil())
+
+	jbsConfig.Spec.JarValidation.RequireMultiRelease = true
+	g.Expect(jarValidationArgs(jbsConfig)).Should(Equal([]string{"validate-jars", "--path=$(workspaces.source.path)/artifacts", "--require-multi-release"}))
+
+	jbsConfig.Spec.JarValidation.RequireModuleInfo = true
+	jbsConfig.Spec.JarValidation.RequiredManifestAttributes = []string{"Automatic-Module-Name", "Implementation-Version"}
+	g.Expect(jarValidationArgs(jbsConfig)).Should(Equal([]string{"validate-jars", "--path=$(workspaces.source.path)/artifacts", "--require-multi-release", "--require-module-info",
+		"--require-manifest-attribute=Automatic-Module-Name", "--require-manifest-attribute=Implementation-Version"}))
+
+	// The validation runs after the verification and before the contaminant check and deployment
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	var script string
+	for _, step := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+		if step.Name == "verify-and-check-for-contaminates" {
+			script = step.Script
+		}
+	}
+	g.Expect(script).Should(ContainSubstring("\"validate-jars\" \"--path=$(workspaces.source.path)/artifacts\" \"--require-multi-release\""))
+	g.Expect(strings.Index(script, "\"verify-built-artifacts\"")).Should(BeNumerically("<", strings.Index(script, "\"validate-jars\"")))
+	g.Expect(strings.Index(script, "\"validate-jars\"")).Should(BeNumerically("<", strings.Index(script, "\"verify\"")))
+}
+
+func TestJavaHome(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(javaHome(&v1alpha1.BuildRecipe{}, "8")).Should(Equal("/lib/jvm/java-1.8.0"))
+	g.Expect(javaHome(&v1alpha1.BuildRecipe{}, "11")).Should(Equal("/lib/jvm/java-11"))
+	g.Expect(javaHome(&v1alpha1.BuildRecipe{}, "17")).Should(Equal("/lib/jvm/java-17"))
+	g.Expect(javaHome(&v1alpha1.BuildRecipe{}, "21")).Should(Equal("/lib/jvm/java-21"))
+	g.Expect(javaHome(&v1alpha1.BuildRecipe{JavaHomeTemplate: "/opt/java/jdk-{VERSION}"}, "21")).Should(Equal("/opt/java/jdk-21"))
+
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", JavaHomeTemplate: "/opt/java/openjdk-{VERSION}", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	found := false
+	for _, step := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+		if step.Name == BuildTaskName {
+			g.Expect(step.Env).Should(ContainElement(v1.EnvVar{Name: JavaHome, Value: "/opt/java/openjdk-17"}))
+			found = true
+		}
+	}
+	g.Expect(found).Should(BeTrue())
+}
+
+func TestLauncherJavaHome(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := newTestRecipe()
+	ps := buildPipeline(g, &v1alpha1.JBSConfig{}, recipe, &v1alpha1.DependencyBuild{})
+	build := stepNamed(ps.Tasks[len(ps.Tasks)-1], BuildTaskName)
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: JavaHome, Value: "/lib/jvm/java-17"}))
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: CompileJavaHome, Value: "/lib/jvm/java-17"}))
+	g.Expect(build.Args).ShouldNot(ContainElement(ContainSubstring("maven.compiler")))
+
+	recipe.LauncherJavaVersion = "21"
+	ps = buildPipeline(g, &v1alpha1.JBSConfig{}, recipe, &v1alpha1.DependencyBuild{})
+	build = stepNamed(ps.Tasks[len(ps.Tasks)-1], BuildTaskName)
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: JavaHome, Value: "/lib/jvm/java-21"}))
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: CompileJavaHome, Value: "/lib/jvm/java-17"}))
+	g.Expect(build.Args).Should(ContainElements("-Dmaven.compiler.fork=true", "-Dmaven.compiler.executable=/lib/jvm/java-17/bin/javac"))
+
+	recipe.Tool = "gradle"
+	ps = buildPipeline(g, &v1alpha1.JBSConfig{}, recipe, &v1alpha1.DependencyBuild{})
+	build = stepNamed(ps.Tasks[len(ps.Tasks)-1], BuildTaskName)
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: JavaHome, Value: "/lib/jvm/java-21"}))
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: CompileJavaHome, Value: "/lib/jvm/java-17"}))
+	g.Expect(build.Args).ShouldNot(ContainElement(ContainSubstring("maven.compiler")))
+}
+
+func TestWorkspaceMountPath(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	mounts := func() []string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		deploy, err := createDeployPipelineSpec(jbsConfig, db, "quay.io/foo/processor:1.0", "gav")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		ret := []string{}
+		for _, task := range append(ps.Tasks, deploy.Tasks...) {
+			for _, ws := range task.TaskSpec.Workspaces {
+				if ws.Name == WorkspaceSource {
+					ret = append(ret, ws.MountPath)
+				}
+			}
+		}
+		return ret
+	}
+	g.Expect(mounts()).Should(HaveEach(WorkspaceMount))
+	g.Expect(projectPath(jbsConfig)).Should(Equal(DiagnosticProjectPath))
+
+	jbsConfig.Spec.BuildSettings.WorkspaceMountPath = "/opt/workspace"
+	g.Expect(mounts()).Should(And(HaveLen(3), HaveEach("/opt/workspace")))
+	_, df, kf, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	for _, generated := range []string{df, kf, konfluxScript} {
+		g.Expect(generated).Should(ContainSubstring("/opt/workspace"))
+		g.Expect(generated).ShouldNot(ContainSubstring(DiagnosticProjectPath))
+		g.Expect(generated).ShouldNot(ContainSubstring(WorkspaceMount))
+	}
+	g.Expect(kf).Should(ContainSubstring("\nCOPY . /opt/workspace/source/"))
+	g.Expect(doSubstitution("$(workspaces.source.path)/artifacts $(workspaces.tls.path)", []tektonpipeline.Param{}, 0, "", projectPath(jbsConfig))).Should(Equal("/opt/workspace/artifacts /opt/workspace/tls/service-ca.crt"))
+}
+
+func TestToolVersionsResult(t *testing.T) {
+	g := NewGomegaWithT(t)
+	toolHomes := map[string]string{"maven": "MAVEN_HOME", "gradle": "GRADLE_HOME", "ant": "ANT_HOME", "lein": "LEIN_HOME", "sbt": "SBT_DIST"}
+	for tool, home := range toolHomes {
+		recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: tool, JavaVersion: "17", ToolVersion: "1.0", ToolVersions: map[string]string{tool: "1.0", "jdk": "17"}}
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), tool, 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+
+		results := []string{}
+		for _, result := range ps.Results {
+			results = append(results, result.Name)
+		}
+		g.Expect(results).Should(ContainElement(PipelineResultToolVersions), tool)
+		var build *tektonpipeline.Step
+		for i, step := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+			if step.Name == BuildTaskName {
+				build = &ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps[i]
+			}
+		}
+		g.Expect(build).ShouldNot(BeNil())
+		g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: home, Value: "/opt/" + tool + "/1.0"}), tool)
+		g.Expect(build.Script).Should(HavePrefix("echo -n \""+home+"=$"+home+" TOOL_VERSION=$TOOL_VERSION JAVA_HOME=$JAVA_HOME COMPILE_JAVA_HOME=$COMPILE_JAVA_HOME\" > $(results."+PipelineResultToolVersions+".path)\n"), tool)
+	}
+}
+
+func TestResolveToolSymlinks(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	toolEnv := []v1.EnvVar{
+		{Name: "MAVEN_HOME", Value: "/opt/maven/3.8.8"},
+		{Name: "SBT_DIST", Value: "/opt/sbt/1.8.0"},
+		{Name: PipelineParamToolVersion, Value: "3.8.8"},
+		{Name: JavaHome, Value: "/lib/jvm/java-17"},
+	}
+	// Symlinks are kept by default
+	g.Expect(resolveToolSymlinksScript(jbsConfig, toolEnv)).Should(BeEmpty())
+
+	jbsConfig.Spec.BuildSettings.ResolveToolSymlinks = true
+	g.Expect(resolveToolSymlinksScript(jbsConfig, toolEnv)).Should(Equal(`export MAVEN_HOME="$(readlink -f "$MAVEN_HOME")"
+export SBT_DIST="$(readlink -f "$SBT_DIST")"
+export JAVA_HOME="$(readlink -f "$JAVA_HOME")"
+`))
+
+	// The locations are resolved before the build and its tool versions result, and in the konflux script
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	ps, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	for _, step := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+		if step.Name == BuildTaskName {
+			g.Expect(step.Script).Should(HavePrefix("export MAVEN_HOME=\"$(readlink -f \"$MAVEN_HOME\")\"\n"))
+			g.Expect(strings.Index(step.Script, "readlink")).Should(BeNumerically("<", strings.Index(step.Script, PipelineResultToolVersions)))
+		}
+	}
+	g.Expect(konfluxScript).Should(ContainSubstring("export MAVEN_HOME=/opt/maven/3.8.8\n"))
+	g.Expect(konfluxScript).Should(ContainSubstring("export MAVEN_HOME=\"$(readlink -f \"$MAVEN_HOME\")\"\n"))
+}
+
+func TestBuildRetries(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	retries := func() map[string]int {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		ret := map[string]int{}
+		for _, task := range ps.Tasks {
+			ret[task.Name] = task.Retries
+		}
+		return ret
+	}
+	g.Expect(retries()).Should(Equal(map[string]int{PreBuildTaskName: 0, BuildTaskName: 0}))
+
+	// Only the build task is retried
+	recipe.Retries = 2
+	g.Expect(retries()).Should(Equal(map[string]int{PreBuildTaskName: 0, BuildTaskName: 2}))
+
+	recipe.Retries = 100
+	g.Expect(retries()[BuildTaskName]).Should(Equal(MaxBuildRetries))
+	recipe.Retries = -1
+	g.Expect(retries()[BuildTaskName]).Should(Equal(0))
+}
+
+func TestRepositoryWithTagOrDigest(t *testing.T) {
+	g := NewGomegaWithT(t)
+	digest := "@sha256:" + strings.Repeat("a", 64)
+	for _, repository := range []string{"deployments", "deployments:latest", "deployments" + digest, "deployments:latest" + digest} {
+		jbsConfig := &v1alpha1.JBSConfig{}
+		jbsConfig.Spec.Registry.Owner = "owner"
+		jbsConfig.Spec.Registry.Repository = repository
+		g.Expect(registryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("quay.io/owner/deployments:tag"), repository)
+		g.Expect(registryArgsWithDefaults(jbsConfig, "")).Should(Equal("quay.io/owner/deployments"), repository)
+	}
+
+	// Only a trailing tag is removed, the host port and nested paths are kept
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Host = "registry.io"
+	jbsConfig.Spec.Registry.Port = "5000"
+	jbsConfig.Spec.Registry.Repository = "team/deployments:1.0"
+	g.Expect(registryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("registry.io:5000/team/deployments:tag"))
+	jbsConfig.Spec.Registry.Repository = "team.v2/deployments"
+	g.Expect(registryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("registry.io:5000/team.v2/deployments:tag"))
+	// The secondary registry is normalised in the same way
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Host: "mirror.io", Owner: "mirror-owner", Repository: "deployments" + digest}
+	g.Expect(mirrorRegistryArgsWithDefaults(jbsConfig, "tag")).Should(Equal("mirror.io/mirror-owner/deployments:tag"))
+}
+
+func TestCacheServiceUrl(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Namespace = "builds"
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	cacheUrl := func() string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 1234, jbsConfig, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, param := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Params {
+			if param.Name == PipelineParamCacheUrl {
+				return param.Default.StringVal
+			}
+		}
+		return ""
+	}
+	g.Expect(cacheUrl()).Should(Equal("https://jvm-build-workspace-artifact-cache-tls.builds.svc.cluster.local/v2/cache/rebuild/1234"))
+	jbsConfig.Spec.CacheSettings.DisableTLS = true
+	g.Expect(cacheUrl()).Should(Equal("http://jvm-build-workspace-artifact-cache.builds.svc.cluster.local/v2/cache/rebuild/1234"))
+
+	jbsConfig.Spec.CacheSettings.ServiceName = "my-cache"
+	jbsConfig.Spec.CacheSettings.ServiceDomain = "cluster.example"
+	g.Expect(cacheUrl()).Should(Equal("http://my-cache.builds.svc.cluster.example/v2/cache/rebuild/1234"))
+	jbsConfig.Spec.CacheSettings.DisableTLS = false
+	g.Expect(cacheUrl()).Should(Equal("https://my-cache-tls.builds.svc.cluster.example/v2/cache/rebuild/1234"))
+	recipe.Repositories = []string{"jboss", "gradle"}
+	g.Expect(cacheUrl()).Should(Equal("https://my-cache-tls.builds.svc.cluster.example/v2/cache/rebuild-jboss,gradle/1234"))
+	g.Expect(cacheServiceUrl(jbsConfig)).Should(Equal("https://my-cache-tls.builds.svc.cluster.example"))
+}
+
+func TestCachePinTimestamp(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Namespace = "builds"
+	recipe := newTestRecipe()
+	generate := func() (string, string) {
+		ps, df, _, _, err := createPipelineSpec(logr.Discard(), "maven", 1234, jbsConfig, &v1alpha1.SystemConfig{}, recipe, &v1alpha1.DependencyBuild{}, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, param := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Params {
+			if param.Name == PipelineParamCacheUrl {
+				return param.Default.StringVal, df
+			}
+		}
+		return "", df
+	}
+	// The cache is pinned to the commit time by default
+	g.Expect(cachePinTime(jbsConfig, 1234)).Should(Equal(int64(1234)))
+	cacheUrl, df := generate()
+	g.Expect(cacheUrl).Should(HaveSuffix("/v2/cache/rebuild/1234"))
+	g.Expect(df).Should(ContainSubstring("\nENV CACHE_URL=http://localhost:8080/v2/cache/rebuild/1234/\n"))
+
+	// The configured time is used for both the pipeline and the diagnostic Containerfile
+	jbsConfig.Spec.CacheSettings.PinTimestamp = 1600000000
+	g.Expect(cachePinTime(jbsConfig, 1234)).Should(Equal(int64(1600000000)))
+	cacheUrl, df = generate()
+	g.Expect(cacheUrl).Should(Equal("https://jvm-build-workspace-artifact-cache-tls.builds.svc.cluster.local/v2/cache/rebuild/1600000000"))
+	g.Expect(df).Should(ContainSubstring("\nENV CACHE_URL=http://localhost:8080/v2/cache/rebuild/1600000000/\n"))
+	g.Expect(df).ShouldNot(ContainSubstring("/1234/"))
+}
+
+func TestMultiToolBuild(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"},
+		AdditionalTools: []v1alpha1.AdditionalTool{{Tool: "gradle", Version: "8.4", CommandLine: []string{"build", "-x", "test"}}}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	var preprocessor, build *tektonpipeline.Step
+	for _, task := range ps.Tasks {
+		for i, step := range task.TaskSpec.Steps {
+			switch step.Name {
+			case "preprocessor":
+				preprocessor = &task.TaskSpec.Steps[i]
+			case BuildTaskName:
+				build = &task.TaskSpec.Steps[i]
+			}
+		}
+	}
+	// The source is prepared for every tool
+	g.Expect(preprocessor).ShouldNot(BeNil())
+	g.Expect(preprocessor.Script).Should(ContainSubstring("maven-prepare"))
+	g.Expect(preprocessor.Script).Should(ContainSubstring("gradle-prepare"))
+	g.Expect(build).ShouldNot(BeNil())
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: "MAVEN_HOME", Value: "/opt/maven/3.8.8"}))
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: "GRADLE_HOME", Value: "/opt/gradle/8.4"}))
+
+	// Gradle runs with its own arguments once Maven has finished
+	maven := strings.Index(konfluxScript, "Running Maven command")
+	gradle := strings.Index(konfluxScript, "set -- 'build' '-x' 'test'\n")
+	g.Expect(maven).Should(BeNumerically(">", 0))
+	g.Expect(gradle).Should(BeNumerically(">", maven))
+	g.Expect(konfluxScript[gradle:]).Should(ContainSubstring("Running Gradle command"))
+
+	// A pre-build image is only reused for a recipe with the same tools
+	g.Expect(preBuildTools(recipe)).Should(Equal("maven+gradle"))
+	ps, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{"quay.io/foo/builder:latest-maven": "sha256:1234"})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks[0].Name).Should(Equal(PreBuildTaskName))
+	ps, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{"quay.io/foo/builder:latest-maven+gradle": "sha256:1234"})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks[0].Name).ShouldNot(Equal(PreBuildTaskName))
+}
+
+func TestRecipeArchitecture(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, df, kf, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(kf).Should(HavePrefix("FROM quay.io/foo/builder:latest\n"))
+	g.Expect(df).Should(ContainSubstring("\nFROM quay.io/foo/builder:latest\n"))
+	for _, task := range ps.Tasks {
+		g.Expect(task.Params).ShouldNot(ContainElement(HaveField("Name", PipelineParamPlatform)))
+	}
+
+	recipe.Architecture = PlatformLinuxArm64
+	ps, df, kf, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(kf).Should(HavePrefix("FROM --platform=linux/arm64 quay.io/foo/builder:latest\n"))
+	g.Expect(df).Should(ContainSubstring("\nFROM --platform=linux/arm64 quay.io/foo/builder:latest\n"))
+	g.Expect(ps.Tasks).Should(HaveLen(2))
+	for _, task := range ps.Tasks {
+		g.Expect(task.Params).Should(ContainElement(tektonpipeline.Param{Name: PipelineParamPlatform, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: "linux/arm64"}}))
+		g.Expect(task.TaskSpec.Params).Should(ContainElement(HaveField("Name", PipelineParamPlatform)))
+	}
+
+	// A pre-build image is only reused for the same platform
+	g.Expect(preBuildTools(recipe)).Should(Equal("maven@linux/arm64"))
+	ps, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{"quay.io/foo/builder:latest-maven": "sha256:1234"})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks[0].Name).Should(Equal(PreBuildTaskName))
+	ps, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{"quay.io/foo/builder:latest-maven@linux/arm64": "sha256:1234"})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Tasks).Should(HaveLen(1))
+	g.Expect(ps.Tasks[0].Name).Should(Equal(BuildTaskName))
+	g.Expect(ps.Tasks[0].Params).Should(ContainElement(HaveField("Name", PipelineParamPlatform)))
+
+	recipe.Architecture = "windows/amd64"
+	_, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(HaveOccurred())
+}
+
+func TestKeepFailedWorkspace(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "foo"
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ps.Finally).Should(BeEmpty())
+	g.Expect(ps.Results).ShouldNot(ContainElement(HaveField("Name", PipelineResultDebugWorkspace)))
+	buildTask := ps.Tasks[len(ps.Tasks)-1].TaskSpec
+	g.Expect(buildTask.Steps).ShouldNot(ContainElement(HaveField("Name", "push-failed-workspace")))
+	for _, step := range buildTask.Steps {
+		g.Expect(step.OnError).Should(BeEmpty())
+	}
+
+	jbsConfig.Spec.BuildSettings.KeepFailedWorkspace = true
+	ps, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	url := "quay.io/foo/artifact-deployments:build-id-failed-workspace"
+	// The build step carries on to push the workspace if it fails
+	buildTask = ps.Tasks[len(ps.Tasks)-1].TaskSpec
+	var names []string
+	for _, step := range buildTask.Steps {
+		names = append(names, step.Name)
+		if step.Name == BuildTaskName {
+			g.Expect(step.OnError).Should(Equal(tektonpipeline.Continue))
+		} else if step.Name == "push-failed-workspace" {
+			g.Expect(step.Script).Should(HavePrefix("BUILD_EXIT_CODE=$(cat $(steps.step-build.exitCode.path))\n"))
+			g.Expect(step.Script).Should(ContainSubstring("create-archive --store " + url + " /tmp/failed-workspace-digest=$(workspaces.source.path)"))
+			g.Expect(step.Script).Should(HaveSuffix("exit $BUILD_EXIT_CODE"))
+		} else {
+			g.Expect(step.OnError).Should(BeEmpty())
+		}
+	}
+	g.Expect(names[1:3]).Should(Equal([]string{BuildTaskName, "push-failed-workspace"}))
+	// The location is only recorded if the build task failed
+	g.Expect(ps.Finally).Should(HaveLen(1))
+	g.Expect(ps.Finally[0].Name).Should(Equal(FailedWorkspaceTaskName))
+	g.Expect(ps.Finally[0].When).Should(HaveLen(1))
+	g.Expect(ps.Finally[0].When[0].Input).Should(Equal("$(tasks.build.status)"))
+	g.Expect(ps.Finally[0].When[0].Values).Should(Equal([]string{"Failed"}))
+	g.Expect(ps.Finally[0].TaskSpec.Steps[0].Script).Should(ContainSubstring("echo -n \"" + url + "\" > $(results." + PipelineResultDebugWorkspace + ".path)"))
+	g.Expect(ps.Results).Should(ContainElement(tektonpipeline.PipelineResult{Name: PipelineResultDebugWorkspace, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: "$(finally." + FailedWorkspaceTaskName + ".results." + PipelineResultDebugWorkspace + ")"}}))
+}
+
+func TestBuildToolWrapper(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "gradle", JavaVersion: "17", ToolVersions: map[string]string{"gradle": "8.4", "jdk": "17"}}
+	g.Expect(buildToolWrapperScript("gradle", jbsConfig, recipe)).Should(BeEmpty())
+
+	recipe.UseBuildToolWrapper = true
+	// sbt has no wrapper support
+	g.Expect(buildToolWrapperScript("sbt", jbsConfig, recipe)).Should(BeEmpty())
+	script := buildToolWrapperScript("gradle", jbsConfig, recipe)
+	g.Expect(script).Should(HavePrefix("WRAPPER_JAR=\"$(workspaces.source.path)/source/gradle/wrapper/gradle-wrapper.jar\"\n"))
+	g.Expect(script).Should(ContainSubstring("WARNING: build tool wrapper jar $WRAPPER_JAR is not verified"))
+	g.Expect(script).ShouldNot(ContainSubstring("sha256sum"))
+	g.Expect(script).Should(ContainSubstring("WRAPPER_PROPERTIES=\"$(workspaces.source.path)/source/gradle/wrapper/gradle-wrapper.properties\"\nif ! grep -q '^distributionSha256Sum=' \"$WRAPPER_PROPERTIES\" 2>/dev/null; then\n    echo \"WARNING: the build tool distribution is not verified"))
+	g.Expect(script).Should(HaveSuffix("gradle() {\n    sh \"$(workspaces.source.path)/source/gradlew\" \"$@\"\n}\n"))
+
+	jbsConfig.Spec.BuildSettings.RequireWrapperChecksum = true
+	script = buildToolWrapperScript("gradle", jbsConfig, recipe)
+	g.Expect(script).Should(ContainSubstring("echo \"No checksum is configured for build tool wrapper jar $WRAPPER_JAR\" >&2\nexit 1\n"))
+	// The distribution checksum is required too, as otherwise the wrapper runs whatever it downloads
+	g.Expect(script).Should(ContainSubstring("so the build tool distribution can't be verified\" >&2\n    exit 1\nfi\n"))
+
+	recipe.WrapperChecksum = "ABCDEF0123"
+	script = buildToolWrapperScript("maven", jbsConfig, recipe)
+	g.Expect(script).Should(HavePrefix("WRAPPER_JAR=\"$(workspaces.source.path)/source/.mvn/wrapper/maven-wrapper.jar\"\n"))
+	g.Expect(script).Should(ContainSubstring("EXPECTED_WRAPPER_CHECKSUM='abcdef0123'\nWRAPPER_CHECKSUM=$(sha256sum \"$WRAPPER_JAR\" | cut -d ' ' -f 1)\n"))
+	g.Expect(script).ShouldNot(ContainSubstring("No checksum is configured"))
+	g.Expect(script).Should(HaveSuffix("mvn() {\n    sh \"$(workspaces.source.path)/source/mvnw\" \"$@\"\n}\n"))
+
+	// The wrapper replaces the build tool before the build tool script runs
+	g.Expect(toolBuildSection("maven", jbsConfig, recipe)).Should(HaveSuffix(script + mavenBuild))
+}
+
+func TestBuildSettingsFiles(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	buildTask := ps.Tasks[len(ps.Tasks)-1].TaskSpec
+	g.Expect(buildTask.Steps).ShouldNot(ContainElement(HaveField("Name", "write-build-settings")))
+
+	recipe.BuildSettingsFiles = map[string]string{"toolchains.xml": "<toolchains/>\n", "gradle.properties": "foo=bar"}
+	script := "tee $(workspaces.build-settings.path)/gradle.properties <<'RHTAPEOF'\nfoo=bar\nRHTAPEOF\n" +
+		"tee $(workspaces.build-settings.path)/toolchains.xml <<'RHTAPEOF'\n<toolchains/>\nRHTAPEOF\n"
+	g.Expect(buildSettingsFilesScript(recipe)).Should(Equal(script))
+	ps, df, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	buildTask = ps.Tasks[len(ps.Tasks)-1].TaskSpec
+	// The files are written right before the build
+	var names []string
+	for _, step := range buildTask.Steps {
+		names = append(names, step.Name)
+		if step.Name == "write-build-settings" {
+			g.Expect(step.Script).Should(Equal(script))
+			g.Expect(step.Image).Should(Equal(recipe.Image))
+		}
+	}
+	g.Expect(names[1:3]).Should(Equal([]string{"write-build-settings", BuildTaskName}))
+	// The diagnostic and Konflux builds write them to the local settings directory
+	diagnostic := strings.ReplaceAll(script, "$(workspaces.build-settings.path)", "/root/software/settings")
+	g.Expect(df).Should(ContainSubstring("\nRUN echo " + base64.StdEncoding.EncodeToString([]byte(diagnostic)) + " | base64 -d | sh\n"))
+	g.Expect(konfluxScript).Should(ContainSubstring("\n\n" + diagnostic))
+
+	recipe.BuildSettingsFiles = map[string]string{"../settings.xml": "<settings/>"}
+	_, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(HaveOccurred())
+}
+
+func TestVerificationExcludesFile(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", AllowedDifferences: []string{"-:foo.*", "^bar$"}}
+	// Small lists are passed inline
+	args := verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe)
+	g.Expect(args).Should(ContainElements("--excludes=-:foo.*", "--excludes=^bar$"))
+	g.Expect(strings.Join(args, " ")).ShouldNot(ContainSubstring("--excludes-file"))
+	g.Expect(verificationExcludesScript(jbsConfig, recipe)).Should(BeEmpty())
+
+	jbsConfig.Spec.BuildSettings.ExcludesFileThreshold = 1
+	args = verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe)
+	g.Expect(args).Should(ContainElement("--excludes-file=" + VerificationExcludesFile))
+	g.Expect(strings.Join(args, " ")).ShouldNot(ContainSubstring("--excludes="))
+	// The diff marker is escaped the same way the verifier escapes arguments
+	g.Expect(verificationExcludesScript(jbsConfig, recipe)).Should(Equal("cat > " + VerificationExcludesFile + " <<'RHTAPEOF'\n^\\-:foo.*\n^bar$\nRHTAPEOF\n"))
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/hacbs-jvm-build-request-processor:latest", "build-id", map[string]string{})
+	g.Expect(err).Should(BeNil())
+	var script string
+	for _, task := range ps.Tasks {
+		if task.Name == BuildTaskName {
+			for _, step := range task.TaskSpec.Steps {
+				if strings.Contains(step.Script, "verify-built-artifacts") {
+					script = step.Script
+				}
+			}
+		}
+	}
+	g.Expect(script).Should(HavePrefix("cat > " + VerificationExcludesFile))
+	g.Expect(script).Should(ContainSubstring("\"--excludes-file=" + VerificationExcludesFile + "\""))
+}
+
+func TestAllowedDifferenceReasons(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", AllowedDifferences: []string{"-:foo.*", "^bar$ # Timestamp embedded by the 'bar' plugin"}}
+	args := verifyParameters(jbsConfig, &v1alpha1.DependencyBuild{}, recipe)
+	g.Expect(args).Should(ContainElements("--excludes=-:foo.*", "--excludes=^bar$"))
+	// Only entries with a reason are logged
+	g.Expect(allowedDifferenceReasonsScript(recipe)).Should(Equal("echo 'Allowed difference ^bar$: Timestamp embedded by the '\\''bar'\\'' plugin'\n"))
+
+	jbsConfig.Spec.BuildSettings.ExcludesFileThreshold = 1
+	g.Expect(verificationExcludesScript(jbsConfig, recipe)).Should(Equal("cat > " + VerificationExcludesFile + " <<'RHTAPEOF'\n^\\-:foo.*\n^bar$\nRHTAPEOF\n"))
+
+	recipe.AllowedDifferences = []string{"-:foo.*"}
+	g.Expect(allowedDifferenceReasonsScript(recipe)).Should(BeEmpty())
+}
+
+func TestDisableDiagnosticContainerfile(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	_, df, kf, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(df).Should(HavePrefix("FROM quay.io/foo/processor:1.0 AS build-request-processor"))
+
+	jbsConfig.Spec.BuildSettings.DisableDiagnosticContainerfile = true
+	_, disabledDf, disabledKf, disabledKonfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(disabledDf).Should(BeEmpty())
+	// The konflux build is unaffected
+	g.Expect(disabledKf).Should(Equal(kf))
+	g.Expect(disabledKonfluxScript).Should(Equal(konfluxScript))
+}
+
+func TestAnnotateBuildScript(t *testing.T) {
+	g := NewGomegaWithT(t)
+	buildScript := func(jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe) string {
+		db := &v1alpha1.DependencyBuild{}
+		db.Name = "test"
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range ps.Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				if strings.Contains(step.Script, "/build.sh <<'RHTAPEOF'") {
+					return step.Script
+				}
+			}
+		}
+		return ""
+	}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"},
+		PreBuildScript: "echo pre", PostBuildScript: "echo post", AdditionalTools: []v1alpha1.AdditionalTool{{Tool: "gradle", Version: "8.4"}},
+		AdditionalDownloads: []v1alpha1.AdditionalDownload{{FileType: "executable", FileName: "tool", Uri: "https://example.com/tool", Sha256: "abc123"}}}
+	jbsConfig := &v1alpha1.JBSConfig{}
+	g.Expect(buildScript(jbsConfig, recipe)).ShouldNot(ContainSubstring("# ---- begin"))
+
+	jbsConfig.Spec.BuildSettings.AnnotateBuildScript = true
+	script := buildScript(jbsConfig, recipe)
+	g.Expect(script).Should(ContainSubstring("# ---- begin pre-build ----\necho pre\n# ---- end pre-build ----\n"))
+	g.Expect(script).Should(ContainSubstring("# ---- begin post-build ----\necho post\n# ---- end post-build ----\n"))
+	// The sections are in the order they run
+	var positions []int
+	for _, section := range []string{"install-package", "pre-build", "build tool maven", "build tool gradle", "post-build"} {
+		begin := strings.Index(script, "# ---- begin "+section+" ----\n")
+		end := strings.Index(script, "# ---- end "+section+" ----\n")
+		g.Expect(begin).Should(BeNumerically(">=", 0), section)
+		g.Expect(end).Should(BeNumerically(">", begin), section)
+		positions = append(positions, begin, end)
+	}
+	g.Expect(sort.IntsAreSorted(positions)).Should(BeTrue())
+
+	// Empty sections are not annotated
+	recipe.PostBuildScript = ""
+	g.Expect(buildScript(jbsConfig, recipe)).ShouldNot(ContainSubstring("# ---- begin post-build"))
+}
+
+func TestRecipeExtraEnv(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"},
+		ExtraEnv: map[string]string{"GRADLE_OPTS": "-Xmx2g -Dorg.gradle.daemon=false", "MAVEN_HOME": "/wrong", "FOO": "bar"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	ps, _, _, konfluxScript, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(konfluxScript).Should(ContainSubstring("export FOO=bar\nexport GRADLE_OPTS='-Xmx2g -Dorg.gradle.daemon=false'\n"))
+	// The managed variables are not overridden
+	g.Expect(konfluxScript).Should(ContainSubstring("export MAVEN_HOME=/opt/maven/3.8.8\n"))
+	g.Expect(konfluxScript).ShouldNot(ContainSubstring("/wrong"))
+	var build *tektonpipeline.Step
+	for _, task := range ps.Tasks {
+		for i, step := range task.TaskSpec.Steps {
+			if step.Name == BuildTaskName {
+				build = &task.TaskSpec.Steps[i]
+			}
+		}
+	}
+	g.Expect(build).ShouldNot(BeNil())
+	g.Expect(build.Env).Should(ContainElements(v1.EnvVar{Name: "GRADLE_OPTS", Value: "-Xmx2g -Dorg.gradle.daemon=false"}, v1.EnvVar{Name: "FOO", Value: "bar"}))
+	g.Expect(build.Env).Should(ContainElement(v1.EnvVar{Name: "MAVEN_HOME", Value: "/opt/maven/3.8.8"}))
+	g.Expect(build.Env).ShouldNot(ContainElement(v1.EnvVar{Name: "MAVEN_HOME", Value: "/wrong"}))
+}
+
+// recordingLogSink keeps the key-values of every enabled log message
+type recordingLogSink struct {
+	level    int
+	messages map[string][]interface{}
+}
+
+func (r *recordingLogSink) Init(logr.RuntimeInfo)  {}
+func (r *recordingLogSink) Enabled(level int) bool { return level <= r.level }
+func (r *recordingLogSink) Info(_ int, msg string, keysAndValues ...interface{}) {
+	r.messages[msg] = keysAndValues
+}
+func (r *recordingLogSink) Error(error, string, ...interface{})    {}
+func (r *recordingLogSink) WithValues(...interface{}) logr.LogSink { return r }
+func (r *recordingLogSink) WithName(string) logr.LogSink           { return r }
+
+func TestCreatePipelineSpecLogging(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	sink := &recordingLogSink{messages: map[string][]interface{}{}}
+	_, _, _, _, err := createPipelineSpec(logr.New(sink), "maven", 1234, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	// The details are only logged at debug level
+	g.Expect(sink.messages).ShouldNot(HaveKey("Creating build pipeline"))
+
+	sink.level = 1
+	_, _, _, _, err = createPipelineSpec(logr.New(sink), "maven", 1234, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(sink.messages).Should(HaveKeyWithValue("Creating build pipeline", []interface{}{"tool", "maven", "image", "quay.io/foo/builder:latest", "cacheUrl", cacheServiceUrl(jbsConfig) + "/v2/cache/rebuild", "buildRequestProcessorImage", "quay.io/foo/processor:1.0", "commitTime", int64(1234)}))
+}
+
+func TestDeployOCIMode(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Owner = "owner"
+	steps := func() ([]string, map[string]tektonpipeline.Step) {
+		ps, err := createDeployPipelineSpec(jbsConfig, &v1alpha1.DependencyBuild{}, "processor", "gav")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		names := []string{}
+		ret := map[string]tektonpipeline.Step{}
+		for _, s := range ps.Tasks[0].TaskSpec.Steps {
+			names = append(names, s.Name)
+			ret[s.Name] = s
+		}
+		return names, ret
+	}
+	names, _ := steps()
+	g.Expect(names).Should(Equal([]string{"restore-post-build-artifacts", "maven-deployment", "tag"}))
+
+	jbsConfig.Spec.MavenDeployment.Mode = v1alpha1.DeployModeOCI
+	jbsConfig.Spec.MavenDeployment.ValidateChecksums = true
+	names, deploySteps := steps()
+	// The artifacts are still validated before they are pushed and the build image is still tagged
+	g.Expect(names).Should(Equal([]string{"restore-post-build-artifacts", "validate-artifact-checksums", "oci-deployment", "tag"}))
+	deploy := deploySteps["oci-deployment"]
+	g.Expect(deploy.Image).Should(Equal(trustedArtifactsImage(jbsConfig)))
+	g.Expect(deploy.Script).Should(ContainSubstring("cd $(workspaces.source.path)/artifacts\nfind . -name '*.pom' | sort | while read -r POM; do\n"))
+	g.Expect(deploy.Script).Should(ContainSubstring(`TAG=$(echo -n "$GROUP:$ARTIFACT:$VERSION" | sha256sum | cut -d ' ' -f 1)`))
+	g.Expect(deploy.Script).Should(ContainSubstring(`(cd "$DIR" && oras push $ORAS_OPTIONS --artifact-type ` + OCIDeployArtifactType + ` --annotation "org.apache.maven.groupId=$GROUP" --annotation "org.apache.maven.artifactId=$ARTIFACT" --annotation "org.apache.maven.version=$VERSION" quay.io/owner/maven-artifacts:$TAG $(find . -maxdepth 1 -type f | sed 's|^\./||' | sort)) || exit 1`))
+
+	jbsConfig.Spec.MavenDeployment.OCIRepository = "registry.example.com/java/artifacts"
+	jbsConfig.Spec.Registry.PrependTag = "prefix"
+	jbsConfig.Spec.MavenDeployment.RetryBudget.MaxRetries = 3
+	_, deploySteps = steps()
+	deploy = deploySteps["oci-deployment"]
+	g.Expect(deploy.Script).Should(HavePrefix("deploy_retry() {"))
+	g.Expect(deploy.Script).Should(ContainSubstring("TAG=prefix_$(echo -n"))
+	g.Expect(deploy.Script).Should(ContainSubstring(`(cd "$DIR" && deploy_retry oras push `))
+	g.Expect(deploy.Script).Should(ContainSubstring(" registry.example.com/java/artifacts:$TAG "))
+}
+
+func TestDeployManifestList(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.ValidateChecksums = true
+	ps, err := createDeployPipelineSpec(jbsConfig, &v1alpha1.DependencyBuild{}, "image", "gav")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	steps := map[string]string{}
+	for _, s := range ps.Tasks[0].TaskSpec.Steps {
+		steps[s.Name] = s.Script
+	}
+	restore := steps["restore-post-build-artifacts"]
+	// The manifest is only fetched once for an image, and again for the first image of a manifest list
+	g.Expect(strings.Count(restore, "oras manifest fetch")).Should(Equal(2))
+	g.Expect(restore).Should(ContainSubstring("MANIFEST=$(oras manifest fetch $ORAS_OPTIONS $URL@$DIGEST)\nif echo \"$MANIFEST\" | jq --exit-status '.manifests' > /dev/null; then\n"))
+	g.Expect(restore).Should(ContainSubstring("ENTRY=$(echo \"$MANIFEST\" | jq --raw-output '.manifests[0].digest')\n"))
+	g.Expect(restore).Should(ContainSubstring("MANIFEST=$(oras manifest fetch $ORAS_OPTIONS $URL@$ENTRY)\nfi\n"))
+	// The layers come from whichever manifest was resolved
+	for _, layer := range []string{"SARCHIVE=$(echo \"$MANIFEST\" | jq --raw-output '.layers[0].digest')", "LARCHIVE=$(echo \"$MANIFEST\" | jq --raw-output '.layers[1].digest')", "AARCHIVE=$(echo \"$MANIFEST\" | jq --raw-output '.layers[2].digest')"} {
+		g.Expect(restore).Should(ContainSubstring(layer))
+		g.Expect(strings.Index(restore, layer)).Should(BeNumerically(">", strings.Index(restore, "\nfi\n")))
+	}
+	// The manifest list rather than one of its images is tagged
+	g.Expect(steps["tag"]).Should(ContainSubstring("@$(params." + PipelineResultImageDigest + ") ${GAVS//,/ }"))
+	g.Expect(steps["tag"]).ShouldNot(ContainSubstring("ENTRY"))
+}
+
+func TestDeployRetryBudget(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.ValidateChecksums = true
+	db := &v1alpha1.DependencyBuild{}
+	db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{BuildId: "build-id"}}
+	scripts := func() map[string]string {
+		ps, err := createDeployPipelineSpec(jbsConfig, db, "image", "gav")
+		g.Expect(err).ShouldNot(HaveOccurred())
+		ret := map[string]string{}
+		for _, s := range ps.Tasks[0].TaskSpec.Steps {
+			ret[s.Name] = s.Script
+		}
+		return ret
+	}
+	for _, script := range scripts() {
+		g.Expect(script).ShouldNot(ContainSubstring("deploy_retry"))
+	}
+
+	jbsConfig.Spec.MavenDeployment.RetryBudget = v1alpha1.DeployRetryBudget{MaxRetries: 3, MaxSeconds: 600}
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{Owner: "mirror"}
+	steps := scripts()
+	// The budget is shared by every step through the source workspace
+	for _, step := range []string{"restore-post-build-artifacts", "tag"} {
+		g.Expect(steps[step]).Should(HavePrefix("deploy_retry() {\n    BUDGET_FILE=$(workspaces.source.path)/" + DeployRetryBudgetFile + "\n"))
+		g.Expect(steps[step]).Should(ContainSubstring("if [ \"$RETRIES\" -ge 3 ]; then"))
+		g.Expect(steps[step]).Should(ContainSubstring("if [ 600 -gt 0 ] && [ $(( $(date +%s) - STARTED )) -ge 600 ]; then"))
+		g.Expect(steps[step]).Should(ContainSubstring("sleep 10\n"))
+	}
+	g.Expect(steps["restore-post-build-artifacts"]).Should(ContainSubstring("MANIFEST=$(deploy_retry oras manifest fetch $ORAS_OPTIONS $URL@$DIGEST)"))
+	g.Expect(steps["restore-post-build-artifacts"]).Should(ContainSubstring("MANIFEST=$(deploy_retry oras manifest fetch $ORAS_OPTIONS $URL@$ENTRY)"))
+	g.Expect(steps["restore-post-build-artifacts"]).Should(ContainSubstring("\ndeploy_retry use-archive oci:$URL@$SARCHIVE"))
+	g.Expect(steps["restore-post-build-artifacts"]).Should(ContainSubstring("\ndeploy_retry use-archive oci:$URL@$LARCHIVE"))
+	g.Expect(strings.Count(steps["tag"], "deploy_retry oras tag ")).Should(Equal(2))
+	// A partially deployed release can not be redeployed so the Maven deployment is not retried
+	g.Expect(steps["maven-deployment"]).ShouldNot(ContainSubstring("deploy_retry"))
+	g.Expect(steps["validate-artifact-checksums"]).ShouldNot(ContainSubstring("deploy_retry"))
+
+	jbsConfig.Spec.MavenDeployment.RetryBudget.DelaySeconds = 30
+	g.Expect(scripts()["tag"]).Should(ContainSubstring("sleep 30\n"))
+}
+
+func TestArchiveArtifactType(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db := &v1alpha1.DependencyBuild{}
+	preBuildImageArgs, postBuildImageArgs, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("export ORAS_OPTIONS=\" --image-spec=v1.0 --artifact-type application/vnd.oci.image.config.v1+json\"\n"))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("export ORAS_OPTIONS=\" --image-spec=v1.0 --artifact-type application/vnd.oci.image.config.v1+json --no-tty --format=json\"\n"))
+
+	jbsConfig.Spec.Registry.ArtifactType = "application/vnd.example.archive"
+	jbsConfig.Spec.Registry.ImageSpec = "v1.1"
+	preBuildImageArgs, postBuildImageArgs, _, _, _ = pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("export ORAS_OPTIONS=\" --image-spec=v1.1 --artifact-type application/vnd.example.archive\"\n"))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("export ORAS_OPTIONS=\" --image-spec=v1.1 --artifact-type application/vnd.example.archive --no-tty --format=json\"\n"))
+	g.Expect(preBuildImageArgs + postBuildImageArgs).ShouldNot(ContainSubstring("vnd.oci.image.config"))
+
+	// The override also applies to the pipeline steps that create the archives
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17"}
+	db.Name = "test"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	archives := 0
+	for _, task := range ps.Tasks {
+		for _, step := range task.TaskSpec.Steps {
+			if strings.Contains(step.Script, "create-archive") {
+				archives++
+				g.Expect(step.Script).Should(ContainSubstring("--image-spec=v1.1 --artifact-type application/vnd.example.archive"))
+			}
+		}
+	}
+	g.Expect(archives).Should(Equal(2))
+}
+
+func TestArchiveAnnotations(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db := &v1alpha1.DependencyBuild{}
+	db.Spec.ScmInfo.SCMURL = "https://github.com/foo/bar.git"
+	db.Spec.ScmInfo.CommitHash = "1234abcd"
+	g.Expect(archiveAnnotationOptions(jbsConfig, db, "build-id")).Should(BeEmpty())
+
+	jbsConfig.Spec.Registry.ArchiveAnnotations = map[string]string{
+		"org.opencontainers.image.source":   "{SCM_URL}",
+		"org.opencontainers.image.revision": "{SCM_COMMIT}",
+		"io.jvmbuildservice.build-id":       "{BUILD_ID}",
+		"io.jvmbuildservice.team":           "java team",
+		"io.jvmbuildservice.owner":          "$(id)",
+	}
+	// The annotations are sorted and those that can't be passed through ORAS_OPTIONS are skipped
+	annotations := " --annotation io.jvmbuildservice.build-id=build-id --annotation org.opencontainers.image.revision=1234abcd --annotation org.opencontainers.image.source=https://github.com/foo/bar.git"
+	g.Expect(archiveAnnotationOptions(jbsConfig, db, "build-id")).Should(Equal(annotations))
+
+	preBuildImageArgs, postBuildImageArgs, _, _, _ := pipelineBuildCommands("image-id", db, jbsConfig, "build-id")
+	g.Expect(preBuildImageArgs).Should(ContainSubstring("export ORAS_OPTIONS=\" --image-spec=v1.0 --artifact-type application/vnd.oci.image.config.v1+json" + annotations + "\"\n"))
+	g.Expect(postBuildImageArgs).Should(ContainSubstring("export ORAS_OPTIONS=\" --image-spec=v1.0 --artifact-type application/vnd.oci.image.config.v1+json" + annotations + " --no-tty --format=json\"\n"))
+}
+
+func TestJbsDirectoryConflictPolicy(t *testing.T) {
+	jbsDir := "$(workspaces.source.path)/source/.jbs"
+	alternateDir := "$(workspaces.source.path)/source/.jbs-build"
+	steps := func(g *WithT, jbsConfig *v1alpha1.JBSConfig) map[string]string {
+		recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+		db := &v1alpha1.DependencyBuild{}
+		db.Name = "test"
+		ps, _, kf, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		g.Expect(kf).Should(ContainSubstring("\nCOPY .jbs/run-build.sh /root\n"))
+		scripts := map[string]string{}
+		for _, task := range ps.Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				scripts[step.Name] = step.Script
+			}
+		}
+		return scripts
+	}
+
+	t.Run("Test the generated files overwrite those in the source by default", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		scripts := steps(g, &v1alpha1.JBSConfig{})
+		g.Expect(scripts["create-pre-build-source"]).Should(HavePrefix("JBS_DIR=" + jbsDir + "\nmkdir -p \"$JBS_DIR\"\ntee \"$JBS_DIR/Containerfile\" <<'RHTAPEOF'\n"))
+		g.Expect(scripts["create-pre-build-image"]).Should(ContainSubstring("JBS_DIR=" + jbsDir + "\ncp $(workspaces.source.path)/build.sh \"$JBS_DIR\"\n"))
+		g.Expect(scripts["restore-pre-build-source"]).Should(HaveSuffix("JBS_DIR=" + jbsDir + "\nmv \"$JBS_DIR/build.sh\" $(workspaces.source.path)"))
+		g.Expect(scripts["restore-pre-build-source"]).ShouldNot(ContainSubstring(alternateDir))
+	})
+	t.Run("Test the build fails if the source has a .jbs directory", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		jbsConfig := &v1alpha1.JBSConfig{}
+		jbsConfig.Spec.BuildSettings.JbsDirectoryConflictPolicy = v1alpha1.JbsDirectoryConflictFail
+		scripts := steps(g, jbsConfig)
+		g.Expect(scripts["create-pre-build-source"]).Should(HavePrefix("JBS_DIR=" + jbsDir + "\nif [ -e \"$JBS_DIR\" ]; then\n    echo \"The source already has a .jbs directory\" >&2\n    exit 1\nfi\nmkdir -p \"$JBS_DIR\"\n"))
+		g.Expect(scripts["restore-pre-build-source"]).ShouldNot(ContainSubstring(alternateDir))
+	})
+	t.Run("Test the alternate directory is used if the source has a .jbs directory", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		jbsConfig := &v1alpha1.JBSConfig{}
+		jbsConfig.Spec.BuildSettings.JbsDirectoryConflictPolicy = v1alpha1.JbsDirectoryConflictUseAlternateDir
+		scripts := steps(g, jbsConfig)
+		g.Expect(scripts["create-pre-build-source"]).Should(HavePrefix("JBS_DIR=" + jbsDir + "\nif [ -e " + alternateDir + " ]; then\n    echo \"The source already has a .jbs-build directory\" >&2\n    exit 1\nfi\nif [ -e \"$JBS_DIR\" ]; then\n    echo \"The source already has a .jbs directory, using .jbs-build\"\n    JBS_DIR=" + alternateDir + "\nfi\n"))
+		// The Containerfile copies the build script from wherever it was written
+		g.Expect(scripts["create-pre-build-source"]).Should(ContainSubstring("\nRHTAPEOF\nsed -i \"s|^COPY .jbs/|COPY $(basename \"$JBS_DIR\")/|\" \"$JBS_DIR/Containerfile\"\n"))
+		directory := "JBS_DIR=" + jbsDir + "\nif [ -d " + alternateDir + " ]; then\n    JBS_DIR=" + alternateDir + "\nfi\n"
+		g.Expect(scripts["create-pre-build-image"]).Should(ContainSubstring(directory + "cp $(workspaces.source.path)/build.sh \"$JBS_DIR\"\n"))
+		g.Expect(scripts["restore-pre-build-source"]).Should(HaveSuffix(directory + "mv \"$JBS_DIR/build.sh\" $(workspaces.source.path)"))
+	})
+}
+
+func TestGracefulTermination(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	command := "$(workspaces.source.path)/build.sh \"$@\""
+	g.Expect(gracefulTerminationScript(jbsConfig, command)).Should(Equal(command))
+	g.Expect(gracefulTerminationShebang(jbsConfig)).Should(BeEmpty())
+
+	jbsConfig.Spec.BuildSettings.GracefulTerminationSeconds = 30
+	jbsConfig.Spec.BuildSettings.BuildTimeoutHours = 2
+	script := gracefulTerminationScript(jbsConfig, command)
+	// The build runs in the background so the trap runs as soon as the step is sent SIGTERM
+	g.Expect(script).Should(HavePrefix("set -m\n"))
+	g.Expect(script).Should(ContainSubstring("\ntrap jbs_terminate TERM\n"))
+	g.Expect(script).Should(ContainSubstring("\n" + command + " &\nJBS_BUILD_PID=$!\n"))
+	g.Expect(script).Should(HaveSuffix("\nexit $JBS_BUILD_STATUS"))
+	// The build is terminated the grace period before the timeout and killed at the end of it. The timeout also covers
+	// the tasks before the build so it is counted from the start of the pipeline run.
+	g.Expect(script).Should(ContainSubstring("\nJBS_START_TIME=\"$(params." + PipelineParamStartTime + ")\"\nJBS_WATCHDOG=$((${JBS_START_TIME:-$JBS_NOW} + 7170 - JBS_NOW))\n"))
+	g.Expect(script).Should(ContainSubstring("\n(sleep \"$JBS_WATCHDOG\" && kill -TERM $$) &\n"))
+	g.Expect(script).Should(ContainSubstring("\n    for _ in $(seq 30); do\n"))
+	g.Expect(script).Should(ContainSubstring("\n    kill -KILL -- \"-$JBS_BUILD_PID\" 2>/dev/null || true\n"))
+	g.Expect(script).Should(ContainSubstring("\n    find $(workspaces.source.path)/artifacts -type f > $(workspaces.source.path)/logs/partial-artifacts.txt 2>/dev/null || true\n    sync\n    exit 143\n"))
+
+	// There is no watchdog if the grace period is as long as the build timeout, the trap still handles SIGTERM
+	jbsConfig.Spec.BuildSettings.GracefulTerminationSeconds = 7200
+	script = gracefulTerminationScript(jbsConfig, command)
+	g.Expect(script).ShouldNot(ContainSubstring("kill -TERM $$"))
+	g.Expect(script).Should(ContainSubstring("\ntrap jbs_terminate TERM\n"))
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	var build *tektonpipeline.Step
+	for _, task := range ps.Tasks {
+		for i, step := range task.TaskSpec.Steps {
+			if step.Name == BuildTaskName {
+				build = &task.TaskSpec.Steps[i]
+			}
+		}
+	}
+	g.Expect(build).ShouldNot(BeNil())
+	g.Expect(build.Script).Should(HaveSuffix(script))
+	// The wrapper uses bash builtins so the step doesn't run with the sh of the builder image
+	g.Expect(build.Script).Should(HavePrefix("#!/bin/bash\nset -e\n"))
+	g.Expect(ps.Tasks[len(ps.Tasks)-1].Params).Should(ContainElement(HaveField("Name", PipelineParamStartTime)))
+	g.Expect(ps.Params).Should(ContainElement(HaveField("Name", PipelineParamStartTime)))
+}
+
+func TestPreprocessorJavaHome(t *testing.T) {
+	g := NewGomegaWithT(t)
+	preprocessor := func(recipe *v1alpha1.BuildRecipe) (*tektonpipeline.Step, string, string) {
+		db := &v1alpha1.DependencyBuild{}
+		db.Name = "test"
+		ps, df, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		encoded := regexp.MustCompile(`RUN echo (\S+) \| base64 -d >/root/preprocessor.sh`).FindStringSubmatch(df)
+		g.Expect(encoded).Should(HaveLen(2))
+		script, err := base64.StdEncoding.DecodeString(encoded[1])
+		g.Expect(err).ShouldNot(HaveOccurred())
+		for _, task := range ps.Tasks {
+			for i, step := range task.TaskSpec.Steps {
+				if step.Name == "preprocessor" {
+					return &task.TaskSpec.Steps[i], df, string(script)
+				}
+			}
+		}
+		return nil, df, string(script)
+	}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "7", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "7"}}
+	step, df, script := preprocessor(recipe)
+	g.Expect(step).ShouldNot(BeNil())
+	g.Expect(step.Env).ShouldNot(ContainElement(HaveField("Name", "JAVA_HOME")))
+	g.Expect(df).ShouldNot(ContainSubstring("preprocessor-java"))
+	g.Expect(script).Should(HavePrefix("#!/bin/sh\n/root/software/system-java/bin/java -jar /root/software/build-request-processor/quarkus-run.jar "))
+
+	// The diagnostic Containerfile runs the preprocessor with the same JDK, copied from the build request processor
+	recipe.PreprocessorJavaHome = "/usr/lib/jvm/java-11"
+	step, df, script = preprocessor(recipe)
+	g.Expect(step).ShouldNot(BeNil())
+	g.Expect(step.Env).Should(ContainElement(v1.EnvVar{Name: "JAVA_HOME", Value: "/usr/lib/jvm/java-11"}))
+	g.Expect(df).Should(ContainSubstring("\nCOPY --from=build-request-processor /usr/lib/jvm/java-11 /root/software/preprocessor-java\n"))
+	g.Expect(script).Should(HavePrefix("#!/bin/sh\n/root/software/preprocessor-java/bin/java -jar /root/software/build-request-processor/quarkus-run.jar "))
+}
+
+func TestReferenceArtifactImage(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	g.Expect(verifyParameters(jbsConfig, db, recipe)).Should(ContainElement("--repository-url=$(params.CACHE_URL)"))
+
+	reference := "quay.io/foo/artifact-deployments@sha256:1234"
+	db.Spec.ReferenceArtifactImage = reference
+	g.Expect(verifyParameters(jbsConfig, db, recipe)).Should(ContainElement("--repository-url=file://$(workspaces.source.path)/reference-artifacts"))
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	var names []string
+	var script string
+	for _, task := range ps.Tasks {
+		for _, step := range task.TaskSpec.Steps {
+			names = append(names, step.Name)
+			if step.Name == "restore-reference-artifacts" {
+				script = step.Script
+			}
+		}
+	}
+	// The reference artifacts are restored after the build and before the verification
+	g.Expect(names).Should(ContainElements(BuildTaskName, "restore-reference-artifacts", "verify-and-check-for-contaminates"))
+	g.Expect(slices.Index(names, "restore-reference-artifacts")).Should(Equal(slices.Index(names, BuildTaskName) + 1))
+	g.Expect(slices.Index(names, "verify-and-check-for-contaminates")).Should(BeNumerically(">", slices.Index(names, "restore-reference-artifacts")))
+	g.Expect(script).Should(ContainSubstring("MANIFEST=$(oras manifest fetch $ORAS_OPTIONS " + reference + ")\n"))
+	g.Expect(script).Should(ContainSubstring("MANIFEST=$(oras manifest fetch $ORAS_OPTIONS quay.io/foo/artifact-deployments@$ENTRY)\n"))
+	g.Expect(script).Should(HaveSuffix("use-archive oci:quay.io/foo/artifact-deployments@$AARCHIVE=$(workspaces.source.path)/reference-artifacts"))
+
+	db.Spec.ReferenceArtifactImage = "quay.io/foo/bar;rm -rf /"
+	_, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(HaveOccurred())
+}
+
+func TestAntNativeDeploy(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "ant", JavaVersion: "17", ToolVersions: map[string]string{"ant": "1.10.13", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	generate := func() (string, string, string) {
+		ps, _, kf, konfluxScript, err := createPipelineSpec(logr.Discard(), "ant", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		return kf, konfluxScript, stepNamed(ps.Tasks[len(ps.Tasks)-1], "verify-and-check-for-contaminates").Script
+	}
+
+	// By default the artifacts are collected from the source tree after the build
+	kf, konfluxScript, verify := generate()
+	g.Expect(verify).Should(ContainSubstring("\"copy-artifacts\""))
+	g.Expect(kf).Should(ContainSubstring("\nRUN /root/run-build.sh\nFROM quay.io/foo/processor:1.0\nUSER 0\nCOPY --from=0 /root/project /root/project\nRUN /opt/jboss/container/java/run/run-java.sh copy-artifacts --source-path=/root/project/source --deploy-path=/root/project/artifacts\nFROM scratch\n"))
+	g.Expect(kf).Should(HaveSuffix("\nCOPY --from=1 /root/project/artifacts /root/artifacts"))
+	g.Expect(konfluxScript).ShouldNot(ContainSubstring(AntDeployUrlProperty))
+
+	// A build that deploys natively is given the repository to deploy to instead
+	recipe.AntNativeDeploy = true
+	kf, konfluxScript, verify = generate()
+	g.Expect(verify).ShouldNot(ContainSubstring("copy-artifacts"))
+	g.Expect(kf).ShouldNot(ContainSubstring("quay.io/foo/processor:1.0"))
+	g.Expect(kf).Should(HaveSuffix("\nRUN /root/run-build.sh\nFROM scratch\nCOPY --from=0 /root/project/artifacts /root/artifacts"))
+	g.Expect(konfluxScript).Should(ContainSubstring("export ANT_ARGS=\"${ANT_ARGS:-} -Djbs.deploy.url=file:/root/project/artifacts\"\n"))
+}
+
+func TestNormalizeTimestamps(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	db := &v1alpha1.DependencyBuild{}
+	generate := func(recipe *v1alpha1.BuildRecipe) string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), recipe.Tool, 1700000000, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		return stepNamed(ps.Tasks[len(ps.Tasks)-1], "verify-and-check-for-contaminates").Script
+	}
+	// The archives are left as built by default
+	g.Expect(normalizeTimestampsArgs(jbsConfig, 1700000000)).Should(BeNil())
+	g.Expect(generate(newTestRecipe())).ShouldNot(ContainSubstring("normalize-timestamps"))
+
+	jbsConfig.Spec.BuildSettings.NormalizeTimestamps = true
+	normalize := "\"normalize-timestamps\" \"--path=$(workspaces.source.path)/artifacts\" \"--timestamp=1700000000\""
+	// The archives are normalized to the commit time before they are verified
+	script := generate(newTestRecipe())
+	g.Expect(script).Should(ContainSubstring(normalize))
+	g.Expect(strings.Index(script, normalize)).Should(BeNumerically("<", strings.Index(script, "\"verify-built-artifacts\"")))
+	g.Expect(strings.Index(script, normalize)).Should(BeNumerically("<", strings.Index(script, "\"verify\"")))
+
+	// The artifacts of an Ant build are only normalized once they have been collected
+	ant := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "ant", JavaVersion: "17", ToolVersions: map[string]string{"ant": "1.10.13", "jdk": "17"}}
+	script = generate(ant)
+	g.Expect(strings.Count(script, normalize)).Should(Equal(1))
+	g.Expect(strings.Index(script, "\"copy-artifacts\"")).Should(BeNumerically("<", strings.Index(script, normalize)))
+	g.Expect(strings.Index(script, normalize)).Should(BeNumerically("<", strings.Index(script, "\"verify\"")))
+}
+
+func TestReferenceRepositories(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := newTestRecipe()
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	g.Expect(strings.Join(verifyParameters(jbsConfig, db, recipe), " ")).ShouldNot(ContainSubstring("--reference-repository-url"))
+
+	db.Spec.ReferenceRepositories = []string{"https://repo.example.com/rebuild-1", "https://repo.example.com/rebuild-2"}
+	args := verifyParameters(jbsConfig, db, recipe)
+	// The cache is still what the artifacts are verified against, the references are only compared
+	g.Expect(args).Should(ContainElement("--repository-url=$(params.CACHE_URL)"))
+	g.Expect(args).Should(ContainElements("--reference-repository-url=https://repo.example.com/rebuild-1", "--reference-repository-url=https://repo.example.com/rebuild-2"))
+	g.Expect(slices.Index(args, "--reference-repository-url=https://repo.example.com/rebuild-1")).Should(BeNumerically("<", slices.Index(args, "--reference-repository-url=https://repo.example.com/rebuild-2")))
+	ps := buildPipeline(g, jbsConfig, recipe, db)
+	script := stepNamed(ps.Tasks[len(ps.Tasks)-1], "verify-and-check-for-contaminates").Script
+	g.Expect(script).Should(ContainSubstring("\"--reference-repository-url=https://repo.example.com/rebuild-1\" \"--reference-repository-url=https://repo.example.com/rebuild-2\""))
+
+	for _, invalid := range []string{"", "https://repo.example.com/$(id)"} {
+		db.Spec.ReferenceRepositories = []string{invalid}
+		_, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).Should(MatchError(ContainSubstring("invalid reference repository")), invalid)
+	}
+}
+
+func TestExcludeTestArtifacts(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := newTestRecipe()
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	// All artifacts are included by default
+	g.Expect(testArtifactArgs(jbsConfig)).Should(BeEmpty())
+	g.Expect(verifyParameters(jbsConfig, db, recipe)).ShouldNot(ContainElement("--exclude-test-artifacts"))
+
+	jbsConfig.Spec.BuildSettings.ExcludeTestArtifacts = true
+	g.Expect(testArtifactArgs(jbsConfig)).Should(Equal([]string{"--exclude-test-artifacts"}))
+	g.Expect(verifyParameters(jbsConfig, db, recipe)).Should(ContainElement("--exclude-test-artifacts"))
+	// Applied to the verified, copied and deployed artifacts of an Ant build
+	antRecipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "ant", JavaVersion: "17", ToolVersions: map[string]string{"ant": "1.10.13", "jdk": "17"}}
+	ps := buildPipeline(g, jbsConfig, antRecipe, db)
+	script := ""
+	for _, step := range ps.Tasks[len(ps.Tasks)-1].TaskSpec.Steps {
+		script += step.Script
+	}
+	g.Expect(strings.Count(script, "\"--exclude-test-artifacts\"")).Should(Equal(3))
+}
+
+func TestEnforceVersionRange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	g.Expect(compareVersions("1.0", "1.0.0")).Should(Equal(0))
+	g.Expect(compareVersions("1.10", "1.9")).Should(Equal(1))
+	g.Expect(compareVersions("1.0-beta1", "1.0")).Should(Equal(-1))
+	g.Expect(compareVersions("1.0.Final", "1.0.1")).Should(Equal(-1))
+	g.Expect(compareVersions("1.0.0.Final", "1.0.0")).Should(Equal(0))
+	g.Expect(compareVersions("1.0-GA", "1.0.RELEASE")).Should(Equal(0))
+	g.Expect(compareVersions("1.0-beta1", "1.0.Final")).Should(Equal(-1))
+	g.Expect(compareVersions("1.0-alpha10", "1.0-alpha9")).Should(Equal(1))
+	g.Expect(compareVersions("1.0-alpha-10", "1.0-alpha9")).Should(Equal(1))
+	g.Expect(compareVersions("1.0-ALPHA1", "1.0-alpha1")).Should(Equal(0))
+
+	for _, invalid := range []string{"[1.0", "[1.0,2.0,3.0]", "(1.0)", "[1.0,2.0) x"} {
+		_, err := parseVersionRange(invalid)
+		g.Expect(err).Should(HaveOccurred(), invalid)
+	}
+
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	db.Spec.Version = "1.5.2"
+	// An exact version is always enforced
+	recipe.EnforceVersion = "true"
+	g.Expect(enforcedVersion(db, recipe)).Should(Equal("true"))
+	for spec, enforced := range map[string]bool{
+		"[1.0,2.0)":        true,
+		"[1.5.2]":          true,
+		"(,1.5.2]":         true,
+		"(,1.5.2)":         false,
+		"[2.0,)":           false,
+		"(,1.0],[1.5,1.6)": true,
+		"(,1.0],[1.6,)":    false,
+		"[1.5.2.Final]":    true,
+		"(1.5.2.Final,)":   false,
+	} {
+		recipe.EnforceVersion = spec
+		if enforced {
+			g.Expect(enforcedVersion(db, recipe)).Should(Equal(spec), spec)
+		} else {
+			g.Expect(enforcedVersion(db, recipe)).Should(BeEmpty(), spec)
+		}
+	}
+
+	recipe.EnforceVersion = "[2.0,)"
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	buildTask := ps.Tasks[len(ps.Tasks)-1].TaskSpec
+	g.Expect(buildTask.Steps).Should(ContainElement(And(HaveField("Name", BuildTaskName), HaveField("Env", ContainElement(v1.EnvVar{Name: PipelineParamEnforceVersion, Value: ""})))))
+	params := buildPipelineParams(logr.Discard(), db, &v1alpha1.BuildAttempt{Recipe: recipe})
+	g.Expect(params).Should(ContainElement(tektonpipeline.Param{Name: PipelineParamEnforceVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: ""}}))
+
+	recipe.EnforceVersion = "[1.0,2.0"
+	_, _, _, _, err = createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("invalid version range \"[1.0,2.0\""))
+}
+
+func TestPreprocessorRequestMemory(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	stepMemory := func() map[string]string {
+		ps, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+		g.Expect(err).ShouldNot(HaveOccurred())
+		ret := map[string]string{}
+		for _, step := range ps.Tasks[0].TaskSpec.Steps {
+			g.Expect(step.ComputeResources.Limits.Memory().String()).Should(Equal(step.ComputeResources.Requests.Memory().String()))
+			ret[step.Name] = step.ComputeResources.Requests.Memory().String()
+		}
+		return ret
+	}
+	// The task and build memory are used by default
+	memory := stepMemory()
+	g.Expect(memory).Should(HaveKeyWithValue("git-clone-and-settings", "512Mi"))
+	g.Expect(memory).Should(HaveKeyWithValue("preprocessor", "512Mi"))
+	g.Expect(memory).Should(HaveKeyWithValue("create-pre-build-source", "1Gi"))
+	g.Expect(memory).Should(HaveKeyWithValue("create-pre-build-image", "1Gi"))
+
+	jbsConfig.Spec.BuildSettings.PreprocessorRequestMemory = "3Gi"
+	memory = stepMemory()
+	g.Expect(memory).Should(HaveKeyWithValue("git-clone-and-settings", "512Mi"))
+	g.Expect(memory).Should(HaveKeyWithValue("preprocessor", "3Gi"))
+	g.Expect(memory).Should(HaveKeyWithValue("create-pre-build-source", "3Gi"))
+	g.Expect(memory).Should(HaveKeyWithValue("create-pre-build-image", "1Gi"))
+
+	jbsConfig.Spec.BuildSettings.PreprocessorRequestMemory = "invalid"
+	_, err := memoryLimits(jbsConfig, 0, 0)
+	g.Expect(err).Should(HaveOccurred())
+}
+
+func TestMistypedParams(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recipe := &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+	db := &v1alpha1.DependencyBuild{}
+	db.Name = "test"
+	params := buildPipelineParams(logr.Discard(), db, &v1alpha1.BuildAttempt{Recipe: recipe})
+	specs := pipelineParamSpecs("", nil)
+	g.Expect(validateParamTypes(specs, params)).Should(Succeed())
+	// Unknown parameters are not checked
+	g.Expect(validateParamTypes(specs, []tektonpipeline.Param{{Name: "OTHER", Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeObject}}})).Should(Succeed())
+
+	cacheUrl := tektonpipeline.Param{Name: PipelineParamCacheUrl, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: []string{"http://cache"}}}
+	g.Expect(validateParamTypes(specs, []tektonpipeline.Param{cacheUrl})).Should(MatchError("parameter CACHE_URL has type \"array\" but \"string\" was expected"))
+	cacheUrl.Value = tektonpipeline.ParamValue{StringVal: "http://cache"}
+	g.Expect(validateParamTypes(specs, []tektonpipeline.Param{cacheUrl})).Should(MatchError("parameter CACHE_URL has type \"\" but \"string\" was expected"))
+	goals := tektonpipeline.Param{Name: PipelineParamGoals, Value: tektonpipeline.ParamValue{Type: tektonpipeline.ParamTypeString, StringVal: "install"}}
+	g.Expect(validateParamTypes(specs, []tektonpipeline.Param{goals})).Should(MatchError("parameter GOALS has type \"string\" but \"array\" was expected"))
+
+	_, _, _, _, err := createPipelineSpec(logr.Discard(), "maven", 0, &v1alpha1.JBSConfig{}, &v1alpha1.SystemConfig{}, recipe, db, append(params, goals), "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).Should(MatchError("parameter GOALS has type \"string\" but \"array\" was expected"))
+}
+
+// newTestRecipe returns a Maven recipe that createPipelineSpec accepts, for the tests to customise.
+func newTestRecipe() *v1alpha1.BuildRecipe {
+	return &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}}
+}
+
+// buildPipeline generates the build pipeline of the recipe's tool, failing the test if that fails.
+func buildPipeline(g *WithT, jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe, db *v1alpha1.DependencyBuild) *tektonpipeline.PipelineSpec {
+	ps, _, _, _, err := createPipelineSpec(logr.Discard(), recipe.Tool, 0, jbsConfig, &v1alpha1.SystemConfig{}, recipe, db, []tektonpipeline.Param{}, "quay.io/foo/processor:1.0", "build-id", map[string]string{})
+	g.Expect(err).ShouldNot(HaveOccurred())
+	return ps
+}
+
+// stepNamed returns the step of the task with the given name, or nil if it has none.
+func stepNamed(task tektonpipeline.PipelineTask, name string) *tektonpipeline.Step {
+	for i := range task.TaskSpec.Steps {
+		if task.TaskSpec.Steps[i].Name == name {
+			return &task.TaskSpec.Steps[i]
+		}
+	}
+	return nil
 }
@@ -36,6 +36,7 @@ import (
 	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/artifactbuild"
 	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/systemconfig"
 	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/util"
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/pod"
 	tektonpipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
 )
 
@@ -55,19 +56,27 @@ const (
 	PipelineParamEnforceVersion      = "ENFORCE_VERSION"
 	PipelineParamProjectVersion      = "PROJECT_VERSION"
 	PipelineParamCacheUrl            = "CACHE_URL"
+	PipelineParamStartTime           = "START_TIME"
+	PipelineParamPlatform            = "PLATFORM"
 	PipelineResultImage              = "IMAGE_URL"
 	PipelineResultImageDigest        = "IMAGE_DIGEST"
 	PipelineResultContaminants       = "CONTAMINANTS"
 	PipelineResultDeployedResources  = "DEPLOYED_RESOURCES"
 	PipelineResultVerificationResult = "VERIFICATION_RESULTS"
 	PipelineResultPassedVerification = "PASSED_VERIFICATION" //#nosec
+	PipelineResultVerificationDiff   = "VERIFICATION_DIFF"
+	PipelineResultArtifactChecksums  = "ARTIFACT_CHECKSUMS"
+	PipelineResultToolVersions       = "TOOL_VERSIONS"
 	PipelineResultGitArchive         = "GIT_ARCHIVE"
 	PipelineResultGavs               = "GAVS"
+	PipelineResultTaggedGavs         = "TAGGED_GAVS"
+	PipelineResultDebugWorkspace     = "DEBUG_WORKSPACE"
 
 	BuildInfoPipelineResultBuildInfo = "BUILD_INFO"
 
 	PipelineTypeLabel     = "jvmbuildservice.io/pipeline-type"
 	RedeployAnnotation    = "jvmbuildservice.io/redeploy"
+	PreviewAnnotation     = "jvmbuildservice.io/preview"
 	PipelineTypeBuildInfo = "build-info"
 	PipelineTypeBuild     = "build"
 	PipelineTypeDeploy    = "deploy"
@@ -77,7 +86,9 @@ const (
 
 	PipelineRunFinalizer = "jvmbuildservice.io/finalizer"
 	JavaHome             = "JAVA_HOME"
+	CompileJavaHome      = "COMPILE_JAVA_HOME"
 	DeploySuffix         = "-deploy"
+	PreviewSuffix        = "-preview"
 )
 
 type ReconcileDependencyBuild struct {
@@ -141,6 +152,11 @@ func (r *ReconcileDependencyBuild) Reconcile(ctx context.Context, request reconc
 
 			return r.handleRedeployAnnotation(ctx, &db)
 		}
+		// A requested preview is handled on its own without changing the state, which is then reconciled as usual
+		// once the annotation has been removed
+		if db.Annotations != nil && db.Annotations[PreviewAnnotation] != "" && len(db.Status.BuildAttempts) > 0 {
+			return r.handlePreviewAnnotation(ctx, &db)
+		}
 
 		switch db.Status.State {
 		case "", v1alpha1.DependencyBuildStateNew:
@@ -241,11 +257,12 @@ func (r *ReconcileDependencyBuild) handleStateNew(ctx context.Context, db *v1alp
 	if err != nil {
 		return reconcile.Result{}, err
 	}
-	if !jbsConfig.Spec.CacheSettings.DisableTLS {
-		pr.Spec.Workspaces = []tektonpipeline.WorkspaceBinding{{Name: "tls", ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.TlsConfigMapName}}}}
-	} else {
-		pr.Spec.Workspaces = []tektonpipeline.WorkspaceBinding{{Name: "tls", EmptyDir: &v1.EmptyDirVolumeSource{}}}
+	tlsWorkspace, err := r.tlsWorkspace(ctx, db.Namespace, jbsConfig)
+	if err != nil {
+		return reconcile.Result{}, err
 	}
+	pr.Spec.Workspaces = []tektonpipeline.WorkspaceBinding{tlsWorkspace}
+	pr.Spec.TaskRunTemplate.PodTemplate = podTemplate(jbsConfig, "")
 	pr.Namespace = db.Namespace
 	pr.Name = fmt.Sprintf("%s-build-discovery-%d", db.Name, db.Status.PipelineRetries)
 	pr.Labels = map[string]string{artifactbuild.PipelineRunLabel: "", artifactbuild.DependencyBuildIdLabel: db.Name, PipelineTypeLabel: PipelineTypeBuildInfo}
@@ -391,28 +408,62 @@ func (r *ReconcileDependencyBuild) handleAnalyzeBuildPipelineRunReceived(ctx con
 				}
 				if imageOk {
 					buildRecipes = append(buildRecipes, &v1alpha1.BuildRecipe{
-						Image:               image.Image,
-						CommandLine:         command.Commands,
-						EnforceVersion:      unmarshalled.EnforceVersion,
-						ToolVersion:         command.ToolVersion[command.Tool],
-						ToolVersions:        command.ToolVersion,
-						JavaVersion:         command.ToolVersion["jdk"],
-						Tool:                command.Tool,
-						DisabledPlugins:     command.DisabledPlugins,
-						PreBuildScript:      unmarshalled.PreBuildScript,
-						PostBuildScript:     unmarshalled.PostBuildScript,
-						AdditionalDownloads: unmarshalled.AdditionalDownloads,
-						DisableSubmodules:   unmarshalled.DisableSubmodules,
-						AdditionalMemory:    unmarshalled.AdditionalMemory,
-						Repositories:        unmarshalled.Repositories,
-						AllowedDifferences:  unmarshalled.AllowedDifferences,
-						ContextPath:         unmarshalled.ContextPath})
+						Image:                 image.Image,
+						CommandLine:           command.Commands,
+						EnforceVersion:        unmarshalled.EnforceVersion,
+						ToolVersion:           command.ToolVersion[command.Tool],
+						ToolVersions:          command.ToolVersion,
+						JavaVersion:           command.ToolVersion["jdk"],
+						Tool:                  command.Tool,
+						DisabledPlugins:       command.DisabledPlugins,
+						PreBuildScript:        unmarshalled.PreBuildScript,
+						PostBuildScript:       unmarshalled.PostBuildScript,
+						AdditionalDownloads:   unmarshalled.AdditionalDownloads,
+						DisableSubmodules:     unmarshalled.DisableSubmodules,
+						AdditionalMemory:      unmarshalled.AdditionalMemory,
+						Repositories:          unmarshalled.Repositories,
+						AllowedDifferences:    unmarshalled.AllowedDifferences,
+						AlsoMake:              unmarshalled.AlsoMake,
+						AlsoMakeDependents:    unmarshalled.AlsoMakeDependents,
+						AdditionalCPU:         unmarshalled.AdditionalCPU,
+						TestRunOrder:          unmarshalled.TestRunOrder,
+						SubmoduleCredentials:  unmarshalled.SubmoduleCredentials,
+						IsolatePreBuildScript: unmarshalled.IsolatePreBuildScript,
+						AllowedContaminants:   unmarshalled.AllowedContaminants,
+						HomeDirectory:         unmarshalled.HomeDirectory,
+						JavaHomeTemplate:      unmarshalled.JavaHomeTemplate,
+						LauncherJavaVersion:   unmarshalled.LauncherJavaVersion,
+						AntNativeDeploy:       unmarshalled.AntNativeDeploy,
+						Retries:               unmarshalled.Retries,
+						AdditionalTools:       unmarshalled.AdditionalTools,
+						ExtraEnv:              unmarshalled.ExtraEnv,
+						CloneDepth:            unmarshalled.CloneDepth,
+						PreprocessorJavaHome:  unmarshalled.PreprocessorJavaHome,
+						GradleInitScript:      unmarshalled.GradleInitScript,
+						Architecture:          unmarshalled.Architecture,
+						UseBuildToolWrapper:   unmarshalled.UseBuildToolWrapper,
+						WrapperChecksum:       unmarshalled.WrapperChecksum,
+						BuildSettingsFiles:    unmarshalled.BuildSettingsFiles,
+						ContextPath:           unmarshalled.ContextPath})
 					break
 				}
 			}
 		}
-		db.Status.PotentialBuildRecipes = buildRecipes
+		jbsConfig := &v1alpha1.JBSConfig{}
+		err = r.client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: v1alpha1.JBSConfigName}, jbsConfig)
+		if err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		strategy := jbsConfig.Spec.BuildSettings.RecipeSelectionStrategy
+		if strategy == "" {
+			strategy = v1alpha1.RecipeSelectionFirstMatch
+		} else if strategy != v1alpha1.RecipeSelectionFirstMatch && strategy != v1alpha1.RecipeSelectionHighestPriority {
+			log.Info(fmt.Sprintf("Unknown recipe selection strategy %#v, using %s", strategy, v1alpha1.RecipeSelectionFirstMatch))
+			strategy = v1alpha1.RecipeSelectionFirstMatch
+		}
+		db.Status.PotentialBuildRecipes = orderBuildRecipes(strategy, buildRecipes, allBuilderImages)
 		db.Status.PotentialBuildRecipesIndex = 0
+		db.Status.RecipeSelectionStrategy = strategy
 
 		if len(unmarshalled.Image) > 0 {
 			err := r.createRebuiltArtifacts(ctx, pr, &db, unmarshalled.Image, unmarshalled.Digest, unmarshalled.Gavs)
@@ -427,21 +478,42 @@ func (r *ReconcileDependencyBuild) handleAnalyzeBuildPipelineRunReceived(ctx con
 }
 
 type marshalledBuildInfo struct {
-	Invocations         []invocation
-	EnforceVersion      string
-	AdditionalDownloads []v1alpha1.AdditionalDownload
-	CommitTime          int64
-	PreBuildScript      string
-	PostBuildScript     string
-	DisableSubmodules   bool
-	AdditionalMemory    int
-	Repositories        []string
-	AllowedDifferences  []string
-	Image               string
-	Digest              string
-	ContextPath         string
-	Gavs                []string
-	DisabledPlugins     []string
+	Invocations           []invocation
+	EnforceVersion        string
+	AdditionalDownloads   []v1alpha1.AdditionalDownload
+	CommitTime            int64
+	PreBuildScript        string
+	PostBuildScript       string
+	DisableSubmodules     bool
+	AdditionalMemory      int
+	Repositories          []string
+	AllowedDifferences    []string
+	Image                 string
+	Digest                string
+	ContextPath           string
+	Gavs                  []string
+	DisabledPlugins       []string
+	AlsoMake              bool
+	AlsoMakeDependents    bool
+	AdditionalCPU         int
+	TestRunOrder          string
+	SubmoduleCredentials  []v1alpha1.SubmoduleCredential
+	IsolatePreBuildScript bool
+	AllowedContaminants   []string
+	HomeDirectory         string
+	JavaHomeTemplate      string
+	LauncherJavaVersion   string
+	AntNativeDeploy       bool
+	Retries               int
+	AdditionalTools       []v1alpha1.AdditionalTool
+	ExtraEnv              map[string]string
+	CloneDepth            int
+	PreprocessorJavaHome  string
+	GradleInitScript      string
+	Architecture          string
+	UseBuildToolWrapper   bool
+	WrapperChecksum       string
+	BuildSettingsFiles    map[string]string
 }
 
 type invocation struct {
@@ -474,6 +546,21 @@ func (r *ReconcileDependencyBuild) processBuilderImages(ctx context.Context) ([]
 	return result, nil
 }
 
+// orderBuildRecipes orders the potential recipes so the preferred one is attempted first. The sort is stable so
+// recipes that are equal under the strategy keep the order they were discovered in.
+func orderBuildRecipes(strategy string, recipes []*v1alpha1.BuildRecipe, builderImages []BuilderImage) []*v1alpha1.BuildRecipe {
+	if strategy == v1alpha1.RecipeSelectionHighestPriority {
+		priorities := map[string]int{}
+		for _, image := range builderImages {
+			priorities[image.Image] = image.Priority
+		}
+		sort.SliceStable(recipes, func(i, j int) bool {
+			return priorities[recipes[i].Image] > priorities[recipes[j].Image]
+		})
+	}
+	return recipes
+}
+
 func (r *ReconcileDependencyBuild) processBuilderImageTags(tags string) map[string][]string {
 	tagList := strings.Split(tags, ",")
 	tools := map[string][]string{}
@@ -508,6 +595,7 @@ func (r *ReconcileDependencyBuild) handleStateSubmitBuild(ctx context.Context, d
 	ba := v1alpha1.BuildAttempt{}
 	ba.BuildId = uuid.New().String()
 	ba.Recipe = db.Status.PotentialBuildRecipes[db.Status.PotentialBuildRecipesIndex]
+	db.Status.SelectedBuildRecipe = ba.Recipe
 	pipelineName := currentDependencyBuildPipelineName(db)
 	ba.Build = &v1alpha1.BuildPipelineRun{
 		PipelineName: pipelineName,
@@ -561,35 +649,76 @@ func (r *ReconcileDependencyBuild) handleStateBuilding(ctx context.Context, db *
 	}
 	pr.Spec.PipelineRef = nil
 
-	contextDir := db.Spec.ScmInfo.Path
-	if attempt.Recipe.ContextPath != "" {
-		contextDir = attempt.Recipe.ContextPath
-	}
-
 	if err != nil {
 		return reconcile.Result{}, err
 	}
-	scmUrl := modifyURLFragment(log, db.Spec.ScmInfo.SCMURL)
-	paramValues := []tektonpipeline.Param{
-		{Name: PipelineBuildId, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Name}},
-		{Name: PipelineParamScmUrl, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: scmUrl}},
-		{Name: PipelineParamScmTag, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.ScmInfo.Tag}},
-		{Name: PipelineParamScmHash, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.ScmInfo.CommitHash}},
-		{Name: PipelineParamChainsGitUrl, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: scmUrl}},
-		{Name: PipelineParamChainsGitCommit, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.ScmInfo.CommitHash}},
-		{Name: PipelineParamPath, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: contextDir}},
-		{Name: PipelineParamGoals, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: attempt.Recipe.CommandLine}},
-		{Name: PipelineParamEnforceVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Recipe.EnforceVersion}},
-		{Name: PipelineParamProjectVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.Version}},
-		{Name: PipelineParamToolVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Recipe.ToolVersion}},
-		{Name: PipelineParamJavaVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Recipe.JavaVersion}},
-	}
+	paramValues := buildPipelineParams(log, db, attempt)
+	// The build timeout covers the whole pipeline so the build step needs to know when it started
+	paramValues = append(paramValues, tektonpipeline.Param{Name: PipelineParamStartTime, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: strconv.FormatInt(time.Now().Unix(), 10)}})
 
 	systemConfig := v1alpha1.SystemConfig{}
 	err = r.client.Get(ctx, types.NamespacedName{Name: systemconfig.SystemConfigKey}, &systemConfig)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+	architecture := builderImageArchitecture(&systemConfig, attempt.Recipe.Image)
+	if attempt.Recipe.Architecture != "" {
+		// The platform the recipe asks for takes precedence as the image may be a multi-arch one
+		architecture = strings.TrimPrefix(attempt.Recipe.Architecture, "linux/")
+	}
+	if architecture != "" {
+		compatible, err := r.nodesAvailableForArchitecture(ctx, architecture)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if !compatible {
+			// Move onto the next recipe as another builder image may be usable on the available nodes
+			msg := "The DependencyBuild %s/%s recipe image %s requires architecture %s but no compatible node exists"
+			r.eventRecorder.Eventf(db, v1.EventTypeWarning, "NoCompatibleNode", msg, db.Namespace, db.Name, attempt.Recipe.Image, architecture)
+			return reconcile.Result{}, r.updateDependencyBuildState(ctx, db, v1alpha1.DependencyBuildStateSubmitBuild, fmt.Sprintf(msg, db.Namespace, db.Name, attempt.Recipe.Image, architecture))
+		}
+	}
+	if check := jbsConfig.Spec.BuildSettings.PullSecretCheck; check == v1alpha1.PullSecretCheckWarn || check == v1alpha1.PullSecretCheckFail {
+		images, err := r.pulledImages(ctx, jbsConfig, attempt.Recipe, buildRequestProcessorImage)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		configs, err := r.pullSecretConfigs(ctx, db.Namespace, jbsConfig)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if uncovered := imagesWithoutCredentials(images, configs); len(uncovered) > 0 {
+			msg := "The DependencyBuild %s/%s pulls images %s that the image registry secrets have no credentials for"
+			r.eventRecorder.Eventf(db, v1.EventTypeWarning, "MissingPullSecret", msg, db.Namespace, db.Name, strings.Join(uncovered, ", "))
+			if check == v1alpha1.PullSecretCheckFail {
+				return reconcile.Result{}, r.updateDependencyBuildState(ctx, db, v1alpha1.DependencyBuildStateFailed, fmt.Sprintf(msg, db.Namespace, db.Name, strings.Join(uncovered, ", ")))
+			}
+			log.Info(fmt.Sprintf(msg, db.Namespace, db.Name, strings.Join(uncovered, ", ")))
+		}
+	}
+	recipe := attempt.Recipe
+	if systemConfig.Spec.ClampAdditionalMemoryToNodes && recipe.AdditionalMemory > 0 {
+		nodes := v1.NodeList{}
+		listOptions := []client.ListOption{}
+		if architecture != "" {
+			listOptions = append(listOptions, client.MatchingLabels{v1.LabelArchStable: architecture})
+		}
+		if err := r.client.List(ctx, &nodes, listOptions...); err != nil {
+			return reconcile.Result{}, err
+		}
+		maxMemory, err := maxAdditionalMemoryForNodes(jbsConfig, nodes.Items)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if maxMemory >= 0 && recipe.AdditionalMemory > maxMemory {
+			// Only the pipeline uses the limited value, the attempt keeps the memory the recipe asked for
+			msg := "The DependencyBuild %s/%s additionalMemory %d exceeds the memory of the largest node so is limited to %d"
+			r.eventRecorder.Eventf(db, v1.EventTypeWarning, "AdditionalMemoryExceedsNodes", msg, db.Namespace, db.Name, recipe.AdditionalMemory, maxMemory)
+			log.Info(fmt.Sprintf(msg, db.Namespace, db.Name, recipe.AdditionalMemory, maxMemory))
+			recipe = recipe.DeepCopy()
+			recipe.AdditionalMemory = maxMemory
+		}
+	}
 	diagnosticContainerfile := ""
 	// TODO: set owner, pass parameter to do verify if true, via an annoaton on the dependency build, may eed to wait for dep build to exist verify is an optional, use append on each step in build recipes
 	preBuildImages := map[string]string{}
@@ -597,10 +726,10 @@ func (r *ReconcileDependencyBuild) handleStateBuilding(ctx context.Context, db *
 		preBuildImages[i.BaseBuilderImage+"-"+i.Tool] = i.BuiltImageDigest
 	}
 	pr.Spec.Timeouts = &tektonpipeline.TimeoutFields{
-		Pipeline: &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout},
-		Tasks:    &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout},
+		Pipeline: &v12.Duration{Duration: buildTimeout(jbsConfig)},
+		Tasks:    &v12.Duration{Duration: buildTimeout(jbsConfig)},
 	}
-	pr.Spec.PipelineSpec, diagnosticContainerfile, _, _, err = createPipelineSpec(log, attempt.Recipe.Tool, db.Status.CommitTime, jbsConfig, &systemConfig, attempt.Recipe, db, paramValues, buildRequestProcessorImage, attempt.BuildId, preBuildImages)
+	pr.Spec.PipelineSpec, diagnosticContainerfile, _, _, err = createPipelineSpec(log, recipe.Tool, db.Status.CommitTime, jbsConfig, &systemConfig, recipe, db, paramValues, buildRequestProcessorImage, attempt.BuildId, preBuildImages)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -613,12 +742,13 @@ func (r *ReconcileDependencyBuild) handleStateBuilding(ctx context.Context, db *
 		{Name: WorkspaceSource, EmptyDir: &v1.EmptyDirVolumeSource{}},
 	}
 
-	if !jbsConfig.Spec.CacheSettings.DisableTLS {
-		pr.Spec.Workspaces = append(pr.Spec.Workspaces, tektonpipeline.WorkspaceBinding{Name: "tls", ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.TlsConfigMapName}}})
-	} else {
-		pr.Spec.Workspaces = append(pr.Spec.Workspaces, tektonpipeline.WorkspaceBinding{Name: "tls", EmptyDir: &v1.EmptyDirVolumeSource{}})
+	tlsWorkspace, err := r.tlsWorkspace(ctx, db.Namespace, jbsConfig)
+	if err != nil {
+		return reconcile.Result{}, err
 	}
-	pr.Spec.Timeouts = &tektonpipeline.TimeoutFields{Pipeline: &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout}}
+	pr.Spec.Workspaces = append(pr.Spec.Workspaces, tlsWorkspace)
+	pr.Spec.Timeouts = &tektonpipeline.TimeoutFields{Pipeline: &v12.Duration{Duration: buildTimeout(jbsConfig)}}
+	pr.Spec.TaskRunTemplate.PodTemplate = podTemplate(jbsConfig, architecture)
 	if err := controllerutil.SetOwnerReference(db, &pr, r.scheme); err != nil {
 		return reconcile.Result{}, err
 	}
@@ -634,6 +764,65 @@ func (r *ReconcileDependencyBuild) handleStateBuilding(ctx context.Context, db *
 	return reconcile.Result{}, r.client.Status().Update(ctx, db)
 }
 
+// builderImageArchitecture returns the architecture of the system builder image matching the recipe image, or an
+// empty string if it is unknown.
+func builderImageArchitecture(systemConfig *v1alpha1.SystemConfig, image string) string {
+	for _, builder := range systemConfig.Spec.Builders {
+		if builder.Image == image {
+			return builder.Architecture
+		}
+	}
+	return ""
+}
+
+// podTemplate returns the pod template of the tasks of a pipeline run, which pins them to the architecture and sets the
+// configured priority class. This is nil if neither is needed.
+func podTemplate(jbsConfig *v1alpha1.JBSConfig, architecture string) *pod.Template {
+	if architecture == "" && jbsConfig.Spec.BuildSettings.PriorityClassName == "" {
+		return nil
+	}
+	template := &pod.Template{}
+	if architecture != "" {
+		template.NodeSelector = map[string]string{v1.LabelArchStable: architecture}
+	}
+	if priorityClassName := jbsConfig.Spec.BuildSettings.PriorityClassName; priorityClassName != "" {
+		template.PriorityClassName = &priorityClassName
+	}
+	return template
+}
+
+func (r *ReconcileDependencyBuild) nodesAvailableForArchitecture(ctx context.Context, architecture string) (bool, error) {
+	nodes := v1.NodeList{}
+	err := r.client.List(ctx, &nodes, client.MatchingLabels{v1.LabelArchStable: architecture})
+	if err != nil {
+		return false, err
+	}
+	return len(nodes.Items) > 0, nil
+}
+
+// maxAdditionalMemoryForNodes returns the most additional memory in MiB a build can have and still fit within the
+// allocatable memory of the largest of the nodes, or -1 if there are no nodes to limit it.
+func maxAdditionalMemoryForNodes(jbsConfig *v1alpha1.JBSConfig, nodes []v1.Node) (int, error) {
+	var largest *resource.Quantity
+	for _, node := range nodes {
+		if allocatable, ok := node.Status.Allocatable[v1.ResourceMemory]; ok && (largest == nil || allocatable.Cmp(*largest) > 0) {
+			largest = &allocatable
+		}
+	}
+	if largest == nil {
+		return -1, nil
+	}
+	limits, err := memoryLimits(jbsConfig, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	available := (largest.Value() - limits.buildRequestMemory.Value()) / (1024 * 1024)
+	if available < 0 {
+		return 0, nil
+	}
+	return int(available), nil
+}
+
 func currentDependencyBuildPipelineName(db *v1alpha1.DependencyBuild) string {
 	return fmt.Sprintf("%s-build-%d", db.Name, len(db.Status.BuildAttempts))
 }
@@ -674,7 +863,7 @@ func (r *ReconcileDependencyBuild) handleBuildPipelineRunReceived(ctx context.Co
 		//this is a big perfomance optimisation, as it can be re-used on subsequent attempts
 		alreadyExists := false
 		for _, i := range db.Status.PreBuildImages {
-			if i.BaseBuilderImage == attempt.Recipe.Image && i.Tool == attempt.Recipe.Tool {
+			if i.BaseBuilderImage == attempt.Recipe.Image && i.Tool == preBuildTools(attempt.Recipe) {
 				alreadyExists = true
 			}
 		}
@@ -690,7 +879,7 @@ func (r *ReconcileDependencyBuild) handleBuildPipelineRunReceived(ctx context.Co
 						if preBuildSuccess {
 							for _, res := range tr.Status.Results {
 								if res.Name == PreBuildImageDigest && res.Value.StringVal != "" {
-									db.Status.PreBuildImages = append(db.Status.PreBuildImages, v1alpha1.PreBuildImage{BaseBuilderImage: attempt.Recipe.Image, BuiltImageDigest: res.Value.StringVal, Tool: attempt.Recipe.Tool})
+									db.Status.PreBuildImages = append(db.Status.PreBuildImages, v1alpha1.PreBuildImage{BaseBuilderImage: attempt.Recipe.Image, BuiltImageDigest: res.Value.StringVal, Tool: preBuildTools(attempt.Recipe)})
 								}
 							}
 						}
@@ -761,6 +950,7 @@ func (r *ReconcileDependencyBuild) handleBuildPipelineRunReceived(ctx context.Co
 			var digest string
 			var passedVerification bool
 			var verificationResults string
+			var artifactChecksums string
 			var deployed []string
 			var gitArchive v1alpha1.GitArchive
 
@@ -785,6 +975,8 @@ func (r *ReconcileDependencyBuild) handleBuildPipelineRunReceived(ctx context.Co
 					// 	But this is now stored as
 					// 		"verificationFailures": "{\"commons-lang:commons-lang:jar:2.5\":[]}"
 					verificationResults = i.Value.StringVal
+				} else if i.Name == PipelineResultArtifactChecksums {
+					artifactChecksums = i.Value.StringVal
 				} else if i.Name == PipelineResultGavs {
 					// TODO: What is the difference between this and PipelineResultDeployedResources?
 					deployed := strings.Split(i.Value.StringVal, ",")
@@ -799,11 +991,13 @@ func (r *ReconcileDependencyBuild) handleBuildPipelineRunReceived(ctx context.Co
 					}
 				}
 			}
-			err = r.createRebuiltArtifacts(ctx, pr, db, image, digest, deployed)
-			if err != nil {
-				return reconcile.Result{}, err
+			if !db.Spec.VerifyOnly {
+				err = r.createRebuiltArtifacts(ctx, pr, db, image, digest, deployed)
+				if err != nil {
+					return reconcile.Result{}, err
+				}
 			}
-			if db.Annotations[artifactbuild.DependencyCreatedAnnotation] != "" {
+			if !db.Spec.VerifyOnly && db.Annotations[artifactbuild.DependencyCreatedAnnotation] != "" {
 				err = r.createArtifacts(ctx, pr, db, deployed)
 				if err != nil {
 					return reconcile.Result{}, err
@@ -815,13 +1009,16 @@ func (r *ReconcileDependencyBuild) handleBuildPipelineRunReceived(ctx context.Co
 				ImageDigest:         digest,
 				Verified:            passedVerification,
 				VerificationResults: verificationResults,
+				ArtifactChecksums:   artifactChecksums,
 				Gavs:                deployed,
 				GitArchive:          gitArchive,
 				Contaminants:        db.Status.Contaminants,
 			}
 
 			problemContaminates := db.Status.ProblemContaminates()
-			if len(problemContaminates) == 0 {
+			if len(problemContaminates) == 0 && db.Spec.VerifyOnly {
+				return reconcile.Result{}, r.updateDependencyBuildState(ctx, db, v1alpha1.DependencyBuildStateComplete, "build was verified, deployment is disabled")
+			} else if len(problemContaminates) == 0 {
 				return reconcile.Result{}, r.updateDependencyBuildState(ctx, db, v1alpha1.DependencyBuildStateDeploying, "build was completed")
 			} else {
 				msg := "The DependencyBuild %s/%s was contaminated with community dependencies"
@@ -1099,11 +1296,8 @@ func (r *ReconcileDependencyBuild) createLookupBuildInfoPipeline(ctx context.Con
 	}
 	build := db.Spec
 	path := build.ScmInfo.Path
-	zero := int64(0)
-	cacheUrl := "https://jvm-build-workspace-artifact-cache-tls." + jbsConfig.Namespace + ".svc.cluster.local"
-	if jbsConfig.Spec.CacheSettings.DisableTLS {
-		cacheUrl = "http://jvm-build-workspace-artifact-cache." + jbsConfig.Namespace + ".svc.cluster.local"
-	}
+	user := runAsUser(jbsConfig)
+	cacheUrl := cacheServiceUrl(jbsConfig)
 	registries := jbsconfig.ImageRegistriesToString(jbsConfig.Spec.SharedRegistries)
 
 	trueBool := true
@@ -1163,6 +1357,9 @@ func (r *ReconcileDependencyBuild) createLookupBuildInfoPipeline(ctx context.Con
 	} else if strings.HasSuffix(image, "dev") {
 		pullPolicy = v1.PullAlways
 	}
+	if policy, ok := configuredPullPolicy(jbsConfig); ok {
+		pullPolicy = policy
+	}
 	secretOptional := false
 	if jbsConfig.Annotations != nil {
 		val := jbsConfig.Annotations[jbsconfig.TestRegistry]
@@ -1188,7 +1385,7 @@ func (r *ReconcileDependencyBuild) createLookupBuildInfoPipeline(ctx context.Con
 				Name:            "process-build-requests",
 				Image:           image,
 				ImagePullPolicy: pullPolicy,
-				SecurityContext: &v1.SecurityContext{RunAsUser: &zero},
+				SecurityContext: &v1.SecurityContext{RunAsUser: user},
 				ComputeResources: v1.ResourceRequirements{
 					//TODO: make configurable
 					Requests: v1.ResourceList{"memory": resource.MustParse(memory), "cpu": resource.MustParse("10m")},
@@ -1282,6 +1479,69 @@ func (r *ReconcileDependencyBuild) failedDueToMemory(ctx context.Context, log lo
 	return false
 }
 
+// tlsWorkspace returns the binding of the TLS workspace. This holds the cache service CA as service-ca.crt and any
+// additional CA certificates under additional-ca, so the keystore script can import them all.
+func (r *ReconcileDependencyBuild) tlsWorkspace(ctx context.Context, namespace string, jbsConfig *v1alpha1.JBSConfig) (tektonpipeline.WorkspaceBinding, error) {
+	additional, err := r.additionalCAProjection(ctx, namespace, jbsConfig)
+	if err != nil {
+		return tektonpipeline.WorkspaceBinding{}, err
+	}
+	if additional == nil {
+		if !jbsConfig.Spec.CacheSettings.DisableTLS {
+			return tektonpipeline.WorkspaceBinding{Name: WorkspaceTls, ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.TlsConfigMapName}}}, nil
+		}
+		return tektonpipeline.WorkspaceBinding{Name: WorkspaceTls, EmptyDir: &v1.EmptyDirVolumeSource{}}, nil
+	}
+	var sources []v1.VolumeProjection
+	if !jbsConfig.Spec.CacheSettings.DisableTLS {
+		sources = append(sources, v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.TlsConfigMapName}}})
+	}
+	sources = append(sources, *additional)
+	return tektonpipeline.WorkspaceBinding{Name: WorkspaceTls, Projected: &v1.ProjectedVolumeSource{Sources: sources}}, nil
+}
+
+// additionalCAProjection projects every key of the additional CA certificates secret or config map under
+// additional-ca. The keys have to be listed to move them into the directory, so a missing secret or config map is
+// ignored as it then holds no certificates.
+func (r *ReconcileDependencyBuild) additionalCAProjection(ctx context.Context, namespace string, jbsConfig *v1alpha1.JBSConfig) (*v1.VolumeProjection, error) {
+	ca := jbsConfig.Spec.AdditionalCACertificates
+	if ca == nil || (ca.SecretName == "" && ca.ConfigMapName == "") {
+		return nil, nil
+	}
+	var keys []string
+	if ca.SecretName != "" {
+		secret := v1.Secret{}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ca.SecretName}, &secret)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		for k := range secret.Data {
+			keys = append(keys, k)
+		}
+	} else {
+		configMap := v1.ConfigMap{}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ca.ConfigMapName}, &configMap)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		for k := range configMap.Data {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+	var items []v1.KeyToPath
+	for _, k := range keys {
+		items = append(items, v1.KeyToPath{Key: k, Path: "additional-ca/" + k})
+	}
+	if ca.SecretName != "" {
+		return &v1.VolumeProjection{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: ca.SecretName}, Items: items}}, nil
+	}
+	return &v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: ca.ConfigMapName}, Items: items}}, nil
+}
+
 func (r *ReconcileDependencyBuild) buildRequestProcessorImage(ctx context.Context) (string, error) {
 	image, err := util.GetImageName(ctx, r.client, "build-request-processor", "JVM_BUILD_SERVICE_REQPROCESSOR_IMAGE")
 	return image, err
@@ -1390,6 +1650,9 @@ func (r *ReconcileDependencyBuild) handleStateDeploying(ctx context.Context, db
 	paramValues := []tektonpipeline.Param{
 		{Name: PipelineResultImageDigest, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Build.Results.ImageDigest}},
 	}
+	if jbsConfig.Spec.MavenDeployment.ValidateChecksums {
+		paramValues = append(paramValues, tektonpipeline.Param{Name: PipelineResultArtifactChecksums, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Build.Results.ArtifactChecksums}})
+	}
 
 	systemConfig := v1alpha1.SystemConfig{}
 	err = r.client.Get(ctx, types.NamespacedName{Name: systemconfig.SystemConfigKey}, &systemConfig)
@@ -1398,8 +1661,8 @@ func (r *ReconcileDependencyBuild) handleStateDeploying(ctx context.Context, db
 	}
 
 	pr.Spec.Timeouts = &tektonpipeline.TimeoutFields{
-		Pipeline: &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout},
-		Tasks:    &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout},
+		Pipeline: &v12.Duration{Duration: buildTimeout(jbsConfig)},
+		Tasks:    &v12.Duration{Duration: buildTimeout(jbsConfig)},
 	}
 	pr.Spec.PipelineSpec, err = createDeployPipelineSpec(jbsConfig, db, buildRequestProcessorImage, gavs)
 	if err != nil {
@@ -1411,12 +1674,13 @@ func (r *ReconcileDependencyBuild) handleStateDeploying(ctx context.Context, db
 		{Name: WorkspaceSource, EmptyDir: &v1.EmptyDirVolumeSource{}},
 	}
 
-	if !jbsConfig.Spec.CacheSettings.DisableTLS {
-		pr.Spec.Workspaces = append(pr.Spec.Workspaces, tektonpipeline.WorkspaceBinding{Name: "tls", ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: v1alpha1.TlsConfigMapName}}})
-	} else {
-		pr.Spec.Workspaces = append(pr.Spec.Workspaces, tektonpipeline.WorkspaceBinding{Name: "tls", EmptyDir: &v1.EmptyDirVolumeSource{}})
+	tlsWorkspace, err := r.tlsWorkspace(ctx, db.Namespace, jbsConfig)
+	if err != nil {
+		return reconcile.Result{}, err
 	}
-	pr.Spec.Timeouts = &tektonpipeline.TimeoutFields{Pipeline: &v12.Duration{Duration: time.Hour * v1alpha1.DefaultTimeout}}
+	pr.Spec.Workspaces = append(pr.Spec.Workspaces, tlsWorkspace)
+	pr.Spec.Timeouts = &tektonpipeline.TimeoutFields{Pipeline: &v12.Duration{Duration: buildTimeout(jbsConfig)}}
+	pr.Spec.TaskRunTemplate.PodTemplate = podTemplate(jbsConfig, "")
 	if err := controllerutil.SetOwnerReference(db, &pr, r.scheme); err != nil {
 		return reconcile.Result{}, err
 	}
@@ -1451,6 +1715,14 @@ func (r *ReconcileDependencyBuild) handleDeployPipelineRunReceived(ctx context.C
 
 		success := pr.Status.GetCondition(apis.ConditionSucceeded).IsTrue()
 		if success {
+			jbsConfig := &v1alpha1.JBSConfig{}
+			err = r.client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: v1alpha1.JBSConfigName}, jbsConfig)
+			if err != nil && !errors.IsNotFound(err) {
+				return reconcile.Result{}, err
+			}
+			if err = r.triggerDownstreamBuilds(ctx, db, jbsConfig); err != nil {
+				return reconcile.Result{}, err
+			}
 			return reconcile.Result{}, r.updateDependencyBuildState(ctx, db, v1alpha1.DependencyBuildStateComplete, "deploy pipeline complete")
 		} else {
 			return reconcile.Result{}, r.updateDependencyBuildState(ctx, db, v1alpha1.DependencyBuildStateFailed, "deploy pipeline failed")
@@ -1460,6 +1732,124 @@ func (r *ReconcileDependencyBuild) handleDeployPipelineRunReceived(ctx context.C
 	return reconcile.Result{}, nil
 }
 
+// downstreamDependents returns the GAVs of the known dependents of the deployed artifacts if downstream builds are
+// enabled, leaving out any that were deployed by the same build.
+func downstreamDependents(jbsConfig *v1alpha1.JBSConfig, deployed []string) []string {
+	downstream := jbsConfig.Spec.MavenDeployment.DownstreamBuilds
+	if !downstream.Enabled {
+		return nil
+	}
+	skip := map[string]bool{}
+	for _, i := range deployed {
+		skip[i] = true
+	}
+	var ret []string
+	for _, i := range deployed {
+		split := strings.Split(i, ":")
+		if len(split) < 2 {
+			continue
+		}
+		for _, dependent := range downstream.Dependents[split[0]+":"+split[1]] {
+			if !skip[dependent] {
+				skip[dependent] = true
+				ret = append(ret, dependent)
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// triggerDownstreamBuilds creates an ArtifactBuild for each known dependent of the deployed artifacts. Dependents
+// that have already been built are rebuilt, those still being built are left alone. Dependents whose build led to this
+// one are skipped, so artifacts that depend on each other don't keep rebuilding each other.
+func (r *ReconcileDependencyBuild) triggerDownstreamBuilds(ctx context.Context, db *v1alpha1.DependencyBuild, jbsConfig *v1alpha1.JBSConfig) error {
+	log, _ := logr.FromContext(ctx)
+	dependents := downstreamDependents(jbsConfig, db.Status.DeployedArtifacts)
+	if len(dependents) == 0 {
+		return nil
+	}
+	upstream, err := r.upstreamArtifactBuilds(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, i := range dependents {
+		ab := v1alpha1.ArtifactBuild{}
+		ab.Namespace = db.Namespace
+		ab.Name = artifactbuild.CreateABRName(i)
+		if upstream[ab.Name] {
+			log.Info(fmt.Sprintf("Not rebuilding ArtifactBuild %s as the build of %s is downstream of it", ab.Name, db.Name))
+			continue
+		}
+		ab.Annotations = map[string]string{artifactbuild.DownstreamOfAnnotation: db.Name}
+		ab.Spec.GAV = i
+		err := r.client.Create(ctx, &ab)
+		if err == nil {
+			log.Info(fmt.Sprintf("Created ArtifactBuild %s for %s as it depends on artifacts deployed by %s", ab.Name, i, db.Name), "action", "ADD")
+			continue
+		} else if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		err = r.client.Get(ctx, types.NamespacedName{Namespace: ab.Namespace, Name: ab.Name}, &ab)
+		if err != nil {
+			return err
+		}
+		if ab.Status.State != v1alpha1.ArtifactBuildStateComplete && ab.Status.State != v1alpha1.ArtifactBuildStateFailed {
+			continue
+		}
+		if ab.Annotations == nil {
+			ab.Annotations = map[string]string{}
+		}
+		ab.Annotations[artifactbuild.RebuildAnnotation] = "true"
+		ab.Annotations[artifactbuild.DownstreamOfAnnotation] = db.Name
+		log.Info(fmt.Sprintf("Rebuilding ArtifactBuild %s as it depends on artifacts deployed by %s", ab.Name, db.Name), "action", "UPDATE")
+		err = r.client.Update(ctx, &ab)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upstreamArtifactBuilds returns the names of the ArtifactBuilds that own the build, and those of the builds that
+// triggered it as a downstream build, following the chain of DownstreamOfAnnotation back to the first build.
+func (r *ReconcileDependencyBuild) upstreamArtifactBuilds(ctx context.Context, db *v1alpha1.DependencyBuild) (map[string]bool, error) {
+	ret := map[string]bool{}
+	visited := map[string]bool{db.Name: true}
+	builds := []*v1alpha1.DependencyBuild{db}
+	for len(builds) > 0 {
+		current := builds[0]
+		builds = builds[1:]
+		for _, ownerRef := range current.OwnerReferences {
+			if !strings.EqualFold(ownerRef.Kind, "artifactbuild") && !strings.EqualFold(ownerRef.Kind, "artifactbuilds") {
+				continue
+			}
+			ret[ownerRef.Name] = true
+			ab := v1alpha1.ArtifactBuild{}
+			err := r.client.Get(ctx, types.NamespacedName{Name: ownerRef.Name, Namespace: db.Namespace}, &ab)
+			if errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			upstream := ab.Annotations[artifactbuild.DownstreamOfAnnotation]
+			if upstream == "" || visited[upstream] {
+				continue
+			}
+			visited[upstream] = true
+			upstreamBuild := v1alpha1.DependencyBuild{}
+			err = r.client.Get(ctx, types.NamespacedName{Name: upstream, Namespace: db.Namespace}, &upstreamBuild)
+			if errors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			builds = append(builds, &upstreamBuild)
+		}
+	}
+	return ret, nil
+}
+
 // This is to remove any '#xxx' fragment from a URI so that git clone commands don't need separate adjustment
 func modifyURLFragment(log logr.Logger, scmURL string) string {
 	var result = scmURL
@@ -1502,3 +1892,30 @@ func (r *ReconcileDependencyBuild) handleStateAnalyzeBuild(ctx context.Context,
 	}
 	return reconcile.Result{}, nil
 }
+
+// buildPipelineParams returns the parameter values of the build pipeline for the given build attempt.
+func buildPipelineParams(log logr.Logger, db *v1alpha1.DependencyBuild, attempt *v1alpha1.BuildAttempt) []tektonpipeline.Param {
+	contextDir := db.Spec.ScmInfo.Path
+	if attempt.Recipe.ContextPath != "" {
+		contextDir = attempt.Recipe.ContextPath
+	}
+	scmUrl := modifyURLFragment(log, db.Spec.ScmInfo.SCMURL)
+	ret := []tektonpipeline.Param{
+		{Name: PipelineBuildId, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Name}},
+		{Name: PipelineParamScmUrl, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: scmUrl}},
+		{Name: PipelineParamScmTag, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.ScmInfo.Tag}},
+		{Name: PipelineParamScmHash, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.ScmInfo.CommitHash}},
+		{Name: PipelineParamChainsGitUrl, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: scmUrl}},
+		{Name: PipelineParamChainsGitCommit, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.ScmInfo.CommitHash}},
+		{Name: PipelineParamPath, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: contextDir}},
+		{Name: PipelineParamEnforceVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: enforcedVersion(db, attempt.Recipe)}},
+		{Name: PipelineParamProjectVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: db.Spec.Version}},
+		{Name: PipelineParamToolVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Recipe.ToolVersion}},
+		{Name: PipelineParamJavaVersion, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: attempt.Recipe.JavaVersion}},
+	}
+	// Without goals the pipeline runs the default goals of the build tool
+	if len(attempt.Recipe.CommandLine) > 0 {
+		ret = append(ret, tektonpipeline.Param{Name: PipelineParamGoals, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeArray, ArrayVal: attempt.Recipe.CommandLine}})
+	}
+	return ret
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -119,6 +121,7 @@ func TestStateNew(t *testing.T) {
 		g.Expect(len(db.Status.BuildAttempts)).Should(Equal(1))
 		g.Expect(db.Status.BuildAttempts[0].Recipe.Image).Should(HavePrefix("quay.io/redhat-appstudio/hacbs-jdk"))
 		g.Expect(db.Status.BuildAttempts[0].Recipe.Repositories).Should(Equal([]string{"jboss", "gradle"}))
+		g.Expect(db.Status.SelectedBuildRecipe).Should(Equal(db.Status.BuildAttempts[0].Recipe))
 
 	})
 }
@@ -228,7 +231,7 @@ func TestStateDetect(t *testing.T) {
 					g.Expect(or.Name).Should(Equal(db.Name))
 				}
 			}
-			g.Expect(len(tr.Spec.Params)).Should(Equal(12))
+			g.Expect(len(tr.Spec.Params)).Should(Equal(13))
 			for _, param := range tr.Spec.Params {
 				switch param.Name {
 				case PipelineParamScmHash:
@@ -248,6 +251,8 @@ func TestStateDetect(t *testing.T) {
 					g.Expect(param.Value.StringVal).Should(BeEmpty())
 				case PipelineParamToolVersion:
 					g.Expect(param.Value.StringVal).Should(Equal("3.8"))
+				case PipelineParamStartTime:
+					g.Expect(strconv.ParseInt(param.Value.StringVal, 10, 64)).Should(BeNumerically("~", time.Now().Unix(), 60))
 				}
 			}
 		}
@@ -409,6 +414,31 @@ func TestStateBuilding(t *testing.T) {
 		g.Expect(client.Get(ctx, types.NamespacedName{Name: pr.Name, Namespace: pr.Namespace}, pr)).ShouldNot(Succeed())
 	})
 
+	t.Run("Test reconcile building verify only DependencyBuild with succeeded pipeline", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		setup(g)
+		db := getBuild(client, g)
+		db.Spec.VerifyOnly = true
+		g.Expect(client.Update(ctx, db)).Should(BeNil())
+
+		pr := getBuildPipeline(client, g)
+		pr.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+		pr.Status.SetCondition(&apis.Condition{
+			Type:               apis.ConditionSucceeded,
+			Status:             "True",
+			LastTransitionTime: apis.VolatileTime{Inner: metav1.Time{Time: time.Now()}},
+		})
+		pr.Status.Results = []tektonpipeline.PipelineRunResult{{Name: PipelineResultDeployedResources, Value: tektonpipeline.ResultValue{Type: tektonpipeline.ParamTypeString, StringVal: TestArtifact}}}
+		g.Expect(client.Status().Update(ctx, pr)).Should(BeNil())
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: taskRunName}))
+		db = getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateComplete))
+
+		// Nothing was pushed so no RebuiltArtifact is created
+		ra := v1alpha1.RebuiltArtifact{}
+		g.Expect(client.Get(ctx, types.NamespacedName{Name: artifactbuild.CreateABRName(TestArtifact), Namespace: metav1.NamespaceDefault}, &ra)).ShouldNot(Succeed())
+	})
+
 	t.Run("Test reconcile building DependencyBuild with failed deploy pipeline", func(t *testing.T) {
 		g := NewGomegaWithT(t)
 		setup(g)
@@ -440,6 +470,41 @@ func TestStateBuilding(t *testing.T) {
 		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateFailed))
 
 	})
+	t.Run("Test reconcile building DependencyBuild with succeeded deploy pipeline triggers downstream builds", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		setup(g)
+		jbsConfig := v1alpha1.JBSConfig{}
+		g.Expect(client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.JBSConfigName}, &jbsConfig)).Should(Succeed())
+		jbsConfig.Spec.MavenDeployment.DownstreamBuilds = v1alpha1.DownstreamBuilds{Enabled: true, Dependents: map[string][]string{"com.test:test": {"com.test:new:1.0", "com.test:built:1.0"}}}
+		g.Expect(client.Update(ctx, &jbsConfig)).Should(Succeed())
+		built := v1alpha1.ArtifactBuild{Spec: v1alpha1.ArtifactBuildSpec{GAV: "com.test:built:1.0"}}
+		built.Name = artifactbuild.CreateABRName(built.Spec.GAV)
+		built.Namespace = metav1.NamespaceDefault
+		g.Expect(client.Create(ctx, &built)).Should(Succeed())
+		built.Status.State = v1alpha1.ArtifactBuildStateComplete
+		g.Expect(client.Status().Update(ctx, &built)).Should(Succeed())
+		runSuccessfulBuild(g, client, ctx, reconciler, taskRunName)
+
+		pr := getDeployPipeline(client, g)
+		pr.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+		pr.Status.SetCondition(&apis.Condition{
+			Type:               apis.ConditionSucceeded,
+			Status:             "True",
+			LastTransitionTime: apis.VolatileTime{Inner: metav1.Time{Time: time.Now()}},
+		})
+		g.Expect(client.Status().Update(ctx, pr)).Should(BeNil())
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Name: pr.Name, Namespace: pr.Namespace}}))
+		db := getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateComplete))
+
+		ab := v1alpha1.ArtifactBuild{}
+		g.Expect(client.Get(ctx, types.NamespacedName{Name: artifactbuild.CreateABRName("com.test:new:1.0"), Namespace: metav1.NamespaceDefault}, &ab)).Should(Succeed())
+		g.Expect(ab.Spec.GAV).Should(Equal("com.test:new:1.0"))
+		g.Expect(ab.Annotations[artifactbuild.DownstreamOfAnnotation]).Should(Equal(db.Name))
+		g.Expect(client.Get(ctx, types.NamespacedName{Name: built.Name, Namespace: metav1.NamespaceDefault}, &ab)).Should(Succeed())
+		g.Expect(ab.Annotations[artifactbuild.RebuildAnnotation]).Should(Equal("true"))
+		g.Expect(ab.Annotations[artifactbuild.DownstreamOfAnnotation]).Should(Equal(db.Name))
+	})
 	t.Run("Test reconcile building DependencyBuild with failed pipeline", func(t *testing.T) {
 		g := NewGomegaWithT(t)
 		setup(g)
@@ -739,3 +804,345 @@ func TestStateDependencyBuildStateAnalyzeBuild(t *testing.T) {
 		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateAnalyzeBuild))
 	})
 }
+
+func TestStateBuildingArchitecture(t *testing.T) {
+	ctx := context.TODO()
+
+	var client runtimeclient.Client
+	var reconciler *ReconcileDependencyBuild
+	buildName := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "test"}
+	image := "quay.io/redhat-appstudio/hacbs-jdk11-builder:latest"
+	setup := func(g *WithT, nodes ...runtimeclient.Object) {
+		client, reconciler = setupClientAndReconciler(nodes...)
+
+		sysConfig := v1alpha1.SystemConfig{}
+		g.Expect(client.Get(ctx, types.NamespacedName{Name: systemconfig.SystemConfigKey}, &sysConfig)).Should(BeNil())
+		builder := sysConfig.Spec.Builders["jdk11"]
+		builder.Architecture = "arm64"
+		sysConfig.Spec.Builders["jdk11"] = builder
+		g.Expect(client.Update(ctx, &sysConfig)).Should(BeNil())
+
+		db := v1alpha1.DependencyBuild{}
+		db.Namespace = metav1.NamespaceDefault
+		db.Name = "test"
+		db.Status.State = v1alpha1.DependencyBuildStateBuilding
+		db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{
+			{
+				Recipe: &v1alpha1.BuildRecipe{
+					Image: image,
+				},
+				Build: &v1alpha1.BuildPipelineRun{
+					PipelineName: "test-build-0",
+				},
+			},
+		}
+		db.Status.PotentialBuildRecipes = []*v1alpha1.BuildRecipe{db.Status.BuildAttempts[0].Recipe}
+		db.Status.PotentialBuildRecipesIndex = 1
+		db.Spec.ScmInfo.SCMURL = "some-url"
+		db.Spec.ScmInfo.Tag = "some-tag"
+		g.Expect(client.Create(ctx, &db)).Should(BeNil())
+	}
+
+	t.Run("Test builder image architecture lookup", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		sysConfig := v1alpha1.SystemConfig{Spec: v1alpha1.SystemConfigSpec{Builders: map[string]v1alpha1.BuilderImageInfo{
+			"arm": {Image: "quay.io/arm-builder:latest", Architecture: "arm64"},
+			"any": {Image: "quay.io/any-builder:latest"},
+		}}}
+		g.Expect(builderImageArchitecture(&sysConfig, "quay.io/arm-builder:latest")).Should(Equal("arm64"))
+		g.Expect(builderImageArchitecture(&sysConfig, "quay.io/any-builder:latest")).Should(BeEmpty())
+		g.Expect(builderImageArchitecture(&sysConfig, "quay.io/unknown-builder:latest")).Should(BeEmpty())
+	})
+	t.Run("Test build is scheduled onto nodes matching the image architecture", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		setup(g, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "arm-node", Labels: map[string]string{v1.LabelArchStable: "arm64"}}})
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		db := getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateBuilding))
+		pr := getBuildPipeline(client, g)
+		g.Expect(pr.Spec.TaskRunTemplate.PodTemplate).ShouldNot(BeNil())
+		g.Expect(pr.Spec.TaskRunTemplate.PodTemplate.NodeSelector).Should(Equal(map[string]string{v1.LabelArchStable: "arm64"}))
+	})
+	t.Run("Test the recipe platform takes precedence over the image architecture", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		setup(g, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "amd-node", Labels: map[string]string{v1.LabelArchStable: "amd64"}}})
+		db := getBuild(client, g)
+		db.Status.BuildAttempts[0].Recipe.Architecture = "linux/amd64"
+		g.Expect(client.Status().Update(ctx, db)).Should(BeNil())
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		db = getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateBuilding))
+		pr := getBuildPipeline(client, g)
+		g.Expect(pr.Spec.TaskRunTemplate.PodTemplate.NodeSelector).Should(Equal(map[string]string{v1.LabelArchStable: "amd64"}))
+	})
+	t.Run("Test build moves to the next recipe when no node matches the image architecture", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		setup(g, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "amd-node", Labels: map[string]string{v1.LabelArchStable: "amd64"}}})
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		db := getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateSubmitBuild))
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		db = getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateFailed))
+	})
+}
+
+func TestPodTemplate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	g.Expect(podTemplate(jbsConfig, "")).Should(BeNil())
+	g.Expect(podTemplate(jbsConfig, "arm64").PriorityClassName).Should(BeNil())
+
+	jbsConfig.Spec.BuildSettings.PriorityClassName = "low-priority"
+	template := podTemplate(jbsConfig, "")
+	g.Expect(*template.PriorityClassName).Should(Equal("low-priority"))
+	g.Expect(template.NodeSelector).Should(BeNil())
+	template = podTemplate(jbsConfig, "arm64")
+	g.Expect(*template.PriorityClassName).Should(Equal("low-priority"))
+	g.Expect(template.NodeSelector).Should(Equal(map[string]string{v1.LabelArchStable: "arm64"}))
+}
+
+func TestStateBuildingPriorityClass(t *testing.T) {
+	ctx := context.TODO()
+	buildName := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "test"}
+	setup := func(g *WithT, priorityClassName string) (runtimeclient.Client, *ReconcileDependencyBuild) {
+		jbsConfig := &v1alpha1.JBSConfig{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: v1alpha1.JBSConfigName}}
+		jbsConfig.Spec.BuildSettings.PriorityClassName = priorityClassName
+		client, reconciler := setupClientAndReconciler(jbsConfig)
+		db := v1alpha1.DependencyBuild{}
+		db.Namespace = metav1.NamespaceDefault
+		db.Name = "test"
+		db.Status.State = v1alpha1.DependencyBuildStateBuilding
+		db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{
+			Recipe: &v1alpha1.BuildRecipe{Image: "quay.io/redhat-appstudio/hacbs-jdk11-builder:latest"},
+			Build:  &v1alpha1.BuildPipelineRun{PipelineName: "test-build-0"},
+		}}
+		db.Spec.ScmInfo.SCMURL = "some-url"
+		db.Spec.ScmInfo.Tag = "some-tag"
+		g.Expect(client.Create(ctx, &db)).Should(BeNil())
+		return client, reconciler
+	}
+
+	t.Run("Test priority class is not set by default", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		client, reconciler := setup(g, "")
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		pr := getBuildPipeline(client, g)
+		g.Expect(pr.Spec.TaskRunTemplate.PodTemplate).Should(BeNil())
+	})
+	t.Run("Test priority class is set on the build tasks", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		client, reconciler := setup(g, "low-priority")
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		pr := getBuildPipeline(client, g)
+		g.Expect(pr.Spec.TaskRunTemplate.PodTemplate).ShouldNot(BeNil())
+		g.Expect(*pr.Spec.TaskRunTemplate.PodTemplate.PriorityClassName).Should(Equal("low-priority"))
+	})
+}
+
+func TestMaxAdditionalMemoryForNodes(t *testing.T) {
+	g := NewGomegaWithT(t)
+	node := func(memory string) v1.Node {
+		return v1.Node{Status: v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceMemory: resource.MustParse(memory)}}}
+	}
+	jbsConfig := &v1alpha1.JBSConfig{}
+	// Without any nodes there is nothing to limit the memory to
+	g.Expect(maxAdditionalMemoryForNodes(jbsConfig, nil)).Should(Equal(-1))
+	g.Expect(maxAdditionalMemoryForNodes(jbsConfig, []v1.Node{{}})).Should(Equal(-1))
+	// The largest node is used, less the memory every build requests
+	g.Expect(maxAdditionalMemoryForNodes(jbsConfig, []v1.Node{node("2Gi"), node("4Gi"), node("3Gi")})).Should(Equal(3072))
+	jbsConfig.Spec.BuildSettings.BuildRequestMemory = "2Gi"
+	g.Expect(maxAdditionalMemoryForNodes(jbsConfig, []v1.Node{node("4Gi")})).Should(Equal(2048))
+	g.Expect(maxAdditionalMemoryForNodes(jbsConfig, []v1.Node{node("1Gi")})).Should(Equal(0))
+	jbsConfig.Spec.BuildSettings.BuildRequestMemory = "invalid"
+	_, err := maxAdditionalMemoryForNodes(jbsConfig, []v1.Node{node("1Gi")})
+	g.Expect(err).Should(HaveOccurred())
+}
+
+func TestStateBuildingClampAdditionalMemoryToNodes(t *testing.T) {
+	ctx := context.TODO()
+	buildName := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "test"}
+	buildMemory := func(g *WithT, clamp bool) string {
+		client, reconciler := setupClientAndReconciler(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node"}, Status: v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1536Mi")}}})
+		sysConfig := v1alpha1.SystemConfig{}
+		g.Expect(client.Get(ctx, types.NamespacedName{Name: systemconfig.SystemConfigKey}, &sysConfig)).Should(BeNil())
+		sysConfig.Spec.ClampAdditionalMemoryToNodes = clamp
+		g.Expect(client.Update(ctx, &sysConfig)).Should(BeNil())
+
+		db := v1alpha1.DependencyBuild{}
+		db.Namespace = metav1.NamespaceDefault
+		db.Name = "test"
+		db.Status.State = v1alpha1.DependencyBuildStateBuilding
+		db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{
+			Recipe: &v1alpha1.BuildRecipe{Image: "quay.io/redhat-appstudio/hacbs-jdk11-builder:latest", AdditionalMemory: 600},
+			Build:  &v1alpha1.BuildPipelineRun{PipelineName: "test-build-0"},
+		}}
+		db.Spec.ScmInfo.SCMURL = "some-url"
+		db.Spec.ScmInfo.Tag = "some-tag"
+		g.Expect(client.Create(ctx, &db)).Should(BeNil())
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		// The attempt still records the memory the recipe asked for
+		g.Expect(getBuild(client, g).Status.BuildAttempts[0].Recipe.AdditionalMemory).Should(Equal(600))
+		pr := getBuildPipeline(client, g)
+		for _, task := range pr.Spec.PipelineSpec.Tasks {
+			for _, step := range task.TaskSpec.Steps {
+				if step.Name == BuildTaskName {
+					return step.ComputeResources.Requests.Memory().String()
+				}
+			}
+		}
+		return ""
+	}
+
+	t.Run("Test additional memory is not limited by default", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(buildMemory(g, false)).Should(Equal("1624Mi"))
+	})
+	t.Run("Test additional memory is limited to the largest node", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(buildMemory(g, true)).Should(Equal("1536Mi"))
+	})
+}
+
+func TestTLSWorkspaceAdditionalCACertificates(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	jbsConfig := &v1alpha1.JBSConfig{}
+	_, reconciler := setupClientAndReconciler()
+	// Without additional certificates the workspace is just the cache service CA
+	ws, err := reconciler.tlsWorkspace(ctx, metav1.NamespaceDefault, jbsConfig)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ws.ConfigMap.Name).Should(Equal(v1alpha1.TlsConfigMapName))
+	g.Expect(ws.Projected).Should(BeNil())
+
+	// A missing secret holds no certificates
+	jbsConfig.Spec.AdditionalCACertificates = &v1alpha1.CACertificateSource{SecretName: "corporate-ca", ConfigMapName: "ignored"}
+	ws, err = reconciler.tlsWorkspace(ctx, metav1.NamespaceDefault, jbsConfig)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ws.ConfigMap.Name).Should(Equal(v1alpha1.TlsConfigMapName))
+
+	// Every key of the secret is projected alongside the cache service CA
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "corporate-ca"}, Data: map[string][]byte{"root.pem": []byte("root"), "intermediate.crt": []byte("intermediate")}}
+	_, reconciler = setupClientAndReconciler(secret)
+	ws, err = reconciler.tlsWorkspace(ctx, metav1.NamespaceDefault, jbsConfig)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ws.Name).Should(Equal(WorkspaceTls))
+	g.Expect(ws.ConfigMap).Should(BeNil())
+	g.Expect(ws.Projected.Sources).Should(HaveLen(2))
+	g.Expect(ws.Projected.Sources[0].ConfigMap.Name).Should(Equal(v1alpha1.TlsConfigMapName))
+	g.Expect(ws.Projected.Sources[0].ConfigMap.Items).Should(BeEmpty())
+	g.Expect(ws.Projected.Sources[1].Secret.Name).Should(Equal("corporate-ca"))
+	g.Expect(ws.Projected.Sources[1].Secret.Items).Should(Equal([]v1.KeyToPath{{Key: "intermediate.crt", Path: "additional-ca/intermediate.crt"}, {Key: "root.pem", Path: "additional-ca/root.pem"}}))
+
+	// The certificates are still added when TLS to the cache is disabled
+	jbsConfig.Spec.CacheSettings.DisableTLS = true
+	jbsConfig.Spec.AdditionalCACertificates = &v1alpha1.CACertificateSource{ConfigMapName: "corporate-ca"}
+	configMap := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "corporate-ca"}, Data: map[string]string{"ca.pem": "ca"}}
+	_, reconciler = setupClientAndReconciler(configMap)
+	ws, err = reconciler.tlsWorkspace(ctx, metav1.NamespaceDefault, jbsConfig)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(ws.Projected.Sources).Should(Equal([]v1.VolumeProjection{{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "corporate-ca"}, Items: []v1.KeyToPath{{Key: "ca.pem", Path: "additional-ca/ca.pem"}}}}}))
+
+	g.Expect(artifactbuild.InstallKeystoreScript()).Should(ContainSubstring("$(workspaces.tls.path)/additional-ca/*"))
+}
+
+func TestDownstreamDependents(t *testing.T) {
+	g := NewGomegaWithT(t)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.DownstreamBuilds.Dependents = map[string][]string{
+		"com.test:a": {"com.test:c:1.0", "com.test:b:1.0"},
+		"com.test:b": {"com.test:c:1.0", "com.test:d:2.0"},
+	}
+	deployed := []string{"com.test:a:1.0", "com.test:b:1.0", "com.test:e:1.0"}
+	// Nothing is triggered unless enabled
+	g.Expect(downstreamDependents(jbsConfig, deployed)).Should(BeEmpty())
+
+	jbsConfig.Spec.MavenDeployment.DownstreamBuilds.Enabled = true
+	// Dependents are only triggered once and not if they were deployed by the same build
+	g.Expect(downstreamDependents(jbsConfig, deployed)).Should(Equal([]string{"com.test:c:1.0", "com.test:d:2.0"}))
+	g.Expect(downstreamDependents(jbsConfig, []string{"com.test:e:1.0"})).Should(BeEmpty())
+}
+
+func TestDownstreamBuildCycle(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	client, reconciler := setupClientAndReconciler()
+	artifactBuild := func(gav string, downstreamOf string) *v1alpha1.ArtifactBuild {
+		ab := &v1alpha1.ArtifactBuild{Spec: v1alpha1.ArtifactBuildSpec{GAV: gav}}
+		ab.Name = artifactbuild.CreateABRName(gav)
+		ab.Namespace = metav1.NamespaceDefault
+		if downstreamOf != "" {
+			ab.Annotations = map[string]string{artifactbuild.DownstreamOfAnnotation: downstreamOf}
+		}
+		g.Expect(client.Create(ctx, ab)).Should(Succeed())
+		ab.Status.State = v1alpha1.ArtifactBuildStateComplete
+		g.Expect(client.Status().Update(ctx, ab)).Should(Succeed())
+		return ab
+	}
+	dependencyBuild := func(name string, owner *v1alpha1.ArtifactBuild) *v1alpha1.DependencyBuild {
+		db := &v1alpha1.DependencyBuild{}
+		db.Name = name
+		db.Namespace = metav1.NamespaceDefault
+		db.Status.DeployedArtifacts = []string{owner.Spec.GAV}
+		g.Expect(controllerutil.SetOwnerReference(owner, db, reconciler.scheme)).Should(Succeed())
+		g.Expect(client.Create(ctx, db)).Should(Succeed())
+		return db
+	}
+	// a was built, which triggered the build of b that depends on it, which in turn triggered the build of c
+	a := artifactBuild("com.test:a:1.0", "")
+	aBuild := dependencyBuild("a-build", a)
+	b := artifactBuild("com.test:b:1.0", aBuild.Name)
+	bBuild := dependencyBuild("b-build", b)
+	c := artifactBuild("com.test:c:1.0", bBuild.Name)
+	cBuild := dependencyBuild("c-build", c)
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.MavenDeployment.DownstreamBuilds = v1alpha1.DownstreamBuilds{Enabled: true, Dependents: map[string][]string{
+		"com.test:a": {"com.test:b:1.0"},
+		"com.test:b": {"com.test:a:1.0", "com.test:c:1.0"},
+		"com.test:c": {"com.test:a:1.0", "com.test:d:1.0"},
+	}}
+
+	upstream, err := reconciler.upstreamArtifactBuilds(ctx, cBuild)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(upstream).Should(Equal(map[string]bool{a.Name: true, b.Name: true, c.Name: true}))
+
+	// The builds that led to c are not rebuilt, other dependents are
+	g.Expect(reconciler.triggerDownstreamBuilds(ctx, cBuild, jbsConfig)).Should(Succeed())
+	g.Expect(reconciler.triggerDownstreamBuilds(ctx, bBuild, jbsConfig)).Should(Succeed())
+	g.Expect(client.Get(ctx, types.NamespacedName{Name: a.Name, Namespace: a.Namespace}, a)).Should(Succeed())
+	g.Expect(a.Annotations).ShouldNot(HaveKey(artifactbuild.RebuildAnnotation))
+	d := v1alpha1.ArtifactBuild{}
+	g.Expect(client.Get(ctx, types.NamespacedName{Name: artifactbuild.CreateABRName("com.test:d:1.0"), Namespace: metav1.NamespaceDefault}, &d)).Should(Succeed())
+	g.Expect(d.Annotations[artifactbuild.DownstreamOfAnnotation]).Should(Equal(cBuild.Name))
+	g.Expect(client.Get(ctx, types.NamespacedName{Name: c.Name, Namespace: c.Namespace}, c)).Should(Succeed())
+	g.Expect(c.Annotations[artifactbuild.RebuildAnnotation]).Should(Equal("true"))
+}
+
+func TestOrderBuildRecipes(t *testing.T) {
+	builderImages := []BuilderImage{{Image: "jdk8", Priority: 1}, {Image: "jdk11", Priority: 3}, {Image: "jdk17", Priority: 2}}
+	recipes := func() []*v1alpha1.BuildRecipe {
+		return []*v1alpha1.BuildRecipe{
+			{Image: "jdk8", ContextPath: "a/b"},
+			{Image: "jdk17"},
+			{Image: "jdk11", ContextPath: "a/b/c/"},
+			{Image: "jdk8", ContextPath: "a"},
+		}
+	}
+	images := func(recipes []*v1alpha1.BuildRecipe) []string {
+		ret := []string{}
+		for _, i := range recipes {
+			ret = append(ret, i.Image+":"+i.ContextPath)
+		}
+		return ret
+	}
+	t.Run("first-match", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		result := orderBuildRecipes(v1alpha1.RecipeSelectionFirstMatch, recipes(), builderImages)
+		g.Expect(images(result)).Should(Equal([]string{"jdk8:a/b", "jdk17:", "jdk11:a/b/c/", "jdk8:a"}))
+	})
+	t.Run("highest-priority", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		result := orderBuildRecipes(v1alpha1.RecipeSelectionHighestPriority, recipes(), builderImages)
+		g.Expect(images(result)).Should(Equal([]string{"jdk11:a/b/c/", "jdk17:", "jdk8:a/b", "jdk8:a"}))
+	})
+}
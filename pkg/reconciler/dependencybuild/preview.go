@@ -0,0 +1,136 @@
+package dependencybuild
+
+import (
+	"context"
+	errors2 "errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/apis/jvmbuildservice/v1alpha1"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/systemconfig"
+	tektonpipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// PreviewConfigMapKey is the key of the preview within the ConfigMap written for the PreviewAnnotation
+	PreviewConfigMapKey = "preview.yaml"
+	// PreviewErrorConfigMapKey is the key of the error within the ConfigMap if the preview could not be generated
+	PreviewErrorConfigMapKey = "error"
+)
+
+// BuildPipelinePreview is everything generated for the latest build attempt of a DependencyBuild, so it can be
+// inspected before (or without) running the build.
+type BuildPipelinePreview struct {
+	PipelineSpec            *tektonpipeline.PipelineSpec `json:"pipelineSpec"`
+	DiagnosticContainerfile string                       `json:"diagnosticContainerfile"`
+	KonfluxContainerfile    string                       `json:"konfluxContainerfile"`
+	KonfluxScript           string                       `json:"konfluxScript"`
+}
+
+// PipelineGenerationError is returned by PreviewBuildPipeline if the pipeline itself can't be generated from the build
+// attempt, as opposed to a failure looking up what it is generated from.
+type PipelineGenerationError struct {
+	Err error
+}
+
+func (e *PipelineGenerationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PipelineGenerationError) Unwrap() error {
+	return e.Err
+}
+
+// ToYaml serialises the preview, e.g. for storing within a ConfigMap.
+func (p *BuildPipelinePreview) ToYaml() (string, error) {
+	out, err := yaml.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// PreviewBuildPipeline generates the build pipeline and container files for the latest build attempt of the
+// DependencyBuild exactly as they would be submitted, without creating any resources.
+func (r *ReconcileDependencyBuild) PreviewBuildPipeline(ctx context.Context, db *v1alpha1.DependencyBuild) (*BuildPipelinePreview, error) {
+	log, _ := logr.FromContext(ctx)
+	if len(db.Status.BuildAttempts) == 0 {
+		return nil, fmt.Errorf("the DependencyBuild %s/%s has no build attempts", db.Namespace, db.Name)
+	}
+	attempt := db.Status.BuildAttempts[len(db.Status.BuildAttempts)-1]
+
+	buildRequestProcessorImage, err := r.buildRequestProcessorImage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jbsConfig := &v1alpha1.JBSConfig{}
+	err = r.client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: v1alpha1.JBSConfigName}, jbsConfig)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	systemConfig := v1alpha1.SystemConfig{}
+	err = r.client.Get(ctx, types.NamespacedName{Name: systemconfig.SystemConfigKey}, &systemConfig)
+	if err != nil {
+		return nil, err
+	}
+	preBuildImages := map[string]string{}
+	for _, i := range db.Status.PreBuildImages {
+		preBuildImages[i.BaseBuilderImage+"-"+i.Tool] = i.BuiltImageDigest
+	}
+	return previewBuildPipeline(log, jbsConfig, &systemConfig, db, attempt, buildRequestProcessorImage, preBuildImages)
+}
+
+func previewBuildPipeline(log logr.Logger, jbsConfig *v1alpha1.JBSConfig, systemConfig *v1alpha1.SystemConfig, db *v1alpha1.DependencyBuild, attempt *v1alpha1.BuildAttempt, buildRequestProcessorImage string, preBuildImages map[string]string) (*BuildPipelinePreview, error) {
+	paramValues := buildPipelineParams(log, db, attempt)
+	ps, df, kf, konfluxScript, err := createPipelineSpec(log, attempt.Recipe.Tool, db.Status.CommitTime, jbsConfig, systemConfig, attempt.Recipe, db, paramValues, buildRequestProcessorImage, attempt.BuildId, preBuildImages)
+	if err != nil {
+		return nil, &PipelineGenerationError{Err: err}
+	}
+	return &BuildPipelinePreview{PipelineSpec: ps, DiagnosticContainerfile: df, KonfluxContainerfile: kf, KonfluxScript: konfluxScript}, nil
+}
+
+// handlePreviewAnnotation writes the preview of the latest build attempt to the ConfigMap named after the
+// DependencyBuild with the PreviewSuffix, then removes the annotation so the preview is only written once per request.
+// The state of the DependencyBuild is not changed, so the build carries on from where it was on the next reconcile.
+func (r *ReconcileDependencyBuild) handlePreviewAnnotation(ctx context.Context, db *v1alpha1.DependencyBuild) (reconcile.Result, error) {
+	data := map[string]string{}
+	preview, err := r.PreviewBuildPipeline(ctx, db)
+	generationError := &PipelineGenerationError{}
+	if errors2.As(err, &generationError) {
+		// Retrying won't help if the pipeline can't be generated so the error is recorded instead
+		data[PreviewErrorConfigMapKey] = generationError.Error()
+	} else if err != nil {
+		// Failures looking up the configuration may be temporary so the reconcile is retried
+		return reconcile.Result{}, err
+	} else {
+		data[PreviewConfigMapKey], err = preview.ToYaml()
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	cm := v1.ConfigMap{}
+	err = r.client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: db.Name + PreviewSuffix}, &cm)
+	if errors.IsNotFound(err) {
+		cm.Namespace = db.Namespace
+		cm.Name = db.Name + PreviewSuffix
+		cm.Data = data
+		if err := controllerutil.SetOwnerReference(db, &cm, r.scheme); err != nil {
+			return reconcile.Result{}, err
+		}
+		err = r.client.Create(ctx, &cm)
+	} else if err == nil {
+		cm.Data = data
+		err = r.client.Update(ctx, &cm)
+	}
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	delete(db.Annotations, PreviewAnnotation)
+	return reconcile.Result{}, r.client.Update(ctx, db)
+}
@@ -0,0 +1,163 @@
+package dependencybuild
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redhat-appstudio/jvm-build-service/pkg/apis/jvmbuildservice/v1alpha1"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/systemconfig"
+	tektonpipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPreviewBuildPipeline(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	client, reconciler := setupClientAndReconciler()
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Namespace = metav1.NamespaceDefault
+	db.Name = "test"
+	db.Spec.ScmInfo.SCMURL = "https://github.com/foo/bar.git"
+	db.Spec.ScmInfo.Tag = "1.0"
+	_, err := reconciler.PreviewBuildPipeline(ctx, db)
+	g.Expect(err).Should(HaveOccurred())
+
+	db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{
+		BuildId: "build-id",
+		Recipe:  &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", CommandLine: []string{"install"}, ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}},
+		Build:   &v1alpha1.BuildPipelineRun{PipelineName: "test-build-0"},
+	}}
+	preview, err := reconciler.PreviewBuildPipeline(ctx, db)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(preview.PipelineSpec.Tasks).ShouldNot(BeEmpty())
+	g.Expect(preview.DiagnosticContainerfile).ShouldNot(BeEmpty())
+	g.Expect(preview.KonfluxContainerfile).ShouldNot(BeEmpty())
+	g.Expect(preview.KonfluxScript).ShouldNot(BeEmpty())
+
+	// Nothing is created by the preview
+	pr := tektonpipeline.PipelineRun{}
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test-build-0"}, &pr)).ShouldNot(Succeed())
+
+	// The serialised preview round-trips
+	serialised, err := preview.ToYaml()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(serialised).Should(ContainSubstring("konfluxScript:"))
+	parsed := BuildPipelinePreview{}
+	g.Expect(yaml.Unmarshal([]byte(serialised), &parsed)).Should(Succeed())
+	g.Expect(parsed.DiagnosticContainerfile).Should(Equal(preview.DiagnosticContainerfile))
+	g.Expect(parsed.KonfluxContainerfile).Should(Equal(preview.KonfluxContainerfile))
+	g.Expect(parsed.KonfluxScript).Should(Equal(preview.KonfluxScript))
+	reserialised, err := parsed.ToYaml()
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(reserialised).Should(Equal(serialised))
+}
+
+func TestPreviewAnnotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	client, reconciler := setupClientAndReconciler()
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Namespace = metav1.NamespaceDefault
+	db.Name = "test"
+	db.Annotations = map[string]string{PreviewAnnotation: "true"}
+	db.Spec.ScmInfo.SCMURL = "https://github.com/foo/bar.git"
+	db.Spec.ScmInfo.Tag = "1.0"
+	g.Expect(client.Create(ctx, db)).Should(Succeed())
+	attempts := []*v1alpha1.BuildAttempt{{
+		BuildId: "build-id",
+		Recipe:  &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", CommandLine: []string{"install"}, ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}},
+	}}
+	db.Status.BuildAttempts = attempts
+	_, err := reconciler.handlePreviewAnnotation(ctx, db)
+	g.Expect(err).ShouldNot(HaveOccurred())
+
+	cm := v1.ConfigMap{}
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test" + PreviewSuffix}, &cm)).Should(Succeed())
+	g.Expect(cm.Data[PreviewConfigMapKey]).Should(ContainSubstring("konfluxScript:"))
+	g.Expect(cm.OwnerReferences).Should(HaveLen(1))
+	updated := v1alpha1.DependencyBuild{}
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: db.Name}, &updated)).Should(Succeed())
+	g.Expect(updated.Annotations).ShouldNot(HaveKey(PreviewAnnotation))
+
+	// A pipeline that can't be generated is recorded in the existing ConfigMap
+	updated.Annotations = map[string]string{PreviewAnnotation: "true"}
+	updated.Status.BuildAttempts = attempts
+	updated.Status.BuildAttempts[0].Recipe.Architecture = "s390x"
+	_, err = reconciler.handlePreviewAnnotation(ctx, &updated)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test" + PreviewSuffix}, &cm)).Should(Succeed())
+	g.Expect(cm.Data).ShouldNot(HaveKey(PreviewConfigMapKey))
+	g.Expect(cm.Data[PreviewErrorConfigMapKey]).Should(ContainSubstring("unsupported architecture"))
+}
+
+func TestPreviewAnnotationLookupFailure(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	client, reconciler := setupClientAndReconciler()
+	g.Expect(client.Delete(ctx, &v1alpha1.SystemConfig{ObjectMeta: metav1.ObjectMeta{Name: systemconfig.SystemConfigKey}})).Should(Succeed())
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Namespace = metav1.NamespaceDefault
+	db.Name = "test"
+	db.Annotations = map[string]string{PreviewAnnotation: "true"}
+	db.Spec.ScmInfo.SCMURL = "https://github.com/foo/bar.git"
+	db.Spec.ScmInfo.Tag = "1.0"
+	g.Expect(client.Create(ctx, db)).Should(Succeed())
+	db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{
+		BuildId: "build-id",
+		Recipe:  &v1alpha1.BuildRecipe{Image: "quay.io/foo/builder:latest", Tool: "maven", JavaVersion: "17", CommandLine: []string{"install"}, ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}},
+	}}
+
+	// The failure may be temporary so it is returned to retry, rather than recorded as the outcome of the preview
+	_, err := reconciler.handlePreviewAnnotation(ctx, db)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test" + PreviewSuffix}, &v1.ConfigMap{})).ShouldNot(Succeed())
+	updated := v1alpha1.DependencyBuild{}
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: db.Name}, &updated)).Should(Succeed())
+	g.Expect(updated.Annotations).Should(HaveKey(PreviewAnnotation))
+}
+
+func TestPreviewAnnotationKeepsState(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	client, reconciler := setupClientAndReconciler()
+
+	db := &v1alpha1.DependencyBuild{}
+	db.Namespace = metav1.NamespaceDefault
+	db.Name = "test"
+	db.Annotations = map[string]string{PreviewAnnotation: "true"}
+	db.Spec.ScmInfo.SCMURL = "https://github.com/foo/bar.git"
+	db.Spec.ScmInfo.Tag = "1.0"
+	g.Expect(client.Create(ctx, db)).Should(Succeed())
+	db.Status.State = v1alpha1.DependencyBuildStateBuilding
+	db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{
+		BuildId: "build-id",
+		Recipe:  &v1alpha1.BuildRecipe{Image: "quay.io/redhat-appstudio/hacbs-jdk17-builder:latest", Tool: "maven", JavaVersion: "17", CommandLine: []string{"install"}, ToolVersions: map[string]string{"maven": "3.8.8", "jdk": "17"}},
+		Build:   &v1alpha1.BuildPipelineRun{PipelineName: "test-build-0"},
+	}}
+	g.Expect(client.Status().Update(ctx, db)).Should(Succeed())
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: db.Namespace, Name: db.Name}}
+
+	// The preview is written without submitting the build
+	_, err := reconciler.Reconcile(ctx, request)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test" + PreviewSuffix}, &v1.ConfigMap{})).Should(Succeed())
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test-build-0"}, &tektonpipeline.PipelineRun{})).ShouldNot(Succeed())
+	updated := getBuild(client, g)
+	g.Expect(updated.Annotations).ShouldNot(HaveKey(PreviewAnnotation))
+	g.Expect(updated.Status.State).Should(Equal(v1alpha1.DependencyBuildStateBuilding))
+
+	// Once the annotation is removed the build is submitted as usual
+	_, err = reconciler.Reconcile(ctx, request)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(client.Get(ctx, types.NamespacedName{Namespace: db.Namespace, Name: "test-build-0"}, &tektonpipeline.PipelineRun{})).Should(Succeed())
+	g.Expect(getBuild(client, g).Status.State).Should(Equal(v1alpha1.DependencyBuildStateBuilding))
+}
@@ -0,0 +1,100 @@
+package dependencybuild
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/redhat-appstudio/jvm-build-service/pkg/apis/jvmbuildservice/v1alpha1"
+	"github.com/redhat-appstudio/jvm-build-service/pkg/reconciler/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// pulledImages returns the images the build pulls: the recipe builder image, the build request processor, the trusted
+// artifacts image and the cache.
+func (r *ReconcileDependencyBuild) pulledImages(ctx context.Context, jbsConfig *v1alpha1.JBSConfig, recipe *v1alpha1.BuildRecipe, buildRequestProcessorImage string) ([]string, error) {
+	cacheImage, err := util.GetImageName(ctx, r.client, "cache", "JVM_BUILD_SERVICE_CACHE_IMAGE")
+	if err != nil {
+		return nil, err
+	}
+	return []string{recipe.Image, buildRequestProcessorImage, trustedArtifactsImage(jbsConfig), jbsConfig.MirroredImage(cacheImage)}, nil
+}
+
+// pullSecretConfigs returns the docker configs of the image registry and mirror secrets. Missing secrets are ignored
+// as they then cover no images.
+func (r *ReconcileDependencyBuild) pullSecretConfigs(ctx context.Context, namespace string, jbsConfig *v1alpha1.JBSConfig) ([][]byte, error) {
+	secretNames := []string{jbsConfig.ImageRegistry().SecretName}
+	if secretNames[0] == "" {
+		secretNames[0] = v1alpha1.DefaultImageSecretName
+	}
+	if mirror := jbsConfig.MirrorImageRegistry(); mirror != nil && mirror.SecretName != "" {
+		secretNames = append(secretNames, mirror.SecretName)
+	}
+	var configs [][]byte
+	for _, name := range secretNames {
+		secret := v1.Secret{}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if data, ok := secret.Data[v1alpha1.ImageSecretTokenKey]; ok {
+			configs = append(configs, data)
+		} else if data, ok := secret.StringData[v1alpha1.ImageSecretTokenKey]; ok {
+			configs = append(configs, []byte(data))
+		}
+	}
+	return configs, nil
+}
+
+// imagesWithoutCredentials returns the images that none of the docker configs have credentials for. An entry in the
+// auths of a config covers an image if it is the registry host or a repository prefix of the image.
+func imagesWithoutCredentials(images []string, dockerConfigs [][]byte) []string {
+	var covered []string
+	for _, i := range dockerConfigs {
+		config := struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}{}
+		if json.Unmarshal(i, &config) != nil {
+			continue
+		}
+		for key := range config.Auths {
+			key = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://"), "/")
+			if key == "index.docker.io/v1" || key == "index.docker.io" {
+				key = "docker.io"
+			}
+			covered = append(covered, key)
+		}
+	}
+	var ret []string
+	for _, image := range images {
+		if image == "" {
+			continue
+		}
+		reference := qualifiedImage(v1alpha1.RepositoryName(image))
+		found := false
+		for _, key := range covered {
+			if reference == key || strings.HasPrefix(reference, key+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, image)
+		}
+	}
+	return ret
+}
+
+// qualifiedImage returns the image with the docker.io registry added if it has none, e.g. ubi8 is docker.io/ubi8.
+func qualifiedImage(image string) string {
+	registry, _, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(registry, ".:") || registry == "localhost") {
+		return image
+	}
+	return "docker.io/" + image
+}
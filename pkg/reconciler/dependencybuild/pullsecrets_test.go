@@ -0,0 +1,98 @@
+package dependencybuild
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redhat-appstudio/jvm-build-service/pkg/apis/jvmbuildservice/v1alpha1"
+	tektonpipeline "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	runtimeclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestImagesWithoutCredentials(t *testing.T) {
+	g := NewGomegaWithT(t)
+	images := []string{"quay.io/foo/builder:latest", "quay.io/bar/processor@sha256:1234", "registry.example.com:5000/cache:1.0", "ubi8/ubi:latest"}
+	g.Expect(imagesWithoutCredentials(images, nil)).Should(Equal(images))
+	// Invalid configs cover nothing
+	g.Expect(imagesWithoutCredentials(images, [][]byte{[]byte("not json")})).Should(Equal(images))
+
+	// A registry host covers every image within it
+	g.Expect(imagesWithoutCredentials(images, [][]byte{[]byte(`{"auths":{"quay.io":{"auth":"Zm9vOmJhcg=="}}}`)})).Should(Equal([]string{"registry.example.com:5000/cache:1.0", "ubi8/ubi:latest"}))
+	// A repository prefix only covers whole path components
+	g.Expect(imagesWithoutCredentials(images, [][]byte{[]byte(`{"auths":{"quay.io/foo":{},"quay.io/ba":{}}}`)})).Should(Equal([]string{"quay.io/bar/processor@sha256:1234", "registry.example.com:5000/cache:1.0", "ubi8/ubi:latest"}))
+	g.Expect(imagesWithoutCredentials(images, [][]byte{[]byte(`{"auths":{"quay.io/foo/builder":{}}}`)})).ShouldNot(ContainElement("quay.io/foo/builder:latest"))
+
+	// The credentials may be spread across several secrets, and images without a registry are from docker.io
+	configs := [][]byte{[]byte(`{"auths":{"quay.io":{}}}`), []byte(`{"auths":{"https://registry.example.com:5000/":{},"https://index.docker.io/v1/":{}}}`)}
+	g.Expect(imagesWithoutCredentials(images, configs)).Should(BeEmpty())
+}
+
+func TestPullSecretConfigs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	jbsConfig := &v1alpha1.JBSConfig{}
+	jbsConfig.Spec.Registry.Mirror = &v1alpha1.ImageRegistry{SecretName: "mirror-secret"}
+	registrySecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: v1alpha1.DefaultImageSecretName}, Data: map[string][]byte{v1alpha1.ImageSecretTokenKey: []byte(`{"auths":{"quay.io":{}}}`)}}
+	_, reconciler := setupClientAndReconciler(registrySecret)
+	// The mirror secret does not exist so it is ignored
+	configs, err := reconciler.pullSecretConfigs(ctx, metav1.NamespaceDefault, jbsConfig)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(configs).Should(Equal([][]byte{[]byte(`{"auths":{"quay.io":{}}}`)}))
+
+	mirrorSecret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "mirror-secret"}, Data: map[string][]byte{v1alpha1.ImageSecretTokenKey: []byte(`{"auths":{"registry.example.com":{}}}`)}}
+	_, reconciler = setupClientAndReconciler(registrySecret, mirrorSecret)
+	configs, err = reconciler.pullSecretConfigs(ctx, metav1.NamespaceDefault, jbsConfig)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(configs).Should(HaveLen(2))
+
+	images, err := reconciler.pulledImages(ctx, jbsConfig, &v1alpha1.BuildRecipe{Image: "registry.example.com/builder:latest"}, "quay.io/foo/processor:1.0")
+	g.Expect(err).ShouldNot(HaveOccurred())
+	g.Expect(images).Should(HaveLen(4))
+	g.Expect(images[:3]).Should(Equal([]string{"registry.example.com/builder:latest", "quay.io/foo/processor:1.0", trustedArtifactsImage(jbsConfig)}))
+}
+
+func TestStateBuildingPullSecretCheck(t *testing.T) {
+	ctx := context.TODO()
+	buildName := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "test"}
+	setup := func(g *WithT, check string) (runtimeclient.Client, *ReconcileDependencyBuild) {
+		jbsConfig := &v1alpha1.JBSConfig{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: v1alpha1.JBSConfigName}}
+		jbsConfig.Spec.BuildSettings.PullSecretCheck = check
+		client, reconciler := setupClientAndReconciler(jbsConfig)
+		db := v1alpha1.DependencyBuild{}
+		db.Namespace = metav1.NamespaceDefault
+		db.Name = "test"
+		db.Status.State = v1alpha1.DependencyBuildStateBuilding
+		db.Status.BuildAttempts = []*v1alpha1.BuildAttempt{{
+			Recipe: &v1alpha1.BuildRecipe{Image: "quay.io/redhat-appstudio/hacbs-jdk11-builder:latest"},
+			Build:  &v1alpha1.BuildPipelineRun{PipelineName: "test-build-0"},
+		}}
+		db.Spec.ScmInfo.SCMURL = "some-url"
+		db.Spec.ScmInfo.Tag = "some-tag"
+		g.Expect(client.Create(ctx, &db)).Should(BeNil())
+		return client, reconciler
+	}
+
+	t.Run("Test missing credentials are reported", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		client, reconciler := setup(g, v1alpha1.PullSecretCheckWarn)
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		db := getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateBuilding))
+		getBuildPipeline(client, g)
+	})
+	t.Run("Test missing credentials fail the build", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		client, reconciler := setup(g, v1alpha1.PullSecretCheckFail)
+		g.Expect(reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: buildName}))
+		db := getBuild(client, g)
+		g.Expect(db.Status.State).Should(Equal(v1alpha1.DependencyBuildStateFailed))
+		g.Expect(db.Status.BuildAttempts[0].Build.PipelineName).Should(Equal("test-build-0"))
+		g.Expect(client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "test-build-0"}, &tektonpipeline.PipelineRun{})).ShouldNot(Succeed())
+	})
+}
@@ -30,17 +30,23 @@ if [ ! -z ${ANT_HOME+x} ]; then
     PATH="${ANT_HOME}/bin:$PATH"
 fi
 
+if [ ! -z ${LEIN_HOME+x} ]; then
+    echo "LEIN_HOME:$LEIN_HOME"
+    PATH="${LEIN_HOME}/bin:$PATH"
+fi
+
 if [ ! -z ${SBT_DIST+x} ]; then
     echo "SBT_DIST:$SBT_DIST"
     PATH="${SBT_DIST}/bin:$PATH"
 fi
 echo "PATH:$PATH"
 
-#fix this when we no longer need to run as root
-export HOME=/root
+{{HOME}}
 
 mkdir -p $(workspaces.source.path)/logs $(workspaces.source.path)/packages $(workspaces.source.path)/build-info
 
+{{DISK_MONITOR}}
+
 {{INSTALL_PACKAGE_SCRIPT}}
 
 #This is replaced when the task is created by the golang code
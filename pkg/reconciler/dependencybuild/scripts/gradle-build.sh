@@ -26,6 +26,11 @@ sonatypeUsername=jbs
 sonatypePassword=jbs
 EOF
 
+# Properties provided by the recipe build settings are added to the defaults
+if [ -f "$(workspaces.build-settings.path)/gradle.properties" ]; then
+    cat "$(workspaces.build-settings.path)/gradle.properties" >> "${GRADLE_USER_HOME}"/gradle.properties
+fi
+
 if [ -d .hacbs-init ]; then
     rm -rf "${GRADLE_USER_HOME}"/init.d
     cp -r .hacbs-init "${GRADLE_USER_HOME}"/init.d
@@ -58,6 +63,10 @@ export LC_ALL="en_US.UTF-8"
 #TODO: should we disable tracing for these builds? It means we can't track dependencies directly, so we can't detect contaminants
 rm -f gradle/verification-metadata.xml
 
+if [ -f $(workspaces.source.path)/jbs-init.gradle ]; then
+    set -- --init-script $(workspaces.source.path)/jbs-init.gradle "$@"
+fi
+
 echo "Running Gradle command with arguments: $@"
 if [ ! -d $(workspaces.source.path)/source-archive ]; then
     cp -r $(workspaces.source.path)/source $(workspaces.source.path)/source-archive
@@ -2,16 +2,45 @@
 set -o verbose
 
 if [ "rpm" = "{TYPE}" ]; then
+    PACKAGE="{PACKAGE_NAME}"
+    if [ -n "{GPG_KEY_URI}" ]; then
+        wget --no-verbose --output-document=$(workspaces.source.path)/packages/{FILENAME}.rpm {URI} \
+        && rpm --import {GPG_KEY_URI}
+        if [ -n "{SHA256}" ] && ! echo "{SHA256} $(workspaces.source.path)/packages/{FILENAME}.rpm" | sha256sum --check -; then
+            echo "SHA256 verification failed for package {PACKAGE_NAME}"
+            exit 1
+        fi
+        if [ -n "{SHA512}" ] && ! echo "{SHA512} $(workspaces.source.path)/packages/{FILENAME}.rpm" | sha512sum --check -; then
+            echo "SHA512 verification failed for package {PACKAGE_NAME}"
+            exit 1
+        fi
+        # rpm --checksig succeeds for an unsigned package as long as its digests match, so require a valid signature
+        rpm -Kv $(workspaces.source.path)/packages/{FILENAME}.rpm > $(workspaces.source.path)/packages/{FILENAME}.checksig 2>&1 || true
+        cat $(workspaces.source.path)/packages/{FILENAME}.checksig
+        if grep -q -E "NOT OK|NOKEY" $(workspaces.source.path)/packages/{FILENAME}.checksig \
+            || ! grep -q -E "Signature, key ID [0-9a-f]+: OK" $(workspaces.source.path)/packages/{FILENAME}.checksig; then
+            echo "GPG signature verification failed for package {PACKAGE_NAME}"
+            exit 1
+        fi
+        PACKAGE="$(workspaces.source.path)/packages/{FILENAME}.rpm"
+    fi
     if [ "${UBI}" = "8" ]; then
-        microdnf --setopt=install_weak_deps=0 --setopt=tsflags=nodocs install -y {PACKAGE_NAME}
+        microdnf --setopt=install_weak_deps=0 --setopt=tsflags=nodocs install -y ${PACKAGE}
     else
-        microdnf --setopt=tsflags=nodocs install -y {PACKAGE_NAME}
+        microdnf --setopt=tsflags=nodocs install -y ${PACKAGE}
     fi
 else
     export PATH="$(workspaces.source.path)/packages:${PATH}"
 
-    wget --no-verbose --output-document=$(workspaces.source.path)/packages/{FILENAME} {URI} \
-    && echo "${SHA256} $(workspaces.source.path)/packages/{FILENAME}" | sha256sum --check -
+    wget --no-verbose --output-document=$(workspaces.source.path)/packages/{FILENAME} {URI}
+    if [ -n "{SHA256}" ] && ! echo "{SHA256} $(workspaces.source.path)/packages/{FILENAME}" | sha256sum --check -; then
+        echo "SHA256 verification failed for package {URI}"
+        exit 1
+    fi
+    if [ -n "{SHA512}" ] && ! echo "{SHA512} $(workspaces.source.path)/packages/{FILENAME}" | sha512sum --check -; then
+        echo "SHA512 verification failed for package {URI}"
+        exit 1
+    fi
 
     if [ "executable" = "{TYPE}" ]; then
         chmod +x $(workspaces.source.path)/packages/{FILENAME}
@@ -19,6 +48,10 @@ else
         mkdir $(workspaces.source.path)/packages/{FILENAME}-extracted
         tar -xvf $(workspaces.source.path)/packages/{FILENAME} --directory $(workspaces.source.path)/packages/{FILENAME}-extracted
         export PATH="$(workspaces.source.path)/packages/{FILENAME}-extracted/{BINARY_PATH}:${PATH}"
+    elif [  "zip" = "{TYPE}"  ]; then
+        mkdir $(workspaces.source.path)/packages/{FILENAME}-extracted
+        unzip -q $(workspaces.source.path)/packages/{FILENAME} -d $(workspaces.source.path)/packages/{FILENAME}-extracted
+        export PATH="$(workspaces.source.path)/packages/{FILENAME}-extracted/{BINARY_PATH}:${PATH}"
     else
         echo "Unknown Type {TYPE}"
         exit 1
@@ -0,0 +1,33 @@
+#!/usr/bin/env bash
+
+if [ ! -d "${LEIN_HOME}" ]; then
+    echo "Leiningen home directory not found at ${LEIN_HOME}" >&2
+    exit 1
+fi
+
+mkdir -p "${HOME}/.m2/repository"
+
+# Leiningen reads the user profile from LEIN_HOME, which is the shared tool install directory. Use a per-build
+# home so the profile is not written into the install, keeping the installed self-installs jar available.
+LEIN_INSTALL="${LEIN_HOME}"
+export LEIN_HOME="$(workspaces.source.path)/lein-home"
+mkdir -p "${LEIN_HOME}"
+if [ -d "${LEIN_INSTALL}/self-installs" ]; then
+    ln -sfn "${LEIN_INSTALL}/self-installs" "${LEIN_HOME}/self-installs"
+fi
+
+# Route all resolution through the cache and deploy into the artifacts directory.
+cat > "${LEIN_HOME}/profiles.clj" << EOF
+{:user {:mirrors {#".+" {:url "$(params.CACHE_URL)"}}
+        :local-repo "${HOME}/.m2/repository"
+        :deploy-repositories [["jbs" {:url "file:$(workspaces.source.path)/artifacts" :sign-releases false}]]}}
+EOF
+
+#if we run out of memory we want the JVM to die with error code 134
+export LEIN_JVM_OPTS="-XX:+CrashOnOutOfMemoryError"
+
+if [ ! -d $(workspaces.source.path)/source-archive ]; then
+    cp -r $(workspaces.source.path)/source $(workspaces.source.path)/source-archive
+fi
+echo "Running $(which lein) with arguments: $@"
+eval "lein $@" | tee $(workspaces.source.path)/logs/lein.log
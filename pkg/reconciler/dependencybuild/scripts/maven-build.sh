@@ -11,21 +11,25 @@ fi
 
 TOOLCHAINS_XML="$(workspaces.build-settings.path)"/toolchains.xml
 
-cat >"$TOOLCHAINS_XML" <<EOF
+# A toolchains.xml provided by the recipe build settings is used as is
+if [ -f "$TOOLCHAINS_XML" ]; then
+    echo "Using the provided toolchains.xml"
+else
+    cat >"$TOOLCHAINS_XML" <<EOF
 <?xml version="1.0" encoding="UTF-8"?>
 <toolchains>
 EOF
 
-if [ "$(params.JAVA_VERSION)" = "7" ]; then
-    JAVA_VERSIONS="7:1.7.0 8:1.8.0 11:11"
-else
-    JAVA_VERSIONS="8:1.8.0 9:11 11:11 17:17 21:21 22:22"
-fi
+    if [ "$(params.JAVA_VERSION)" = "7" ]; then
+        JAVA_VERSIONS="7:1.7.0 8:1.8.0 11:11"
+    else
+        JAVA_VERSIONS="8:1.8.0 9:11 11:11 17:17 21:21 22:22"
+    fi
 
-for i in $JAVA_VERSIONS; do
-    version=$(echo $i | cut -d : -f 1)
-    home=$(echo $i | cut -d : -f 2)
-    cat >>"$TOOLCHAINS_XML" <<EOF
+    for i in $JAVA_VERSIONS; do
+        version=$(echo $i | cut -d : -f 1)
+        home=$(echo $i | cut -d : -f 2)
+        cat >>"$TOOLCHAINS_XML" <<EOF
   <toolchain>
     <type>jdk</type>
     <provides>
@@ -36,11 +40,12 @@ for i in $JAVA_VERSIONS; do
     </configuration>
   </toolchain>
 EOF
-done
+    done
 
-cat >>"$TOOLCHAINS_XML" <<EOF
+    cat >>"$TOOLCHAINS_XML" <<EOF
 </toolchains>
 EOF
+fi
 
 if [ -n "$(params.ENFORCE_VERSION)" ]; then
   echo "Setting version to $(params.PROJECT_VERSION) to match enforced version"
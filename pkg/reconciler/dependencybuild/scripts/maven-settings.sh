@@ -3,11 +3,15 @@
 if [ ! -z ${JBS_DISABLE_CACHE+x} ]; then
     cat >"$(workspaces.build-settings.path)"/settings.xml <<EOF
     <settings>
+      <mirrors>
+{{MAVEN_MIRRORS}}
+      </mirrors>
 EOF
 else
     cat >"$(workspaces.build-settings.path)"/settings.xml <<EOF
     <settings>
       <mirrors>
+{{MAVEN_MIRRORS}}
         <mirror>
           <id>mirror.default</id>
           <url>${CACHE_URL}</url>
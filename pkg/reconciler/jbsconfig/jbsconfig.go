@@ -533,7 +533,7 @@ func (r *ReconcilerJBSConfig) cacheDeployment(ctx context.Context, log logr.Logg
 		}, cache)
 
 		if jbsConfig.Spec.MavenDeployment.Repository != "" {
-			cache = setEnvVarValue(jbsConfig.Spec.MavenDeployment.Repository, "MAVEN_REPOSITORY_URL", cache)
+			cache = setEnvVarValue(util.MavenRepositoryURL(jbsConfig.Spec.MavenDeployment.Repository), "MAVEN_REPOSITORY_URL", cache)
 			cache = setEnvVarValue(jbsConfig.Spec.MavenDeployment.Username, "MAVEN_REPOSITORY_USERNAME", cache)
 			cache = setEnvVar(corev1.EnvVar{
 				Name:      "MAVEN_REPOSITORY_PASSWORD",
@@ -573,6 +573,21 @@ func (r *ReconcilerJBSConfig) cacheDeployment(ctx context.Context, log logr.Logg
 			repos = append(repos, Repo{position: atoi, name: name})
 		}
 	}
+	// Private upstream repositories are accessed with the credentials in their secret, public ones are left anonymous
+	for name, secret := range jbsConfig.Spec.CacheSettings.RepositorySecrets {
+		if secret == "" {
+			continue
+		}
+		prefix := "STORE_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		cache = setEnvVar(corev1.EnvVar{
+			Name:      prefix + "_USERNAME",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secret}, Key: v1alpha1.RepositoryUsernameKey, Optional: &trueBool}},
+		}, cache)
+		cache = setEnvVar(corev1.EnvVar{
+			Name:      prefix + "_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secret}, Key: v1alpha1.RepositoryPasswordKey, Optional: &trueBool}},
+		}, cache)
+	}
 	var sb strings.Builder
 	sort.Slice(repos, func(i, j int) bool {
 		return repos[i].position < repos[j].position
@@ -591,7 +606,7 @@ func (r *ReconcilerJBSConfig) cacheDeployment(ctx context.Context, log logr.Logg
 			return err
 		}
 	}
-	cache.Spec.Template.Spec.Containers[0].Image = r.configuredCacheImage
+	cache.Spec.Template.Spec.Containers[0].Image = jbsConfig.MirroredImage(r.configuredCacheImage)
 	if strings.HasPrefix(r.configuredCacheImage, "quay.io/minikube") {
 		cache.Spec.Template.Spec.Containers[0].ImagePullPolicy = corev1.PullNever
 	} else if !strings.HasPrefix(r.configuredCacheImage, "quay.io/redhat-appstudio") {
@@ -167,6 +167,36 @@ func TestRebuildEnabledCustomRepo(t *testing.T) {
 
 }
 
+func TestCacheRepositorySecrets(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	jbsConfig := setupJBSConfig()
+	jbsConfig.Spec.EnableRebuilds = true
+	jbsConfig.Spec.MavenBaseLocations = map[string]string{
+		"maven-repository-301-private-repo": "https://repo.example.com/private",
+		"maven-repository-302-gradle":       "https://repo.gradle.org/artifactory/libs-releases",
+	}
+	jbsConfig.Spec.CacheSettings.RepositorySecrets = map[string]string{"private-repo": "private-repo-credentials"}
+	objs := []runtimeclient.Object{jbsConfig, setupSecret(), setupSystemConfig()}
+	client, reconciler := setupClientAndReconciler(false, objs...)
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.JBSConfigName}})
+	g.Expect(err).To(BeNil())
+
+	dep := appsv1.Deployment{}
+	err = client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.CacheDeploymentName}, &dep)
+	g.Expect(err).To(BeNil())
+	env := dep.Spec.Template.Spec.Containers[0].Env
+	trueBool := true
+	g.Expect(env).To(ContainElement(corev1.EnvVar{Name: "STORE_PRIVATE_REPO_USERNAME", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "private-repo-credentials"}, Key: v1alpha1.RepositoryUsernameKey, Optional: &trueBool}}}))
+	g.Expect(env).To(ContainElement(corev1.EnvVar{Name: "STORE_PRIVATE_REPO_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "private-repo-credentials"}, Key: v1alpha1.RepositoryPasswordKey, Optional: &trueBool}}}))
+	// Public repositories are still accessed anonymously
+	g.Expect(env).To(ContainElement(corev1.EnvVar{Name: "STORE_GRADLE_URL", Value: "https://repo.gradle.org/artifactory/libs-releases"}))
+	for _, name := range []string{"STORE_GRADLE_USERNAME", "STORE_GRADLE_PASSWORD", "STORE_CENTRAL_USERNAME", "STORE_CENTRAL_PASSWORD"} {
+		g.Expect(env).NotTo(ContainElement(HaveField("Name", name)))
+	}
+	g.Expect(*readConfiguredRepositories(client, g)).Should(Equal("rebuilt,central,redhat,private-repo,gradle"))
+}
+
 func TestCacheCreatedAndDeleted(t *testing.T) {
 	g := NewGomegaWithT(t)
 	ctx := context.TODO()
@@ -225,6 +255,41 @@ func TestCacheCreatedAndDeleted(t *testing.T) {
 	g.Expect(errors.IsNotFound(err)).To(BeTrue())
 }
 
+func TestCacheRegistryRepositoryWithTag(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	jbsConfig := setupJBSConfig()
+	jbsConfig.Spec.EnableRebuilds = true
+	jbsConfig.Spec.Registry.Repository = "deployments:latest@sha256:1234"
+	objs := []runtimeclient.Object{jbsConfig, setupSecret(), setupSystemConfig()}
+	client, reconciler := setupClientAndReconciler(false, objs...)
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.JBSConfigName}})
+	g.Expect(err).To(BeNil())
+
+	// The cache appends the tag of each image to the repository, as the pipelines do
+	dep := appsv1.Deployment{}
+	err = client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.CacheDeploymentName}, &dep)
+	g.Expect(err).To(BeNil())
+	g.Expect(dep.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "REGISTRY_REPOSITORY", Value: "deployments"}))
+}
+
+func TestCacheImageMirror(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.TODO()
+	jbsConfig := setupJBSConfig()
+	jbsConfig.Spec.EnableRebuilds = true
+	jbsConfig.Spec.ImageMirrors = []v1alpha1.ImageMirror{{Source: "quay.io/redhat-appstudio", Mirror: "mirror.example.com/jbs"}}
+	objs := []runtimeclient.Object{jbsConfig, setupSecret(), setupSystemConfig()}
+	client, reconciler := setupClientAndReconciler(false, objs...)
+	_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.JBSConfigName}})
+	g.Expect(err).To(BeNil())
+
+	dep := appsv1.Deployment{}
+	err = client.Get(ctx, types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: v1alpha1.CacheDeploymentName}, &dep)
+	g.Expect(err).To(BeNil())
+	g.Expect(dep.Spec.Template.Spec.Containers[0].Image).To(Equal("mirror.example.com/jbs/hacbs-jvm-cache:foo"))
+}
+
 func TestMissingRegistrySecretWithSpi(t *testing.T) {
 	g := NewGomegaWithT(t)
 	ctx := context.TODO()
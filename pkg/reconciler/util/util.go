@@ -5,7 +5,9 @@ import (
 	"crypto/md5" //#nosec G501
 	"encoding/hex"
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -27,6 +29,8 @@ const (
 )
 
 var (
+	repeatedSlashes = regexp.MustCompile("/{2,}")
+
 	controllerName = types.NamespacedName{
 		Namespace: ControllerNamespace,
 		Name:      ControllerDeploymentName,
@@ -85,3 +89,22 @@ func HashString(unique string) string {
 	depId := hex.EncodeToString(hash[:])
 	return depId
 }
+
+// MavenRepositoryURL returns the repository URL with any path prefix (e.g. /nexus/repository/releases) kept as is but
+// without repeated or trailing slashes. The deployer and the cache both append the artifact paths to the URL, and
+// repository managers may not resolve the resulting empty path segments. URLs that can't be parsed are returned
+// unchanged.
+func MavenRepositoryURL(repository string) string {
+	u, err := url.Parse(strings.TrimSpace(repository))
+	if err != nil || u.Host == "" {
+		return repository
+	}
+	path := strings.TrimSuffix(repeatedSlashes.ReplaceAllString(u.EscapedPath(), "/"), "/")
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return repository
+	}
+	u.Path = unescaped
+	u.RawPath = path
+	return u.String()
+}
@@ -106,3 +106,27 @@ func TestGetImageName(t *testing.T) {
 		})
 	}
 }
+
+func TestMavenRepositoryURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		repository string
+		want       string
+	}{
+		{name: "host only", repository: "https://repo.example.com", want: "https://repo.example.com"},
+		{name: "host with trailing slash", repository: "https://repo.example.com/", want: "https://repo.example.com"},
+		{name: "path prefix", repository: "https://repo.example.com/nexus/repository/releases", want: "https://repo.example.com/nexus/repository/releases"},
+		{name: "path prefix with trailing slash", repository: "https://repo.example.com/nexus/repository/releases/", want: "https://repo.example.com/nexus/repository/releases"},
+		{name: "repeated slashes", repository: "https://repo.example.com//artifactory//libs-release//", want: "https://repo.example.com/artifactory/libs-release"},
+		{name: "port and escaped path", repository: "http://repo.example.com:8081/repository/my%20repo/", want: "http://repo.example.com:8081/repository/my%20repo"},
+		{name: "surrounding whitespace", repository: " https://repo.example.com/maven2/ ", want: "https://repo.example.com/maven2"},
+		{name: "not a URL", repository: "repo.example.com/releases/", want: "repo.example.com/releases/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MavenRepositoryURL(tt.repository); got != tt.want {
+				t.Errorf("MavenRepositoryURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}